@@ -1,42 +1,85 @@
 package api
 
 import (
-	"errors"
+	"context"
 	"gocker-api/auth"
 	"gocker-api/models"
 	"gocker-api/services"
 	"gocker-api/utils"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
 )
 
+const defaultRequestTimeout = 30 * time.Second
+
 // Middleware function to check if the auth token provided is correct and has not expired.
-func AuthMiddleware(next http.Handler) http.Handler {
+func (server *APIServer) AuthMiddleware(next http.Handler) http.Handler {
 
-	allowedEndpoints := regexp.MustCompile(`/api/v1/auth/*`)
+	allowedEndpoints := regexp.MustCompile(`^/api/v1/auth/(register|authenticate|refresh-token|mfa/verify|verify-email|verify-email/resend|password/forgot|password/reset|invitations/accept)$|^/oauth/(authorize|login|token|introspect|revoke)$|^/\.well-known/`)
+	// Endpoints that any authenticated user can call on their own behalf, regardless of their role
+	selfServiceEndpoints := regexp.MustCompile(`^/api/v1/auth/`)
+	// Endpoints that check the scope of the token themselves
+	scopedEndpoints := regexp.MustCompile(`^/userinfo$`)
+	// Endpoints to manage personal access tokens, which can't be called with tokens limited by a scope
+	personalAccessTokenEndpoints := regexp.MustCompile(`^/api/v1/users/[0-9]+/tokens(/[0-9]+)?$`)
 
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		//If the endpoint is not allowed, check its auth token.
 		if allowedEndpoints.MatchString(req.URL.Path) {
 			next.ServeHTTP(res, req)
-		} else {
-			authErr := checkAuth(req)
+			return
+		}
 
-			//If the token is valid, execute the next function. Otherwise, respond with an error.
-			if authErr == nil {
-				next.ServeHTTP(res, req)
-			} else {
-				utils.WriteJSON(res, 403, utils.ApiError{Error: authErr.Error()})
+		user, token, organizationId, authErr := server.checkAuth(req)
+
+		if authErr == nil && scopedEndpoints.MatchString(req.URL.Path) {
+			//The handler decides what the token can access
+		} else if authErr == nil && selfServiceEndpoints.MatchString(req.URL.Path) {
+			//Tokens issued to OAuth clients and personal access tokens can't manage the user's account
+			if token.Scoped() {
+				authErr = utils.NewError(utils.KindForbidden, "scoped_token_not_allowed", "tokens issued to OAuth clients and personal access tokens can't be used at this endpoint")
 			}
+		} else if authErr == nil && personalAccessTokenEndpoints.MatchString(req.URL.Path) {
+			if token.Scoped() {
+				authErr = utils.NewError(utils.KindForbidden, "scoped_token_not_allowed", "personal access tokens can only be managed with a user authorization token")
+			}
+		}
+		//Every other route declares the permission it requires, which its handler enforces
+
+		//If the token is valid, execute the next function. Otherwise, respond with an error.
+		if authErr == nil {
+			ctx := auth.NewContext(req.Context(), user, token)
+			next.ServeHTTP(res, req.WithContext(auth.WithOrganization(ctx, organizationId)))
+		} else {
+			utils.WriteError(res, req, authErr)
 		}
 	})
 }
 
+// Middleware that cancels the context of every request after REQUEST_TIMEOUT (e.g. "10s", 30s by default),
+// so that the database work of a request stops when it takes too long, as it does when the client disconnects.
+func RequestTimeout(next http.Handler) http.Handler {
+	timeout, parseErr := time.ParseDuration(os.Getenv("REQUEST_TIMEOUT"))
+
+	if parseErr != nil || timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(res, req.WithContext(ctx))
+	})
+}
+
 // Middleware to check if the id parameter of an endpoint is a valid number.
 func ValidateIdParam(next http.Handler) http.Handler {
 
@@ -47,7 +90,7 @@ func ValidateIdParam(next http.Handler) http.Handler {
 		if idParam != "" {
 			//If there is param check if it's a number.
 			if _, err := strconv.Atoi(idParam); err != nil {
-				utils.WriteJSON(res, 400, utils.ApiError{Error: "Id parameter must be a number."})
+				utils.WriteError(res, req, utils.NewError(utils.KindInvalid, "invalid_id", "Id parameter must be a number."))
 			} else {
 				next.ServeHTTP(res, req)
 			}
@@ -59,41 +102,74 @@ func ValidateIdParam(next http.Handler) http.Handler {
 }
 
 // AUX FUNCTIONS
-// Function that checks if a request is authorized
-func checkAuth(req *http.Request) error {
+// Function that checks if a request is authenticated, returning its user, its token and the
+// organization it acts within (nil if there's none)
+func (server *APIServer) checkAuth(req *http.Request) (*models.User, *models.Token, *uint, error) {
 	fullToken := req.Header.Get("Authorization")
 
-	if fullToken == "" || !strings.HasPrefix(fullToken, "Bearer") {
-		return errors.New("authorization token must be provided, starting with Bearer")
+	if fullToken == "" || !strings.HasPrefix(fullToken, "Bearer ") {
+		return nil, nil, nil, services.ErrMissingToken
 	}
 
 	tokenString := fullToken[7:]
 
+	//Personal access tokens are opaque, so they're checked against their stored hash instead.
+	//They act within the active organization of their user.
+	if strings.HasPrefix(tokenString, services.PersonalAccessTokenPrefix) {
+		user, token, err := server.Services.AuthenticatePersonalAccessToken(req.Context(), tokenString)
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		return user, token, user.ActiveOrganizationRefer, nil
+	}
+
 	//Validate token
-	if err := auth.ValidateToken(tokenString); err != nil {
-		if err.(*jwt.ValidationError).Errors == jwt.ValidationErrorExpired {
-			return errors.New("token expired. Please, get a new one at /auth/refresh-token")
+	claims, validationErr := auth.GetClaims(tokenString)
+
+	if validationErr != nil {
+		if jwtErr, ok := validationErr.(*jwt.ValidationError); ok && jwtErr.Errors == jwt.ValidationErrorExpired {
+			return nil, nil, nil, utils.NewError(utils.KindUnauthorized, "token_expired", "token expired. Please, get a new one at /auth/refresh-token")
 		} else {
-			return errors.New("token not valid")
+			return nil, nil, nil, services.ErrInvalidToken
 		}
 	}
 
 	//Then check if token is in the database
-	if _, tokenNotFoundErr := services.GetTokenByValue(tokenString); tokenNotFoundErr != nil {
-		return errors.New("token revoked")
+	token, tokenNotFoundErr := server.Services.GetTokenByValue(req.Context(), tokenString)
+
+	if tokenNotFoundErr != nil {
+		return nil, nil, nil, services.ErrTokenRevoked
 	}
 
-	claims, claimsErr := auth.GetClaims(tokenString)
+	//Refresh tokens can only be used to get new tokens
+	if token.Kind != models.Access {
+		return nil, nil, nil, services.ErrInvalidToken
+	}
 
-	if claimsErr != nil {
-		return claimsErr
+	//Tokens issued through the client credentials grant act on behalf of the client, so they have no user
+	if token.UserRefer == nil {
+		return nil, token, nil, nil
 	}
 
-	user, _ := services.GetUserByEmail(claims["email"].(string))
+	user, userNotFoundErr := server.Services.GetUserById(req.Context(), int(*token.UserRefer))
 
-	if (req.Method == "POST" || req.Method == "PUT" || req.Method == "DELETE") && user.Role != models.Admin {
-		return errors.New("method not allowed")
+	if userNotFoundErr != nil {
+		return nil, nil, nil, services.ErrInvalidToken
 	}
 
-	return nil
+	//Tokens act within the organization they were issued for, as long as the user is still a member of it
+	organizationId := auth.GetOrganization(claims)
+
+	if organizationId != nil {
+		if _, notMemberErr := server.Services.GetMembership(*organizationId, user.ID); notMemberErr != nil {
+			return nil, nil, nil, utils.NewError(utils.KindUnauthorized, "stale_organization", "the user is no longer a member of the organization of the token. Please, get a new one at /auth/refresh-token")
+		}
+	}
+
+	//Keep track of when the session was last used. Failing to do so must not deny the request
+	server.Services.TouchSession(*token)
+
+	return user, token, organizationId, nil
 }
@@ -2,6 +2,7 @@ package api
 
 import (
 	"gocker-api/handlers"
+	"gocker-api/services"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -9,20 +10,41 @@ import (
 
 type APIServer struct {
 	ListenAddress string
+	Services      *services.Services
+}
+
+// Returns a server that listens at the given address, serving the API with the given services
+func NewAPIServer(listenAddress string, services *services.Services) *APIServer {
+	return &APIServer{ListenAddress: listenAddress, Services: services}
 }
 
 func (server *APIServer) Run() error {
+	return http.ListenAndServe(server.ListenAddress, server.Router())
+}
+
+// Returns the router of the API, with its middlewares and every route, to serve it from another binary
+func (server *APIServer) Router() *mux.Router {
 	router := mux.NewRouter()
 	// init middlewares
-	router.Use(AuthMiddleware)
+	router.Use(RequestTimeout)
+	router.Use(server.AuthMiddleware)
 	router.Use(ValidateIdParam)
 	// init all routes
-	initRoutes(router)
+	initRoutes(router, handlers.NewHandler(server.Services))
 
-	return http.ListenAndServe(server.ListenAddress, router)
+	return router
 }
 
-func initRoutes(router *mux.Router) {
-	handlers.InitUserRoutes(router)
-	handlers.InitAuthRoutes(router)
+func initRoutes(router *mux.Router, handler *handlers.Handler) {
+	handler.InitUserRoutes(router)
+	handler.InitPersonalAccessTokenRoutes(router)
+	handler.InitRoleRoutes(router)
+	handler.InitOrganizationRoutes(router)
+	handler.InitUserInvitationRoutes(router)
+	handler.InitAuthRoutes(router)
+	handler.InitSessionRoutes(router)
+	handler.InitMFARoutes(router)
+	handler.InitOAuthRoutes(router)
+	handler.InitOIDCRoutes(router)
+	handler.InitWellKnownRoutes(router)
 }
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"gocker-api/models"
 	"os"
@@ -9,16 +11,46 @@ import (
 	"github.com/golang-jwt/jwt"
 )
 
+// Purposes of the tokens that are not access or refresh tokens. They are never stored as access tokens,
+// so they can't be used as bearer tokens.
+type TokenPurpose string
+
+const (
+	EmailVerificationPurpose TokenPurpose = "email-verification"
+	LoginSessionPurpose      TokenPurpose = "login-session"
+)
+
 // Returns a new token as string and an error (if there was one)
 func GenerateToken(user models.User, kind models.TokenKind) (string, error) {
-	secretKey, envErr := getSecretKey()
+	var expiration int64
 
-	if envErr != nil {
-		return "", nil
+	if kind == models.Access {
+		expiration = time.Now().Add(24 * time.Hour).Unix()
+	} else {
+		expiration = time.Now().Add(8766 * time.Hour).Unix()
 	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
+	jti, jtiErr := generateTokenId()
+
+	if jtiErr != nil {
+		return "", jtiErr
+	}
+
+	// the jti makes every token unique, even if two of them are issued for the same user in the same second
+	claims := jwt.MapClaims{
+		"exp":   expiration,
+		"email": user.Email,
+		"jti":   jti,
+	}
+
+	addOrganizationClaim(claims, user)
+
+	return signToken(claims)
+}
+
+// Returns a new token issued to an OAuth client, carrying its client id and the scope granted to it.
+// If user is nil, the token acts on behalf of the client itself (client credentials grant).
+func GenerateClientToken(user *models.User, clientId string, kind models.TokenKind, scope string) (string, error) {
 	var expiration int64
 
 	if kind == models.Access {
@@ -27,54 +59,93 @@ func GenerateToken(user models.User, kind models.TokenKind) (string, error) {
 		expiration = time.Now().Add(8766 * time.Hour).Unix()
 	}
 
-	claims["exp"] = expiration
-	claims["email"] = user.Email
+	jti, jtiErr := generateTokenId()
 
-	tokenString, err := token.SignedString(secretKey)
+	if jtiErr != nil {
+		return "", jtiErr
+	}
+
+	claims := jwt.MapClaims{
+		"exp":       expiration,
+		"jti":       jti,
+		"client_id": clientId,
+		"scope":     scope,
+	}
 
-	if err != nil {
-		return "", err
+	if user != nil {
+		claims["email"] = user.Email
+		addOrganizationClaim(claims, *user)
 	}
-	return tokenString, nil
+
+	return signToken(claims)
 }
 
-// Validates the token string passed and returns an error if it's not valid
-func ValidateToken(tokenString string) error {
+// Returns a short-lived token for the given purpose, proving something about the user
+// (e.g. that its password was right, or that it owns its email address)
+func GeneratePurposeToken(user models.User, purpose TokenPurpose, duration time.Duration) (string, error) {
+	jti, jtiErr := generateTokenId()
 
-	secretKey, envErr := getSecretKey()
+	if jtiErr != nil {
+		return "", jtiErr
+	}
 
-	if envErr != nil {
-		return envErr
+	claims := jwt.MapClaims{
+		"exp":     time.Now().Add(duration).Unix(),
+		"email":   user.Email,
+		"jti":     jti,
+		"purpose": string(purpose),
 	}
 
-	//First check if token is in the database, since if it's not it would be revoked
-	token, parseErr := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		_, ok := t.Method.(*jwt.SigningMethodHMAC)
+	return signToken(claims)
+}
 
-		if !ok {
-			return nil, errors.New("signing method not valid")
-		}
+// Validates a token issued for the given purpose, returning the email of the user it was issued to
+func ValidatePurposeToken(tokenString string, purpose TokenPurpose) (string, error) {
+	claims, claimsErr := GetClaims(tokenString)
 
-		return secretKey, nil
-	})
+	if claimsErr != nil {
+		return "", claimsErr
+	}
 
-	if !token.Valid {
+	if tokenPurpose, _ := claims["purpose"].(string); tokenPurpose != string(purpose) {
+		return "", errors.New("token not valid")
+	}
+
+	email, _ := claims["email"].(string)
+
+	return email, nil
+}
+
+// Validates the token string passed and returns an error if it's not valid
+func ValidateToken(tokenString string) error {
+	token, parseErr := jwt.Parse(tokenString, keyFunc)
+
+	if parseErr != nil {
 		return parseErr
 	}
 
+	if !token.Valid {
+		return errors.New("token not valid")
+	}
+
 	return nil
 }
 
-func GetClaims(tokenString string) (jwt.MapClaims, error) {
-	secretKey, envErr := getSecretKey()
+// Returns the id of the organization the token was issued for, or nil if it was issued for none
+func GetOrganization(claims jwt.MapClaims) *uint {
+	organization, ok := claims["org"].(float64)
 
-	if envErr != nil {
-		return nil, envErr
+	if !ok {
+		return nil
 	}
 
-	jwtToken, parseErr := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		return secretKey, nil
-	})
+	organizationId := uint(organization)
+
+	return &organizationId
+}
+
+func GetClaims(tokenString string) (jwt.MapClaims, error) {
+	jwtToken, parseErr := jwt.Parse(tokenString, keyFunc)
 
 	if parseErr != nil {
 		return nil, parseErr
@@ -85,6 +156,88 @@ func GetClaims(tokenString string) (jwt.MapClaims, error) {
 	return claims, nil
 }
 
+// AUX FUNCTIONS
+
+// Function that adds the active organization of the user to the claims, as the org claim
+func addOrganizationClaim(claims jwt.MapClaims, user models.User) {
+	if user.ActiveOrganizationRefer != nil {
+		claims["org"] = *user.ActiveOrganizationRefer
+	}
+}
+
+// Function that signs the given claims with the active key of the ring, falling back to HS256 if there's none
+func signToken(claims jwt.MapClaims) (string, error) {
+	keyRing, keyRingErr := GetKeyRing()
+
+	if keyRingErr != nil {
+		return "", keyRingErr
+	}
+
+	if activeKey := keyRing.ActiveKey(); activeKey != nil {
+		token := jwt.NewWithClaims(activeKey.Method, claims)
+		token.Header["kid"] = activeKey.Kid
+
+		return token.SignedString(activeKey.PrivateKey)
+	}
+
+	secretKey, envErr := getSecretKey()
+
+	if envErr != nil {
+		return "", envErr
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secretKey)
+}
+
+// Function that returns the key to verify a token with, depending on its kid header.
+// Tokens without kid are the ones signed with HS256 and SECRET_KEY, which keep validating while it's set.
+func keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, hasKid := t.Header["kid"].(string)
+
+	if !hasKid {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("signing method not valid")
+		}
+
+		return getSecretKey()
+	}
+
+	keyRing, keyRingErr := GetKeyRing()
+
+	if keyRingErr != nil {
+		return nil, keyRingErr
+	}
+
+	key := keyRing.Key(kid)
+
+	if key == nil {
+		return nil, errors.New("signing key not found")
+	}
+
+	// Never let the token choose the algorithm, to avoid algorithm confusion attacks
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("signing method not valid")
+	}
+
+	return key.PublicKey, nil
+}
+
+func generateTokenId() (string, error) {
+	id := make([]byte, 16)
+
+	if _, readErr := rand.Read(id); readErr != nil {
+		return "", readErr
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
 func getSecretKey() ([]byte, error) {
-	return []byte(os.Getenv("SECRET_KEY")), nil
+	secretKey := os.Getenv("SECRET_KEY")
+
+	if secretKey == "" {
+		return nil, errors.New("SECRET_KEY is not set")
+	}
+
+	return []byte(secretKey), nil
 }
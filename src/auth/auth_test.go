@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"gocker-api/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	user := models.User{Email: "testauth@gmail.com"}
+
+	t.Setenv("JWT_KEYS_DIR", dir)
+	t.Setenv("SECRET_KEY", "")
+
+	var steps = []struct {
+		kid         string
+		generateKey func() (any, error)
+	}{
+		{"rsa-1", func() (any, error) { return rsa.GenerateKey(rand.Reader, 2048) }},
+		{"ec-1", func() (any, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) }},
+		{"ed-1", func() (any, error) {
+			_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+			return privateKey, err
+		}},
+	}
+
+	var previousTokens []string
+
+	for _, step := range steps {
+		writeKey(t, dir, step.kid, step.generateKey)
+		t.Setenv("JWT_ACTIVE_KID", step.kid)
+
+		if reloadErr := ReloadKeyRing(); reloadErr != nil {
+			t.Fatal(reloadErr)
+		}
+
+		token, tokenErr := GenerateToken(user, models.Access)
+
+		if tokenErr != nil {
+			t.Fatal(tokenErr)
+		}
+
+		// tokens signed by previous keys must keep validating after the rotation
+		for _, tokenString := range append(previousTokens, token) {
+			if validationErr := ValidateToken(tokenString); validationErr != nil {
+				t.Errorf("token should be valid with active key %s, got error %s", step.kid, validationErr)
+			}
+		}
+
+		previousTokens = append(previousTokens, token)
+	}
+
+	keyRing, _ := GetKeyRing()
+
+	if jwks := keyRing.JWKS(); len(jwks.Keys) != len(steps) {
+		t.Errorf("wrong number of published keys. expected %d and got %d", len(steps), len(jwks.Keys))
+	}
+
+	// retire the first key, so the tokens it signed are not valid anymore
+	os.Remove(filepath.Join(dir, "rsa-1.pem"))
+
+	if reloadErr := ReloadKeyRing(); reloadErr != nil {
+		t.Fatal(reloadErr)
+	}
+
+	if ValidateToken(previousTokens[0]) == nil {
+		t.Errorf("token signed by a retired key should not be valid")
+	}
+}
+
+func writeKey(t *testing.T, dir string, kid string, generateKey func() (any, error)) {
+	privateKey, generateErr := generateKey()
+
+	if generateErr != nil {
+		t.Fatal(generateErr)
+	}
+
+	der, marshalErr := x509.MarshalPKCS8PrivateKey(privateKey)
+
+	if marshalErr != nil {
+		t.Fatal(marshalErr)
+	}
+
+	content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if writeErr := os.WriteFile(filepath.Join(dir, kid+".pem"), content, 0600); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+}
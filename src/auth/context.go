@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"gocker-api/models"
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	tokenContextKey
+	organizationContextKey
+)
+
+// Returns a copy of the context carrying the authenticated user and the token it used
+func NewContext(ctx context.Context, user *models.User, token *models.Token) context.Context {
+	ctx = context.WithValue(ctx, userContextKey, user)
+
+	return context.WithValue(ctx, tokenContextKey, token)
+}
+
+// Returns the authenticated user stored in the context, or nil if there's none
+func UserFromContext(ctx context.Context) *models.User {
+	user, _ := ctx.Value(userContextKey).(*models.User)
+
+	return user
+}
+
+// Returns the token the request was authenticated with, or nil if there's none
+func TokenFromContext(ctx context.Context) *models.Token {
+	token, _ := ctx.Value(tokenContextKey).(*models.Token)
+
+	return token
+}
+
+// Returns a copy of the context carrying the organization the request acts within
+func WithOrganization(ctx context.Context, organizationId *uint) context.Context {
+	return context.WithValue(ctx, organizationContextKey, organizationId)
+}
+
+// Returns the organization the request acts within, or nil if there's none
+func OrganizationFromContext(ctx context.Context) *uint {
+	organizationId, _ := ctx.Value(organizationContextKey).(*uint)
+
+	return organizationId
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Signing key identified by its kid. Only the active key of the ring signs new tokens,
+// while every key present in the ring keeps validating the tokens it signed.
+type SigningKey struct {
+	Kid        string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+}
+
+type KeyRing struct {
+	keys      map[string]*SigningKey
+	activeKid string
+}
+
+// JSON Web Key, as described in RFC 7517. Only public parameters are exposed.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+var keyRingInstance *KeyRing
+var keyRingLock = &sync.Mutex{}
+
+// Returns the key ring loaded from the JWT_KEYS_DIR directory. If the env var is not set,
+// the ring is empty and tokens are signed with HS256 and SECRET_KEY instead.
+func GetKeyRing() (*KeyRing, error) {
+	keyRingLock.Lock()
+	defer keyRingLock.Unlock()
+
+	if keyRingInstance == nil {
+		keyRing, loadErr := loadKeyRing(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_ACTIVE_KID"))
+
+		if loadErr != nil {
+			return nil, loadErr
+		}
+
+		keyRingInstance = keyRing
+	}
+
+	return keyRingInstance, nil
+}
+
+// Function that loads the key ring again from disk, so that keys can be rotated without restarting.
+func ReloadKeyRing() error {
+	keyRing, loadErr := loadKeyRing(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_ACTIVE_KID"))
+
+	if loadErr != nil {
+		return loadErr
+	}
+
+	keyRingLock.Lock()
+	defer keyRingLock.Unlock()
+	keyRingInstance = keyRing
+
+	return nil
+}
+
+// Returns the key used to sign new tokens, or nil if the ring is empty
+func (keyRing *KeyRing) ActiveKey() *SigningKey {
+	return keyRing.keys[keyRing.activeKid]
+}
+
+// Returns the key with the given kid, or nil if it's not in the ring (or has been retired)
+func (keyRing *KeyRing) Key(kid string) *SigningKey {
+	return keyRing.keys[kid]
+}
+
+// Returns the public part of every key in the ring, as a JWK set
+func (keyRing *KeyRing) JWKS() JWKSet {
+	jwks := JWKSet{Keys: make([]JWK, 0, len(keyRing.keys))}
+	kids := make([]string, 0, len(keyRing.keys))
+
+	for kid := range keyRing.keys {
+		kids = append(kids, kid)
+	}
+
+	sort.Strings(kids)
+
+	for _, kid := range kids {
+		key := keyRing.keys[kid]
+		jwk := JWK{Use: "sig", Alg: key.Method.Alg(), Kid: key.Kid}
+
+		switch publicKey := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeSegment(publicKey.N.Bytes())
+			jwk.E = encodeSegment(big.NewInt(int64(publicKey.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (publicKey.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = publicKey.Curve.Params().Name
+			jwk.X = encodeSegment(publicKey.X.FillBytes(make([]byte, size)))
+			jwk.Y = encodeSegment(publicKey.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = encodeSegment(publicKey)
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
+
+// AUX FUNCTIONS
+
+// Function that reads every <kid>.pem private key inside dir
+func loadKeyRing(dir string, activeKid string) (*KeyRing, error) {
+	keyRing := &KeyRing{keys: make(map[string]*SigningKey)}
+
+	if dir == "" {
+		return keyRing, nil
+	}
+
+	paths, globErr := filepath.Glob(filepath.Join(dir, "*.pem"))
+
+	if globErr != nil {
+		return nil, globErr
+	}
+
+	for _, path := range paths {
+		content, readErr := os.ReadFile(path)
+
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, parseErr := parseSigningKey(kid, content)
+
+		if parseErr != nil {
+			return nil, errors.New("key " + kid + ": " + parseErr.Error())
+		}
+
+		keyRing.keys[kid] = key
+	}
+
+	if len(keyRing.keys) == 0 {
+		return nil, errors.New("no keys found at " + dir)
+	}
+
+	if keyRing.Key(activeKid) == nil {
+		return nil, errors.New("active key " + activeKid + " not found at " + dir)
+	}
+
+	keyRing.activeKid = activeKid
+
+	return keyRing, nil
+}
+
+// Function that parses a PEM private key and picks the signing method that matches its type
+func parseSigningKey(kid string, content []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(content)
+
+	if block == nil {
+		return nil, errors.New("not a PEM encoded key")
+	}
+
+	var privateKey any
+	var parseErr error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, parseErr = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, parseErr = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, parseErr = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{Kid: kid, Method: jwt.SigningMethodRS256, PrivateKey: key, PublicKey: &key.PublicKey}, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 curve is supported for ES256")
+		}
+		return &SigningKey{Kid: kid, Method: jwt.SigningMethodES256, PrivateKey: key, PublicKey: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{Kid: kid, Method: jwt.SigningMethodEdDSA, PrivateKey: key, PublicKey: key.Public()}, nil
+	default:
+		return nil, errors.New("key type not supported")
+	}
+}
+
+func encodeSegment(value []byte) string {
+	return base64.RawURLEncoding.EncodeToString(value)
+}
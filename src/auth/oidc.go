@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const idTokenDuration = time.Hour
+
+// Returns the OpenID Connect issuer identifier, set by OIDC_ISSUER. It must be the public
+// base URL of the API, since clients fetch the discovery document relative to it.
+func Issuer() string {
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		return issuer
+	}
+
+	return "http://localhost:8080"
+}
+
+// Returns the algorithm ID tokens are signed with, or an empty string if they can't be issued
+func IDTokenSigningAlg() string {
+	keyRing, keyRingErr := GetKeyRing()
+
+	if keyRingErr != nil || keyRing.ActiveKey() == nil {
+		return ""
+	}
+
+	return keyRing.ActiveKey().Method.Alg()
+}
+
+// Returns a new ID token for the given subject, issued to the client with the given id.
+// The user claims (e.g. email or name) are added on top of the ones OpenID Connect requires.
+// ID tokens are only signed with the active key of the ring, since clients must be able to
+// verify them with the public keys exposed in the JWK set.
+func GenerateIDToken(subject string, clientId string, nonce string, userClaims map[string]interface{}) (string, error) {
+	keyRing, keyRingErr := GetKeyRing()
+
+	if keyRingErr != nil {
+		return "", keyRingErr
+	}
+
+	activeKey := keyRing.ActiveKey()
+
+	if activeKey == nil {
+		return "", errors.New("ID tokens can't be issued without a signing key. Set JWT_KEYS_DIR and JWT_ACTIVE_KID")
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{}
+
+	for name, value := range userClaims {
+		claims[name] = value
+	}
+
+	claims["iss"] = Issuer()
+	claims["sub"] = subject
+	claims["aud"] = clientId
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(idTokenDuration).Unix()
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	token := jwt.NewWithClaims(activeKey.Method, claims)
+	token.Header["kid"] = activeKey.Kid
+
+	return token.SignedString(activeKey.PrivateKey)
+}
@@ -1,42 +1,62 @@
 package database
 
 import (
-	"gocker-api/models"
-	"log"
-	"os"
-	"sync"
+	"fmt"
+	"strings"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
-type Database struct {
-	db *gorm.DB
-}
-
-func (database Database) GetDB() *gorm.DB {
-	return database.db
-}
+// Opens the database at the given DSN with the given driver: postgres (the default one) or sqlite.
+// The schema is managed by the migrations (see MigrateUp), which are run as a separate step.
+func Open(driver string, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+
+	switch driver {
+	case "", "postgres":
+		dialector = postgres.Open(dsn)
+	case "sqlite":
+		dialector = sqlite.Open(withForeignKeys(dsn))
+	default:
+		return nil, fmt.Errorf("unknown database driver %s", driver)
+	}
 
-var databaseInstance *Database
-var lock = &sync.Mutex{}
+	db, openErr := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Info), TranslateError: true})
 
-func GetInstance() *Database {
-	lock.Lock()
-	defer lock.Unlock()
+	if openErr != nil {
+		return nil, openErr
+	}
 
-	if databaseInstance == nil {
-		db, dbErr := gorm.Open(postgres.Open(os.Getenv("DB_STRING")), &gorm.Config{})
+	if driver == "sqlite" {
+		sqlDB, sqlErr := db.DB()
 
-		if dbErr != nil {
-			log.Fatal(dbErr.Error())
+		if sqlErr != nil {
+			return nil, sqlErr
 		}
 
-		db.Logger = logger.Default.LogMode(logger.Info)
-		db.AutoMigrate(&models.User{}, &models.Token{})
-		databaseInstance = &Database{db}
+		// SQLite only allows one writer at a time, and every connection to a :memory: database
+		// would get a database of its own
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	return db, nil
+}
+
+// AUX FUNCTIONS
+
+// SQLite doesn't enforce foreign keys unless every connection enables them, and the schema
+// relies on them to delete what depends on a record
+func withForeignKeys(dsn string) string {
+	if strings.Contains(dsn, "foreign_keys") {
+		return dsn
+	}
+
+	if strings.Contains(dsn, "?") {
+		return dsn + "&_pragma=foreign_keys(1)"
 	}
 
-	return databaseInstance
+	return dsn + "?_pragma=foreign_keys(1)"
 }
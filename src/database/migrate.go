@@ -0,0 +1,418 @@
+package database
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations are SQL scripts named <version>_<name>.up.sql, with an optional <version>_<name>.down.sql
+// that reverts them. They're applied in version order, each one within a transaction.
+// They're written for Postgres, in migrations/postgres, and the SQLite ones are generated from them by
+// swapping the types and functions SQLite names differently (see sqliteScript). The few scripts that need
+// more than that have a SQLite version in migrations/sqlite, which replaces the generated one.
+// The first one is the schema the API had before migrations existed, and the later ones only add what's
+// missing (CREATE TABLE IF NOT EXISTS, ADD COLUMN IF NOT EXISTS...), so that databases created by
+// AutoMigrate are adopted whatever version of the API created them.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+var ErrPendingMigrations = errors.New("the database has pending migrations. Please, run `migrate up` first")
+
+// Statement adding a column only if the table doesn't have it, which SQLite doesn't support
+var addColumnIfNotExists = regexp.MustCompile(`(?i)ALTER TABLE\s+(\w+)\s+ADD COLUMN IF NOT EXISTS\s+(\w+)([^;]*;)`)
+
+// Types and functions of the Postgres scripts, along with the ones SQLite has instead. Foreign keys are only
+// enforced by SQLite if the connection enables them, which Open does.
+var sqliteReplacer = strings.NewReplacer(
+	"bigserial PRIMARY KEY", "integer PRIMARY KEY AUTOINCREMENT",
+	"bigint", "integer",
+	"boolean", "numeric",
+	"bytea", "blob",
+	"timestamptz", "datetime",
+	"now()", "CURRENT_TIMESTAMP",
+	"DROP COLUMN IF EXISTS", "DROP COLUMN",
+)
+
+// Key of the advisory lock held while migrating, so that only one instance migrates at a time
+const migrationLockKey = 7318029
+
+type Migration struct {
+	Version uint
+	Name    string
+	up      string
+	down    string
+}
+
+// Row of the schema_migrations table, one for every migration applied
+type SchemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+type MigrationStatus struct {
+	Migration
+	// Time the migration was applied at, or nil if it's pending
+	AppliedAt *time.Time
+}
+
+// Returns the embedded migrations of the given dialect (postgres or sqlite), sorted by version
+func LoadMigrations(dialect string) ([]Migration, error) {
+	migrations, loadErr := loadMigrations(migrationFiles, "migrations/postgres")
+
+	if loadErr != nil || dialect == "postgres" {
+		return migrations, loadErr
+	}
+
+	if dialect != "sqlite" {
+		return nil, fmt.Errorf("there are no migrations for the %s dialect", dialect)
+	}
+
+	overrides, overridesErr := loadMigrations(migrationFiles, "migrations/sqlite")
+
+	if overridesErr != nil {
+		return nil, overridesErr
+	}
+
+	return sqliteMigrations(migrations, overrides)
+}
+
+// Function that applies every pending migration, returning the ones it applied
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	migrations, loadErr := LoadMigrations(db.Dialector.Name())
+
+	if loadErr != nil {
+		return nil, loadErr
+	}
+
+	applied := make([]Migration, 0)
+
+	lockErr := withMigrationLock(db, func(conn *gorm.DB) error {
+		appliedVersions, getErr := getAppliedVersions(conn)
+
+		if getErr != nil {
+			return getErr
+		}
+
+		for _, migration := range migrations {
+			if _, isApplied := appliedVersions[migration.Version]; isApplied {
+				continue
+			}
+
+			migrateErr := conn.Transaction(func(tx *gorm.DB) error {
+				if execErr := tx.Exec(addMissingColumns(tx, migration.up)).Error; execErr != nil {
+					return execErr
+				}
+
+				return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+			})
+
+			if migrateErr != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, migrateErr)
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, lockErr
+}
+
+// Function that reverts the given number of migrations, starting from the last one applied,
+// returning the ones it reverted
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	migrations, loadErr := LoadMigrations(db.Dialector.Name())
+
+	if loadErr != nil {
+		return nil, loadErr
+	}
+
+	reverted := make([]Migration, 0)
+
+	lockErr := withMigrationLock(db, func(conn *gorm.DB) error {
+		appliedVersions, getErr := getAppliedVersions(conn)
+
+		if getErr != nil {
+			return getErr
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := migrations[i]
+
+			if _, isApplied := appliedVersions[migration.Version]; !isApplied {
+				continue
+			}
+
+			if migration.down == "" {
+				return fmt.Errorf("migration %d_%s can't be reverted, it has no down script", migration.Version, migration.Name)
+			}
+
+			migrateErr := conn.Transaction(func(tx *gorm.DB) error {
+				if execErr := tx.Exec(migration.down).Error; execErr != nil {
+					return execErr
+				}
+
+				return tx.Delete(&SchemaMigration{Version: migration.Version}).Error
+			})
+
+			if migrateErr != nil {
+				return fmt.Errorf("reverting migration %d_%s failed: %w", migration.Version, migration.Name, migrateErr)
+			}
+
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return reverted, lockErr
+}
+
+// Returns every migration along with the time it was applied at, if it was
+func GetMigrationStatus(db *gorm.DB) ([]MigrationStatus, error) {
+	migrations, loadErr := LoadMigrations(db.Dialector.Name())
+
+	if loadErr != nil {
+		return nil, loadErr
+	}
+
+	appliedVersions, getErr := getAppliedVersions(db)
+
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+
+	for _, migration := range migrations {
+		status := MigrationStatus{Migration: migration}
+
+		if appliedAt, isApplied := appliedVersions[migration.Version]; isApplied {
+			status.AppliedAt = &appliedAt
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Returns the migrations that haven't been applied yet
+func GetPendingMigrations(db *gorm.DB) ([]Migration, error) {
+	statuses, statusErr := GetMigrationStatus(db)
+
+	if statusErr != nil {
+		return nil, statusErr
+	}
+
+	pending := make([]Migration, 0)
+
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.Migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Function that returns ErrPendingMigrations if any migration hasn't been applied yet
+func CheckMigrations(db *gorm.DB) error {
+	pending, pendingErr := GetPendingMigrations(db)
+
+	if pendingErr != nil {
+		return pendingErr
+	}
+
+	if len(pending) > 0 {
+		return ErrPendingMigrations
+	}
+
+	return nil
+}
+
+// AUX FUNCTIONS
+
+func loadMigrations(files fs.FS, dir string) ([]Migration, error) {
+	entries, readErr := fs.ReadDir(files, dir)
+
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	migrationsByVersion := make(map[uint]*Migration)
+
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		var direction string
+
+		if strings.HasSuffix(fileName, ".up.sql") {
+			direction = "up"
+		} else if strings.HasSuffix(fileName, ".down.sql") {
+			direction = "down"
+		} else {
+			continue
+		}
+
+		versionText, name, hasName := strings.Cut(strings.TrimSuffix(fileName, "."+direction+".sql"), "_")
+		version, parseErr := strconv.ParseUint(versionText, 10, 32)
+
+		if !hasName || parseErr != nil {
+			return nil, fmt.Errorf("migration file %s must be named <version>_<name>.%s.sql", fileName, direction)
+		}
+
+		content, contentErr := fs.ReadFile(files, path.Join(dir, fileName))
+
+		if contentErr != nil {
+			return nil, contentErr
+		}
+
+		migration, exists := migrationsByVersion[uint(version)]
+
+		if !exists {
+			migration = &Migration{Version: uint(version), Name: name}
+			migrationsByVersion[uint(version)] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migrations %d_%s and %d_%s share the same version", version, migration.Name, version, name)
+		}
+
+		if direction == "up" {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(migrationsByVersion))
+
+	for _, migration := range migrationsByVersion {
+		if migration.up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Function that generates the SQLite version of the Postgres migrations, replacing the scripts of the
+// migrations given as overrides with theirs. Their down scripts are generated if they don't have any.
+func sqliteMigrations(migrations []Migration, overrides []Migration) ([]Migration, error) {
+	overridesByVersion := make(map[uint]Migration, len(overrides))
+
+	for _, override := range overrides {
+		overridesByVersion[override.Version] = override
+	}
+
+	generated := make([]Migration, 0, len(migrations))
+
+	for _, migration := range migrations {
+		migration.up = sqliteReplacer.Replace(migration.up)
+		migration.down = sqliteReplacer.Replace(migration.down)
+
+		if override, exists := overridesByVersion[migration.Version]; exists {
+			if override.Name != migration.Name {
+				return nil, fmt.Errorf("sqlite migration %d_%s must be named like the one it replaces, %d_%s", override.Version, override.Name, migration.Version, migration.Name)
+			}
+
+			migration.up = override.up
+
+			if override.down != "" {
+				migration.down = override.down
+			}
+
+			delete(overridesByVersion, migration.Version)
+		}
+
+		generated = append(generated, migration)
+	}
+
+	for _, override := range overridesByVersion {
+		return nil, fmt.Errorf("sqlite migration %d_%s replaces no migration", override.Version, override.Name)
+	}
+
+	return generated, nil
+}
+
+// Function that turns the ADD COLUMN IF NOT EXISTS statements of a script into ones SQLite supports:
+// plain ADD COLUMN statements for the columns the tables don't have yet, and nothing for the rest
+func addMissingColumns(tx *gorm.DB, script string) string {
+	if tx.Dialector.Name() != "sqlite" {
+		return script
+	}
+
+	return addColumnIfNotExists.ReplaceAllStringFunc(script, func(statement string) string {
+		parts := addColumnIfNotExists.FindStringSubmatch(statement)
+
+		if tx.Migrator().HasColumn(parts[1], parts[2]) {
+			return ""
+		}
+
+		return "ALTER TABLE " + parts[1] + " ADD COLUMN " + parts[2] + parts[3]
+	})
+}
+
+// Function that runs fn holding the migration advisory lock. Advisory locks belong to a database
+// session, so everything runs on the same connection of the pool. SQLite has no advisory locks,
+// but its databases only allow one writer at a time anyway.
+func withMigrationLock(db *gorm.DB, fn func(conn *gorm.DB) error) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		createStatement := "CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY, name text NOT NULL, applied_at datetime NOT NULL)"
+
+		if conn.Dialector.Name() == "postgres" {
+			if lockErr := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockKey).Error; lockErr != nil {
+				return lockErr
+			}
+
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockKey)
+
+			createStatement = "CREATE TABLE IF NOT EXISTS schema_migrations (version bigint PRIMARY KEY, name text NOT NULL, applied_at timestamptz NOT NULL)"
+		}
+
+		createErr := conn.Exec(createStatement).Error
+
+		if createErr != nil {
+			return createErr
+		}
+
+		return fn(conn)
+	})
+}
+
+// Returns the versions of the migrations applied, along with the time they were applied at
+func getAppliedVersions(db *gorm.DB) (map[uint]time.Time, error) {
+	appliedVersions := make(map[uint]time.Time)
+
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return appliedVersions, nil
+	}
+
+	var schemaMigrations []SchemaMigration
+
+	if result := db.Order("version").Find(&schemaMigrations); result.Error != nil {
+		return nil, result.Error
+	}
+
+	for _, schemaMigration := range schemaMigrations {
+		appliedVersions[schemaMigration.Version] = schemaMigration.AppliedAt
+	}
+
+	return appliedVersions, nil
+}
@@ -0,0 +1,285 @@
+package database
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Models as the API had them before migrations existed, when AutoMigrate created the tables
+type baselineUser struct {
+	ID        uint `gorm:"primaryKey"`
+	FirstName string
+	Email     string
+	Password  []byte
+	Tokens    []baselineToken `gorm:"foreignKey:UserRefer;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Role      int
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineToken struct {
+	ID         uint `gorm:"primaryKey"`
+	TokenValue string
+	UserRefer  uint
+	Kind       int
+}
+
+func (baselineToken) TableName() string { return "tokens" }
+
+// Same models as AutoMigrate left them a few versions later, with some of the columns the migrations add
+type laterUser struct {
+	ID            uint `gorm:"primaryKey"`
+	FirstName     string
+	Email         string
+	Password      []byte
+	Tokens        []laterToken `gorm:"foreignKey:UserRefer;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Role          int
+	EmailVerified bool
+}
+
+func (laterUser) TableName() string { return "users" }
+
+type laterToken struct {
+	ID         uint `gorm:"primaryKey"`
+	TokenValue string
+	UserRefer  uint
+	Kind       int
+	Family     string `gorm:"index"`
+	UsedAt     *time.Time
+}
+
+func (laterToken) TableName() string { return "tokens" }
+
+func TestLoadMigrations(t *testing.T) {
+	files := fstest.MapFS{
+		"migrations/0002_add_column.up.sql":      {Data: []byte("ALTER TABLE t ADD COLUMN c text;")},
+		"migrations/0001_create_table.up.sql":    {Data: []byte("CREATE TABLE t (id bigserial);")},
+		"migrations/0001_create_table.down.sql":  {Data: []byte("DROP TABLE t;")},
+		"migrations/0010_backfill_column.up.sql": {Data: []byte("UPDATE t SET c = '';")},
+		"migrations/README.md":                   {Data: []byte("not a migration")},
+	}
+
+	migrations, err := loadMigrations(files, "migrations")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		version uint
+		name    string
+		hasDown bool
+	}{
+		{1, "create_table", true},
+		{2, "add_column", false},
+		{10, "backfill_column", false},
+	}
+
+	if len(migrations) != len(expected) {
+		t.Fatalf("expected %d migrations and got %d", len(expected), len(migrations))
+	}
+
+	for i, test := range expected {
+		migration := migrations[i]
+
+		if migration.Version != test.version || migration.Name != test.name {
+			t.Errorf("expected migration %d_%s at position %d and got %d_%s", test.version, test.name, i, migration.Version, migration.Name)
+		}
+
+		if (migration.down != "") != test.hasDown {
+			t.Errorf("migration %d_%s: expected down script %t", migration.Version, migration.Name, test.hasDown)
+		}
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	var tests = []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{"missing version", fstest.MapFS{"migrations/create_table.up.sql": {Data: []byte("SELECT 1;")}}},
+		{"missing up script", fstest.MapFS{"migrations/0001_create_table.down.sql": {Data: []byte("SELECT 1;")}}},
+		{"duplicated version", fstest.MapFS{
+			"migrations/0001_create_table.up.sql": {Data: []byte("SELECT 1;")},
+			"migrations/0001_add_column.up.sql":   {Data: []byte("SELECT 1;")},
+		}},
+	}
+
+	for _, test := range tests {
+		if _, err := loadMigrations(test.files, "migrations"); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "create_table", up: "CREATE TABLE t (id bigserial PRIMARY KEY, at timestamptz DEFAULT now());", down: "DROP TABLE t;"},
+		{Version: 2, Name: "add_column", up: "ALTER TABLE t ADD COLUMN IF NOT EXISTS c boolean;", down: "ALTER TABLE t DROP COLUMN IF EXISTS c;"},
+	}
+
+	generated, err := sqliteMigrations(migrations, []Migration{{Version: 2, Name: "add_column", up: "SELECT 1;"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if generated[0].up != "CREATE TABLE t (id integer PRIMARY KEY AUTOINCREMENT, at datetime DEFAULT CURRENT_TIMESTAMP);" {
+		t.Errorf("expected the SQLite types and functions and got %s", generated[0].up)
+	}
+
+	if generated[1].up != "SELECT 1;" || generated[1].down != "ALTER TABLE t DROP COLUMN c;" {
+		t.Errorf("expected the up script to be replaced and the down one to be generated and got %q and %q", generated[1].up, generated[1].down)
+	}
+
+	if _, err := sqliteMigrations(migrations, []Migration{{Version: 2, Name: "other", up: "SELECT 1;"}}); err == nil {
+		t.Error("expected an error for a SQLite migration named unlike the one it replaces")
+	}
+
+	if _, err := sqliteMigrations(migrations, []Migration{{Version: 3, Name: "other", up: "SELECT 1;"}}); err == nil {
+		t.Error("expected an error for a SQLite migration that replaces none")
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	postgresMigrations, err := LoadMigrations("postgres")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqliteMigrations, err := LoadMigrations("sqlite")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(postgresMigrations) != len(sqliteMigrations) {
+		t.Fatalf("expected the same migrations for every dialect, got %d for postgres and %d for sqlite", len(postgresMigrations), len(sqliteMigrations))
+	}
+
+	for i, migration := range postgresMigrations {
+		if migration.Version != uint(i+1) {
+			t.Errorf("expected version %d and got %d_%s. Versions must be consecutive", i+1, migration.Version, migration.Name)
+		}
+
+		if migration.down == "" || sqliteMigrations[i].down == "" {
+			t.Errorf("migration %d_%s has no down script", migration.Version, migration.Name)
+		}
+
+		if sqliteMigrations[i].Version != migration.Version || sqliteMigrations[i].Name != migration.Name {
+			t.Errorf("expected sqlite migration %d_%s and got %d_%s", migration.Version, migration.Name, sqliteMigrations[i].Version, sqliteMigrations[i].Name)
+		}
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	db, err := Open("sqlite", "file::memory:")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckMigrations(db); err != ErrPendingMigrations {
+		t.Fatalf("expected pending migrations before migrating and got %v", err)
+	}
+
+	applied, err := MigrateUp(db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckMigrations(db); err != nil {
+		t.Fatalf("expected no pending migrations after migrating and got %v", err)
+	}
+
+	var roles int64
+
+	if err := db.Table("roles").Count(&roles).Error; err != nil || roles != 2 {
+		t.Errorf("expected the 2 built-in roles to be seeded and got %d (%v)", roles, err)
+	}
+
+	reverted, err := MigrateDown(db, len(applied))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reverted) != len(applied) {
+		t.Errorf("expected %d migrations reverted and got %d", len(applied), len(reverted))
+	}
+
+	if db.Migrator().HasTable("users") {
+		t.Error("expected the users table to be dropped")
+	}
+}
+
+// Databases created by AutoMigrate, with the baseline schema or a later one, are adopted by the migrations,
+// keeping their data
+func TestMigrateAutoMigratedSQLite(t *testing.T) {
+	var tests = []struct {
+		name   string
+		models []interface{}
+	}{
+		{"baseline", []interface{}{&baselineUser{}, &baselineToken{}}},
+		{"later", []interface{}{&laterUser{}, &laterToken{}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := Open("sqlite", "file::memory:")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.AutoMigrate(test.models...); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.Exec("INSERT INTO users (first_name, email, role) VALUES ('legacy', 'legacy@gmail.com', 2)").Error; err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.Exec("INSERT INTO tokens (token_value, user_refer, kind) VALUES ('legacy', 1, 2)").Error; err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := MigrateUp(db); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := CheckMigrations(db); err != nil {
+				t.Fatalf("expected no pending migrations after migrating and got %v", err)
+			}
+
+			var user struct {
+				Email     string
+				Version   uint
+				CreatedAt *time.Time
+			}
+
+			if err := db.Raw("SELECT email, version, created_at FROM users WHERE id = 1").Scan(&user).Error; err != nil || user.Email != "legacy@gmail.com" {
+				t.Fatalf("expected the user to be kept and got %+v (%v)", user, err)
+			}
+
+			if user.Version != 0 || user.CreatedAt == nil {
+				t.Errorf("expected the user to get the new columns and got %+v", user)
+			}
+
+			var tokens int64
+
+			if err := db.Table("tokens").Where("token_value = ? AND family IS NULL AND client_refer IS NULL", "legacy").Count(&tokens).Error; err != nil || tokens != 1 {
+				t.Errorf("expected the token to be kept with the new columns and got %d (%v)", tokens, err)
+			}
+
+			for _, table := range []string{"sessions", "roles", "organizations", "user_invitations"} {
+				if !db.Migrator().HasTable(table) {
+					t.Errorf("expected the %s table to be created", table)
+				}
+			}
+		})
+	}
+}
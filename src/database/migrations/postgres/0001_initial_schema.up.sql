@@ -0,0 +1,18 @@
+-- Schema the API had before it had migrations, when AutoMigrate created the tables. Databases created
+-- that way are adopted as they are, since every statement only creates what's missing.
+
+CREATE TABLE IF NOT EXISTS users (
+    id bigserial PRIMARY KEY,
+    first_name text,
+    email text,
+    password bytea,
+    role bigint
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+    id bigserial PRIMARY KEY,
+    token_value text,
+    user_refer bigint,
+    kind bigint,
+    CONSTRAINT fk_users_tokens FOREIGN KEY (user_refer) REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_tokens_family;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS used_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS parent_refer;
+ALTER TABLE tokens DROP COLUMN IF EXISTS family;
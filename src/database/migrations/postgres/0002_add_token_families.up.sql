@@ -0,0 +1,6 @@
+-- Refresh tokens belong to the family of the login they were issued for, and point to the one they replaced
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family text;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS parent_refer bigint CONSTRAINT fk_tokens_parent REFERENCES tokens (id) ON DELETE SET NULL;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS idx_tokens_family ON tokens (family);
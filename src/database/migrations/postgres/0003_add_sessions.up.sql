@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS max_sessions bigint;
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id bigserial PRIMARY KEY,
+    user_refer bigint,
+    family text,
+    user_agent text,
+    ip_address text,
+    created_at timestamptz,
+    last_used_at timestamptz,
+    CONSTRAINT fk_users_sessions FOREIGN KEY (user_refer) REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_refer ON sessions (user_refer);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_family ON sessions (family);
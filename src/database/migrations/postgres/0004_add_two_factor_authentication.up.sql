@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled boolean;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_counter bigint;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id bigserial PRIMARY KEY,
+    user_refer bigint,
+    code_hash text,
+    used_at timestamptz,
+    CONSTRAINT fk_users_recovery_codes FOREIGN KEY (user_refer) REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_refer ON recovery_codes (user_refer);
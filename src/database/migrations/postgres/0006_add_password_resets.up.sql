@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS password_resets (
+    id bigserial PRIMARY KEY,
+    user_refer bigint,
+    token_hash text,
+    expires_at timestamptz,
+    used_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_refer ON password_resets (user_refer);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_password_resets_token_hash ON password_resets (token_hash);
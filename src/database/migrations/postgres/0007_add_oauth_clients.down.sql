@@ -0,0 +1,5 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS scope;
+ALTER TABLE tokens DROP COLUMN IF EXISTS client_refer;
+
+DROP TABLE IF EXISTS authorization_codes;
+DROP TABLE IF EXISTS o_auth_clients;
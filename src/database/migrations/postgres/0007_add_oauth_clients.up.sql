@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS o_auth_clients (
+    id bigserial PRIMARY KEY,
+    client_id text,
+    secret_hash text,
+    name text,
+    confidential boolean,
+    redirect_uris text,
+    scopes text,
+    created_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_o_auth_clients_client_id ON o_auth_clients (client_id);
+
+CREATE TABLE IF NOT EXISTS authorization_codes (
+    id bigserial PRIMARY KEY,
+    code_hash text,
+    client_refer bigint,
+    user_refer bigint,
+    redirect_uri text,
+    scope text,
+    code_challenge text,
+    code_challenge_method text,
+    expires_at timestamptz,
+    used_at timestamptz,
+    family text,
+    CONSTRAINT fk_authorization_codes_client FOREIGN KEY (client_refer) REFERENCES o_auth_clients (id) ON DELETE CASCADE,
+    CONSTRAINT fk_authorization_codes_user FOREIGN KEY (user_refer) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_authorization_codes_code_hash ON authorization_codes (code_hash);
+CREATE INDEX IF NOT EXISTS idx_authorization_codes_client_refer ON authorization_codes (client_refer);
+
+-- Tokens issued to a client are limited to the scope it was granted
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS client_refer bigint CONSTRAINT fk_tokens_client REFERENCES o_auth_clients (id) ON DELETE CASCADE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS scope text;
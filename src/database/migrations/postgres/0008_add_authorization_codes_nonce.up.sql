@@ -0,0 +1,2 @@
+-- Nonce of the OpenID Connect request, returned in the ID token issued for the code
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS nonce text;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id bigserial PRIMARY KEY,
+    user_refer bigint,
+    name text,
+    token_hash text,
+    scopes text,
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    created_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_refer ON personal_access_tokens (user_refer);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_personal_access_tokens_token_hash ON personal_access_tokens (token_hash);
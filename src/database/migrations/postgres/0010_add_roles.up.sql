@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text,
+    description text,
+    permissions text,
+    built_in boolean,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_roles_name ON roles (name);
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id bigserial PRIMARY KEY,
+    actor_refer bigint,
+    action text,
+    target_type text,
+    target_id bigint,
+    details text,
+    created_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor_refer ON audit_logs (actor_refer);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created_at ON audit_logs (created_at);
+
+-- Built-in roles, with the ids the role of users already had. Once created, they're only changed through the API.
+INSERT INTO roles (id, name, description, permissions, built_in, created_at, updated_at) VALUES
+    (1, 'admin', 'Manages every user, role and OAuth client',
+        '["users:read","users:read:self","users:write","users:write:self","oauth-clients:read","oauth-clients:write","roles:read","roles:write"]',
+        true, now(), now()),
+    (2, 'standard', 'Manages its own account', '["users:read:self","users:write:self"]', true, now(), now())
+ON CONFLICT DO NOTHING;
+
+-- Roles created later get ids after the built-in ones, which were inserted with explicit ids
+SELECT setval(pg_get_serial_sequence('roles', 'id'), (SELECT MAX(id) FROM roles));
@@ -0,0 +1,5 @@
+ALTER TABLE users DROP COLUMN IF EXISTS active_organization_refer;
+
+DROP TABLE IF EXISTS organization_invitations;
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
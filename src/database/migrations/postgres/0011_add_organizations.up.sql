@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    name text,
+    created_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS memberships (
+    id bigserial PRIMARY KEY,
+    organization_refer bigint,
+    user_refer bigint,
+    role text,
+    created_at timestamptz,
+    CONSTRAINT fk_organizations_memberships FOREIGN KEY (organization_refer) REFERENCES organizations (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    CONSTRAINT fk_users_memberships FOREIGN KEY (user_refer) REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_membership ON memberships (organization_refer, user_refer);
+CREATE INDEX IF NOT EXISTS idx_memberships_user_refer ON memberships (user_refer);
+
+CREATE TABLE IF NOT EXISTS organization_invitations (
+    id bigserial PRIMARY KEY,
+    organization_refer bigint,
+    email text,
+    role text,
+    token_hash text,
+    invited_by_refer bigint,
+    expires_at timestamptz,
+    accepted_at timestamptz,
+    created_at timestamptz,
+    CONSTRAINT fk_organization_invitations_organization FOREIGN KEY (organization_refer) REFERENCES organizations (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_invitations_organization_refer ON organization_invitations (organization_refer);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_invitations_token_hash ON organization_invitations (token_hash);
+
+-- Organization the next tokens of the user act within
+ALTER TABLE users ADD COLUMN IF NOT EXISTS active_organization_refer bigint CONSTRAINT fk_users_active_organization REFERENCES organizations (id) ON DELETE SET NULL;
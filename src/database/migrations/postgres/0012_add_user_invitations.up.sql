@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_invitations (
+    id bigserial PRIMARY KEY,
+    email text,
+    role bigint,
+    token_hash text,
+    invited_by_refer bigint,
+    expires_at timestamptz,
+    accepted_at timestamptz,
+    sent_at timestamptz,
+    created_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_invitations_email ON user_invitations (email);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_invitations_token_hash ON user_invitations (token_hash);
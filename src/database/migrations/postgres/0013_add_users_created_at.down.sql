@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_first_name;
+DROP INDEX IF EXISTS idx_users_email;
+DROP INDEX IF EXISTS idx_users_created_at;
+
+ALTER TABLE users DROP COLUMN IF EXISTS created_at;
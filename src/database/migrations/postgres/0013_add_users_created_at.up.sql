@@ -0,0 +1,9 @@
+-- Users can be filtered and sorted by the time they were created at, as well as by email and first name.
+-- Existing users get the time of the migration, since the one they were created at is unknown.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at timestamptz;
+
+UPDATE users SET created_at = now() WHERE created_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users (created_at);
+CREATE INDEX IF NOT EXISTS idx_users_email ON users (email);
+CREATE INDEX IF NOT EXISTS idx_users_first_name ON users (first_name);
@@ -0,0 +1,3 @@
+-- Every update of a user moves it to the next version, and only applies to the version it was read at,
+-- so that concurrent updates don't overwrite each other. The version is the ETag of the user.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- Emails are unique, so that two concurrent requests can't register the same one. Databases that already have
+-- users sharing an email must merge or rename them before running this migration.
+DROP INDEX IF EXISTS idx_users_email;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
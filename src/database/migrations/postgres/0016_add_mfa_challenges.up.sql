@@ -0,0 +1,13 @@
+-- Logins of users with two-factor authentication wait for a code on a stored challenge, which counts the attempts
+-- to answer it, so that codes can't be guessed by retrying the same challenge.
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    id bigserial PRIMARY KEY,
+    user_refer bigint,
+    token_hash text,
+    attempts bigint NOT NULL DEFAULT 0,
+    expires_at timestamptz,
+    CONSTRAINT fk_users_mfa_challenges FOREIGN KEY (user_refer) REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_user_refer ON mfa_challenges (user_refer);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mfa_challenges_token_hash ON mfa_challenges (token_hash);
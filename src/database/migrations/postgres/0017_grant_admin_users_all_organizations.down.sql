@@ -0,0 +1,2 @@
+UPDATE roles SET permissions = replace(replace(replace(permissions, ',"users:all-organizations"', ''), '"users:all-organizations",', ''), '"users:all-organizations"', ''), updated_at = now()
+WHERE permissions LIKE '%"users:all-organizations"%';
@@ -0,0 +1,4 @@
+-- Reaching the users of every organization is a permission of its own, which the admin role had implicitly.
+-- Permissions are a JSON array, so the new one is added before its closing bracket.
+UPDATE roles SET permissions = substr(permissions, 1, length(permissions) - 1) || ',"users:all-organizations"]', updated_at = now()
+WHERE id = 1 AND permissions NOT LIKE '%"users:all-organizations"%';
@@ -0,0 +1,34 @@
+-- SQLite version of the migration, since it has no sequences to move past the ids of the built-in roles.
+
+CREATE TABLE IF NOT EXISTS roles (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name text,
+    description text,
+    permissions text,
+    built_in numeric,
+    created_at datetime,
+    updated_at datetime
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_roles_name ON roles (name);
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    actor_refer integer,
+    action text,
+    target_type text,
+    target_id integer,
+    details text,
+    created_at datetime
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor_refer ON audit_logs (actor_refer);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created_at ON audit_logs (created_at);
+
+-- Built-in roles, with the ids the role of users already had. Once created, they're only changed through the API.
+-- AUTOINCREMENT keeps the ids of the roles created later after theirs.
+INSERT OR IGNORE INTO roles (id, name, description, permissions, built_in, created_at, updated_at) VALUES
+    (1, 'admin', 'Manages every user, role and OAuth client',
+        '["users:read","users:read:self","users:write","users:write:self","oauth-clients:read","oauth-clients:write","roles:read","roles:write"]',
+        1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
+    (2, 'standard', 'Manages its own account', '["users:read:self","users:write:self"]', 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);
@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"errors"
+	"gocker-api/auth"
 	"gocker-api/models"
 	"gocker-api/services"
 	"gocker-api/utils"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"strings"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 )
 
@@ -15,14 +20,21 @@ type AuthenticationResponse struct {
 	RefreshTokenValue string `json:"refresh-token"`
 }
 
-type TokenResponse struct {
-	TokenValue string `json:"token"`
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
 }
 
-func InitAuthRoutes(router *mux.Router) {
-	router.HandleFunc("/api/v1/auth/register", utils.ParseToHandlerFunc(handleRegisterUser)).Methods("POST")
-	router.HandleFunc("/api/v1/auth/authenticate", utils.ParseToHandlerFunc(handleAuthenticateUser)).Methods("POST")
-	router.HandleFunc("/api/v1/auth/refresh-token", utils.ParseToHandlerFunc(handleRefreshToken)).Methods("POST")
+func (handler *Handler) InitAuthRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/auth/register", utils.ParseToHandlerFunc(handler.handleRegisterUser)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/authenticate", utils.ParseToHandlerFunc(handler.handleAuthenticateUser)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/refresh-token", utils.ParseToHandlerFunc(handler.handleRefreshToken)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/verify-email", utils.ParseToHandlerFunc(handler.handleVerifyEmail)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/verify-email/resend", utils.ParseToHandlerFunc(handler.handleResendVerification)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/password/forgot", utils.ParseToHandlerFunc(handler.handleForgotPassword)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/password/reset", utils.ParseToHandlerFunc(handler.handleResetPassword)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/logout", utils.ParseToHandlerFunc(handler.handleLogout)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/logout-all", utils.ParseToHandlerFunc(handler.handleLogoutAll)).Methods("POST")
 }
 
 func CreateResponseToken(token models.Token) AuthenticationResponse {
@@ -30,84 +42,227 @@ func CreateResponseToken(token models.Token) AuthenticationResponse {
 }
 
 // Function that creates a new user and returns its JWT token
-func handleRegisterUser(res http.ResponseWriter, req *http.Request) error {
+func (handler *Handler) handleRegisterUser(res http.ResponseWriter, req *http.Request) error {
 	var userBody services.UserBody
 
+	if services.GetRegistrationMode() != services.OpenRegistration {
+		return utils.WriteError(res, req, services.ErrRegistrationNotOpen)
+	}
+
 	// Handle body validation
 	if parseErr := utils.ReadJSON(req.Body, &userBody); parseErr != nil {
-		if validationErrs, ok := parseErr.(validator.ValidationErrors); ok {
-			validationErrors := make([]utils.ApiError, 0)
-
-			for _, validationErr := range validationErrs {
-				validationErrors = append(validationErrors, utils.ApiError{Error: "Field " + validationErr.Field() + " must be provided"})
-			}
-
-			return utils.WriteJSON(res, 400, validationErrors)
-		} else {
-			return utils.WriteJSON(res, 400, utils.ApiError{Error: "not valid json."})
-		}
+		return utils.WriteError(res, req, parseErr)
 	}
 
-	accessToken, refreshToken, err := services.RegisterUser(userBody)
+	accessToken, refreshToken, err := handler.services.RegisterUser(req.Context(), userBody, newSessionInfo(req))
 
 	if err != nil {
-		return utils.WriteJSON(res, 500, utils.ApiError{Error: err.Error()})
+		return utils.WriteError(res, req, err)
+	}
+
+	// No tokens are issued until the user verifies its email, if verification is required
+	if accessToken == nil {
+		return utils.WriteJSON(res, 201, map[string]string{"Success": "User successfully registered. Please, verify your email before authenticating."})
 	}
 
 	return utils.WriteJSON(res, 201, AuthenticationResponse{TokenValue: accessToken.TokenValue, RefreshTokenValue: refreshToken.TokenValue})
 }
 
 // Function that returns a user's JWT token, given its email and password
-func handleAuthenticateUser(res http.ResponseWriter, req *http.Request) error {
+func (handler *Handler) handleAuthenticateUser(res http.ResponseWriter, req *http.Request) error {
 	var userAuth services.UserAuthenticateBody
 
 	//Validate user auth body
 	if parseErr := utils.ReadJSON(req.Body, &userAuth); parseErr != nil {
-		if errors, ok := parseErr.(validator.ValidationErrors); ok {
-			validationErrors := make([]utils.ApiError, 0)
+		return utils.WriteError(res, req, parseErr)
+	}
 
-			for _, validationErr := range errors {
-				validationErrors = append(validationErrors, utils.ApiError{Error: "Field " + validationErr.Field() + " must be provided"})
-			}
+	accessToken, refreshToken, err := handler.services.AuthenticateUser(req.Context(), userAuth, newSessionInfo(req))
 
-			return utils.WriteJSON(res, 400, validationErrors)
-		} else {
-			return utils.WriteJSON(res, 400, utils.ApiError{Error: "not valid json."})
-		}
-	}
+	var mfaRequiredErr *services.MFARequiredError
 
-	accessToken, refreshToken, err := services.AuthenticateUser(userAuth)
+	if errors.As(err, &mfaRequiredErr) {
+		return utils.WriteJSON(res, 200, MFAChallengeResponse{MFARequired: true, MFAToken: mfaRequiredErr.ChallengeToken})
+	}
 
 	if err != nil {
-		return utils.WriteJSON(res, 500, utils.ApiError{Error: err.Error()})
+		return utils.WriteError(res, req, err)
 	}
 
 	return utils.WriteJSON(res, 200, AuthenticationResponse{TokenValue: accessToken.TokenValue, RefreshTokenValue: refreshToken.TokenValue})
 }
 
-func handleRefreshToken(res http.ResponseWriter, req *http.Request) error {
+// Function that exchanges a refresh token for a new access token and a new refresh token
+func (handler *Handler) handleRefreshToken(res http.ResponseWriter, req *http.Request) error {
 	var refreshTokenRequest services.RefreshTokenRequest
 
 	//Validate request body
 	if parseErr := utils.ReadJSON(req.Body, &refreshTokenRequest); parseErr != nil {
-		if errors, ok := parseErr.(validator.ValidationErrors); ok {
-			validationErrors := make([]utils.ApiError, 0)
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	accessToken, refreshToken, err := handler.services.RefreshToken(req.Context(), refreshTokenRequest, newSessionInfo(req))
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 201, AuthenticationResponse{TokenValue: accessToken.TokenValue, RefreshTokenValue: refreshToken.TokenValue})
+}
 
-			for _, validationErr := range errors {
-				validationErrors = append(validationErrors, utils.ApiError{Error: "Field " + validationErr.Field() + " must be provided"})
+// Function that marks a user's email as verified, given the token sent to it
+func (handler *Handler) handleVerifyEmail(res http.ResponseWriter, req *http.Request) error {
+	var verifyBody services.VerifyEmailBody
+
+	//Validate request body
+	if parseErr := utils.ReadJSON(req.Body, &verifyBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	if err := handler.services.VerifyEmail(req.Context(), verifyBody); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Email successfully verified."})
+}
+
+// Function that sends a new verification link to the given email. It responds the same whether
+// the email is registered or not, so it can't be used to find out which ones are.
+func (handler *Handler) handleResendVerification(res http.ResponseWriter, req *http.Request) error {
+	var resendBody services.ResendVerificationBody
+
+	//Validate request body
+	if parseErr := utils.ReadJSON(req.Body, &resendBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	handler.services.ResendVerificationEmail(req.Context(), resendBody)
+
+	return utils.WriteJSON(res, 202, map[string]string{"Success": "If the email is registered and not verified yet, a verification link has been sent to it."})
+}
+
+// Function that sends a password reset link to the given email. It responds the same whether
+// the email is registered or not, so it can't be used to find out which ones are.
+func (handler *Handler) handleForgotPassword(res http.ResponseWriter, req *http.Request) error {
+	var forgotBody services.ForgotPasswordBody
+
+	//Validate request body
+	if parseErr := utils.ReadJSON(req.Body, &forgotBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	handler.services.ForgotPassword(req.Context(), forgotBody)
+
+	return utils.WriteJSON(res, 202, map[string]string{"Success": "If the email is registered, a password reset link has been sent to it."})
+}
+
+// Function that sets a new password, given the token sent by email
+func (handler *Handler) handleResetPassword(res http.ResponseWriter, req *http.Request) error {
+	var resetBody services.ResetPasswordBody
+
+	//Validate request body
+	if parseErr := utils.ReadJSON(req.Body, &resetBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	if err := handler.services.ResetPassword(req.Context(), resetBody); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Password successfully reset. Please, authenticate again."})
+}
+
+// Function that revokes the access token used in the request and its paired refresh token
+func (handler *Handler) handleLogout(res http.ResponseWriter, req *http.Request) error {
+	token := auth.TokenFromContext(req.Context())
+
+	if token == nil {
+		return utils.WriteError(res, req, errMissingToken)
+	}
+
+	if err := handler.services.Logout(req.Context(), *token); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Successfully logged out."})
+}
+
+// Function that revokes every token of the user making the request
+func (handler *Handler) handleLogoutAll(res http.ResponseWriter, req *http.Request) error {
+	user := auth.UserFromContext(req.Context())
+
+	if user == nil {
+		return utils.WriteError(res, req, errMissingToken)
+	}
+
+	if err := handler.services.LogoutAll(req.Context(), *user); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Successfully logged out of every session."})
+}
+
+// AUX FUNCTIONS
+
+// Function that reads the metadata of the device making the request
+func newSessionInfo(req *http.Request) services.SessionInfo {
+	ipAddress, _, splitErr := net.SplitHostPort(req.RemoteAddr)
+
+	if splitErr != nil {
+		ipAddress = req.RemoteAddr
+	}
+
+	// Only the proxies set by TRUSTED_PROXIES are believed about the address they forward, since anyone else can
+	// send any X-Forwarded-For. Each proxy appends the address it got the request from, so the client is the last
+	// one that isn't a trusted proxy.
+	if trustedProxies := getTrustedProxies(); isTrustedProxy(trustedProxies, ipAddress) {
+		forwardedFor := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+
+		for i := len(forwardedFor) - 1; i >= 0; i-- {
+			if forwarded := strings.TrimSpace(forwardedFor[i]); forwarded != "" {
+				ipAddress = forwarded
+
+				if !isTrustedProxy(trustedProxies, forwarded) {
+					break
+				}
 			}
+		}
+	}
 
-			return utils.WriteJSON(res, 400, validationErrors)
-		} else {
-			return utils.WriteJSON(res, 400, utils.ApiError{Error: "not valid json."})
+	return services.SessionInfo{UserAgent: req.UserAgent(), IPAddress: ipAddress}
+}
+
+// Returns the addresses and networks of the proxies the API runs behind, set by TRUSTED_PROXIES as comma
+// separated IPs or CIDRs (e.g. 10.0.0.1,172.16.0.0/12). Values that are neither are ignored.
+func getTrustedProxies() []netip.Prefix {
+	var trustedProxies []netip.Prefix
+
+	for _, value := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		value = strings.TrimSpace(value)
+
+		if prefix, parseErr := netip.ParsePrefix(value); parseErr == nil {
+			trustedProxies = append(trustedProxies, prefix.Masked())
+		} else if address, parseErr := netip.ParseAddr(value); parseErr == nil {
+			trustedProxies = append(trustedProxies, netip.PrefixFrom(address, address.BitLen()))
 		}
 	}
 
-	accessToken, err := services.RefreshToken(refreshTokenRequest)
+	return trustedProxies
+}
+
+// Returns true if the given address belongs to one of the trusted proxies
+func isTrustedProxy(trustedProxies []netip.Prefix, ipAddress string) bool {
+	address, parseErr := netip.ParseAddr(ipAddress)
 
-	if err != nil {
-		return utils.WriteJSON(res, 400, utils.ApiError{Error: err.Error()})
+	if parseErr != nil {
+		return false
+	}
+
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(address.Unmap()) {
+			return true
+		}
 	}
 
-	return utils.WriteJSON(res, 201, TokenResponse{TokenValue: accessToken.TokenValue})
+	return false
 }
@@ -1,71 +1,394 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gocker-api/auth"
+	"gocker-api/mail"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/storage"
+	"gocker-api/storage/storagetest"
+	"gocker-api/totp"
 	"gocker-api/utils"
 	"io"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"net/url"
 	"strings"
 	"testing"
-
-	"github.com/joho/godotenv"
+	"time"
 )
 
 func TestAuth(t *testing.T) {
-	var tests = []struct {
-		endpoint     string
-		method       string
-		expectedCode int
-		body         io.Reader
-		handler      utils.APIFunc
-	}{
-		// test registering a correct user
-		{"/api/v1/auth/register", "POST", 201,
-			strings.NewReader(`{"first_name": "test", "email": "testauth@gmail.com", "password": "testpass"}`),
-			handleRegisterUser,
-		},
-		// test registering an incorrect user
-		{"/api/v1/auth/register", "POST", 400,
-			strings.NewReader(`{"first_name": "test"}`),
-			handleRegisterUser,
-		},
-		// test authenticating an existing user
-		{"/api/v1/auth/authenticate", "POST", 200,
-			strings.NewReader(`{"email": "testauth@gmail.com", "password": "testpass"}`),
-			handleAuthenticateUser,
-		},
-		// test authenticating a user with wrong password
-		{"/api/v1/auth/authenticate", "POST", 500,
-			strings.NewReader(`{"email": "testauth@gmail.com", "password": "wrongpass"}`),
-			handleAuthenticateUser,
-		},
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		var tests = []struct {
+			endpoint     string
+			method       string
+			expectedCode int
+			body         io.Reader
+			handler      utils.APIFunc
+		}{
+			// test registering a correct user
+			{"/api/v1/auth/register", "POST", 201,
+				strings.NewReader(`{"first_name": "test", "email": "testauth@gmail.com", "password": "testpass1"}`),
+				handler.handleRegisterUser,
+			},
+			// test registering an incorrect user
+			{"/api/v1/auth/register", "POST", 400,
+				strings.NewReader(`{"first_name": "test"}`),
+				handler.handleRegisterUser,
+			},
+			// test authenticating an existing user
+			{"/api/v1/auth/authenticate", "POST", 200,
+				strings.NewReader(`{"email": "testauth@gmail.com", "password": "testpass1"}`),
+				handler.handleAuthenticateUser,
+			},
+			// test authenticating a user with wrong password
+			{"/api/v1/auth/authenticate", "POST", 401,
+				strings.NewReader(`{"email": "testauth@gmail.com", "password": "wrongpass"}`),
+				handler.handleAuthenticateUser,
+			},
+		}
+
+		for _, test := range tests {
+			req, reqErr := http.NewRequest(test.method, test.endpoint, test.body)
+
+			if reqErr != nil {
+				t.Fatal(reqErr)
+			}
+
+			rr := httptest.NewRecorder()
+			handlerFunc := http.HandlerFunc(utils.ParseToHandlerFunc(test.handler))
+
+			handlerFunc.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedCode {
+				t.Errorf("%s: wrong status code. expected %d and got %d, with error %s", test.endpoint, test.expectedCode, rr.Code, rr.Body.String())
+			}
+		}
+	})
+}
+
+func TestRefreshToken(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		ctx := context.Background()
+
+		refresh := func(refreshToken string) (*httptest.ResponseRecorder, AuthenticationResponse) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/api/v1/auth/refresh-token", strings.NewReader(`{"refresh_token": "`+refreshToken+`"}`))
+			http.HandlerFunc(utils.ParseToHandlerFunc(handler.handleRefreshToken)).ServeHTTP(rr, req)
+
+			var response AuthenticationResponse
+			json.Unmarshal(rr.Body.Bytes(), &response)
+
+			return rr, response
+		}
+
+		_, firstRefreshToken, loginErr := handler.services.AuthenticateUser(ctx, services.UserAuthenticateBody{Email: testAdminEmail, Password: testAdminPassword}, services.SessionInfo{})
+
+		if loginErr != nil {
+			t.Fatal(loginErr)
+		}
+
+		// rotation: a new pair of the same family replaces the used one
+		rr, rotated := refresh(firstRefreshToken.TokenValue)
+
+		if rr.Code != 201 || rotated.RefreshTokenValue == "" || rotated.RefreshTokenValue == firstRefreshToken.TokenValue {
+			t.Fatalf("expected a new refresh token and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		rotatedToken, getErr := handler.services.GetTokenByValue(ctx, rotated.RefreshTokenValue)
+
+		if getErr != nil || rotatedToken.Family != firstRefreshToken.Family || rotatedToken.ParentRefer == nil || *rotatedToken.ParentRefer != firstRefreshToken.ID {
+			t.Errorf("expected the new refresh token to replace the used one in its family and got %+v (%v)", rotatedToken, getErr)
+		}
+
+		// reuse: replaying the used token revokes the whole family, including the token that replaced it
+		if rr, _ := refresh(firstRefreshToken.TokenValue); rr.Code != 401 || !strings.Contains(rr.Body.String(), "refresh_token_reused") {
+			t.Errorf("expected the reuse to be detected and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		if rr, _ := refresh(rotated.RefreshTokenValue); rr.Code != 401 {
+			t.Errorf("expected the family to be revoked after the reuse and got %d", rr.Code)
+		}
+
+		// legacy adoption: tokens issued before families existed start one of their own, and their reuse
+		// only revokes that one
+		legacyTokens := make([]*models.Token, 2)
+
+		for i := range legacyTokens {
+			tokenValue, generateErr := auth.GenerateToken(*admin, models.Refresh)
+
+			if generateErr != nil {
+				t.Fatal(generateErr)
+			}
+
+			legacyTokens[i] = &models.Token{TokenValue: tokenValue, UserRefer: &admin.ID, Kind: models.Refresh}
+
+			if _, createErr := handler.services.CreateToken(ctx, legacyTokens[i]); createErr != nil {
+				t.Fatal(createErr)
+			}
+		}
+
+		rr, adopted := refresh(legacyTokens[0].TokenValue)
+
+		if rr.Code != 201 {
+			t.Fatalf("expected the legacy token to be refreshed and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		adoptedToken, _ := handler.services.GetTokenByValue(ctx, adopted.RefreshTokenValue)
+
+		if adoptedToken == nil || adoptedToken.Family == "" {
+			t.Fatalf("expected the legacy token to start a family and got %+v", adoptedToken)
+		}
+
+		if _, sessionErr := handler.services.GetSessionByToken(*adoptedToken); sessionErr != nil {
+			t.Errorf("expected a session for the adopted family and got %v", sessionErr)
+		}
+
+		if rr, _ := refresh(legacyTokens[0].TokenValue); rr.Code != 401 {
+			t.Errorf("expected the reuse of the legacy token to be detected and got %d", rr.Code)
+		}
+
+		if _, getErr := handler.services.GetTokenByValue(ctx, legacyTokens[1].TokenValue); getErr != nil {
+			t.Errorf("expected other legacy tokens to survive the reuse and got %v", getErr)
+		}
+
+		// the session an adopted token starts counts towards the maximum, like the one of a new login
+		_, loginRefreshToken, loginErr := handler.services.AuthenticateUser(ctx, services.UserAuthenticateBody{Email: testAdminEmail, Password: testAdminPassword}, services.SessionInfo{})
+
+		if loginErr != nil {
+			t.Fatal(loginErr)
+		}
+
+		t.Setenv("MAX_SESSIONS_PER_USER", "1")
+
+		if rr, _ := refresh(legacyTokens[1].TokenValue); rr.Code != 201 {
+			t.Errorf("expected another legacy token to be refreshed and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		if _, sessionErr := handler.services.GetSessionByToken(*loginRefreshToken); sessionErr == nil {
+			t.Error("expected the adoption to end the least recently used session once the maximum is reached")
+		}
+	})
+}
+
+// Every attempt to answer a two-factor authentication challenge counts, and it can't be answered after too many
+func TestVerifyMFA(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		ctx := context.Background()
+		enrollment, enrollErr := handler.services.EnrollTOTP(ctx, admin)
+
+		if enrollErr != nil {
+			t.Fatal(enrollErr)
+		}
+
+		code, _ := totp.Code(enrollment.Secret, totp.Counter(time.Now()), totp.Digits)
+		recoveryCodes, confirmErr := handler.services.ConfirmTOTP(ctx, admin, code)
+
+		if confirmErr != nil {
+			t.Fatal(confirmErr)
+		}
+
+		challenge := func() string {
+			login := services.UserAuthenticateBody{Email: testAdminEmail, Password: testAdminPassword}
+			_, _, authErr := handler.services.AuthenticateUser(ctx, login, services.SessionInfo{})
+
+			var mfaErr *services.MFARequiredError
+
+			if !errors.As(authErr, &mfaErr) {
+				t.Fatalf("expected a two-factor authentication challenge and got %v", authErr)
+			}
+
+			return mfaErr.ChallengeToken
+		}
+
+		verify := func(challengeToken string, code string) *httptest.ResponseRecorder {
+			body, _ := json.Marshal(services.MFAVerifyBody{MFAToken: challengeToken, Code: code})
+			req := httptest.NewRequest("POST", "/api/v1/auth/mfa/verify", strings.NewReader(string(body)))
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(utils.ParseToHandlerFunc(handler.handleVerifyMFA)).ServeHTTP(rr, req)
+
+			return rr
+		}
+
+		challengeToken := challenge()
+
+		for attempt := 1; attempt <= 5; attempt++ {
+			if rr := verify(challengeToken, "wrong-code"); rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "invalid_mfa_code") {
+				t.Errorf("attempt %d: expected the wrong code to be rejected and got %d %s", attempt, rr.Code, rr.Body.String())
+			}
+		}
+
+		if rr := verify(challengeToken, recoveryCodes[0]); rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "too_many_mfa_attempts") {
+			t.Errorf("expected the challenge not to be answerable after 5 attempts and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		// a new challenge can be answered, but only once
+		challengeToken = challenge()
+
+		if rr := verify(challengeToken, recoveryCodes[0]); rr.Code != http.StatusOK {
+			t.Errorf("expected a new challenge to be answered and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		if rr := verify(challengeToken, recoveryCodes[1]); rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "invalid_mfa_token") {
+			t.Errorf("expected an answered challenge not to be answerable again and got %d %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+// Users that haven't verified their email can ask for a new link, and the response doesn't tell whether the email is registered
+func TestResendVerification(t *testing.T) {
+	t.Setenv("SECRET_KEY", "test-secret-key")
+
+	storagetest.ForEachBackend(t, func(t *testing.T, repositories *storage.Repositories) {
+		mailer := &mail.MemoryMailer{}
+		handler := NewHandler(services.New(repositories, mailer))
+		email := "unverified@gmail.com"
+
+		if _, createErr := handler.services.CreateUser(context.Background(), services.UserBody{FirstName: "unverified", Email: email, Password: "testpass1"}); createErr != nil {
+			t.Fatal(createErr)
+		}
+
+		for _, to := range []string{email, "unknown@gmail.com"} {
+			req := httptest.NewRequest("POST", "/api/v1/auth/verify-email/resend", strings.NewReader(`{"email": "`+to+`"}`))
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(utils.ParseToHandlerFunc(handler.handleResendVerification)).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusAccepted {
+				t.Errorf("expected 202 asking for a link for %s and got %d", to, rr.Code)
+			}
+		}
+
+		if message := waitForMessage(mailer, email); !strings.Contains(message.Body, "?token=") {
+			t.Errorf("expected a verification link to be sent and got %q", message.Body)
+		}
+	})
+}
+
+// A password reset ends every session of the user and revokes its personal access tokens, since whoever
+// knew the old password may have created them
+func TestResetPassword(t *testing.T) {
+	t.Setenv("SECRET_KEY", "test-secret-key")
+
+	storagetest.ForEachBackend(t, func(t *testing.T, repositories *storage.Repositories) {
+		ctx := context.Background()
+		mailer := &mail.MemoryMailer{}
+		handler := NewHandler(services.New(repositories, mailer))
+		email := "reset@gmail.com"
+		user, createErr := handler.services.CreateUser(ctx, services.UserBody{FirstName: "reset", Email: email, Password: "testpass1"})
+
+		if createErr != nil {
+			t.Fatal(createErr)
+		}
+
+		_, refreshToken, loginErr := handler.services.AuthenticateUser(ctx, services.UserAuthenticateBody{Email: email, Password: "testpass1"}, services.SessionInfo{})
+
+		if loginErr != nil {
+			t.Fatal(loginErr)
+		}
+
+		if _, _, tokenErr := handler.services.CreatePersonalAccessToken(*user, services.PersonalAccessTokenBody{Name: "ci", Scope: services.UsersReadScope}); tokenErr != nil {
+			t.Fatal(tokenErr)
+		}
+
+		handler.services.ForgotPassword(ctx, services.ForgotPasswordBody{Email: email})
+
+		_, link, found := strings.Cut(waitForMessage(mailer, email).Body, "?token=")
+
+		if !found {
+			t.Fatal("expected a password reset link to be sent")
+		}
+
+		token, _ := url.QueryUnescape(strings.Fields(link)[0])
+
+		if resetErr := handler.services.ResetPassword(ctx, services.ResetPasswordBody{Token: token, Password: "newpass12"}); resetErr != nil {
+			t.Fatal(resetErr)
+		}
+
+		if _, sessionErr := handler.services.GetSessionByToken(*refreshToken); sessionErr == nil {
+			t.Error("expected the sessions of the user to be ended")
+		}
+
+		if tokens, _ := handler.services.GetPersonalAccessTokens(*user); len(tokens) != 0 {
+			t.Errorf("expected the personal access tokens of the user to be revoked and got %d", len(tokens))
+		}
+	})
+}
+
+// Returns the last email sent to the given address, waiting for a while for emails sent in the background
+func waitForMessage(mailer *mail.MemoryMailer, to string) mail.Message {
+	deadline := time.Now().Add(2 * time.Second)
+	message, sent := mailer.LastMessageTo(to)
+
+	for !sent && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		message, sent = mailer.LastMessageTo(to)
 	}
 
-	err := godotenv.Load("../.env")
+	return message
+}
+
+// The forwarded client address is only believed when the request comes through a trusted proxy
+func TestNewSessionInfo(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1, 172.16.0.0/12")
 
-	if err != nil {
-		t.Fatal(err)
+	var tests = []struct {
+		remoteAddr   string
+		forwardedFor string
+		expectedIP   string
+	}{
+		{"203.0.113.7:1234", "", "203.0.113.7"},
+		// clients can't spoof their address by sending the header themselves
+		{"203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		// addresses the client prepends are skipped, and so are the ones of other trusted proxies
+		{"10.0.0.1:1234", "192.0.2.9, 198.51.100.1, 172.16.5.5", "198.51.100.1"},
+		{"172.20.0.3:1234", "172.16.5.5", "172.16.5.5"},
 	}
 
 	for _, test := range tests {
-		req, reqErr := http.NewRequest(test.method, test.endpoint, test.body)
+		req := httptest.NewRequest("GET", "/api/v1/auth/authenticate", nil)
+		req.RemoteAddr = test.remoteAddr
 
-		if reqErr != nil {
-			t.Fatal(reqErr)
+		if test.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", test.forwardedFor)
 		}
 
-		req.Header.Add("Authorization", os.Getenv("TEST_TOKEN"))
+		if info := newSessionInfo(req); info.IPAddress != test.expectedIP {
+			t.Errorf("from %s forwarding %q: expected %s and got %s", test.remoteAddr, test.forwardedFor, test.expectedIP, info.IPAddress)
+		}
+	}
+}
+
+// Logging in with an unknown email takes about as long as with a wrong password, so timing doesn't tell which emails are registered
+func TestAuthenticateTiming(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		ctx := context.Background()
 
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(utils.ParseToHandlerFunc(test.handler))
+		// the fastest of a few attempts, to leave out pauses unrelated to the login
+		fastest := func(email string) time.Duration {
+			var fastest time.Duration
 
-		handler.ServeHTTP(rr, req)
+			for i := 0; i < 3; i++ {
+				start := time.Now()
+				handler.services.AuthenticateUser(ctx, services.UserAuthenticateBody{Email: email, Password: "wrongpass1"}, services.SessionInfo{})
 
-		if rr.Code != test.expectedCode {
-			t.Errorf("wrong status code. expected %d and got %d, with error %s", test.expectedCode, rr.Code, rr.Body.String())
+				if elapsed := time.Since(start); i == 0 || elapsed < fastest {
+					fastest = elapsed
+				}
+			}
+
+			return fastest
 		}
 
-	}
+		known, unknown := fastest(testAdminEmail), fastest("unknown@gmail.com")
 
+		if unknown < known/4 {
+			t.Errorf("expected an unknown email to take about as long as a known one and took %s against %s", unknown, known)
+		}
+	})
 }
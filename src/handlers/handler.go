@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"gocker-api/services"
+	"gocker-api/utils"
+)
+
+var (
+	errMissingToken      = services.ErrMissingToken
+	errUserTokenRequired = utils.NewError(utils.KindForbidden, "user_token_required", "a user authorization token must be provided")
+)
+
+// Handlers of every route of the API, which call the services they're given
+type Handler struct {
+	services *services.Services
+}
+
+func NewHandler(services *services.Services) *Handler {
+	return &Handler{services: services}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"gocker-api/auth"
+	"gocker-api/mail"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/storage"
+	"gocker-api/storage/storagetest"
+	"net/http"
+	"testing"
+)
+
+const (
+	testAdminEmail    = "admin@gmail.com"
+	testAdminPassword = "adminpass"
+)
+
+// Runs the test once for every storage backend, with a handler on an empty store of its own
+// and an admin user to make requests as
+func forEachBackend(t *testing.T, test func(t *testing.T, handler *Handler, admin *models.User)) {
+	t.Setenv("SECRET_KEY", "test-secret-key")
+	t.Setenv("ADMIN_EMAIL", testAdminEmail)
+
+	storagetest.ForEachBackend(t, func(t *testing.T, repositories *storage.Repositories) {
+		handler := NewHandler(services.New(repositories, &mail.MemoryMailer{}))
+
+		admin, createErr := handler.services.CreateUser(context.Background(), services.UserBody{
+			FirstName: "admin",
+			Email:     testAdminEmail,
+			Password:  testAdminPassword,
+		})
+
+		if createErr != nil {
+			t.Fatal(createErr)
+		}
+
+		test(t, handler, admin)
+	})
+}
+
+// Returns a copy of the request authenticated as the given user, like the auth middleware leaves it
+func authenticateRequest(req *http.Request, user *models.User) *http.Request {
+	return req.WithContext(auth.NewContext(req.Context(), user, &models.Token{UserRefer: &user.ID, Kind: models.Access}))
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"gocker-api/auth"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (handler *Handler) InitMFARoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/auth/mfa/totp/enroll", utils.ParseToHandlerFunc(handler.handleEnrollTOTP)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/mfa/totp/confirm", utils.ParseToHandlerFunc(handler.handleConfirmTOTP)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/mfa/totp/disable", utils.ParseToHandlerFunc(handler.handleDisableTOTP)).Methods("POST")
+	router.HandleFunc("/api/v1/auth/mfa/verify", utils.ParseToHandlerFunc(handler.handleVerifyMFA)).Methods("POST")
+}
+
+// Function that starts the TOTP enrollment of the user making the request
+func (handler *Handler) handleEnrollTOTP(res http.ResponseWriter, req *http.Request) error {
+	user := auth.UserFromContext(req.Context())
+
+	if user == nil {
+		return utils.WriteError(res, req, errMissingToken)
+	}
+
+	enrollment, err := handler.services.EnrollTOTP(req.Context(), user)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 201, TOTPEnrollmentResponse{Secret: enrollment.Secret, URI: enrollment.URI})
+}
+
+// Function that confirms the TOTP enrollment with a first code, returning the recovery codes
+func (handler *Handler) handleConfirmTOTP(res http.ResponseWriter, req *http.Request) error {
+	var codeBody services.MFACodeBody
+	user := auth.UserFromContext(req.Context())
+
+	if user == nil {
+		return utils.WriteError(res, req, errMissingToken)
+	}
+
+	//Validate request body
+	if parseErr := utils.ReadJSON(req.Body, &codeBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	recoveryCodes, err := handler.services.ConfirmTOTP(req.Context(), user, codeBody.Code)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+}
+
+// Function that disables two-factor authentication for the user making the request
+func (handler *Handler) handleDisableTOTP(res http.ResponseWriter, req *http.Request) error {
+	var codeBody services.MFACodeBody
+	user := auth.UserFromContext(req.Context())
+
+	if user == nil {
+		return utils.WriteError(res, req, errMissingToken)
+	}
+
+	//Validate request body
+	if parseErr := utils.ReadJSON(req.Body, &codeBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	if err := handler.services.DisableTOTP(req.Context(), user, codeBody.Code); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Two-factor authentication successfully disabled."})
+}
+
+// Function that exchanges the challenge token returned by authenticate and a code for the user's tokens
+func (handler *Handler) handleVerifyMFA(res http.ResponseWriter, req *http.Request) error {
+	var verifyBody services.MFAVerifyBody
+
+	//Validate request body
+	if parseErr := utils.ReadJSON(req.Body, &verifyBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	accessToken, refreshToken, err := handler.services.VerifyMFA(req.Context(), verifyBody, newSessionInfo(req))
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, AuthenticationResponse{TokenValue: accessToken.TokenValue, RefreshTokenValue: refreshToken.TokenValue})
+}
@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"errors"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type ResponseOAuthClient struct {
+	ID           uint      `json:"id"`
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+	Name         string    `json:"name"`
+	Confidential bool      `json:"confidential"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scope        string    `json:"scope"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Successful token response, as described in RFC 6749 section 5.1
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// Introspection response, as described in RFC 7662 section 2.2
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+}
+
+// Error response, as described in RFC 6749 section 5.2
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func CreateResponseOAuthClient(client models.OAuthClient, secret string) ResponseOAuthClient {
+	return ResponseOAuthClient{
+		ID:           client.ID,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+		Name:         client.Name,
+		Confidential: client.Confidential,
+		RedirectURIs: client.RedirectURIs,
+		Scope:        client.Scopes,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+func (handler *Handler) InitOAuthRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/oauth/clients", handler.requirePermission(models.OAuthClientsReadPermission, handler.handleGetOAuthClients)).Methods("GET")
+	router.HandleFunc("/api/v1/oauth/clients", handler.requirePermission(models.OAuthClientsWritePermission, handler.handleCreateOAuthClient)).Methods("POST")
+	router.HandleFunc("/api/v1/oauth/clients/{id}", handler.requirePermission(models.OAuthClientsWritePermission, handler.handleDeleteOAuthClient)).Methods("DELETE")
+	router.HandleFunc("/oauth/authorize", utils.ParseToHandlerFunc(handler.handleGetConsent)).Methods("GET")
+	router.HandleFunc("/oauth/authorize", utils.ParseToHandlerFunc(handler.handleAuthorize)).Methods("POST")
+	router.HandleFunc("/oauth/login", utils.ParseToHandlerFunc(handler.handleGetLogin)).Methods("GET")
+	router.HandleFunc("/oauth/login", utils.ParseToHandlerFunc(handler.handleLogin)).Methods("POST")
+	router.HandleFunc("/oauth/token", utils.ParseToHandlerFunc(handler.handleToken)).Methods("POST")
+	router.HandleFunc("/oauth/introspect", utils.ParseToHandlerFunc(handler.handleIntrospect)).Methods("POST")
+	router.HandleFunc("/oauth/revoke", utils.ParseToHandlerFunc(handler.handleRevoke)).Methods("POST")
+}
+
+func (handler *Handler) handleGetOAuthClients(res http.ResponseWriter, req *http.Request) error {
+	clients, err := handler.services.GetAllOAuthClients()
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	responseClients := make([]ResponseOAuthClient, 0, len(clients))
+
+	for _, client := range clients {
+		responseClients = append(responseClients, CreateResponseOAuthClient(*client, ""))
+	}
+
+	return utils.WriteJSON(res, 200, responseClients)
+}
+
+// Function that registers a new OAuth client. Its secret is only returned in this response.
+func (handler *Handler) handleCreateOAuthClient(res http.ResponseWriter, req *http.Request) error {
+	var clientBody services.OAuthClientBody
+
+	//Validate request body
+	if parseErr := utils.ReadJSON(req.Body, &clientBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	client, secret, err := handler.services.RegisterOAuthClient(clientBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 201, CreateResponseOAuthClient(*client, secret))
+}
+
+func (handler *Handler) handleDeleteOAuthClient(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if err := handler.services.DeleteOAuthClient(id); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Client successfully deleted."})
+}
+
+// Function that validates an authorization request and shows the user the page to consent to it.
+// Users are authenticated by their login session, so those who have none are sent to the login page first.
+func (handler *Handler) handleGetConsent(res http.ResponseWriter, req *http.Request) error {
+	request := readAuthorizationRequest(req)
+	client, redirectURI, err := handler.services.ValidateAuthorizationRequest(request)
+
+	if err != nil {
+		return writeAuthorizationError(res, req, redirectURI, request.State, err)
+	}
+
+	if handler.loginSessionUser(req) == nil {
+		return redirectToLogin(res, req, request)
+	}
+
+	scope := request.Scope
+
+	if scope == "" {
+		scope = client.Scopes
+	}
+
+	return writePage(res, 200, consentPage, consentPageData{
+		ClientName: client.Name,
+		Scopes:     strings.Fields(scope),
+		Params:     authorizationParams(request),
+	})
+}
+
+// Function that records the user's consent decision, redirecting it to the client.
+// The consent parameter must be approve to issue an authorization code.
+func (handler *Handler) handleAuthorize(res http.ResponseWriter, req *http.Request) error {
+	if !sameOrigin(req) {
+		return utils.WriteError(res, req, errCrossOriginRequest)
+	}
+
+	request := readAuthorizationRequest(req)
+	user := handler.loginSessionUser(req)
+
+	if user == nil {
+		return redirectToLogin(res, req, request)
+	}
+
+	var redirectTo string
+	var err error
+
+	if req.Form.Get("consent") == "approve" {
+		redirectTo, err = handler.services.Authorize(*user, request)
+	} else {
+		redirectTo, err = handler.services.DenyAuthorization(request)
+	}
+
+	if err != nil {
+		return writeAuthorizationError(res, req, redirectTo, request.State, err)
+	}
+
+	http.Redirect(res, req, redirectTo, http.StatusFound)
+
+	return nil
+}
+
+// Function that issues tokens to OAuth clients, according to the grant type of the request
+func (handler *Handler) handleToken(res http.ResponseWriter, req *http.Request) error {
+	res.Header().Set("Cache-Control", "no-store")
+
+	if parseErr := req.ParseForm(); parseErr != nil {
+		return utils.WriteJSON(res, 400, OAuthErrorResponse{Error: "invalid_request", ErrorDescription: "body must be form encoded"})
+	}
+
+	request := services.TokenRequest{
+		GrantType:    req.PostForm.Get("grant_type"),
+		Code:         req.PostForm.Get("code"),
+		RedirectURI:  req.PostForm.Get("redirect_uri"),
+		CodeVerifier: req.PostForm.Get("code_verifier"),
+		RefreshToken: req.PostForm.Get("refresh_token"),
+		Scope:        req.PostForm.Get("scope"),
+	}
+	request.ClientID, request.ClientSecret = readClientCredentials(req)
+
+	tokens, err := handler.services.ExchangeToken(req.Context(), request, newSessionInfo(req))
+
+	if err != nil {
+		return writeOAuthError(res, err)
+	}
+
+	response := OAuthTokenResponse{
+		AccessToken: tokens.AccessToken.TokenValue,
+		TokenType:   "Bearer",
+		ExpiresIn:   int((24 * time.Hour).Seconds()),
+		Scope:       tokens.AccessToken.Scope,
+		IDToken:     tokens.IDToken,
+	}
+
+	if tokens.RefreshToken != nil {
+		response.RefreshToken = tokens.RefreshToken.TokenValue
+	}
+
+	return utils.WriteJSON(res, 200, response)
+}
+
+// Function that tells resource servers whether a token is active, as described in RFC 7662
+func (handler *Handler) handleIntrospect(res http.ResponseWriter, req *http.Request) error {
+	res.Header().Set("Cache-Control", "no-store")
+
+	request, parseErr := readTokenCheckRequest(req)
+
+	if parseErr != nil {
+		return utils.WriteJSON(res, 400, OAuthErrorResponse{Error: "invalid_request", ErrorDescription: parseErr.Error()})
+	}
+
+	introspection, err := handler.services.IntrospectToken(req.Context(), request)
+
+	if err != nil {
+		return writeOAuthError(res, err)
+	}
+
+	return utils.WriteJSON(res, 200, IntrospectionResponse{
+		Active:    introspection.Active,
+		Scope:     introspection.Scope,
+		ClientID:  introspection.ClientID,
+		Username:  introspection.Username,
+		TokenType: introspection.TokenType,
+		Exp:       introspection.Exp,
+		Sub:       introspection.Sub,
+	})
+}
+
+// Function that revokes a token issued to the client, as described in RFC 7009.
+// It responds 200 even if the token was not valid, as section 2.2 asks.
+func (handler *Handler) handleRevoke(res http.ResponseWriter, req *http.Request) error {
+	request, parseErr := readTokenCheckRequest(req)
+
+	if parseErr != nil {
+		return utils.WriteJSON(res, 400, OAuthErrorResponse{Error: "invalid_request", ErrorDescription: parseErr.Error()})
+	}
+
+	if err := handler.services.RevokeToken(req.Context(), request); err != nil {
+		return writeOAuthError(res, err)
+	}
+
+	res.WriteHeader(200)
+
+	return nil
+}
+
+// AUX FUNCTIONS
+
+// Function that reads the authorization request parameters, from the query or a form encoded body
+func readAuthorizationRequest(req *http.Request) services.AuthorizationRequest {
+	req.ParseForm()
+
+	return services.AuthorizationRequest{
+		ResponseType:        req.Form.Get("response_type"),
+		ClientID:            req.Form.Get("client_id"),
+		RedirectURI:         req.Form.Get("redirect_uri"),
+		Scope:               req.Form.Get("scope"),
+		State:               req.Form.Get("state"),
+		CodeChallenge:       req.Form.Get("code_challenge"),
+		CodeChallengeMethod: req.Form.Get("code_challenge_method"),
+		Nonce:               req.Form.Get("nonce"),
+	}
+}
+
+// Returns the parameters of the authorization request, to send it again once the user has logged in or consented
+func authorizationParams(request services.AuthorizationRequest) url.Values {
+	params := url.Values{}
+
+	for name, value := range map[string]string{
+		"response_type":         request.ResponseType,
+		"client_id":             request.ClientID,
+		"redirect_uri":          request.RedirectURI,
+		"scope":                 request.Scope,
+		"state":                 request.State,
+		"code_challenge":        request.CodeChallenge,
+		"code_challenge_method": request.CodeChallengeMethod,
+		"nonce":                 request.Nonce,
+	} {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+
+	return params
+}
+
+// Function that reads the client credentials, either from the Authorization header
+// (RFC 6749 section 2.3.1) or from the form encoded body
+func readClientCredentials(req *http.Request) (string, string) {
+	if clientId, clientSecret, ok := req.BasicAuth(); ok {
+		decodedId, idErr := url.QueryUnescape(clientId)
+		decodedSecret, secretErr := url.QueryUnescape(clientSecret)
+
+		if idErr == nil && secretErr == nil {
+			return decodedId, decodedSecret
+		}
+	}
+
+	return req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+}
+
+// Function that reads the parameters of an introspection or revocation request from its form encoded body
+func readTokenCheckRequest(req *http.Request) (services.TokenCheckRequest, error) {
+	if parseErr := req.ParseForm(); parseErr != nil {
+		return services.TokenCheckRequest{}, errors.New("body must be form encoded")
+	}
+
+	request := services.TokenCheckRequest{
+		Token:         req.PostForm.Get("token"),
+		TokenTypeHint: req.PostForm.Get("token_type_hint"),
+	}
+	request.ClientID, request.ClientSecret = readClientCredentials(req)
+
+	if request.Token == "" {
+		return request, errors.New("token must be provided")
+	}
+
+	return request, nil
+}
+
+// Function that sends an authorization error to the client through its redirect URI, if it's trusted.
+// Otherwise the error is shown to the user.
+func writeAuthorizationError(res http.ResponseWriter, req *http.Request, redirectURI string, state string, err error) error {
+	var oauthErr *services.OAuthError
+
+	if !errors.As(err, &oauthErr) {
+		return utils.WriteError(res, req, err)
+	}
+
+	if redirectURI == "" {
+		return utils.WriteJSON(res, 400, OAuthErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+	}
+
+	http.Redirect(res, req, services.BuildErrorRedirectURI(redirectURI, oauthErr, state), http.StatusFound)
+
+	return nil
+}
+
+// Function that writes a token endpoint error with the status RFC 6749 section 5.2 asks for
+func writeOAuthError(res http.ResponseWriter, err error) error {
+	var oauthErr *services.OAuthError
+
+	if !errors.As(err, &oauthErr) {
+		return utils.WriteJSON(res, 500, OAuthErrorResponse{Error: "server_error", ErrorDescription: err.Error()})
+	}
+
+	if oauthErr.Code == "invalid_client" {
+		res.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		return utils.WriteJSON(res, 401, OAuthErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+	}
+
+	return utils.WriteJSON(res, 400, OAuthErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"gocker-api/auth"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Cookie holding the login session users authorize OAuth clients with
+const loginSessionCookie = "gocker_login_session"
+
+var errCrossOriginRequest = utils.NewError(utils.KindForbidden, "cross_origin_request", "the request must come from a page of the API")
+
+type loginPageData struct {
+	ReturnTo string
+	Email    string
+	Error    string
+}
+
+type consentPageData struct {
+	ClientName string
+	Scopes     []string
+	Params     url.Values
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Log in</title></head>
+<body>
+<h1>Log in</h1>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/login">
+<input type="hidden" name="return_to" value="{{.ReturnTo}}">
+<p><label>Email <input type="email" name="email" value="{{.Email}}" required></label></p>
+<p><label>Password <input type="password" name="password" required></label></p>
+<p><label>Two-factor authentication code, if enabled <input type="text" name="code" autocomplete="one-time-code"></label></p>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize {{.ClientName}}</title></head>
+<body>
+<h1>{{.ClientName}} wants to access your account</h1>
+{{if .Scopes}}<p>It asks for:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<form method="post" action="/oauth/authorize">
+{{range $name, $values := .Params}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}<button type="submit" name="consent" value="approve">Allow</button>
+<button type="submit" name="consent" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// Function that shows the page users log in at to authorize OAuth clients
+func (handler *Handler) handleGetLogin(res http.ResponseWriter, req *http.Request) error {
+	return writePage(res, 200, loginPage, loginPageData{ReturnTo: safeReturnTo(req.URL.Query().Get("return_to"))})
+}
+
+// Function that logs the user in, keeping its session in a cookie, and takes it back to the authorization
+// request it came from. Failures show the login page again.
+func (handler *Handler) handleLogin(res http.ResponseWriter, req *http.Request) error {
+	if !sameOrigin(req) {
+		return utils.WriteError(res, req, errCrossOriginRequest)
+	}
+
+	if parseErr := req.ParseForm(); parseErr != nil {
+		return writePage(res, 400, loginPage, loginPageData{Error: "body must be form encoded"})
+	}
+
+	data := loginPageData{ReturnTo: safeReturnTo(req.PostForm.Get("return_to")), Email: req.PostForm.Get("email")}
+	body := services.LoginBody{
+		Email:    req.PostForm.Get("email"),
+		Password: req.PostForm.Get("password"),
+		Code:     req.PostForm.Get("code"),
+	}
+
+	value, err := handler.services.StartLoginSession(req.Context(), body, newSessionInfo(req))
+
+	if err != nil {
+		var apiErr *utils.Error
+
+		if !errors.As(err, &apiErr) || apiErr.Kind == utils.KindInternal {
+			return utils.WriteError(res, req, err)
+		}
+
+		data.Error = apiErr.Message
+
+		return writePage(res, apiErr.Status(), loginPage, data)
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     loginSessionCookie,
+		Value:    value,
+		Path:     "/oauth/",
+		MaxAge:   int(services.LoginSessionDuration.Seconds()),
+		Secure:   req.TLS != nil || strings.HasPrefix(auth.Issuer(), "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(res, req, data.ReturnTo, http.StatusFound)
+
+	return nil
+}
+
+// AUX FUNCTIONS
+
+// Returns the user logged in through the login page, or nil if the browser has no valid login session
+func (handler *Handler) loginSessionUser(req *http.Request) *models.User {
+	cookie, cookieErr := req.Cookie(loginSessionCookie)
+
+	if cookieErr != nil {
+		return nil
+	}
+
+	user, authErr := handler.services.AuthenticateLoginSession(req.Context(), cookie.Value)
+
+	if authErr != nil {
+		return nil
+	}
+
+	return user
+}
+
+// Function that sends the user to the login page, which takes it back to the given authorization request
+func redirectToLogin(res http.ResponseWriter, req *http.Request, request services.AuthorizationRequest) error {
+	returnTo := "/oauth/authorize?" + authorizationParams(request).Encode()
+
+	http.Redirect(res, req, "/oauth/login?"+url.Values{"return_to": {returnTo}}.Encode(), http.StatusFound)
+
+	return nil
+}
+
+// Returns the page to go back to after logging in. Only authorization requests are allowed, so that the
+// login page can't be used to redirect users anywhere else.
+func safeReturnTo(returnTo string) string {
+	parsedURI, parseErr := url.Parse(returnTo)
+
+	if parseErr != nil || parsedURI.Scheme != "" || parsedURI.Host != "" || parsedURI.Path != "/oauth/authorize" {
+		return "/oauth/authorize"
+	}
+
+	return parsedURI.RequestURI()
+}
+
+// Returns false if the request was sent by a page of another site, as browsers tell with the Origin header.
+// Login sessions live in a cookie, so forms posted by other sites must not be able to use them.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+
+	if origin == "" {
+		return true
+	}
+
+	parsedOrigin, parseErr := url.Parse(origin)
+
+	return parseErr == nil && parsedOrigin.Host == req.Host
+}
+
+// Function that writes an HTML page, which can't be shown within frames of other sites
+func writePage(res http.ResponseWriter, status int, page *template.Template, data any) error {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "no-store")
+	res.Header().Set("X-Frame-Options", "DENY")
+	res.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	res.WriteHeader(status)
+
+	return page.Execute(res, data)
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"gocker-api/auth"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Tokens issued through the client credentials grant only get the scopes explicitly granted to their client
+func TestClientCredentialsScopes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		register := func(scope string) (*models.OAuthClient, string) {
+			client, secret, registerErr := handler.services.RegisterOAuthClient(services.OAuthClientBody{Name: "client", Confidential: true, Scope: scope})
+
+			if registerErr != nil {
+				t.Fatal(registerErr)
+			}
+
+			return client, secret
+		}
+
+		requestToken := func(client *models.OAuthClient, secret string) *httptest.ResponseRecorder {
+			form := url.Values{"grant_type": {"client_credentials"}, "client_id": {client.ClientID}, "client_secret": {secret}}
+			req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(utils.ParseToHandlerFunc(handler.handleToken)).ServeHTTP(rr, req)
+
+			return rr
+		}
+
+		getUsers := func(client *models.OAuthClient, scope string) int {
+			req := httptest.NewRequest("GET", "/api/v1/users", nil)
+			token := &models.Token{ClientRefer: &client.ID, Kind: models.Access, Scope: scope}
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(handler.requirePermission(models.UsersReadPermission, handler.handleGetUsers)).
+				ServeHTTP(rr, req.WithContext(auth.NewContext(req.Context(), nil, token)))
+
+			return rr.Code
+		}
+
+		// clients registered without a scope are granted none
+		unscoped, unscopedSecret := register("")
+
+		if unscoped.Scopes != "" {
+			t.Errorf("expected the client to be granted no scope and got %q", unscoped.Scopes)
+		}
+
+		if rr := requestToken(unscoped, unscopedSecret); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_scope") {
+			t.Errorf("expected invalid_scope issuing a token to a client without scopes and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		if code := getUsers(unscoped, services.UsersReadScope); code != http.StatusForbidden {
+			t.Errorf("expected 403 for a token whose client wasn't granted the scope and got %d", code)
+		}
+
+		// while clients granted the scope get it
+		scoped, scopedSecret := register(services.UsersReadScope)
+		rr := requestToken(scoped, scopedSecret)
+
+		var tokenResponse OAuthTokenResponse
+
+		if decodeErr := json.NewDecoder(rr.Body).Decode(&tokenResponse); decodeErr != nil || tokenResponse.Scope != services.UsersReadScope {
+			t.Errorf("expected a token with the scope granted to the client and got %v (%v)", tokenResponse, decodeErr)
+		}
+
+		if code := getUsers(scoped, services.UsersReadScope); code != http.StatusOK {
+			t.Errorf("expected 200 for a token whose client was granted the scope and got %d", code)
+		}
+	})
+}
+
+// Users authorize clients through their login session, and are sent back to the client with a redirect
+func TestAuthorize(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		redirectURI := "https://client.example/callback"
+		client, _, registerErr := handler.services.RegisterOAuthClient(services.OAuthClientBody{
+			Name:         "client",
+			Confidential: true,
+			RedirectURIs: []string{redirectURI},
+			Scope:        services.ProfileScope,
+		})
+
+		if registerErr != nil {
+			t.Fatal(registerErr)
+		}
+
+		authorization := url.Values{"response_type": {"code"}, "client_id": {client.ClientID}, "redirect_uri": {redirectURI}, "state": {"xyz"}}
+
+		send := func(method string, target string, form url.Values, cookie *http.Cookie, apiFunc utils.APIFunc) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			if cookie != nil {
+				req.AddCookie(cookie)
+			}
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(utils.ParseToHandlerFunc(apiFunc)).ServeHTTP(rr, req)
+
+			return rr
+		}
+
+		// bearer tokens don't authenticate the user, so it's sent to the login page
+		req := authenticateRequest(httptest.NewRequest("GET", "/oauth/authorize?"+authorization.Encode(), nil), admin)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(utils.ParseToHandlerFunc(handler.handleGetConsent)).ServeHTTP(rr, req)
+
+		location, _ := url.Parse(rr.Header().Get("Location"))
+
+		if rr.Code != http.StatusFound || location == nil || location.Path != "/oauth/login" {
+			t.Fatalf("expected to be redirected to the login page and got %d %s", rr.Code, rr.Header().Get("Location"))
+		}
+
+		returnTo := location.Query().Get("return_to")
+
+		if rr := send("POST", "/oauth/login", url.Values{"email": {testAdminEmail}, "password": {"wrongpass1"}, "return_to": {returnTo}}, nil, handler.handleLogin); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 logging in with a wrong password and got %d", rr.Code)
+		}
+
+		rr = send("POST", "/oauth/login", url.Values{"email": {testAdminEmail}, "password": {testAdminPassword}, "return_to": {returnTo}}, nil, handler.handleLogin)
+
+		if rr.Code != http.StatusFound || rr.Header().Get("Location") != returnTo {
+			t.Fatalf("expected to be taken back to the authorization request and got %d %s", rr.Code, rr.Header().Get("Location"))
+		}
+
+		var cookie *http.Cookie
+
+		for _, setCookie := range rr.Result().Cookies() {
+			if setCookie.Name == loginSessionCookie && setCookie.HttpOnly {
+				cookie = setCookie
+			}
+		}
+
+		if cookie == nil {
+			t.Fatal("expected the login session to be kept in an http only cookie")
+		}
+
+		if rr := send("GET", returnTo, nil, cookie, handler.handleGetConsent); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "client wants to access your account") {
+			t.Errorf("expected the consent page and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		// the decision redirects the user to the client
+		approval := url.Values{"consent": {"approve"}}
+
+		for name, values := range authorization {
+			approval[name] = values
+		}
+
+		rr = send("POST", "/oauth/authorize", approval, cookie, handler.handleAuthorize)
+		location, _ = url.Parse(rr.Header().Get("Location"))
+
+		if rr.Code != http.StatusFound || location == nil || !strings.HasPrefix(location.String(), redirectURI) || location.Query().Get("code") == "" || location.Query().Get("state") != "xyz" {
+			t.Errorf("expected to be redirected to the client with a code and got %d %s", rr.Code, rr.Header().Get("Location"))
+		}
+
+		approval.Set("consent", "deny")
+		rr = send("POST", "/oauth/authorize", approval, cookie, handler.handleAuthorize)
+		location, _ = url.Parse(rr.Header().Get("Location"))
+
+		if rr.Code != http.StatusFound || location == nil || location.Query().Get("error") != "access_denied" {
+			t.Errorf("expected to be redirected to the client with access_denied and got %d %s", rr.Code, rr.Header().Get("Location"))
+		}
+
+		// forms posted by other sites can't use the login session
+		req = httptest.NewRequest("POST", "/oauth/authorize", strings.NewReader(approval.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://attacker.example")
+		req.AddCookie(cookie)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(utils.ParseToHandlerFunc(handler.handleAuthorize)).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected 403 for a form posted by another site and got %d", rr.Code)
+		}
+	})
+}
+
+// The openid scope is only granted while ID tokens can be signed, and a failure signing one leaves the code usable
+func TestIDTokens(t *testing.T) {
+	// registered first, so that it runs once the env vars are restored
+	t.Cleanup(func() { auth.ReloadKeyRing() })
+
+	dir := t.TempDir()
+	privateKey, generateErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if generateErr != nil {
+		t.Fatal(generateErr)
+	}
+
+	der, marshalErr := x509.MarshalPKCS8PrivateKey(privateKey)
+
+	if marshalErr != nil {
+		t.Fatal(marshalErr)
+	}
+
+	if writeErr := os.WriteFile(filepath.Join(dir, "ec-1.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+
+	useKeys := func(t *testing.T, keysDir string) {
+		t.Setenv("JWT_KEYS_DIR", keysDir)
+		t.Setenv("JWT_ACTIVE_KID", "ec-1")
+
+		if reloadErr := auth.ReloadKeyRing(); reloadErr != nil {
+			t.Fatal(reloadErr)
+		}
+	}
+
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		redirectURI := "https://client.example/callback"
+		client, secret, registerErr := handler.services.RegisterOAuthClient(services.OAuthClientBody{
+			Name:         "client",
+			Confidential: true,
+			RedirectURIs: []string{redirectURI},
+			Scope:        services.OpenIDScope + " " + services.ProfileScope,
+		})
+
+		if registerErr != nil {
+			t.Fatal(registerErr)
+		}
+
+		request := services.AuthorizationRequest{ResponseType: "code", ClientID: client.ClientID, RedirectURI: redirectURI, Scope: services.OpenIDScope}
+
+		useKeys(t, dir)
+		location, authorizeErr := handler.services.Authorize(*admin, request)
+
+		if authorizeErr != nil {
+			t.Fatal(authorizeErr)
+		}
+
+		redirect, _ := url.Parse(location)
+		exchange := services.TokenRequest{
+			GrantType:    "authorization_code",
+			Code:         redirect.Query().Get("code"),
+			RedirectURI:  redirectURI,
+			ClientID:     client.ClientID,
+			ClientSecret: secret,
+		}
+
+		// without a signing key the exchange fails before using the code, and openid can't be asked for anymore
+		useKeys(t, "")
+
+		if _, exchangeErr := handler.services.ExchangeToken(context.Background(), exchange, services.SessionInfo{}); exchangeErr == nil {
+			t.Error("expected the exchange to fail without a key to sign the ID token")
+		}
+
+		var oauthErr *services.OAuthError
+
+		if _, _, validationErr := handler.services.ValidateAuthorizationRequest(request); !errors.As(validationErr, &oauthErr) || oauthErr.Code != "invalid_scope" {
+			t.Errorf("expected invalid_scope asking for openid without a signing key and got %v", validationErr)
+		}
+
+		useKeys(t, dir)
+		tokens, exchangeErr := handler.services.ExchangeToken(context.Background(), exchange, services.SessionInfo{})
+
+		if exchangeErr != nil || tokens.IDToken == "" {
+			t.Errorf("expected the code to still be exchanged for an ID token and got %v (%v)", tokens, exchangeErr)
+		}
+	})
+}
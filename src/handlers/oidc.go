@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"gocker-api/auth"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (handler *Handler) InitOIDCRoutes(router *mux.Router) {
+	router.HandleFunc("/userinfo", utils.ParseToHandlerFunc(handler.handleGetUserInfo)).Methods("GET", "POST")
+}
+
+// Function that returns the claims about the user of the token, as described in OpenID Connect Core section 5.3.
+// Tokens limited by a scope need the openid one, and only get the claims their scopes allow.
+// First-party tokens get every claim.
+func (handler *Handler) handleGetUserInfo(res http.ResponseWriter, req *http.Request) error {
+	user, token := auth.UserFromContext(req.Context()), auth.TokenFromContext(req.Context())
+
+	if user == nil {
+		res.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return utils.WriteJSON(res, 401, OAuthErrorResponse{Error: "invalid_token", ErrorDescription: "the token was not issued on behalf of a user"})
+	}
+
+	scope := services.OpenIDScope + " " + services.ProfileScope + " " + services.EmailScope
+
+	if token.Scoped() {
+		if !services.HasScope(token.Scope, services.OpenIDScope) {
+			res.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			return utils.WriteJSON(res, 403, OAuthErrorResponse{Error: "insufficient_scope", ErrorDescription: "the openid scope is required"})
+		}
+
+		scope = token.Scope
+	}
+
+	return utils.WriteJSON(res, 200, services.GetUserInfo(*user, scope))
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"gocker-api/auth"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type ResponseMember struct {
+	ID        uint                  `json:"id"`
+	FirstName string                `json:"first_name"`
+	Email     string                `json:"email"`
+	Role      models.MembershipRole `json:"role"`
+	JoinedAt  time.Time             `json:"joined_at"`
+}
+
+func CreateResponseMember(member services.OrganizationMember) ResponseMember {
+	return ResponseMember{
+		ID:        member.User.ID,
+		FirstName: member.User.FirstName,
+		Email:     member.User.Email,
+		Role:      member.Membership.Role,
+		JoinedAt:  member.Membership.CreatedAt,
+	}
+}
+
+func (handler *Handler) InitOrganizationRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/organizations", requireUser(handler.handleGetOrganizations)).Methods("GET")
+	router.HandleFunc("/api/v1/organizations", requireUser(handler.handleCreateOrganization)).Methods("POST")
+	router.HandleFunc("/api/v1/organizations/invitations/accept", requireUser(handler.handleAcceptInvitation)).Methods("POST")
+	router.HandleFunc("/api/v1/organizations/{id:[0-9]+}", handler.requireMembership(models.MemberMembership, handler.handleGetOrganization)).Methods("GET")
+	router.HandleFunc("/api/v1/organizations/{id:[0-9]+}", handler.requireMembership(models.AdminMembership, handler.handleUpdateOrganization)).Methods("PUT")
+	router.HandleFunc("/api/v1/organizations/{id:[0-9]+}", handler.requireMembership(models.OwnerMembership, handler.handleDeleteOrganization)).Methods("DELETE")
+	router.HandleFunc("/api/v1/organizations/{id:[0-9]+}/members", handler.requireMembership(models.MemberMembership, handler.handleGetMembers)).Methods("GET")
+	router.HandleFunc("/api/v1/organizations/{id:[0-9]+}/members/{userId:[0-9]+}", handler.requireMembership(models.AdminMembership, handler.handleUpdateMember)).Methods("PUT")
+	router.HandleFunc("/api/v1/organizations/{id:[0-9]+}/members/{userId:[0-9]+}", handler.requireMembership(models.MemberMembership, handler.handleRemoveMember)).Methods("DELETE")
+	router.HandleFunc("/api/v1/organizations/{id:[0-9]+}/invitations", handler.requireMembership(models.AdminMembership, handler.handleGetInvitations)).Methods("GET")
+	router.HandleFunc("/api/v1/organizations/{id:[0-9]+}/invitations", handler.requireMembership(models.AdminMembership, handler.handleCreateInvitation)).Methods("POST")
+	router.HandleFunc("/api/v1/organizations/{id:[0-9]+}/invitations/{invitationId:[0-9]+}", handler.requireMembership(models.AdminMembership, handler.handleRevokeInvitation)).Methods("DELETE")
+	router.HandleFunc("/api/v1/auth/organization", utils.ParseToHandlerFunc(handler.handleSwitchOrganization)).Methods("PUT")
+}
+
+// Function that returns the organizations the user making the request is a member of
+func (handler *Handler) handleGetOrganizations(res http.ResponseWriter, req *http.Request) error {
+	organizations, err := handler.services.GetUserOrganizations(*auth.UserFromContext(req.Context()))
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, organizations)
+}
+
+// Function that creates an organization owned by the user making the request
+func (handler *Handler) handleCreateOrganization(res http.ResponseWriter, req *http.Request) error {
+	var organizationBody services.OrganizationBody
+
+	if parseErr := utils.ReadJSON(req.Body, &organizationBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	organization, err := handler.services.CreateOrganization(req.Context(), *auth.UserFromContext(req.Context()), organizationBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 201, organization)
+}
+
+func (handler *Handler) handleGetOrganization(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	organization, notFoundErr := handler.services.GetOrganizationById(uint(id))
+
+	if notFoundErr != nil {
+		return utils.WriteError(res, req, notFoundErr)
+	}
+
+	return utils.WriteJSON(res, 200, organization)
+}
+
+func (handler *Handler) handleUpdateOrganization(res http.ResponseWriter, req *http.Request) error {
+	var organizationBody services.OrganizationBody
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if parseErr := utils.ReadJSON(req.Body, &organizationBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	organization, err := handler.services.UpdateOrganization(uint(id), organizationBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, organization)
+}
+
+func (handler *Handler) handleDeleteOrganization(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if err := handler.services.DeleteOrganization(uint(id)); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Organization successfully deleted."})
+}
+
+// Function that returns the users of an organization, along with their role in it
+func (handler *Handler) handleGetMembers(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	members, err := handler.services.GetOrganizationMembers(req.Context(), uint(id))
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	responseMembers := make([]ResponseMember, 0, len(members))
+
+	for _, member := range members {
+		responseMembers = append(responseMembers, CreateResponseMember(member))
+	}
+
+	return utils.WriteJSON(res, 200, responseMembers)
+}
+
+func (handler *Handler) handleUpdateMember(res http.ResponseWriter, req *http.Request) error {
+	var membershipBody services.MembershipBody
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	userId, _ := strconv.Atoi(mux.Vars(req)["userId"])
+
+	if parseErr := utils.ReadJSON(req.Body, &membershipBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	actor, _ := handler.services.GetMembership(uint(id), auth.UserFromContext(req.Context()).ID)
+
+	membership, err := handler.services.UpdateMembership(*actor, uint(id), uint(userId), membershipBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, membership)
+}
+
+// Function that removes a member from an organization. Members can also remove themselves, to leave it.
+func (handler *Handler) handleRemoveMember(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	userId, _ := strconv.Atoi(mux.Vars(req)["userId"])
+
+	actor, _ := handler.services.GetMembership(uint(id), auth.UserFromContext(req.Context()).ID)
+
+	if err := handler.services.RemoveMember(req.Context(), *actor, uint(id), uint(userId)); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Member successfully removed."})
+}
+
+func (handler *Handler) handleGetInvitations(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	invitations, err := handler.services.GetPendingInvitations(uint(id))
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, invitations)
+}
+
+// Function that invites someone to the organization by email
+func (handler *Handler) handleCreateInvitation(res http.ResponseWriter, req *http.Request) error {
+	var invitationBody services.InvitationBody
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if parseErr := utils.ReadJSON(req.Body, &invitationBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	inviter, _ := handler.services.GetMembership(uint(id), auth.UserFromContext(req.Context()).ID)
+
+	invitation, err := handler.services.InviteToOrganization(*inviter, uint(id), invitationBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 201, invitation)
+}
+
+func (handler *Handler) handleRevokeInvitation(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	invitationId, _ := strconv.Atoi(mux.Vars(req)["invitationId"])
+
+	if err := handler.services.RevokeInvitation(uint(id), invitationId); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Invitation successfully revoked."})
+}
+
+// Function that makes the user making the request a member of the organization it was invited to
+func (handler *Handler) handleAcceptInvitation(res http.ResponseWriter, req *http.Request) error {
+	var acceptBody services.AcceptInvitationBody
+
+	if parseErr := utils.ReadJSON(req.Body, &acceptBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	invitation, err := handler.services.AcceptInvitation(req.Context(), *auth.UserFromContext(req.Context()), acceptBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, invitation)
+}
+
+// Function that switches the organization the tokens of the current session act within,
+// returning new tokens that replace them
+func (handler *Handler) handleSwitchOrganization(res http.ResponseWriter, req *http.Request) error {
+	var switchBody services.SwitchOrganizationBody
+	user, token := auth.UserFromContext(req.Context()), auth.TokenFromContext(req.Context())
+
+	if user == nil || token == nil {
+		return utils.WriteError(res, req, errMissingToken)
+	}
+
+	if parseErr := utils.ReadJSON(req.Body, &switchBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	accessToken, refreshToken, err := handler.services.SwitchOrganization(req.Context(), *user, *token, switchBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, AuthenticationResponse{TokenValue: accessToken.TokenValue, RefreshTokenValue: refreshToken.TokenValue})
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/storage"
+	"gocker-api/storage/storagetest"
+	"testing"
+)
+
+// Organization invitations whose email couldn't be sent aren't kept pending, so the email can be invited again
+func TestOrganizationInvitationNotSent(t *testing.T) {
+	t.Setenv("SECRET_KEY", "test-secret-key")
+
+	storagetest.ForEachBackend(t, func(t *testing.T, repositories *storage.Repositories) {
+		ctx := context.Background()
+		mailer := &switchableMailer{}
+		svc := services.New(repositories, mailer)
+		owner, createErr := svc.CreateUser(ctx, services.UserBody{FirstName: "owner", Email: "owner@gmail.com", Password: "testpass1"})
+
+		if createErr != nil {
+			t.Fatal(createErr)
+		}
+
+		organization, createErr := svc.CreateOrganization(ctx, *owner, services.OrganizationBody{Name: "organization"})
+
+		if createErr != nil {
+			t.Fatal(createErr)
+		}
+
+		inviter, getErr := svc.GetMembership(organization.ID, owner.ID)
+
+		if getErr != nil {
+			t.Fatal(getErr)
+		}
+
+		body := services.InvitationBody{Email: "invited@gmail.com", Role: models.MemberMembership}
+		mailer.failing = true
+
+		if _, inviteErr := svc.InviteToOrganization(*inviter, organization.ID, body); inviteErr == nil {
+			t.Fatal("expected an error inviting while the mail server is unavailable")
+		}
+
+		if pending, _ := svc.GetPendingInvitations(organization.ID); len(pending) != 0 {
+			t.Errorf("expected the invitation that wasn't sent not to be pending and got %d", len(pending))
+		}
+
+		mailer.failing = false
+
+		if _, inviteErr := svc.InviteToOrganization(*inviter, organization.ID, body); inviteErr != nil {
+			t.Errorf("expected the email to be invited again once it can be sent and got %v", inviteErr)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"gocker-api/auth"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Function that wraps a handler so that it's only called if the role of the user making the request
+// grants the given permission. Tokens limited by a scope also need the one matching the permission.
+func (handler *Handler) requirePermission(permission models.Permission, next utils.APIFunc) http.HandlerFunc {
+	return handler.requirePermissionOrSelf(permission, "", next)
+}
+
+// Same as requirePermission, but selfPermission is enough when the route acts on the user making
+// the request, identified by the id route variable
+func (handler *Handler) requirePermissionOrSelf(permission models.Permission, selfPermission models.Permission, next utils.APIFunc) http.HandlerFunc {
+	handlerFunc := utils.ParseToHandlerFunc(next)
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, token := auth.UserFromContext(req.Context()), auth.TokenFromContext(req.Context())
+
+		if token == nil {
+			utils.WriteError(res, req, errMissingToken)
+			return
+		}
+
+		granted := permission
+
+		//Tokens issued through the client credentials grant have no user, so their client must have been granted the scope
+		if user == nil {
+			if token.ClientRefer == nil || !handler.services.ClientGrantsScope(*token.ClientRefer, permission.Scope()) {
+				utils.WriteError(res, req, utils.NewError(utils.KindForbidden, "insufficient_scope", "insufficient scope. "+permission.Scope()+" must be granted to the client"))
+				return
+			}
+		} else if !handler.services.HasPermission(*user, permission) {
+			if selfPermission == "" || !isSelf(req, *user) || !handler.services.HasPermission(*user, selfPermission) {
+				utils.WriteError(res, req, utils.NewError(utils.KindForbidden, "permission_denied", "permission denied. "+string(permission)+" is required"))
+				return
+			}
+
+			granted = selfPermission
+		}
+
+		if token.Scoped() && !services.HasScope(token.Scope, granted.Scope()) {
+			utils.WriteError(res, req, utils.NewError(utils.KindForbidden, "insufficient_scope", "insufficient scope. "+granted.Scope()+" is required"))
+			return
+		}
+
+		handlerFunc(res, req)
+	}
+}
+
+// Same as requirePermission, but only for requests made by a user, for routes that act on behalf of the caller
+// (e.g. recording it as the inviter). Tokens issued through the client credentials grant have no user.
+func (handler *Handler) requireUserPermission(permission models.Permission, next utils.APIFunc) http.HandlerFunc {
+	return handler.requirePermission(permission, func(res http.ResponseWriter, req *http.Request) error {
+		if auth.UserFromContext(req.Context()) == nil {
+			return utils.WriteError(res, req, errUserTokenRequired)
+		}
+
+		return next(res, req)
+	})
+}
+
+// Same as requirePermission, but only for the user the route acts on, identified by the id route variable.
+// For routes nobody else may call whatever their permissions, since they'd let them act as that user.
+func (handler *Handler) requireSelfPermission(permission models.Permission, next utils.APIFunc) http.HandlerFunc {
+	return handler.requirePermission(permission, func(res http.ResponseWriter, req *http.Request) error {
+		if user := auth.UserFromContext(req.Context()); user == nil || !isSelf(req, *user) {
+			return utils.WriteError(res, req, utils.NewError(utils.KindForbidden, "permission_denied", "permission denied. Only the user itself can do this"))
+		}
+
+		return next(res, req)
+	})
+}
+
+// AUX FUNCTIONS
+
+// Function that returns the users a request can reach. Users whose role grants users:all-organizations reach every
+// user with their own tokens (not limited by a scope), and users always reach themselves, identified by the given id.
+// Everyone else only reaches the members of the organization the request acts within, which is nobody if it acts
+// within none (e.g. client credentials tokens).
+func (handler *Handler) userScope(req *http.Request, id int) services.UserScope {
+	user, token := auth.UserFromContext(req.Context()), auth.TokenFromContext(req.Context())
+
+	if user != nil && token != nil && (user.ID == uint(id) || (!token.Scoped() && handler.services.HasPermission(*user, models.UsersAllOrganizationsPermission))) {
+		return services.AllUsers
+	}
+
+	return services.UserScope{OrganizationID: auth.OrganizationFromContext(req.Context())}
+}
+
+// Returns true if the id route variable is the id of the given user
+func isSelf(req *http.Request, user models.User) bool {
+	return mux.Vars(req)["id"] == strconv.FormatUint(uint64(user.ID), 10)
+}
+
+// Function that wraps a handler so that it's only called by a user with a first-party token
+// (not limited by a scope), for routes any user can call on its own behalf
+func requireUser(next utils.APIFunc) http.HandlerFunc {
+	handlerFunc := utils.ParseToHandlerFunc(next)
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, token := auth.UserFromContext(req.Context()), auth.TokenFromContext(req.Context())
+
+		if user == nil || token == nil || token.Scoped() {
+			utils.WriteError(res, req, errUserTokenRequired)
+			return
+		}
+
+		handlerFunc(res, req)
+	}
+}
+
+// Function that wraps a handler so that it's only called by members of the organization identified
+// by the id route variable, whose membership role is at least the given one
+func (handler *Handler) requireMembership(role models.MembershipRole, next utils.APIFunc) http.HandlerFunc {
+	return requireUser(func(res http.ResponseWriter, req *http.Request) error {
+		organizationId, _ := strconv.Atoi(mux.Vars(req)["id"])
+		membership, notFoundErr := handler.services.GetMembership(uint(organizationId), auth.UserFromContext(req.Context()).ID)
+
+		// Organizations the user isn't a member of don't exist as far as it's concerned
+		if notFoundErr != nil {
+			return utils.WriteError(res, req, services.ErrOrganizationNotFound)
+		}
+
+		if !membership.Role.AtLeast(role) {
+			return utils.WriteError(res, req, utils.NewError(utils.KindForbidden, "permission_denied", "permission denied. The "+string(role)+" role of the organization is required"))
+		}
+
+		return next(res, req)
+	})
+}
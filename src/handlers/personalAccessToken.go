@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type ResponsePersonalAccessToken struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	Scope      string     `json:"scope"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func CreateResponsePersonalAccessToken(token models.PersonalAccessToken, value string) ResponsePersonalAccessToken {
+	return ResponsePersonalAccessToken{
+		ID:         token.ID,
+		Name:       token.Name,
+		Token:      value,
+		Scope:      token.Scopes,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+func (handler *Handler) InitPersonalAccessTokenRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/users/{id}/tokens", handler.requirePermissionOrSelf(models.UsersReadPermission, models.UsersReadSelfPermission, handler.handleGetPersonalAccessTokens)).Methods("GET")
+	router.HandleFunc("/api/v1/users/{id}/tokens", handler.requireSelfPermission(models.UsersWriteSelfPermission, handler.handleCreatePersonalAccessToken)).Methods("POST")
+	router.HandleFunc("/api/v1/users/{id}/tokens/{tokenId:[0-9]+}", handler.requirePermissionOrSelf(models.UsersReadPermission, models.UsersReadSelfPermission, handler.handleGetPersonalAccessToken)).Methods("GET")
+	router.HandleFunc("/api/v1/users/{id}/tokens/{tokenId:[0-9]+}", handler.requireSelfPermission(models.UsersWriteSelfPermission, handler.handleUpdatePersonalAccessToken)).Methods("PUT")
+	router.HandleFunc("/api/v1/users/{id}/tokens/{tokenId:[0-9]+}", handler.requirePermissionOrSelf(models.UsersWritePermission, models.UsersWriteSelfPermission, handler.handleDeletePersonalAccessToken)).Methods("DELETE")
+}
+
+func (handler *Handler) handleGetPersonalAccessTokens(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	user, notFoundErr := handler.services.GetUserInScope(req.Context(), handler.userScope(req, id), id)
+
+	if notFoundErr != nil {
+		return utils.WriteError(res, req, notFoundErr)
+	}
+
+	tokens, err := handler.services.GetPersonalAccessTokens(*user)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	responseTokens := make([]ResponsePersonalAccessToken, 0, len(tokens))
+
+	for _, token := range tokens {
+		responseTokens = append(responseTokens, CreateResponsePersonalAccessToken(*token, ""))
+	}
+
+	return utils.WriteJSON(res, 200, responseTokens)
+}
+
+// Function that creates a personal access token. Its value is only returned in this response.
+func (handler *Handler) handleCreatePersonalAccessToken(res http.ResponseWriter, req *http.Request) error {
+	var tokenBody services.PersonalAccessTokenBody
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if parseErr := utils.ReadJSON(req.Body, &tokenBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	user, notFoundErr := handler.services.GetUserInScope(req.Context(), handler.userScope(req, id), id)
+
+	if notFoundErr != nil {
+		return utils.WriteError(res, req, notFoundErr)
+	}
+
+	token, value, err := handler.services.CreatePersonalAccessToken(*user, tokenBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 201, CreateResponsePersonalAccessToken(*token, value))
+}
+
+func (handler *Handler) handleGetPersonalAccessToken(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	tokenId, _ := strconv.Atoi(mux.Vars(req)["tokenId"])
+
+	user, notFoundErr := handler.services.GetUserInScope(req.Context(), handler.userScope(req, id), id)
+
+	if notFoundErr != nil {
+		return utils.WriteError(res, req, notFoundErr)
+	}
+
+	token, tokenNotFoundErr := handler.services.GetPersonalAccessToken(*user, tokenId)
+
+	if tokenNotFoundErr != nil {
+		return utils.WriteError(res, req, tokenNotFoundErr)
+	}
+
+	return utils.WriteJSON(res, 200, CreateResponsePersonalAccessToken(*token, ""))
+}
+
+func (handler *Handler) handleUpdatePersonalAccessToken(res http.ResponseWriter, req *http.Request) error {
+	var tokenBody services.PersonalAccessTokenBody
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	tokenId, _ := strconv.Atoi(mux.Vars(req)["tokenId"])
+
+	if parseErr := utils.ReadJSON(req.Body, &tokenBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	user, notFoundErr := handler.services.GetUserInScope(req.Context(), handler.userScope(req, id), id)
+
+	if notFoundErr != nil {
+		return utils.WriteError(res, req, notFoundErr)
+	}
+
+	token, err := handler.services.UpdatePersonalAccessToken(*user, tokenId, tokenBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, CreateResponsePersonalAccessToken(*token, ""))
+}
+
+func (handler *Handler) handleDeletePersonalAccessToken(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	tokenId, _ := strconv.Atoi(mux.Vars(req)["tokenId"])
+
+	user, notFoundErr := handler.services.GetUserInScope(req.Context(), handler.userScope(req, id), id)
+
+	if notFoundErr != nil {
+		return utils.WriteError(res, req, notFoundErr)
+	}
+
+	if err := handler.services.DeletePersonalAccessToken(*user, tokenId); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Token successfully deleted."})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"gocker-api/models"
+	"gocker-api/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Only their owner can create or update personal access tokens, while users with users:write can still delete them
+func TestPersonalAccessTokenOwnership(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		user, createErr := handler.services.CreateUser(context.Background(), services.UserBody{FirstName: "test", Email: "test@gmail.com", Password: "testpass1"})
+
+		if createErr != nil {
+			t.Fatal(createErr)
+		}
+
+		router := mux.NewRouter()
+		handler.InitPersonalAccessTokenRoutes(router)
+
+		send := func(method string, target string, body string, caller *models.User) *httptest.ResponseRecorder {
+			req := authenticateRequest(httptest.NewRequest(method, target, strings.NewReader(body)), caller)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			return rr
+		}
+
+		tokensPath := fmt.Sprintf("/api/v1/users/%d/tokens", user.ID)
+		tokenBody := `{"name": "ci", "scope": "users:read"}`
+
+		if rr := send("POST", tokensPath, tokenBody, admin); rr.Code != http.StatusForbidden {
+			t.Errorf("expected 403 creating a token for another user and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		if rr := send("POST", tokensPath, tokenBody, user); rr.Code != http.StatusCreated {
+			t.Fatalf("expected the user to create its own token and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		tokens, _ := handler.services.GetPersonalAccessTokens(*user)
+
+		if len(tokens) != 1 {
+			t.Fatalf("expected the user to have 1 token and got %d", len(tokens))
+		}
+
+		tokenPath := fmt.Sprintf("%s/%d", tokensPath, tokens[0].ID)
+
+		if rr := send("PUT", tokenPath, tokenBody, admin); rr.Code != http.StatusForbidden {
+			t.Errorf("expected 403 updating the token of another user and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		if rr := send("GET", tokensPath, "", admin); rr.Code != http.StatusOK {
+			t.Errorf("expected an admin to list the tokens of another user and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		if rr := send("DELETE", tokenPath, "", admin); rr.Code != http.StatusOK {
+			t.Errorf("expected an admin to delete the token of another user and got %d %s", rr.Code, rr.Body.String())
+		}
+	})
+}
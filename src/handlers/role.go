@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"gocker-api/auth"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (handler *Handler) InitRoleRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/roles", handler.requirePermission(models.RolesReadPermission, handler.handleGetRoles)).Methods("GET")
+	router.HandleFunc("/api/v1/roles", handler.requireUserPermission(models.RolesWritePermission, handler.handleCreateRole)).Methods("POST")
+	router.HandleFunc("/api/v1/roles/audit", handler.requirePermission(models.RolesReadPermission, handler.handleGetRoleAuditLog)).Methods("GET")
+	router.HandleFunc("/api/v1/roles/{id:[0-9]+}", handler.requirePermission(models.RolesReadPermission, handler.handleGetRole)).Methods("GET")
+	router.HandleFunc("/api/v1/roles/{id:[0-9]+}", handler.requireUserPermission(models.RolesWritePermission, handler.handleUpdateRole)).Methods("PUT")
+	router.HandleFunc("/api/v1/roles/{id:[0-9]+}", handler.requireUserPermission(models.RolesWritePermission, handler.handleDeleteRole)).Methods("DELETE")
+	router.HandleFunc("/api/v1/users/{id}/role", handler.requireUserPermission(models.RolesWritePermission, handler.handleAssignRole)).Methods("PUT")
+}
+
+func (handler *Handler) handleGetRoles(res http.ResponseWriter, req *http.Request) error {
+	roles, err := handler.services.GetAllRoles()
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, roles)
+}
+
+func (handler *Handler) handleGetRole(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	role, notFoundErr := handler.services.GetRoleById(id)
+
+	if notFoundErr != nil {
+		return utils.WriteError(res, req, notFoundErr)
+	}
+
+	return utils.WriteJSON(res, 200, role)
+}
+
+func (handler *Handler) handleCreateRole(res http.ResponseWriter, req *http.Request) error {
+	var roleBody services.RoleBody
+
+	if parseErr := utils.ReadJSON(req.Body, &roleBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	role, err := handler.services.CreateRole(*auth.UserFromContext(req.Context()), roleBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 201, role)
+}
+
+func (handler *Handler) handleUpdateRole(res http.ResponseWriter, req *http.Request) error {
+	var roleBody services.RoleBody
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if parseErr := utils.ReadJSON(req.Body, &roleBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	role, err := handler.services.UpdateRole(*auth.UserFromContext(req.Context()), id, roleBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, role)
+}
+
+func (handler *Handler) handleDeleteRole(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if err := handler.services.DeleteRole(req.Context(), *auth.UserFromContext(req.Context()), id); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Role successfully deleted."})
+}
+
+// Function that returns who changed roles, or the role of a user, and when
+func (handler *Handler) handleGetRoleAuditLog(res http.ResponseWriter, req *http.Request) error {
+	auditLogs, err := handler.services.GetRoleAuditLog()
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, auditLogs)
+}
+
+// Function that assigns a role to a user. It applies to the tokens the user already has on their next request.
+func (handler *Handler) handleAssignRole(res http.ResponseWriter, req *http.Request) error {
+	var assignBody services.AssignRoleBody
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if parseErr := utils.ReadJSON(req.Body, &assignBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	user, err := handler.services.AssignRole(req.Context(), *auth.UserFromContext(req.Context()), id, assignBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, CreateResponseUser(*user))
+}
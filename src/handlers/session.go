@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"gocker-api/auth"
+	"gocker-api/models"
+	"gocker-api/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type ResponseSession struct {
+	ID         uint      `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+func CreateResponseSession(session models.Session, currentToken models.Token) ResponseSession {
+	return ResponseSession{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		IPAddress:  session.IPAddress,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: session.LastUsedAt,
+		Current:    session.Family == currentToken.Family,
+	}
+}
+
+func (handler *Handler) InitSessionRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/auth/sessions", utils.ParseToHandlerFunc(handler.handleGetSessions)).Methods("GET")
+	router.HandleFunc("/api/v1/auth/sessions/{id}", utils.ParseToHandlerFunc(handler.handleDeleteSession)).Methods("DELETE")
+}
+
+// Function that returns the active sessions of the user making the request
+func (handler *Handler) handleGetSessions(res http.ResponseWriter, req *http.Request) error {
+	user, token := auth.UserFromContext(req.Context()), auth.TokenFromContext(req.Context())
+
+	if user == nil || token == nil {
+		return utils.WriteError(res, req, errMissingToken)
+	}
+
+	sessions, err := handler.services.GetUserSessions(*user)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	responseSessions := make([]ResponseSession, 0, len(sessions))
+
+	for _, session := range sessions {
+		responseSessions = append(responseSessions, CreateResponseSession(*session, *token))
+	}
+
+	return utils.WriteJSON(res, 200, responseSessions)
+}
+
+// Function that revokes one of the sessions of the user making the request
+func (handler *Handler) handleDeleteSession(res http.ResponseWriter, req *http.Request) error {
+	user := auth.UserFromContext(req.Context())
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if user == nil {
+		return utils.WriteError(res, req, errMissingToken)
+	}
+
+	if err := handler.services.RevokeSession(req.Context(), *user, id); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Session successfully revoked."})
+}
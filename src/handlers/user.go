@@ -1,122 +1,381 @@
 package handlers
 
 import (
-	"gocker-api/database"
+	"errors"
+	"fmt"
+	"gocker-api/auth"
 	"gocker-api/models"
 	"gocker-api/services"
 	"gocker-api/utils"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 )
 
 type ResponseUser struct {
-	ID        uint   `json:"id"`
-	FirstName string `json:"first_name"`
-	Email     string `json:"email"`
+	ID        uint            `json:"id"`
+	FirstName string          `json:"first_name"`
+	Email     string          `json:"email"`
+	Role      models.UserRole `json:"role_id"`
+	CreatedAt time.Time       `json:"created_at"`
 }
 
 func CreateResponseUser(user models.User) ResponseUser {
-	return ResponseUser{ID: user.ID, FirstName: user.FirstName, Email: user.Email}
+	return ResponseUser{ID: user.ID, FirstName: user.FirstName, Email: user.Email, Role: user.Role, CreatedAt: user.CreatedAt}
 }
 
-func InitUserRoutes(router *mux.Router) {
-	router.HandleFunc("/api/v1/users", utils.ParseToHandlerFunc(handleGetUsers)).Methods("GET")
-	router.HandleFunc("/api/v1/users", utils.ParseToHandlerFunc(handleCreateUser)).Methods("POST")
-	router.HandleFunc("/api/v1/users/{id}", utils.ParseToHandlerFunc(handleGetUser)).Methods("GET")
-	router.HandleFunc("/api/v1/users/{id}", utils.ParseToHandlerFunc(handleUpdateUser)).Methods("PUT")
-	router.HandleFunc("/api/v1/users/{id}", utils.ParseToHandlerFunc(handleDeleteUser)).Methods("DELETE")
+// Page of users. The next one is listed by passing NextCursor as the after query param,
+// which the next link of the Link header already does.
+type ResponseUserPage struct {
+	Data []ResponseUser `json:"data"`
+	// Null on the last page
+	NextCursor *string `json:"next_cursor"`
+	// Only set if the count query param is true
+	Total *int64 `json:"total,omitempty"`
 }
 
-func handleGetUsers(res http.ResponseWriter, req *http.Request) error {
-	users := services.GetAllUsers()
-	var responseUsers []ResponseUser = make([]ResponseUser, 0)
+var errIfMatchRequired = utils.NewError(utils.KindPreconditionRequired, "if_match_required", "If-Match header required. Please, send the ETag the user was returned with")
 
-	for _, value := range users {
-		responseUsers = append(responseUsers, CreateResponseUser(value))
+func (handler *Handler) InitUserRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/users", handler.requirePermission(models.UsersReadPermission, handler.handleGetUsers)).Methods("GET")
+	router.HandleFunc("/api/v1/users", handler.requirePermission(models.UsersWritePermission, handler.handleCreateUser)).Methods("POST")
+	router.HandleFunc("/api/v1/users/{id}", handler.requirePermissionOrSelf(models.UsersReadPermission, models.UsersReadSelfPermission, handler.handleGetUser)).Methods("GET")
+	router.HandleFunc("/api/v1/users/{id}", handler.requirePermissionOrSelf(models.UsersWritePermission, models.UsersWriteSelfPermission, handler.handleUpdateUser)).Methods("PUT")
+	router.HandleFunc("/api/v1/users/{id}", handler.requirePermissionOrSelf(models.UsersWritePermission, models.UsersWriteSelfPermission, handler.handlePatchUser)).Methods("PATCH")
+	router.HandleFunc("/api/v1/users/{id}", handler.requirePermissionOrSelf(models.UsersWritePermission, models.UsersWriteSelfPermission, handler.handleDeleteUser)).Methods("DELETE")
+}
+
+// Function that returns a page of the users the request can reach (see userScope). The query params are:
+//   - limit: maximum number of users of the page, 50 by default and 200 at most
+//   - after: cursor of the page, as returned by the previous one
+//   - email, first_name and role: values the users must have
+//   - created_after and created_before: RFC 3339 times the users must have been created within
+//   - sort: comma separated fields to sort by, descending if prefixed by a -, e.g. -created_at,email
+//   - count: whether to return the total of users matching the filters
+func (handler *Handler) handleGetUsers(res http.ResponseWriter, req *http.Request) error {
+	options, parseErr := parseUserListOptions(req.URL.Query())
+
+	if parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	page, err := handler.services.ListUsers(req.Context(), handler.userScope(req, 0), options)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
 	}
 
-	return utils.WriteJSON(res, 200, responseUsers)
+	response := ResponseUserPage{Data: make([]ResponseUser, 0, len(page.Users)), Total: page.Total}
+
+	for _, value := range page.Users {
+		response.Data = append(response.Data, CreateResponseUser(*value))
+	}
+
+	res.Header().Add("Link", fmt.Sprintf(`<%s>; rel="first"`, pageURL(req, "")))
+
+	if page.NextCursor != "" {
+		response.NextCursor = &page.NextCursor
+		res.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, pageURL(req, page.NextCursor)))
+	}
+
+	return utils.WriteJSON(res, 200, response)
 }
 
-func handleGetUser(res http.ResponseWriter, req *http.Request) error {
+// Function that returns a user along with its ETag. Returns 304 without the user if it has one of the
+// ETags of the If-None-Match header, which means the client already has it.
+func (handler *Handler) handleGetUser(res http.ResponseWriter, req *http.Request) error {
 	id, _ := strconv.Atoi(mux.Vars(req)["id"])
 
-	user, notFoundErr := services.GetUserById(id)
+	user, notFoundErr := handler.services.GetUserInScope(req.Context(), handler.userScope(req, id), id)
 
 	if notFoundErr != nil {
-		return utils.WriteJSON(res, 404, utils.ApiError{Error: notFoundErr.Error()})
+		return utils.WriteError(res, req, notFoundErr)
+	}
+
+	res.Header().Set("ETag", userETag(user))
+
+	if ifNoneMatch := req.Header.Values("If-None-Match"); len(ifNoneMatch) > 0 && matchesETag(ifNoneMatch, userETag(user)) {
+		res.WriteHeader(304)
+
+		return nil
 	}
 
 	return utils.WriteJSON(res, 200, CreateResponseUser(*user))
 }
 
-func handleCreateUser(res http.ResponseWriter, req *http.Request) error {
+func (handler *Handler) handleCreateUser(res http.ResponseWriter, req *http.Request) error {
 	var userBody services.UserBody
 
 	// Handle body validation
 	if parseErr := utils.ReadJSON(req.Body, &userBody); parseErr != nil {
-		if validationErrs, ok := parseErr.(validator.ValidationErrors); ok {
-			validationErrors := make([]utils.ApiError, 0)
+		return utils.WriteError(res, req, parseErr)
+	}
 
-			for _, validationErr := range validationErrs {
-				validationErrors = append(validationErrors, utils.ApiError{Error: "Field " + validationErr.Field() + " must be provided"})
-			}
+	user, err := handler.services.CreateUser(req.Context(), userBody)
 
-			return utils.WriteJSON(res, 400, validationErrors)
-		} else {
-			return utils.WriteJSON(res, 400, utils.ApiError{Error: "not valid json."})
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	//Users created within an organization join it, so that they can be found there
+	if organizationId := auth.OrganizationFromContext(req.Context()); organizationId != nil {
+		if joinErr := handler.services.JoinOrganization(req.Context(), *organizationId, *user, models.MemberMembership); joinErr != nil {
+			return utils.WriteError(res, req, joinErr)
 		}
 	}
 
-	user, err := services.CreateUser(userBody)
+	return utils.WriteJSON(res, 201, CreateResponseUser(*user))
+}
+
+// Function that replaces a user with the document of the body, clearing the fields it doesn't have.
+// The password is kept unless the body has one. Only applies if the user has one of the ETags of the If-Match header.
+func (handler *Handler) handleUpdateUser(res http.ResponseWriter, req *http.Request) error {
+	var document services.UserDocument
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	options, preconditionErr := handler.userUpdateOptions(req)
+
+	if preconditionErr != nil {
+		return writeUserUpdateError(res, req, preconditionErr)
+	}
+
+	if parseErr := utils.ReadJSON(req.Body, &document); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	user, err := handler.services.ReplaceUser(req.Context(), handler.userScope(req, id), id, document, options)
 
 	if err != nil {
-		return utils.WriteJSON(res, 500, err.Error())
+		return writeUserUpdateError(res, req, err)
 	}
 
-	return utils.WriteJSON(res, 201, CreateResponseUser(*user))
+	res.Header().Set("ETag", userETag(user))
+
+	return utils.WriteJSON(res, 200, CreateResponseUser(*user))
+}
+
+// Function that patches a user with the patch of the body, which is either a JSON Merge Patch
+// (application/merge-patch+json) or a JSON Patch (application/json-patch+json) of its first_name, email,
+// max_sessions and password. The patched user is validated before it's stored, and nothing is stored if it isn't valid.
+// Only applies if the user has one of the ETags of the If-Match header.
+func (handler *Handler) handlePatchUser(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	options, preconditionErr := handler.userUpdateOptions(req)
+
+	if preconditionErr != nil {
+		return writeUserUpdateError(res, req, preconditionErr)
+	}
+
+	patch, readErr := io.ReadAll(req.Body)
+
+	if readErr != nil {
+		return utils.WriteError(res, req, readErr)
+	}
+
+	user, err := handler.services.PatchUser(req.Context(), handler.userScope(req, id), id, services.PatchType(mediaType), patch, options)
+
+	if err != nil {
+		return writeUserUpdateError(res, req, err)
+	}
+
+	res.Header().Set("ETag", userETag(user))
+
+	return utils.WriteJSON(res, 200, CreateResponseUser(*user))
 }
 
-func handleUpdateUser(res http.ResponseWriter, req *http.Request) error {
-	var updatedUser services.UpdateUserBody
+// Function that deletes a user, only if it has one of the ETags of the If-Match header. Within an organization,
+// the user is only removed from it, unless it's a member of no other one.
+func (handler *Handler) handleDeleteUser(res http.ResponseWriter, req *http.Request) error {
 	id, _ := strconv.Atoi(mux.Vars(req)["id"])
 
-	if parseErr := utils.ReadJSON(req.Body, &updatedUser); parseErr != nil {
-		if errors, ok := parseErr.(validator.ValidationErrors); ok {
-			validationErrors := make([]utils.ApiError, 0)
+	options, preconditionErr := handler.userUpdateOptions(req)
+
+	if preconditionErr != nil {
+		return writeUserUpdateError(res, req, preconditionErr)
+	}
+
+	if err := handler.services.DeleteUser(req.Context(), handler.userScope(req, id), id, options.Versions); err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			return utils.WriteError(res, req, services.ErrUserNotFound)
+		}
+
+		return writeUserUpdateError(res, req, err)
+	}
+
+	res.WriteHeader(204)
+	return nil
+}
+
+// AUX FUNCTIONS
 
-			for _, validationErr := range errors {
-				validationErrors = append(validationErrors, utils.ApiError{Error: "Field " + validationErr.Field() + " must be provided"})
+// Function that reads the options of an update of a user from the request: whether the caller can change the
+// session limit of users, which is set by admins so that users acting on themselves can't lift it, and the versions
+// of the If-Match header. Returns errIfMatchRequired if the header is missing and REQUIRE_IF_MATCH is set.
+func (handler *Handler) userUpdateOptions(req *http.Request) (services.UserUpdateOptions, error) {
+	caller, token := auth.UserFromContext(req.Context()), auth.TokenFromContext(req.Context())
+	options := services.UserUpdateOptions{
+		CanLimitSessions:       caller == nil || handler.services.HasPermission(*caller, models.UsersWritePermission),
+		RequireCurrentPassword: caller != nil && isSelf(req, *caller),
+	}
+
+	if options.RequireCurrentPassword && token != nil {
+		options.Family = token.Family
+	}
+
+	ifMatch := req.Header.Values("If-Match")
+
+	if len(ifMatch) == 0 {
+		if services.IfMatchRequired() {
+			return options, errIfMatchRequired
+		}
+
+		return options, nil
+	}
+
+	options.Versions = parseIfMatch(ifMatch)
+
+	return options, nil
+}
+
+// Function that returns the strong ETag of a user, which changes whenever the user does
+func userETag(user *models.User) string {
+	return `"` + strconv.FormatUint(uint64(user.Version), 10) + `"`
+}
+
+// Function that returns the versions of the ETags of an If-Match header, or nil if it's * and any version matches.
+// If-Match compares ETags strongly, so weak ones never match, as well as ETags that aren't of a user.
+func parseIfMatch(ifMatch []string) []uint {
+	versions := make([]uint, 0)
+
+	for _, etag := range splitETags(ifMatch) {
+		if etag == "*" {
+			return nil
+		}
+
+		if version, parseErr := strconv.ParseUint(strings.Trim(etag, `"`), 10, 0); parseErr == nil && !strings.HasPrefix(etag, "W/") {
+			versions = append(versions, uint(version))
+		}
+	}
+
+	return versions
+}
+
+// Function that tells whether an If-None-Match header has the given ETag, comparing them weakly
+func matchesETag(ifNoneMatch []string, etag string) bool {
+	for _, candidate := range splitETags(ifNoneMatch) {
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Function that returns the ETags of the values of a header, which are comma separated lists of them
+func splitETags(values []string) []string {
+	etags := make([]string, 0)
+
+	for _, value := range values {
+		for _, etag := range strings.Split(value, ",") {
+			if etag = strings.TrimSpace(etag); etag != "" {
+				etags = append(etags, etag)
+			}
+		}
+	}
+
+	return etags
+}
+
+// Function that writes the error of replacing, patching or deleting a user, advertising the supported
+// patch types if the patch had another one
+func writeUserUpdateError(res http.ResponseWriter, req *http.Request, err error) error {
+	if errors.Is(err, services.ErrUnsupportedPatchType) {
+		for _, patchType := range services.PatchTypes {
+			res.Header().Add("Accept-Patch", string(patchType))
+		}
+	}
+
+	return utils.WriteError(res, req, err)
+}
+
+// Function that reads the options to list users with from the query params of the request
+func parseUserListOptions(query url.Values) (services.UserListOptions, error) {
+	options := services.UserListOptions{
+		Email:     query.Get("email"),
+		FirstName: query.Get("first_name"),
+		Sort:      query.Get("sort"),
+		After:     query.Get("after"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		parsedLimit, parseErr := strconv.Atoi(limit)
+
+		if parseErr != nil || parsedLimit < 1 || parsedLimit > services.MaxUsersPageSize {
+			return options, invalidQueryError(fmt.Sprintf("limit must be a number between 1 and %d", services.MaxUsersPageSize))
+		}
+
+		options.Limit = parsedLimit
+	}
+
+	if role := query.Get("role"); role != "" {
+		parsedRole, parseErr := strconv.Atoi(role)
+
+		if parseErr != nil {
+			return options, invalidQueryError("role must be the id of a role")
+		}
+
+		userRole := models.UserRole(parsedRole)
+		options.Role = &userRole
+	}
+
+	for param, target := range map[string]**time.Time{"created_after": &options.CreatedAfter, "created_before": &options.CreatedBefore} {
+		if value := query.Get(param); value != "" {
+			parsedTime, parseErr := time.Parse(time.RFC3339, value)
+
+			if parseErr != nil {
+				return options, invalidQueryError(param + " must be an RFC 3339 time, e.g. 2006-01-02T15:04:05Z")
 			}
 
-			return utils.WriteJSON(res, 400, validationErrors)
-		} else {
-			return utils.WriteJSON(res, 400, utils.ApiError{Error: "not valid json."})
+			*target = &parsedTime
 		}
 	}
 
-	user, notFoundErr := services.UpdateUser(id, updatedUser)
+	if count := query.Get("count"); count != "" {
+		includeTotal, parseErr := strconv.ParseBool(count)
 
-	if notFoundErr != nil {
-		return utils.WriteJSON(res, 404, utils.ApiError{Error: "user not found"})
+		if parseErr != nil {
+			return options, invalidQueryError("count must be true or false")
+		}
+
+		options.IncludeTotal = includeTotal
 	}
 
-	return utils.WriteJSON(res, 201, CreateResponseUser(*user))
+	return options, nil
 }
 
-func handleDeleteUser(res http.ResponseWriter, req *http.Request) error {
-	var user models.User
-	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+func invalidQueryError(message string) error {
+	return utils.NewError(utils.KindInvalid, "invalid_query", message)
+}
 
-	database := database.GetInstance().GetDB()
+// Function that returns the URL of the request pointing to the page after the given cursor,
+// or to the first page if it's empty, keeping the rest of the query params
+func pageURL(req *http.Request, after string) string {
+	query := req.URL.Query()
+	query.Del("after")
 
-	if result := database.Find(&user, "id = ?", id); result.RowsAffected == 0 {
-		return utils.WriteJSON(res, 404, utils.ApiError{Error: "User not found."})
-	} else {
-		database.Delete(user)
-		return utils.WriteJSON(res, 201, map[string]string{"Success": "User successfully deleted."})
+	if after != "" {
+		query.Set("after", after)
 	}
+
+	pageURL := url.URL{Path: req.URL.Path, RawQuery: query.Encode()}
+
+	return pageURL.String()
 }
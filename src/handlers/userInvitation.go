@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"gocker-api/auth"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (handler *Handler) InitUserInvitationRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/invitations", handler.requirePermission(models.UsersReadPermission, handler.handleGetUserInvitations)).Methods("GET")
+	router.HandleFunc("/api/v1/invitations", handler.requireUserPermission(models.UsersWritePermission, handler.handleCreateUserInvitation)).Methods("POST")
+	router.HandleFunc("/api/v1/invitations/{id}/resend", handler.requirePermission(models.UsersWritePermission, handler.handleResendUserInvitation)).Methods("POST")
+	router.HandleFunc("/api/v1/invitations/{id}", handler.requirePermission(models.UsersWritePermission, handler.handleRevokeUserInvitation)).Methods("DELETE")
+	router.HandleFunc("/api/v1/auth/invitations/accept", utils.ParseToHandlerFunc(handler.handleAcceptUserInvitation)).Methods("POST")
+}
+
+// Function that returns the invitations that have not been accepted yet
+func (handler *Handler) handleGetUserInvitations(res http.ResponseWriter, req *http.Request) error {
+	invitations, err := handler.services.GetPendingUserInvitations()
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, invitations)
+}
+
+// Function that invites someone to create an account, by email
+func (handler *Handler) handleCreateUserInvitation(res http.ResponseWriter, req *http.Request) error {
+	var invitationBody services.UserInvitationBody
+
+	if parseErr := utils.ReadJSON(req.Body, &invitationBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	invitation, err := handler.services.InviteUser(req.Context(), *auth.UserFromContext(req.Context()), invitationBody)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 201, invitation)
+}
+
+func (handler *Handler) handleResendUserInvitation(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	invitation, err := handler.services.ResendUserInvitation(id)
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, invitation)
+}
+
+func (handler *Handler) handleRevokeUserInvitation(res http.ResponseWriter, req *http.Request) error {
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+
+	if err := handler.services.RevokeUserInvitation(id); err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 200, map[string]string{"Success": "Invitation successfully revoked."})
+}
+
+// Function that creates the account of an invited user, returning its tokens
+func (handler *Handler) handleAcceptUserInvitation(res http.ResponseWriter, req *http.Request) error {
+	var acceptBody services.AcceptUserInvitationBody
+
+	if parseErr := utils.ReadJSON(req.Body, &acceptBody); parseErr != nil {
+		return utils.WriteError(res, req, parseErr)
+	}
+
+	accessToken, refreshToken, err := handler.services.AcceptUserInvitation(req.Context(), acceptBody, newSessionInfo(req))
+
+	if err != nil {
+		return utils.WriteError(res, req, err)
+	}
+
+	return utils.WriteJSON(res, 201, AuthenticationResponse{TokenValue: accessToken.TokenValue, RefreshTokenValue: refreshToken.TokenValue})
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"gocker-api/auth"
+	"gocker-api/mail"
+	"gocker-api/models"
+	"gocker-api/services"
+	"gocker-api/storage"
+	"gocker-api/storage/storagetest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Mailer that fails to send while failing is set, and keeps the emails in memory otherwise
+type switchableMailer struct {
+	mail.MemoryMailer
+	failing bool
+}
+
+func (mailer *switchableMailer) Send(message mail.Message) error {
+	if mailer.failing {
+		return errors.New("mail server unavailable")
+	}
+
+	return mailer.MemoryMailer.Send(message)
+}
+
+// Invitations that couldn't be sent can be made again, resending keeps their validity period and
+// accepting them creates the user once
+func TestUserInvitations(t *testing.T) {
+	t.Setenv("SECRET_KEY", "test-secret-key")
+
+	storagetest.ForEachBackend(t, func(t *testing.T, repositories *storage.Repositories) {
+		mailer := &switchableMailer{}
+		svc := services.New(repositories, mailer)
+		ctx := context.Background()
+		inviter := models.User{ID: 1, Role: models.Admin}
+		email := "invited@gmail.com"
+
+		mailer.failing = true
+
+		if _, inviteErr := svc.InviteUser(ctx, inviter, services.UserInvitationBody{Email: email}); inviteErr == nil {
+			t.Fatal("expected an error inviting while the mail server is unavailable")
+		}
+
+		mailer.failing = false
+		expiresAt := time.Now().Add(time.Hour)
+		invitation, inviteErr := svc.InviteUser(ctx, inviter, services.UserInvitationBody{Email: email, ExpiresAt: &expiresAt})
+
+		if inviteErr != nil {
+			t.Fatalf("expected the invitation to be made again once it can be sent and got %v", inviteErr)
+		}
+
+		// resending keeps the validity period the invitation was sent with, instead of adding to it
+		for i := 0; i < 3; i++ {
+			if invitation, inviteErr = svc.ResendUserInvitation(int(invitation.ID)); inviteErr != nil {
+				t.Fatal(inviteErr)
+			}
+		}
+
+		if validity := invitation.ExpiresAt.Sub(invitation.SentAt); !invitation.ExpiresAt.After(expiresAt) || validity < time.Hour-time.Minute || validity > time.Hour {
+			t.Errorf("expected the resent invitation to be valid for an hour from now and got %s until %s", validity, invitation.ExpiresAt)
+		}
+
+		message, sent := mailer.LastMessageTo(email)
+		_, link, found := strings.Cut(message.Body, "?token=")
+
+		if !sent || !found {
+			t.Fatalf("expected the invitation to be sent by email and got %q", message.Body)
+		}
+
+		token, unescapeErr := url.QueryUnescape(strings.Fields(link)[0])
+
+		if unescapeErr != nil {
+			t.Fatal(unescapeErr)
+		}
+
+		body := services.AcceptUserInvitationBody{Token: token, FirstName: "invited", Password: "testpass1"}
+
+		if _, _, acceptErr := svc.AcceptUserInvitation(ctx, body, services.SessionInfo{}); acceptErr != nil {
+			t.Fatalf("expected the invitation to be accepted and got %v", acceptErr)
+		}
+
+		if user, getErr := svc.GetUserByEmail(ctx, email); getErr != nil || !user.EmailVerified {
+			t.Errorf("expected the invited user to be created with its email verified and got %v (%v)", user, getErr)
+		}
+
+		if _, _, acceptErr := svc.AcceptUserInvitation(ctx, body, services.SessionInfo{}); !errors.Is(acceptErr, services.ErrInvalidInvitation) {
+			t.Errorf("expected %v accepting the invitation again and got %v", services.ErrInvalidInvitation, acceptErr)
+		}
+	})
+}
+
+// Invitations record who made them, so tokens without a user can't make them even if their client was granted the scope
+func TestCreateUserInvitationWithoutUser(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		client, _, registerErr := handler.services.RegisterOAuthClient(services.OAuthClientBody{Name: "client", Confidential: true, Scope: services.UsersWriteScope})
+
+		if registerErr != nil {
+			t.Fatal(registerErr)
+		}
+
+		router := mux.NewRouter()
+		handler.InitUserInvitationRoutes(router)
+
+		req := httptest.NewRequest("POST", "/api/v1/invitations", strings.NewReader(`{"email": "invited@gmail.com"}`))
+		token := &models.Token{ClientRefer: &client.ID, Kind: models.Access, Scope: services.UsersWriteScope}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(auth.NewContext(req.Context(), nil, token)))
+
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "user_token_required") {
+			t.Errorf("expected 403 user_token_required for a client credentials token and got %d %s", rr.Code, rr.Body.String())
+		}
+	})
+}
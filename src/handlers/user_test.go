@@ -1,79 +1,752 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"gocker-api/auth"
+	"gocker-api/models"
+	"gocker-api/services"
 	"gocker-api/utils"
 	"io"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 )
 
 func TestUsers(t *testing.T) {
-	var tests = []struct {
-		endpoint     string
-		method       string
-		expectedCode int
-		param        string
-		body         io.Reader
-		handler      utils.APIFunc
-	}{
-		// test getting all users
-		{"/api/v1/users", "GET", 200, "", nil, handleGetUsers},
-		// test getting an specific user
-		{"/api/v1/users/{id}", "GET", 200, "1", nil, handleGetUser},
-		// test getting a not existent user
-		{"/api/v1/users/{id}", "GET", 404, "10000", nil, handleGetUser},
-		// test adding a correct user
-		{"/api/v1/users", "POST", 201, "",
-			strings.NewReader(`{"first_name": "test", "email": "test@gmail.com", "password": "testpass"}`),
-			handleCreateUser,
-		},
-		// test adding an incorrect user
-		{"/api/v1/users", "POST", 400, "",
-			strings.NewReader(`{"first_name": "test"}`),
-			handleCreateUser,
-		},
-		// test updating an existing user
-		{"/api/v1/users/{id}", "PUT", 201, "10",
-			strings.NewReader(`{"first_name": "updatedtest"}`),
-			handleUpdateUser,
-		},
-		// test deleting a user
-		{"/api/v1/users/{id}", "DELETE", 201, "10", nil, handleDeleteUser},
-	}
-
-	err := godotenv.Load("../.env")
-
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	for _, test := range tests {
-		req, reqErr := http.NewRequest(test.method, test.endpoint, test.body)
-
-		if reqErr != nil {
-			t.Fatal(reqErr)
-		}
-
-		req.Header.Add("Authorization", os.Getenv("TEST_TOKEN"))
-
-		if test.param != "" {
-			req = mux.SetURLVars(req, map[string]string{"id": test.param})
-		}
-
-		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(utils.ParseToHandlerFunc(test.handler))
-
-		handler.ServeHTTP(rr, req)
-
-		if rr.Code != test.expectedCode {
-			t.Errorf("wrong status code. expected %d and got %d, with error %s", test.expectedCode, rr.Code, rr.Body.String())
-		}
-
-	}
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		var tests = []struct {
+			endpoint     string
+			method       string
+			expectedCode int
+			param        string
+			body         io.Reader
+			handler      utils.APIFunc
+		}{
+			// test getting all users
+			{"/api/v1/users", "GET", 200, "", nil, handler.handleGetUsers},
+			// test getting an specific user
+			{"/api/v1/users/{id}", "GET", 200, "1", nil, handler.handleGetUser},
+			// test getting a not existent user
+			{"/api/v1/users/{id}", "GET", 404, "10000", nil, handler.handleGetUser},
+			// test adding a correct user
+			{"/api/v1/users", "POST", 201, "",
+				strings.NewReader(`{"first_name": "test", "email": "test@gmail.com", "password": "testpass1"}`),
+				handler.handleCreateUser,
+			},
+			// test adding an incorrect user
+			{"/api/v1/users", "POST", 400, "",
+				strings.NewReader(`{"first_name": "test"}`),
+				handler.handleCreateUser,
+			},
+			// test replacing an existing user
+			{"/api/v1/users/{id}", "PUT", 200, "2",
+				strings.NewReader(`{"first_name": "updatedtest", "email": "test@gmail.com"}`),
+				handler.handleUpdateUser,
+			},
+			// test replacing a user without every field
+			{"/api/v1/users/{id}", "PUT", 400, "2",
+				strings.NewReader(`{"first_name": "updatedtest"}`),
+				handler.handleUpdateUser,
+			},
+			// test replacing a not existent user
+			{"/api/v1/users/{id}", "PUT", 404, "10000",
+				strings.NewReader(`{"first_name": "updatedtest", "email": "test@gmail.com"}`),
+				handler.handleUpdateUser,
+			},
+			// test deleting a user
+			{"/api/v1/users/{id}", "DELETE", 204, "2", nil, handler.handleDeleteUser},
+			// test deleting an already deleted user
+			{"/api/v1/users/{id}", "DELETE", 404, "2", nil, handler.handleDeleteUser},
+		}
+
+		for _, test := range tests {
+			req, reqErr := http.NewRequest(test.method, test.endpoint, test.body)
+
+			if reqErr != nil {
+				t.Fatal(reqErr)
+			}
+
+			req = authenticateRequest(req, admin)
+
+			if test.param != "" {
+				req = mux.SetURLVars(req, map[string]string{"id": test.param})
+			}
+
+			rr := httptest.NewRecorder()
+			handlerFunc := http.HandlerFunc(utils.ParseToHandlerFunc(test.handler))
+
+			handlerFunc.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedCode {
+				t.Errorf("%s %s: wrong status code. expected %d and got %d, with error %s", test.method, test.param, test.expectedCode, rr.Code, rr.Body.String())
+			}
+		}
+	})
+}
+
+func TestUsersPagination(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		for _, name := range []string{"carol", "alice", "dave", "bob"} {
+			userBody := services.UserBody{FirstName: name, Email: name + "@gmail.com", Password: "testpass1"}
+
+			if _, createErr := handler.services.CreateUser(context.Background(), userBody); createErr != nil {
+				t.Fatal(createErr)
+			}
+		}
+
+		getUsers := func(target string) (*httptest.ResponseRecorder, ResponseUserPage) {
+			req := authenticateRequest(httptest.NewRequest("GET", target, nil), admin)
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(utils.ParseToHandlerFunc(handler.handleGetUsers)).ServeHTTP(rr, req)
+
+			var page ResponseUserPage
+			json.Unmarshal(rr.Body.Bytes(), &page)
+
+			return rr, page
+		}
+
+		// Returns the first names of every user, walking every page through the next links
+		walkPages := func(target string) []string {
+			names := make([]string, 0)
+
+			for pages := 0; target != ""; pages++ {
+				if pages > 3 {
+					t.Fatal("expected 3 pages at most")
+				}
+
+				rr, page := getUsers(target)
+
+				if rr.Code != 200 {
+					t.Fatalf("wrong status code. expected 200 and got %d, with error %s", rr.Code, rr.Body.String())
+				}
+
+				if page.Total == nil || *page.Total != 5 {
+					t.Errorf("expected a total of 5 users and got %v", page.Total)
+				}
+
+				for _, user := range page.Data {
+					names = append(names, user.FirstName)
+				}
+
+				target = ""
+
+				for _, link := range rr.Header().Values("Link") {
+					if strings.HasSuffix(link, `rel="next"`) {
+						target = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+					}
+				}
+
+				if (target == "") != (page.NextCursor == nil) {
+					t.Errorf("expected a next link only along with a next cursor")
+				}
+			}
+
+			return names
+		}
+
+		if names := walkPages("/api/v1/users?limit=2&sort=-first_name&count=true"); strings.Join(names, ",") != "dave,carol,bob,alice,admin" {
+			t.Errorf("expected every user sorted by first name descending and got %v", names)
+		}
+
+		if names := walkPages("/api/v1/users?limit=2&sort=-created_at&count=true"); strings.Join(names, ",") != "bob,dave,alice,carol,admin" {
+			t.Errorf("expected every user sorted by creation time descending and got %v", names)
+		}
+
+		// filters
+		if _, page := getUsers("/api/v1/users?role=1"); len(page.Data) != 1 || page.Data[0].ID != admin.ID {
+			t.Errorf("expected the admin to be the only user with the admin role and got %v", page.Data)
+		}
+
+		if _, page := getUsers("/api/v1/users?email=bob@gmail.com"); len(page.Data) != 1 || page.Data[0].FirstName != "bob" {
+			t.Errorf("expected bob to be the only user with its email and got %v", page.Data)
+		}
+
+		if _, page := getUsers("/api/v1/users?created_before=2000-01-01T00:00:00Z"); len(page.Data) != 0 {
+			t.Errorf("expected no user created before 2000 and got %v", page.Data)
+		}
+
+		if _, page := getUsers("/api/v1/users?created_after=2000-01-01T00:00:00Z"); len(page.Data) != 5 {
+			t.Errorf("expected every user to be created after 2000 and got %v", page.Data)
+		}
+
+		// wrong params
+		for _, target := range []string{
+			"/api/v1/users?limit=0",
+			"/api/v1/users?sort=password",
+			"/api/v1/users?after=notacursor",
+			"/api/v1/users?created_after=yesterday",
+		} {
+			if rr, _ := getUsers(target); rr.Code != 400 {
+				t.Errorf("%s: wrong status code. expected 400 and got %d", target, rr.Code)
+			}
+		}
+
+		// a cursor can't be used with another sort
+		_, firstPage := getUsers("/api/v1/users?limit=1&sort=email")
+
+		if rr, _ := getUsers("/api/v1/users?sort=first_name&after=" + *firstPage.NextCursor); rr.Code != 400 {
+			t.Errorf("expected a cursor of another sort to be rejected and got %d", rr.Code)
+		}
+	})
+}
+
+func TestPatchUser(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		userBody := services.UserBody{FirstName: "test", Email: "test@gmail.com", Password: "testpass1"}
+		user, createErr := handler.services.CreateUser(context.Background(), userBody)
+
+		if createErr != nil {
+			t.Fatal(createErr)
+		}
+
+		userId := strconv.Itoa(int(user.ID))
+
+		send := func(method string, contentType string, body string, caller *models.User) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/v1/users/"+userId, strings.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+			req = mux.SetURLVars(authenticateRequest(req, caller), map[string]string{"id": userId})
+
+			apiFunc := handler.handlePatchUser
+
+			if method == "PUT" {
+				apiFunc = handler.handleUpdateUser
+			}
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(utils.ParseToHandlerFunc(apiFunc)).ServeHTTP(rr, req)
+
+			return rr
+		}
+
+		var tests = []struct {
+			name         string
+			method       string
+			contentType  string
+			body         string
+			expectedCode int
+		}{
+			{"merge patch", "PATCH", "application/merge-patch+json", `{"first_name": "merged", "max_sessions": 3}`, 200},
+			{"json patch", "PATCH", "application/json-patch+json; charset=utf-8",
+				`[{"op": "test", "path": "/first_name", "value": "merged"}, {"op": "replace", "path": "/first_name", "value": "patched"}]`, 200},
+			{"failing test operation", "PATCH", "application/json-patch+json",
+				`[{"op": "test", "path": "/first_name", "value": "merged"}, {"op": "replace", "path": "/first_name", "value": "other"}]`, 409},
+			{"unknown operation", "PATCH", "application/json-patch+json", `[{"op": "increment", "path": "/max_sessions"}]`, 400},
+			{"malformed patch", "PATCH", "application/merge-patch+json", `{"first_name": `, 400},
+			{"missing path", "PATCH", "application/json-patch+json", `[{"op": "replace", "path": "/last_name", "value": "test"}]`, 422},
+			{"cleared required field", "PATCH", "application/merge-patch+json", `{"email": null}`, 422},
+			{"unknown field", "PATCH", "application/merge-patch+json", `{"role": 1}`, 422},
+			{"wrong type", "PATCH", "application/merge-patch+json", `{"max_sessions": "many"}`, 422},
+			{"registered email", "PATCH", "application/merge-patch+json", `{"email": "` + testAdminEmail + `"}`, 409},
+			{"unsupported type", "PATCH", "application/json", `{"first_name": "plain"}`, 415},
+		}
+
+		for _, test := range tests {
+			if rr := send(test.method, test.contentType, test.body, admin); rr.Code != test.expectedCode {
+				t.Errorf("%s: wrong status code. expected %d and got %d, with error %s", test.name, test.expectedCode, rr.Code, rr.Body.String())
+			}
+		}
+
+		// every failing patch left the user as the last successful one did
+		patched, _ := handler.services.GetUserById(context.Background(), int(user.ID))
+
+		if patched.FirstName != "patched" || patched.Email != userBody.Email || patched.MaxSessions == nil || *patched.MaxSessions != 3 {
+			t.Errorf("expected only the successful patches to be applied and got %+v", patched)
+		}
+
+		if rr := send("PATCH", "application/json", `{}`, admin); len(rr.Header().Values("Accept-Patch")) != 2 {
+			t.Errorf("expected the supported patch types to be advertised and got %v", rr.Header().Values("Accept-Patch"))
+		}
+
+		// users can't lift the session limit of their own, but can patch the rest
+		if rr := send("PATCH", "application/merge-patch+json", `{"max_sessions": null}`, patched); rr.Code != 403 {
+			t.Errorf("expected a user not to be able to clear its session limit and got %d", rr.Code)
+		}
+
+		// and must send their current password to change their password or email, which ends their other sessions
+		ctx := context.Background()
+		login := services.UserAuthenticateBody{Email: userBody.Email, Password: userBody.Password}
+		current, _, loginErr := handler.services.AuthenticateUser(ctx, login, services.SessionInfo{})
+		other, _, otherLoginErr := handler.services.AuthenticateUser(ctx, login, services.SessionInfo{})
+		_, _, createErr = handler.services.CreatePersonalAccessToken(*patched, services.PersonalAccessTokenBody{Name: "ci", Scope: services.UsersReadScope})
+
+		if loginErr != nil || otherLoginErr != nil || createErr != nil {
+			t.Fatal(loginErr, otherLoginErr, createErr)
+		}
+
+		sendAsSelf := func(body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("PATCH", "/api/v1/users/"+userId, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			req = mux.SetURLVars(req.WithContext(auth.NewContext(req.Context(), patched, current)), map[string]string{"id": userId})
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(utils.ParseToHandlerFunc(handler.handlePatchUser)).ServeHTTP(rr, req)
+
+			return rr
+		}
+
+		for _, test := range []struct {
+			body         string
+			expectedCode int
+		}{
+			{`{"password": "newpass12"}`, 403},
+			{`{"password": "newpass12", "current_password": "wrongpass1"}`, 403},
+			{`{"email": "new@gmail.com"}`, 403},
+			{`{"password": "newpass12", "current_password": "testpass1"}`, 200},
+		} {
+			if rr := sendAsSelf(test.body); rr.Code != test.expectedCode {
+				t.Errorf("%s: wrong status code. expected %d and got %d, with error %s", test.body, test.expectedCode, rr.Code, rr.Body.String())
+			}
+		}
+
+		patched, _ = handler.services.GetUserById(ctx, int(user.ID))
+
+		if patched.ComparePassword("newpass12") != nil {
+			t.Error("expected the password to be changed")
+		}
+
+		if _, getErr := handler.services.GetTokenByValue(ctx, current.TokenValue); getErr != nil {
+			t.Errorf("expected the session that changed the password to stay open (%v)", getErr)
+		}
+
+		if _, getErr := handler.services.GetTokenByValue(ctx, other.TokenValue); getErr == nil {
+			t.Error("expected the other sessions to end when the password changes")
+		}
+
+		if tokens, _ := handler.services.GetPersonalAccessTokens(*patched); len(tokens) != 0 {
+			t.Errorf("expected the personal access tokens to be revoked when the password changes and got %d", len(tokens))
+		}
+
+		// a new email has to be verified again
+		verificationToken, _ := auth.GeneratePurposeToken(*patched, auth.EmailVerificationPurpose, time.Minute)
+
+		if verifyErr := handler.services.VerifyEmail(ctx, services.VerifyEmailBody{Token: verificationToken}); verifyErr != nil {
+			t.Fatal(verifyErr)
+		}
+
+		if rr := sendAsSelf(`{"email": "new@gmail.com", "current_password": "newpass12"}`); rr.Code != 200 {
+			t.Errorf("expected a user to be able to change its email and got %d, with error %s", rr.Code, rr.Body.String())
+		}
+
+		if patched, _ = handler.services.GetUserById(ctx, int(user.ID)); patched.Email != "new@gmail.com" || patched.EmailVerified {
+			t.Errorf("expected the new email not to be verified and got %+v", patched)
+		}
+
+		// PUT replaces every field, clearing the ones that aren't sent but the password
+		if rr := send("PUT", "application/json", `{"first_name": "replaced", "email": "replaced@gmail.com"}`, admin); rr.Code != 200 {
+			t.Fatalf("wrong status code. expected 201 and got %d, with error %s", rr.Code, rr.Body.String())
+		}
+
+		replaced, _ := handler.services.GetUserById(context.Background(), int(user.ID))
+
+		if replaced.FirstName != "replaced" || replaced.Email != "replaced@gmail.com" || replaced.MaxSessions != nil || replaced.ComparePassword("newpass12") != nil {
+			t.Errorf("expected the user to be replaced but its password and got %+v", replaced)
+		}
+	})
+}
+
+func TestUserETags(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		user, createErr := handler.services.CreateUser(context.Background(), services.UserBody{FirstName: "test", Email: "test@gmail.com", Password: "testpass1"})
+
+		if createErr != nil {
+			t.Fatal(createErr)
+		}
+
+		userId := strconv.Itoa(int(user.ID))
+
+		send := func(method string, apiFunc utils.APIFunc, headers map[string]string, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/v1/users/"+userId, strings.NewReader(body))
+
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+
+			req = mux.SetURLVars(authenticateRequest(req, admin), map[string]string{"id": userId})
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(utils.ParseToHandlerFunc(apiFunc)).ServeHTTP(rr, req)
+
+			return rr
+		}
+
+		etag := send("GET", handler.handleGetUser, nil, "").Header().Get("ETag")
+
+		if etag == "" || strings.HasPrefix(etag, "W/") {
+			t.Fatalf("expected a strong ETag and got %q", etag)
+		}
+
+		if rr := send("GET", handler.handleGetUser, map[string]string{"If-None-Match": etag}, ""); rr.Code != 304 || rr.Body.Len() != 0 {
+			t.Errorf("expected 304 without a body for the current ETag and got %d", rr.Code)
+		}
+
+		patch := map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": etag}
+
+		rr := send("PATCH", handler.handlePatchUser, patch, `{"first_name": "first"}`)
+
+		if rr.Code != 200 || rr.Header().Get("ETag") == etag {
+			t.Fatalf("expected the patch to apply and change the ETag and got %d, with error %s", rr.Code, rr.Body.String())
+		}
+
+		// the second edit was made from the first version, so it would overwrite the first one
+		if rr := send("PATCH", handler.handlePatchUser, patch, `{"first_name": "second"}`); rr.Code != 412 {
+			t.Errorf("expected a patch of an old version to fail and got %d", rr.Code)
+		}
+
+		if rr := send("PUT", handler.handleUpdateUser, map[string]string{"If-Match": etag}, `{"first_name": "second", "email": "test@gmail.com"}`); rr.Code != 412 {
+			t.Errorf("expected a replacement of an old version to fail and got %d", rr.Code)
+		}
+
+		if rr := send("DELETE", handler.handleDeleteUser, map[string]string{"If-Match": etag}, ""); rr.Code != 412 {
+			t.Errorf("expected a deletion of an old version to fail and got %d", rr.Code)
+		}
+
+		if rr := send("GET", handler.handleGetUser, map[string]string{"If-None-Match": etag}, ""); rr.Code != 200 {
+			t.Errorf("expected the user to be returned for an old ETag and got %d", rr.Code)
+		}
+
+		// changes the API makes on its own apply to a user read before a client's update, since nobody
+		// could act on their 412, but they still change the ETag
+		beforeEnrollment := send("GET", handler.handleGetUser, nil, "").Header().Get("ETag")
+
+		if _, enrollErr := handler.services.EnrollTOTP(context.Background(), user); enrollErr != nil {
+			t.Errorf("expected the enrollment of a user read before an update to apply and got %v", enrollErr)
+		}
+
+		if rr := send("GET", handler.handleGetUser, map[string]string{"If-None-Match": beforeEnrollment}, ""); rr.Code != 200 || !strings.Contains(rr.Body.String(), `"first"`) {
+			t.Errorf("expected the enrollment to change the ETag and keep the client's update and got %d %s", rr.Code, rr.Body.String())
+		}
+
+		// If-Match can be required
+		t.Setenv("REQUIRE_IF_MATCH", "true")
+
+		if rr := send("PUT", handler.handleUpdateUser, nil, `{"first_name": "second", "email": "test@gmail.com"}`); rr.Code != 428 {
+			t.Errorf("expected a replacement without If-Match to be rejected and got %d", rr.Code)
+		}
+
+		if rr := send("PUT", handler.handleUpdateUser, map[string]string{"If-Match": "*"}, `{"first_name": "second", "email": "test@gmail.com"}`); rr.Code != 200 {
+			t.Errorf("expected a replacement of any version to apply and got %d, with error %s", rr.Code, rr.Body.String())
+		}
+
+		current := send("GET", handler.handleGetUser, nil, "").Header().Get("ETag")
+
+		if rr := send("DELETE", handler.handleDeleteUser, map[string]string{"If-Match": `"0", ` + current}, ""); rr.Code != 204 {
+			t.Errorf("expected the deletion of the current version to apply and got %d, with error %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+func TestUserProblems(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		send := func(req *http.Request, apiFunc utils.APIFunc) (*httptest.ResponseRecorder, utils.Problem) {
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(utils.ParseToHandlerFunc(apiFunc)).ServeHTTP(rr, authenticateRequest(req, admin))
+
+			var problem utils.Problem
+			json.Unmarshal(rr.Body.Bytes(), &problem)
+
+			return rr, problem
+		}
+
+		rr, problem := send(mux.SetURLVars(httptest.NewRequest("GET", "/api/v1/users/10000", nil), map[string]string{"id": "10000"}), handler.handleGetUser)
+
+		if rr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("expected a problem+json response and got %s", rr.Header().Get("Content-Type"))
+		}
+
+		if problem.Status != 404 || problem.Code != "user_not_found" || problem.Title != "Not Found" || problem.Type != "about:blank" || problem.Instance != "/api/v1/users/10000" {
+			t.Errorf("expected the problem of a not found user and got %+v", problem)
+		}
+
+		if _, problem := send(httptest.NewRequest("POST", "/api/v1/users", strings.NewReader(`{"first_name": `)), handler.handleCreateUser); problem.Status != 400 || problem.Code != utils.CodeInvalidJSON {
+			t.Errorf("expected the problem of a body that isn't json and got %+v", problem)
+		}
+
+		_, problem = send(httptest.NewRequest("POST", "/api/v1/users", strings.NewReader(`{"first_name": "test"}`)), handler.handleCreateUser)
+
+		if problem.Status != 400 || problem.Code != utils.CodeValidationFailed || len(problem.Errors) != 2 {
+			t.Errorf("expected the problem of the email and the password missing and got %+v", problem)
+		}
+
+		// services errors get the status of their kind instead of a 500
+		userBody := `{"first_name": "test", "email": "` + testAdminEmail + `", "password": "testpass1"}`
+
+		if _, problem := send(httptest.NewRequest("POST", "/api/v1/users", strings.NewReader(userBody)), handler.handleCreateUser); problem.Status != 409 || problem.Code != "email_already_registered" {
+			t.Errorf("expected the problem of a registered email and got %+v", problem)
+		}
+
+		// types point to the documentation of the problems, if there's any
+		t.Setenv("PROBLEM_TYPES_URL", "https://example.com/problems/")
+
+		if _, problem := send(httptest.NewRequest("GET", "/api/v1/users?sort=password", nil), handler.handleGetUsers); problem.Type != "https://example.com/problems/invalid_sort" {
+			t.Errorf("expected the type of the problem under PROBLEM_TYPES_URL and got %s", problem.Type)
+		}
+	})
+}
+
+func TestUserValidationErrors(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		tests := []struct {
+			body string
+			// field, rule and param of every error, in the order of the fields
+			errors []string
+		}{
+			{`{}`, []string{"first_name required ", "email required ", "password required "}},
+			{`{"first_name": "test", "email": "test", "password": "testpass1"}`, []string{"email email "}},
+			{`{"first_name": "test", "email": "test@gmail.com", "password": "short1"}`, []string{"password min 8"}},
+			{`{"first_name": "test", "email": "test@gmail.com", "password": "onlyletters"}`, []string{"password password "}},
+			{`{"first_name": "` + strings.Repeat("a", 101) + `", "email": "test@gmail.com", "password": "` + strings.Repeat("a1", 37) + `"}`, []string{"first_name max 100", "password max 72"}},
+		}
+
+		for _, test := range tests {
+			rr := httptest.NewRecorder()
+			req := authenticateRequest(httptest.NewRequest("POST", "/api/v1/users", strings.NewReader(test.body)), admin)
+
+			http.HandlerFunc(utils.ParseToHandlerFunc(handler.handleCreateUser)).ServeHTTP(rr, req)
+
+			var problem utils.Problem
+			json.Unmarshal(rr.Body.Bytes(), &problem)
+
+			var errors []string
+
+			for _, fieldErr := range problem.Errors {
+				errors = append(errors, fieldErr.Field+" "+fieldErr.Rule+" "+fieldErr.Param)
+			}
+
+			if rr.Code != 400 || !slices.Equal(errors, test.errors) {
+				t.Errorf("expected the errors %v with %s and got %v (%d)", test.errors, test.body, errors, rr.Code)
+			}
+		}
+
+		// messages are in the language of the request, and in English if it's not supported
+		languages := []struct {
+			acceptLanguage string
+			language       string
+			message        string
+		}{
+			{"", "en", "email is a required field"},
+			{"es-ES,es;q=0.9,en;q=0.8", "es", "email es un campo requerido"},
+			{"de, en;q=0.5, es;q=0.7", "es", "email es un campo requerido"},
+			{"de", "en", "email is a required field"},
+		}
+
+		for _, test := range languages {
+			rr := httptest.NewRecorder()
+			req := authenticateRequest(httptest.NewRequest("POST", "/api/v1/users", strings.NewReader(`{"first_name": "test", "password": "testpass1"}`)), admin)
+			req.Header.Set("Accept-Language", test.acceptLanguage)
+
+			http.HandlerFunc(utils.ParseToHandlerFunc(handler.handleCreateUser)).ServeHTTP(rr, req)
+
+			var problem utils.Problem
+			json.Unmarshal(rr.Body.Bytes(), &problem)
+
+			if len(problem.Errors) != 1 || problem.Errors[0].Message != test.message || rr.Header().Get("Content-Language") != test.language {
+				t.Errorf("expected %q in %s for Accept-Language %q and got %+v in %s", test.message, test.language, test.acceptLanguage, problem.Errors, rr.Header().Get("Content-Language"))
+			}
+		}
+	})
+}
+
+// Requests made within an organization only reach its members, and requests made within none reach nobody
+func TestUserTenantIsolation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler *Handler, admin *models.User) {
+		ctx := context.Background()
+		users := map[string]*models.User{}
+
+		for _, name := range []string{"member", "outsider", "shared"} {
+			user, createErr := handler.services.CreateUser(ctx, services.UserBody{FirstName: name, Email: name + "@gmail.com", Password: "testpass1"})
+
+			if createErr != nil {
+				t.Fatal(createErr)
+			}
+
+			users[name] = user
+		}
+
+		organizationA, createErr := handler.services.CreateOrganization(ctx, *admin, services.OrganizationBody{Name: "A"})
+
+		if createErr != nil {
+			t.Fatal(createErr)
+		}
+
+		organizationB, createErr := handler.services.CreateOrganization(ctx, *users["outsider"], services.OrganizationBody{Name: "B"})
+
+		if createErr != nil {
+			t.Fatal(createErr)
+		}
+
+		for _, joining := range []struct {
+			organizationId uint
+			user           *models.User
+		}{{organizationA.ID, users["member"]}, {organizationA.ID, users["shared"]}, {organizationB.ID, users["shared"]}} {
+			if joinErr := handler.services.JoinOrganization(ctx, joining.organizationId, *joining.user, models.MemberMembership); joinErr != nil {
+				t.Fatal(joinErr)
+			}
+		}
+
+		// reaching every organization is granted by a permission of the role, not by being an admin
+		for name, permissions := range map[string][]models.Permission{
+			"member":   {models.UsersReadPermission},
+			"outsider": {models.UsersReadPermission, models.UsersAllOrganizationsPermission},
+		} {
+			role, roleErr := handler.services.CreateRole(*admin, services.RoleBody{Name: name, Permissions: permissions})
+
+			if roleErr != nil {
+				t.Fatal(roleErr)
+			}
+
+			if users[name], roleErr = handler.services.AssignRole(ctx, *admin, int(users[name].ID), services.AssignRoleBody{RoleID: int(role.ID)}); roleErr != nil {
+				t.Fatal(roleErr)
+			}
+		}
+
+		firstParty := func(user *models.User, organizationId *uint) func(req *http.Request) *http.Request {
+			return func(req *http.Request) *http.Request {
+				token := &models.Token{UserRefer: &user.ID, Kind: models.Access}
+
+				return req.WithContext(auth.WithOrganization(auth.NewContext(req.Context(), user, token), organizationId))
+			}
+		}
+
+		clientId := uint(1)
+		callers := map[string]func(req *http.Request) *http.Request{
+			"role with users:read":              firstParty(users["member"], &organizationA.ID),
+			"role with users:all-organizations": firstParty(users["outsider"], &organizationB.ID),
+			// a personal access token of the admin acts within its active organization, not as the admin
+			"personal access token": func(req *http.Request) *http.Request {
+				token := &models.Token{UserRefer: &admin.ID, Kind: models.Personal, Scope: "users:read users:write"}
+
+				return req.WithContext(auth.WithOrganization(auth.NewContext(req.Context(), admin, token), &organizationA.ID))
+			},
+			// a client credentials token has no user nor organization
+			"client credentials token": func(req *http.Request) *http.Request {
+				token := &models.Token{ClientRefer: &clientId, Kind: models.Access, Scope: "users:read users:write"}
+
+				return req.WithContext(auth.WithOrganization(auth.NewContext(req.Context(), nil, token), nil))
+			},
+		}
+
+		send := func(caller string, method string, apiFunc utils.APIFunc, id uint, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/api/v1/users", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			req = callers[caller](req)
+
+			if id != 0 {
+				req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(int(id))})
+			}
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(utils.ParseToHandlerFunc(apiFunc)).ServeHTTP(rr, req)
+
+			return rr
+		}
+
+		listed := func(caller string) []string {
+			rr := send(caller, "GET", handler.handleGetUsers, 0, "")
+
+			var page struct {
+				Data []models.User `json:"data"`
+			}
+
+			if decodeErr := json.NewDecoder(rr.Body).Decode(&page); decodeErr != nil {
+				t.Fatal(decodeErr)
+			}
+
+			names := []string{}
+
+			for _, user := range page.Data {
+				names = append(names, user.FirstName)
+			}
+
+			slices.Sort(names)
+
+			return names
+		}
+
+		if names := listed("personal access token"); !slices.Equal(names, []string{"admin", "member", "shared"}) {
+			t.Errorf("expected to list the members of the organization only and got %v", names)
+		}
+
+		if names := listed("role with users:read"); !slices.Equal(names, []string{"admin", "member", "shared"}) {
+			t.Errorf("expected a role without users:all-organizations to list the members of the organization only and got %v", names)
+		}
+
+		if names := listed("role with users:all-organizations"); !slices.Equal(names, []string{"admin", "member", "outsider", "shared"}) {
+			t.Errorf("expected a role with users:all-organizations to list every user and got %v", names)
+		}
+
+		if names := listed("client credentials token"); len(names) != 0 {
+			t.Errorf("expected to list no user without an organization and got %v", names)
+		}
+
+		var reaches = []struct {
+			caller      string
+			reachable   []*models.User
+			unreachable []*models.User
+		}{
+			{"personal access token", []*models.User{users["member"]}, []*models.User{users["outsider"]}},
+			{"client credentials token", nil, []*models.User{admin, users["member"], users["outsider"]}},
+			{"role with users:read", []*models.User{users["member"], users["shared"]}, []*models.User{users["outsider"]}},
+			{"role with users:all-organizations", []*models.User{admin, users["member"], users["outsider"]}, nil},
+		}
+
+		for _, reach := range reaches {
+			for _, user := range reach.reachable {
+				if rr := send(reach.caller, "GET", handler.handleGetUser, user.ID, ""); rr.Code != http.StatusOK {
+					t.Errorf("%s: expected to get %s and got %d", reach.caller, user.FirstName, rr.Code)
+				}
+			}
+
+			for _, user := range reach.unreachable {
+				for _, test := range []struct {
+					method  string
+					apiFunc utils.APIFunc
+					body    string
+				}{
+					{"GET", handler.handleGetUser, ""},
+					{"PATCH", handler.handlePatchUser, `{"first_name": "patched"}`},
+					{"DELETE", handler.handleDeleteUser, ""},
+				} {
+					if rr := send(reach.caller, test.method, test.apiFunc, user.ID, test.body); rr.Code != http.StatusNotFound {
+						t.Errorf("%s: %s %s: expected 404 and got %d", reach.caller, test.method, user.FirstName, rr.Code)
+					}
+				}
+			}
+		}
+
+		if stored, _ := handler.services.GetUserById(ctx, int(users["outsider"].ID)); stored == nil || stored.FirstName != "outsider" {
+			t.Errorf("expected the user of the other organization to be left untouched and got %v", stored)
+		}
+
+		// deleting a user that belongs to another organization only removes it from this one
+		if rr := send("personal access token", "DELETE", handler.handleDeleteUser, users["shared"].ID, ""); rr.Code >= 300 {
+			t.Fatalf("expected to delete the shared user and got %d", rr.Code)
+		}
+
+		if _, getErr := handler.services.GetUserById(ctx, int(users["shared"].ID)); getErr != nil {
+			t.Errorf("expected the shared user to be kept (%v)", getErr)
+		}
+
+		if _, notFoundErr := handler.services.GetMembership(organizationA.ID, users["shared"].ID); notFoundErr == nil {
+			t.Error("expected the shared user not to be a member of the organization anymore")
+		}
+
+		if _, notFoundErr := handler.services.GetMembership(organizationB.ID, users["shared"].ID); notFoundErr != nil {
+			t.Errorf("expected the shared user to still be a member of the other organization (%v)", notFoundErr)
+		}
+
+		// while a user that only belongs to this one is deleted
+		if rr := send("personal access token", "DELETE", handler.handleDeleteUser, users["member"].ID, ""); rr.Code >= 300 {
+			t.Fatalf("expected to delete the member and got %d", rr.Code)
+		}
+
+		if _, getErr := handler.services.GetUserById(ctx, int(users["member"].ID)); getErr == nil {
+			t.Error("expected the member to be deleted")
+		}
+	})
 }
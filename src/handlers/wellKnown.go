@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"gocker-api/auth"
+	"gocker-api/services"
+	"gocker-api/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// OpenID Provider metadata, as described in OpenID Connect Discovery 1.0 section 3
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+func (handler *Handler) InitWellKnownRoutes(router *mux.Router) {
+	router.HandleFunc("/.well-known/jwks.json", utils.ParseToHandlerFunc(handler.handleGetJWKS)).Methods("GET")
+	router.HandleFunc("/.well-known/openid-configuration", utils.ParseToHandlerFunc(handler.handleGetOpenIDConfiguration)).Methods("GET")
+}
+
+// Function that returns the public keys used to verify the API tokens, as a JWK set
+func (handler *Handler) handleGetJWKS(res http.ResponseWriter, req *http.Request) error {
+	keyRing, keyRingErr := auth.GetKeyRing()
+
+	if keyRingErr != nil {
+		return utils.WriteError(res, req, keyRingErr)
+	}
+
+	res.Header().Set("Cache-Control", "public, max-age=300")
+
+	return utils.WriteJSON(res, 200, keyRing.JWKS())
+}
+
+// Function that returns the OpenID Provider metadata, so that clients can configure themselves
+func (handler *Handler) handleGetOpenIDConfiguration(res http.ResponseWriter, req *http.Request) error {
+	issuer := auth.Issuer()
+	signingAlgs := make([]string, 0, 1)
+
+	if alg := auth.IDTokenSigningAlg(); alg != "" {
+		signingAlgs = append(signingAlgs, alg)
+	}
+
+	res.Header().Set("Cache-Control", "public, max-age=300")
+
+	return utils.WriteJSON(res, 200, OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  signingAlgs,
+		ScopesSupported:                   services.GetSupportedScopes(),
+		ClaimsSupported:                   services.GetSupportedClaims(),
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	})
+}
@@ -0,0 +1,135 @@
+package hashing
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idIdentifier = "argon2id"
+
+// Hasher that uses argon2id, tunable through ARGON2_MEMORY (KiB), ARGON2_ITERATIONS and ARGON2_PARALLELISM.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2idParams struct {
+	version     int
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// Returns a new argon2id hasher with the parameters read from the env (or the OWASP recommended ones).
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      uint32(getEnvUint("ARGON2_MEMORY", 64*1024, 32)),
+		Iterations:  uint32(getEnvUint("ARGON2_ITERATIONS", 3, 32)),
+		Parallelism: uint8(getEnvUint("ARGON2_PARALLELISM", 2, 8)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (hasher *Argon2idHasher) Identifiers() []string {
+	return []string{argon2idIdentifier}
+}
+
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, hasher.SaltLength)
+
+	if _, readErr := io.ReadFull(rand.Reader, salt); readErr != nil {
+		return "", readErr
+	}
+
+	key := argon2.IDKey([]byte(password), salt, hasher.Iterations, hasher.Memory, hasher.Parallelism, hasher.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idIdentifier,
+		argon2.Version,
+		hasher.Memory,
+		hasher.Iterations,
+		hasher.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (hasher *Argon2idHasher) Verify(password string, encodedHash string) error {
+	params, decodeErr := decodeArgon2id(encodedHash)
+
+	if decodeErr != nil {
+		return decodeErr
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+
+	if subtle.ConstantTimeCompare(key, params.key) != 1 {
+		return ErrMismatchedPassword
+	}
+
+	return nil
+}
+
+func (hasher *Argon2idHasher) NeedsRehash(encodedHash string) bool {
+	params, decodeErr := decodeArgon2id(encodedHash)
+
+	if decodeErr != nil {
+		return true
+	}
+
+	return params.version != argon2.Version ||
+		params.memory != hasher.Memory ||
+		params.iterations != hasher.Iterations ||
+		params.parallelism != hasher.Parallelism ||
+		uint32(len(params.key)) != hasher.KeyLength
+}
+
+// AUX FUNCTIONS
+
+// Function that decodes a $argon2id$v=19$m=65536,t=3,p=2$salt$key string
+func decodeArgon2id(encodedHash string) (*argon2idParams, error) {
+	parts := strings.Split(encodedHash, "$")
+
+	if len(parts) != 6 || parts[1] != argon2idIdentifier {
+		return nil, ErrUnknownHashFormat
+	}
+
+	params := &argon2idParams{}
+
+	if _, scanErr := fmt.Sscanf(parts[2], "v=%d", &params.version); scanErr != nil {
+		return nil, ErrUnknownHashFormat
+	}
+
+	if _, scanErr := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); scanErr != nil {
+		return nil, ErrUnknownHashFormat
+	}
+
+	salt, saltErr := base64.RawStdEncoding.DecodeString(parts[4])
+
+	if saltErr != nil {
+		return nil, ErrUnknownHashFormat
+	}
+
+	key, keyErr := base64.RawStdEncoding.DecodeString(parts[5])
+
+	if keyErr != nil || len(key) == 0 {
+		return nil, ErrUnknownHashFormat
+	}
+
+	params.salt = salt
+	params.key = key
+
+	return params, nil
+}
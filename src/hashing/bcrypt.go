@@ -0,0 +1,54 @@
+package hashing
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher that uses bcrypt, tunable through BCRYPT_COST.
+// bcrypt's own modular crypt format ($2a$10$...) is already PHC-style, so it's stored as is.
+type BcryptHasher struct {
+	Cost int
+}
+
+// Returns a new bcrypt hasher with the cost read from the env.
+func NewBcryptHasher() *BcryptHasher {
+	cost := int(getEnvUint("BCRYPT_COST", 12, 8))
+
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = 12
+	}
+
+	return &BcryptHasher{Cost: cost}
+}
+
+func (hasher *BcryptHasher) Identifiers() []string {
+	return []string{"2b", "2a", "2y"}
+}
+
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	hash, hashErr := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
+
+	if hashErr != nil {
+		return "", hashErr
+	}
+
+	return string(hash), nil
+}
+
+func (hasher *BcryptHasher) Verify(password string, encodedHash string) error {
+	compareErr := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+
+	if errors.Is(compareErr, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatchedPassword
+	}
+
+	return compareErr
+}
+
+func (hasher *BcryptHasher) NeedsRehash(encodedHash string) bool {
+	cost, costErr := bcrypt.Cost([]byte(encodedHash))
+
+	return costErr != nil || cost != hasher.Cost
+}
@@ -0,0 +1,116 @@
+package hashing
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrMismatchedPassword = errors.New("wrong password. Please, try again")
+	ErrUnknownHashFormat  = errors.New("unknown password hash format")
+)
+
+// Interface that every password hashing algorithm must implement.
+// Hashes are encoded as PHC-style strings ($id$params$salt$hash), so the algorithm
+// and its cost parameters travel together with the hash itself.
+type PasswordHasher interface {
+	// Returns the PHC identifier(s) this hasher is able to verify
+	Identifiers() []string
+	Hash(password string) (string, error)
+	Verify(password string, encodedHash string) error
+	// Returns true if the encoded hash was produced with parameters different from the current ones
+	NeedsRehash(encodedHash string) bool
+}
+
+var hashers = []PasswordHasher{
+	&Argon2idHasher{},
+	&BcryptHasher{},
+}
+
+// Returns the hasher configured through the PASSWORD_HASHER env var, using argon2id by default.
+func Default() PasswordHasher {
+	switch strings.ToLower(os.Getenv("PASSWORD_HASHER")) {
+	case "bcrypt":
+		return NewBcryptHasher()
+	default:
+		return NewArgon2idHasher()
+	}
+}
+
+// Returns the hasher that is able to verify the given encoded hash, or nil if none is.
+func Identify(encodedHash string) PasswordHasher {
+	id := identifier(encodedHash)
+
+	if id == "" {
+		return nil
+	}
+
+	for _, hasher := range hashers {
+		for _, hasherId := range hasher.Identifiers() {
+			if hasherId == id {
+				return hasher
+			}
+		}
+	}
+
+	return nil
+}
+
+// Function that verifies a password against any supported encoded hash.
+func Verify(password string, encodedHash string) error {
+	hasher := Identify(encodedHash)
+
+	if hasher == nil {
+		return ErrUnknownHashFormat
+	}
+
+	return hasher.Verify(password, encodedHash)
+}
+
+// Function that checks if an encoded hash should be replaced with one generated by the default hasher.
+func NeedsRehash(encodedHash string) bool {
+	hasher := Identify(encodedHash)
+	defaultHasher := Default()
+
+	if hasher == nil || hasher.Identifiers()[0] != defaultHasher.Identifiers()[0] {
+		return true
+	}
+
+	return defaultHasher.NeedsRehash(encodedHash)
+}
+
+// AUX FUNCTIONS
+
+// Returns the PHC identifier of an encoded hash, i.e. the text between the first two '$'
+func identifier(encodedHash string) string {
+	if !strings.HasPrefix(encodedHash, "$") {
+		return ""
+	}
+
+	parts := strings.SplitN(encodedHash[1:], "$", 2)
+
+	if len(parts) < 2 {
+		return ""
+	}
+
+	return parts[0]
+}
+
+// Reads an unsigned integer from the env, returning the fallback if it's not present or not valid
+func getEnvUint(key string, fallback uint64, bitSize int) uint64 {
+	value, isPresent := os.LookupEnv(key)
+
+	if !isPresent {
+		return fallback
+	}
+
+	parsed, parseErr := strconv.ParseUint(value, 10, bitSize)
+
+	if parseErr != nil || parsed == 0 {
+		return fallback
+	}
+
+	return parsed
+}
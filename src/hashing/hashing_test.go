@@ -0,0 +1,71 @@
+package hashing
+
+import (
+	"testing"
+)
+
+func TestHashers(t *testing.T) {
+	var tests = []struct {
+		hasher        PasswordHasher
+		password      string
+		candidate     string
+		expectedMatch bool
+	}{
+		// test argon2id with the right password
+		{&Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, "testpass", "testpass", true},
+		// test argon2id with a wrong password
+		{&Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, "testpass", "wrongpass", false},
+		// test bcrypt with the right password
+		{&BcryptHasher{Cost: 4}, "testpass", "testpass", true},
+		// test bcrypt with a wrong password
+		{&BcryptHasher{Cost: 4}, "testpass", "wrongpass", false},
+	}
+
+	for _, test := range tests {
+		hash, hashErr := test.hasher.Hash(test.password)
+
+		if hashErr != nil {
+			t.Fatal(hashErr)
+		}
+
+		if Identify(hash) == nil {
+			t.Errorf("hash %s was not identified", hash)
+		}
+
+		if verifyErr := Verify(test.candidate, hash); (verifyErr == nil) != test.expectedMatch {
+			t.Errorf("wrong verification result for %s. expected match %t and got error %v", hash, test.expectedMatch, verifyErr)
+		}
+
+		if test.hasher.NeedsRehash(hash) {
+			t.Errorf("hash %s should not need a rehash with the parameters it was created with", hash)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	t.Setenv("PASSWORD_HASHER", "argon2id")
+	t.Setenv("ARGON2_MEMORY", "1024")
+	t.Setenv("ARGON2_ITERATIONS", "1")
+	t.Setenv("ARGON2_PARALLELISM", "1")
+
+	current, _ := NewArgon2idHasher().Hash("testpass")
+	outdated, _ := (&Argon2idHasher{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}).Hash("testpass")
+	bcryptHash, _ := (&BcryptHasher{Cost: 4}).Hash("testpass")
+
+	var tests = []struct {
+		hash     string
+		expected bool
+	}{
+		{current, false},
+		{outdated, true},
+		{bcryptHash, true},
+		// legacy AES encrypted passwords are not PHC strings
+		{"\x8f\x01legacy", true},
+	}
+
+	for _, test := range tests {
+		if result := NeedsRehash(test.hash); result != test.expected {
+			t.Errorf("wrong rehash result for %q. expected %t and got %t", test.hash, test.expected, result)
+		}
+	}
+}
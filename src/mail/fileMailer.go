@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Mailer that writes every email as an .eml file inside Dir, for local development
+type FileMailer struct {
+	Dir string
+}
+
+func NewFileMailer(dir string) *FileMailer {
+	if dir == "" {
+		dir = "mails"
+	}
+
+	return &FileMailer{Dir: dir}
+}
+
+func (mailer *FileMailer) Send(message Message) error {
+	if mkdirErr := os.MkdirAll(mailer.Dir, 0755); mkdirErr != nil {
+		return mkdirErr
+	}
+
+	fileName := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitizeFileName(message.To))
+
+	return os.WriteFile(filepath.Join(mailer.Dir, fileName), formatMessage(os.Getenv("MAIL_FROM"), message), 0644)
+}
+
+// Function that keeps only the characters of an address that are safe in a file name
+func sanitizeFileName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("@.-_+", r) {
+			return r
+		}
+		return '_'
+	}, value)
+}
@@ -0,0 +1,12 @@
+package mail
+
+import "log"
+
+// Mailer that writes every email to the log instead of sending it, for local development
+type LogMailer struct{}
+
+func (mailer *LogMailer) Send(message Message) error {
+	log.Printf("Email to %s: %s\n%s\n", message.To, message.Subject, message.Body)
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"errors"
+	"os"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Interface that every way of delivering emails must implement
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Returns a new mailer, configured through the MAILER env var: smtp, or file and log for local development.
+// It must be set, so that a server that can't deliver emails fails at startup instead of dropping them.
+func NewMailer() (Mailer, error) {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		if os.Getenv("SMTP_HOST") == "" {
+			return nil, errors.New("SMTP_HOST must be set to send emails through SMTP")
+		}
+
+		return NewSMTPMailer(), nil
+	case "file":
+		return NewFileMailer(os.Getenv("MAIL_DIR")), nil
+	case "log":
+		return &LogMailer{}, nil
+	default:
+		return nil, errors.New("MAILER must be set to smtp, or to file or log for local development")
+	}
+}
@@ -0,0 +1,40 @@
+package mail
+
+import "sync"
+
+// Mailer that keeps every email in memory, so tests can read them
+type MemoryMailer struct {
+	messages []Message
+	lock     sync.Mutex
+}
+
+func (mailer *MemoryMailer) Send(message Message) error {
+	mailer.lock.Lock()
+	defer mailer.lock.Unlock()
+
+	mailer.messages = append(mailer.messages, message)
+
+	return nil
+}
+
+// Returns all the emails sent so far
+func (mailer *MemoryMailer) Messages() []Message {
+	mailer.lock.Lock()
+	defer mailer.lock.Unlock()
+
+	return append([]Message(nil), mailer.messages...)
+}
+
+// Returns the last email sent to the given address, if there's one
+func (mailer *MemoryMailer) LastMessageTo(to string) (Message, bool) {
+	mailer.lock.Lock()
+	defer mailer.lock.Unlock()
+
+	for i := len(mailer.messages) - 1; i >= 0; i-- {
+		if mailer.messages[i].To == to {
+			return mailer.messages[i], true
+		}
+	}
+
+	return Message{}, false
+}
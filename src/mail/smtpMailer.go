@@ -0,0 +1,64 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Mailer that sends emails through an SMTP server, configured with SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPMailer() *SMTPMailer {
+	port := os.Getenv("SMTP_PORT")
+
+	if port == "" {
+		port = "587"
+	}
+
+	return &SMTPMailer{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	}
+}
+
+func (mailer *SMTPMailer) Send(message Message) error {
+	var smtpAuth smtp.Auth
+
+	if mailer.Username != "" {
+		smtpAuth = smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(mailer.Host, mailer.Port), smtpAuth, mailer.From, []string{message.To}, formatMessage(mailer.From, message))
+}
+
+// Function that formats a message as a plain text RFC 5322 email
+func formatMessage(from string, message Message) []byte {
+	var builder strings.Builder
+
+	builder.WriteString("From: " + sanitizeHeader(from) + "\r\n")
+	builder.WriteString("To: " + sanitizeHeader(message.To) + "\r\n")
+	builder.WriteString("Subject: " + sanitizeHeader(message.Subject) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(message.Body)
+
+	return []byte(builder.String())
+}
+
+// Function that removes line breaks from a header value, so it can't inject other headers
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"gocker-api/api"
+	"gocker-api/auth"
+	"gocker-api/database"
+	"gocker-api/mail"
+	"gocker-api/services"
+	"gocker-api/storage"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"github.com/joho/godotenv"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -16,6 +26,36 @@ func main() {
 		log.Fatal(envErr)
 	}
 
+	isMigrateCommand := len(os.Args) > 1 && os.Args[1] == "migrate"
+	var repositories *storage.Repositories
+
+	//DB_DRIVER selects where the data is kept: postgres (the default one), sqlite or memory
+	if driver := os.Getenv("DB_DRIVER"); driver == "memory" {
+		if isMigrateCommand {
+			log.Fatal("the memory driver has no schema to migrate")
+		}
+
+		repositories = storage.NewMemoryRepositories()
+	} else {
+		db, dbErr := database.Open(driver, os.Getenv("DB_STRING"))
+
+		if dbErr != nil {
+			log.Fatal(dbErr)
+		}
+
+		if isMigrateCommand {
+			runMigrateCommand(db, os.Args[2:])
+			return
+		}
+
+		//the schema is migrated as a separate deploy step, so refuse to serve an outdated one
+		if migrationsErr := database.CheckMigrations(db); migrationsErr != nil {
+			log.Fatal(migrationsErr)
+		}
+
+		repositories = storage.NewRepositories(db)
+	}
+
 	var listenAddress string
 	port, isPresent := os.LookupEnv("PORT")
 
@@ -25,7 +65,100 @@ func main() {
 		listenAddress = ":8080"
 	}
 
-	server := api.APIServer{ListenAddress: listenAddress}
+	//load the signing keys up front, so a wrong key setup fails at startup
+	if _, keyRingErr := auth.GetKeyRing(); keyRingErr != nil {
+		log.Fatal(keyRingErr)
+	}
+
+	go reloadKeysOnSignal()
+
+	mailer, mailerErr := mail.NewMailer()
+
+	if mailerErr != nil {
+		log.Fatal(mailerErr)
+	}
+
+	server := api.NewAPIServer(listenAddress, services.New(repositories, mailer))
 	log.Printf("Server listening at %s\n", server.ListenAddress)
 	log.Fatal(server.Run())
 }
+
+// Function that reloads the JWT signing keys every time the process receives a SIGHUP,
+// so keys can be rotated without restarting the server.
+func reloadKeysOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		if reloadErr := auth.ReloadKeyRing(); reloadErr != nil {
+			log.Printf("Could not reload signing keys: %s\n", reloadErr)
+		} else {
+			log.Println("Signing keys reloaded")
+		}
+	}
+}
+
+// Function that runs the migrate subcommand: `migrate up` applies every pending migration,
+// `migrate down [steps]` reverts the last ones applied (one by default), and `migrate status` lists them.
+func runMigrateCommand(db *gorm.DB, args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: migrate up | down [steps] | status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, migrateErr := database.MigrateUp(db)
+
+		for _, migration := range applied {
+			log.Printf("Applied migration %d_%s\n", migration.Version, migration.Name)
+		}
+
+		if migrateErr != nil {
+			log.Fatal(migrateErr)
+		}
+
+		log.Printf("%d migrations applied\n", len(applied))
+	case "down":
+		steps := 1
+
+		if len(args) > 1 {
+			parsedSteps, parseErr := strconv.Atoi(args[1])
+
+			if parseErr != nil || parsedSteps < 1 {
+				log.Fatal("steps must be a positive number")
+			}
+
+			steps = parsedSteps
+		}
+
+		reverted, migrateErr := database.MigrateDown(db, steps)
+
+		for _, migration := range reverted {
+			log.Printf("Reverted migration %d_%s\n", migration.Version, migration.Name)
+		}
+
+		if migrateErr != nil {
+			log.Fatal(migrateErr)
+		}
+
+		log.Printf("%d migrations reverted\n", len(reverted))
+	case "status":
+		statuses, statusErr := database.GetMigrationStatus(db)
+
+		if statusErr != nil {
+			log.Fatal(statusErr)
+		}
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+
+			if status.AppliedAt != nil {
+				appliedAt = "applied at " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		log.Fatal("usage: migrate up | down [steps] | status")
+	}
+}
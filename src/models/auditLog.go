@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Record of a change made through the API. ActorRefer is the user who made it, and the target
+// is identified by its type (e.g. role or user) and id. Details holds what changed, as JSON.
+type AuditLog struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ActorRefer *uint     `json:"actor_id" gorm:"index"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetID   uint      `json:"target_id"`
+	Details    string    `json:"details"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Code issued by the authorize endpoint once the user consents, to be exchanged by the client
+// for tokens. Only its SHA-256 hash is stored, and it can only be exchanged once.
+type AuthorizationCode struct {
+	ID                  uint        `json:"id" gorm:"primaryKey"`
+	CodeHash            string      `gorm:"uniqueIndex"`
+	ClientRefer         uint        `gorm:"index"`
+	Client              OAuthClient `gorm:"foreignKey:ClientRefer;constraint:OnDelete:CASCADE;"`
+	UserRefer           uint
+	User                User `gorm:"foreignKey:UserRefer;constraint:OnDelete:CASCADE;"`
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	ExpiresAt           time.Time
+	UsedAt              *time.Time
+	// Family of the tokens the code was exchanged for, to revoke them if the code is replayed
+	Family string
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Challenge of a login of a user with two-factor authentication, exchanged along with a code for its tokens.
+// Only the SHA-256 hash of its token is stored, and every attempt to answer it is counted, so that codes
+// can't be guessed by retrying the same challenge.
+type MFAChallenge struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserRefer uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	Attempts  int
+	ExpiresAt time.Time
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Application registered to get tokens through OAuth 2.0. Confidential clients authenticate
+// with a secret, of which only the hash is stored. Public clients (SPAs, mobile apps) can't
+// keep a secret, so they must use PKCE instead.
+type OAuthClient struct {
+	ID           uint     `json:"id" gorm:"primaryKey"`
+	ClientID     string   `json:"client_id" gorm:"uniqueIndex"`
+	SecretHash   string   `json:"-"`
+	Name         string   `json:"name"`
+	Confidential bool     `json:"confidential"`
+	RedirectURIs []string `json:"redirect_uris" gorm:"serializer:json"`
+	// Space separated scopes the client is allowed to request
+	Scopes    string    `json:"scope"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Returns true if the given URI is exactly one of the client's redirect URIs
+func (client OAuthClient) HasRedirectURI(uri string) bool {
+	for _, redirectURI := range client.RedirectURIs {
+		if redirectURI == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true if every scope of the given space separated list is allowed for the client
+func (client OAuthClient) AllowsScope(scope string) bool {
+	allowed := strings.Fields(client.Scopes)
+
+	for _, requested := range strings.Fields(scope) {
+		found := false
+
+		for _, allowedScope := range allowed {
+			if allowedScope == requested {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
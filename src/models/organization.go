@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// Tenant grouping users through their memberships. A user can belong to several organizations,
+// and its tokens act within the one that was active when they were issued.
+type Organization struct {
+	ID          uint         `json:"id" gorm:"primaryKey"`
+	Name        string       `json:"name"`
+	Memberships []Membership `json:"-" gorm:"foreignKey:OrganizationRefer;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// Role of a user within an organization, unrelated to its Role in the whole API
+type MembershipRole string
+
+const (
+	OwnerMembership  MembershipRole = "owner"
+	AdminMembership  MembershipRole = "admin"
+	MemberMembership MembershipRole = "member"
+)
+
+var membershipRanks = map[MembershipRole]int{OwnerMembership: 3, AdminMembership: 2, MemberMembership: 1}
+
+// Returns true if the membership role is one of owner, admin or member
+func (role MembershipRole) Valid() bool {
+	return membershipRanks[role] > 0
+}
+
+// Returns true if the membership role grants at least what the given one grants
+// (owners can do whatever admins can, and admins whatever members can)
+func (role MembershipRole) AtLeast(other MembershipRole) bool {
+	return membershipRanks[role] >= membershipRanks[other]
+}
+
+type Membership struct {
+	ID                uint           `json:"id" gorm:"primaryKey"`
+	OrganizationRefer uint           `json:"organization_id" gorm:"uniqueIndex:idx_membership"`
+	UserRefer         uint           `json:"user_id" gorm:"uniqueIndex:idx_membership;index"`
+	Role              MembershipRole `json:"role"`
+	CreatedAt         time.Time      `json:"created_at"`
+}
+
+// Invitation to join an organization, sent by email. Only the SHA-256 hash of the token sent is stored.
+type OrganizationInvitation struct {
+	ID                uint           `json:"id" gorm:"primaryKey"`
+	OrganizationRefer uint           `json:"organization_id" gorm:"index"`
+	Organization      *Organization  `json:"-" gorm:"foreignKey:OrganizationRefer;constraint:OnDelete:CASCADE;"`
+	Email             string         `json:"email"`
+	Role              MembershipRole `json:"role"`
+	TokenHash         string         `json:"-" gorm:"uniqueIndex"`
+	InvitedByRefer    *uint          `json:"invited_by"`
+	ExpiresAt         time.Time      `json:"expires_at"`
+	AcceptedAt        *time.Time     `json:"accepted_at"`
+	CreatedAt         time.Time      `json:"created_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Single-use password reset request. Only the SHA-256 hash of the token sent by email is stored.
+type PasswordReset struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	UserRefer uint   `json:"user_id" gorm:"index"`
+	TokenHash string `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
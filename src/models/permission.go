@@ -0,0 +1,45 @@
+package models
+
+import "strings"
+
+// Named permission a route can require. Permissions ending in :self only allow acting on the user itself.
+type Permission string
+
+const (
+	UsersReadPermission             Permission = "users:read"
+	UsersReadSelfPermission         Permission = "users:read:self"
+	UsersWritePermission            Permission = "users:write"
+	UsersWriteSelfPermission        Permission = "users:write:self"
+	UsersAllOrganizationsPermission Permission = "users:all-organizations"
+	OAuthClientsReadPermission      Permission = "oauth-clients:read"
+	OAuthClientsWritePermission     Permission = "oauth-clients:write"
+	RolesReadPermission             Permission = "roles:read"
+	RolesWritePermission            Permission = "roles:write"
+)
+
+var allPermissions = []Permission{
+	UsersReadPermission, UsersReadSelfPermission, UsersWritePermission, UsersWriteSelfPermission, UsersAllOrganizationsPermission,
+	OAuthClientsReadPermission, OAuthClientsWritePermission, RolesReadPermission, RolesWritePermission,
+}
+
+// Returns every permission roles can be granted
+func AllPermissions() []Permission {
+	return allPermissions
+}
+
+// Returns true if the permission is one of AllPermissions
+func (permission Permission) Valid() bool {
+	for _, known := range allPermissions {
+		if known == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the OAuth scope a token limited by one needs to use the permission, which is the
+// permission itself without its :self suffix (e.g. users:read for users:read:self)
+func (permission Permission) Scope() string {
+	return strings.TrimSuffix(string(permission), ":self")
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Long-lived token a user creates for scripts and automation. It's not tied to a session, so
+// logging in or out doesn't revoke it. Only the SHA-256 hash of the token is stored.
+type PersonalAccessToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserRefer  uint       `json:"user_id" gorm:"index"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex"`
+	Scopes     string     `json:"scope"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Returns true if the token has an expiry and it has passed
+func (token PersonalAccessToken) Expired() bool {
+	return token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now())
+}
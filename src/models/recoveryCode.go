@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// One-time code that can be used instead of a TOTP code. Only its SHA-256 hash is stored.
+type RecoveryCode struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	UserRefer uint   `json:"user_id" gorm:"index"`
+	CodeHash  string `json:"-"`
+	UsedAt    *time.Time
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Role of a user, granting it a set of permissions. Users refer to it through User.Role, so that
+// changing the permissions of a role applies to its users (and their tokens) on their next request.
+// Built-in roles are seeded with the ids of the Admin and Standard constants, and can't be deleted.
+type Role struct {
+	ID          UserRole     `json:"id" gorm:"primaryKey"`
+	Name        string       `json:"name" gorm:"uniqueIndex"`
+	Description string       `json:"description"`
+	Permissions []Permission `json:"permissions" gorm:"serializer:json"`
+	BuiltIn     bool         `json:"built_in"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// Returns the roles every database starts with
+func BuiltInRoles() []Role {
+	return []Role{
+		{
+			ID:          Admin,
+			Name:        "admin",
+			Description: "Manages every user, role and OAuth client",
+			Permissions: AllPermissions(),
+			BuiltIn:     true,
+		},
+		{
+			ID:          Standard,
+			Name:        "standard",
+			Description: "Manages its own account",
+			Permissions: []Permission{UsersReadSelfPermission, UsersWriteSelfPermission},
+			BuiltIn:     true,
+		},
+	}
+}
+
+// Returns true if the role grants the given permission
+func (role Role) HasPermission(permission Permission) bool {
+	for _, granted := range role.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Session groups the access/refresh pair issued for a login, through the token Family,
+// together with the metadata of the device that logged in.
+type Session struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserRefer  uint      `json:"user_id" gorm:"index"`
+	Family     string    `json:"-" gorm:"uniqueIndex"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
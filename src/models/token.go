@@ -1,15 +1,40 @@
 package models
 
+import "time"
+
 type TokenKind int
 
 const (
 	Access TokenKind = iota + 1
 	Refresh
+	// Personal access tokens are stored apart, as PersonalAccessToken. Tokens of this kind
+	// only represent them in the request context, so they're never saved.
+	Personal
+	// Tokens of the login sessions the browser keeps in a cookie, to authorize OAuth clients.
+	// They can't be used as bearer tokens.
+	Login
 )
 
+// Every access/refresh pair issued for the same login shares a Family. Each refresh token
+// issued by a refresh call points to the one it replaced through ParentRefer.
+// Tokens issued to an OAuth client carry its ClientRefer and the Scope granted to it. Tokens
+// issued through the client credentials grant act on behalf of the client, so they have no user.
 type Token struct {
-	ID         uint   `json:"id" gorm:"primaryKey"`
-	TokenValue string `json:"token" validate:"required"`
-	UserRefer  uint   `json:"user_id" validate:"required"`
-	Kind       TokenKind
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	TokenValue  string `json:"token" validate:"required"`
+	UserRefer   *uint  `json:"user_id"`
+	Kind        TokenKind
+	Family      string       `json:"family" gorm:"index"`
+	ParentRefer *uint        `json:"parent_id"`
+	Parent      *Token       `json:"-" gorm:"foreignKey:ParentRefer;constraint:OnDelete:SET NULL;"`
+	UsedAt      *time.Time   `json:"used_at"`
+	ClientRefer *uint        `json:"client_id"`
+	Client      *OAuthClient `json:"-" gorm:"foreignKey:ClientRefer;constraint:OnDelete:CASCADE;"`
+	Scope       string       `json:"scope"`
+}
+
+// Returns true if what the token can access is limited by its Scope, which is the case
+// of the tokens issued to OAuth clients and of personal access tokens
+func (token Token) Scoped() bool {
+	return token.ClientRefer != nil || token.Kind == Personal
 }
@@ -3,12 +3,13 @@ package models
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
-	"errors"
-	"io"
+	"crypto/subtle"
+	"gocker-api/hashing"
 	"os"
+	"time"
 )
 
+// Id of the Role of a user. Admin and Standard are the ids of the built-in roles.
 type UserRole int
 
 const (
@@ -18,41 +19,64 @@ const (
 
 type User struct {
 	ID        uint    `json:"id" gorm:"primaryKey"`
-	FirstName string  `json:"first_name" validate:"required"`
-	Email     string  `json:"email" validate:"required"`
+	FirstName string  `json:"first_name" validate:"required" gorm:"index"`
+	Email     string  `json:"email" validate:"required" gorm:"uniqueIndex"`
 	Password  []byte  `json:"password" validate:"required"`
 	Tokens    []Token `gorm:"foreignKey:UserRefer;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	Role      UserRole
+	// Set once the user follows the link sent to its email. Only enforced if REQUIRE_EMAIL_VERIFICATION is set
+	EmailVerified bool `json:"email_verified"`
+	// Maximum number of active sessions, overriding MAX_SESSIONS_PER_USER when set. 0 means unlimited
+	MaxSessions *int      `json:"max_sessions"`
+	Sessions    []Session `gorm:"foreignKey:UserRefer;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// TOTP secret, set on enrollment but only required to authenticate once TOTPEnabled
+	TOTPSecret      string         `json:"-"`
+	TOTPEnabled     bool           `json:"totp_enabled"`
+	TOTPLastCounter int64          `json:"-"`
+	RecoveryCodes   []RecoveryCode `json:"-" gorm:"foreignKey:UserRefer;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Memberships     []Membership   `json:"-" gorm:"foreignKey:UserRefer;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// Organization new tokens are issued for. Tokens keep the one that was active when they were issued
+	ActiveOrganizationRefer *uint         `json:"active_organization_id"`
+	ActiveOrganization      *Organization `json:"-" gorm:"foreignKey:ActiveOrganizationRefer;constraint:OnDelete:SET NULL;"`
+	CreatedAt               time.Time     `json:"created_at" gorm:"index"`
+	// Incremented by every update. The updates clients make only apply to the version they read, so that
+	// concurrent ones never overwrite each other. It's the ETag of the user.
+	Version uint `json:"-" gorm:"not null"`
 }
 
-// Function that encodes user's password using AES encryption.
+// Function that hashes user's password with the configured password hasher.
 func (user *User) EncodePassword(password string) error {
-	key := getPasswordKey()
-	cipherBlock, cipherErr := aes.NewCipher([]byte(key))
+	hash, hashErr := hashing.Default().Hash(password)
 
-	if cipherErr != nil {
-		return cipherErr
+	if hashErr != nil {
+		return hashErr
 	}
 
-	gcm, gcmErr := cipher.NewGCM(cipherBlock)
+	user.Password = []byte(hash)
 
-	if gcmErr != nil {
-		return gcmErr
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
+	return nil
+}
 
-	if _, readErr := io.ReadFull(rand.Reader, nonce); readErr != nil {
-		return readErr
+// Function that compares the input password to the user's stored one.
+// Rows that still hold a legacy AES encrypted password are decrypted with USER_PASSWORD_KEY.
+func (user User) ComparePassword(password string) error {
+	if hashing.Identify(string(user.Password)) == nil {
+		return user.compareLegacyPassword(password)
 	}
 
-	user.Password = gcm.Seal(nonce, nonce, []byte(password), nil)
+	return hashing.Verify(password, string(user.Password))
+}
 
-	return nil
+// Function that checks if the user's password should be hashed again, either because it's
+// a legacy AES encrypted one or because the hasher or its cost parameters have changed.
+func (user User) PasswordNeedsRehash() bool {
+	return hashing.NeedsRehash(string(user.Password))
 }
 
-// Function that decodes user's password using AES decryption and compares it to the input password.
-func (user User) ComparePassword(password string) error {
+// AUX FUNCTIONS
+
+// Function that decodes user's legacy password using AES decryption and compares it to the input password.
+func (user User) compareLegacyPassword(password string) error {
 	key := getPasswordKey()
 
 	cipherBlock, cipherErr := aes.NewCipher([]byte(key))
@@ -62,12 +86,17 @@ func (user User) ComparePassword(password string) error {
 	}
 
 	gcm, gcmErr := cipher.NewGCM(cipherBlock)
-	nonceSize := gcm.NonceSize()
 
-	if gcmErr != nil || (len(user.Password) < nonceSize) {
+	if gcmErr != nil {
 		return gcmErr
 	}
 
+	nonceSize := gcm.NonceSize()
+
+	if len(user.Password) < nonceSize {
+		return hashing.ErrUnknownHashFormat
+	}
+
 	nonce, cipherText := user.Password[:nonceSize], user.Password[nonceSize:]
 
 	passwordText, decryptErr := gcm.Open(nil, []byte(nonce), []byte(cipherText), nil)
@@ -76,8 +105,8 @@ func (user User) ComparePassword(password string) error {
 		return decryptErr
 	}
 
-	if string(passwordText) != password {
-		return errors.New("wrong password. Please, try again")
+	if subtle.ConstantTimeCompare(passwordText, []byte(password)) != 1 {
+		return hashing.ErrMismatchedPassword
 	}
 
 	return nil
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Invitation to create an account with a pre-assigned role, sent by email. The invitee sets its
+// password when accepting it. Only the SHA-256 hash of the token sent is stored, and resending
+// the invitation replaces it.
+type UserInvitation struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	Email          string     `json:"email" gorm:"index"`
+	Role           UserRole   `json:"role_id"`
+	TokenHash      string     `json:"-" gorm:"uniqueIndex"`
+	InvitedByRefer *uint      `json:"invited_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	SentAt         time.Time  `json:"sent_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
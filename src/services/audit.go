@@ -0,0 +1,42 @@
+package services
+
+import (
+	"encoding/json"
+	"gocker-api/models"
+)
+
+// Types of the targets of audited changes
+const (
+	RoleAuditTarget = "role"
+	UserAuditTarget = "user"
+)
+
+// Function that returns the records of changes made to roles and to the roles of users
+func (services *Services) GetRoleAuditLog() ([]*models.AuditLog, error) {
+	return services.auditLogStorage.GetByTargetTypes([]string{RoleAuditTarget, UserAuditTarget})
+}
+
+// AUX FUNCTIONS
+
+// Returns the record of a change made by the given user, to be stored along with the change.
+// The details are stored as JSON.
+func newAuditLog(actor *models.User, action string, targetType string, targetId uint, details interface{}) (*models.AuditLog, error) {
+	encodedDetails, encodeErr := json.Marshal(details)
+
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+
+	auditLog := &models.AuditLog{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetId,
+		Details:    string(encodedDetails),
+	}
+
+	if actor != nil {
+		auditLog.ActorRefer = &actor.ID
+	}
+
+	return auditLog, nil
+}
@@ -1,10 +1,15 @@
 package services
 
 import (
-	"errors"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"gocker-api/auth"
-	"gocker-api/database"
+	"gocker-api/hashing"
 	"gocker-api/models"
+	"gocker-api/utils"
+	"log"
+	"sync"
 )
 
 type UserAuthenticateBody struct {
@@ -16,152 +21,399 @@ type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
 
-// Function that registers a new user to the API, returning access token and refresh token
-func RegisterUser(userBody UserBody) (accessToken *models.Token, refreshToken *models.Token, err error) {
+// Hash of no user's password, made with the default hasher. Passwords are checked against it when the email isn't
+// registered, so that logging in takes as long for unknown emails as for known ones.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashing.Default().Hash("not the password of any user")
+	return hash
+})
+
+// Function that registers a new user to the API, returning access token and refresh token.
+// Only allowed if the registration mode is open.
+func (services *Services) RegisterUser(ctx context.Context, userBody UserBody, info SessionInfo) (accessToken *models.Token, refreshToken *models.Token, err error) {
+	if GetRegistrationMode() != OpenRegistration {
+		err = ErrRegistrationNotOpen
+		return
+	}
+
 	// Save a new user into the database
-	user, parseErr := CreateUser(userBody)
+	user, parseErr := services.CreateUser(ctx, userBody)
 
 	if parseErr != nil {
 		err = parseErr
 		return
 	}
 
-	//Generate both an access token and a refresh token for that user and save them to the databse
-	tokenString, tokenErr := auth.GenerateToken(*user, models.Access)
+	//Send the user a link to verify its email. Failing to do so must not fail the registration
+	if mailErr := services.sendVerificationEmail(*user); mailErr != nil {
+		log.Printf("Could not send verification email to user %d: %s\n", user.ID, mailErr)
+	}
 
-	if tokenErr != nil {
-		err = tokenErr
+	//Unverified users can't log in, so no tokens are issued until they verify their email
+	if EmailVerificationRequired() {
 		return
 	}
 
-	accessToken = &models.Token{
-		TokenValue: tokenString,
-		UserRefer:  user.ID,
-		Kind:       models.Access,
-	}
+	//Start a session for that user, generating both an access token and a refresh token
+	return services.startSession(ctx, *user, info)
+}
 
-	refreshTokenString, refreshTokenErr := auth.GenerateToken(*user, models.Refresh)
+var (
+	ErrMissingToken       = utils.NewError(utils.KindUnauthorized, "missing_token", "authorization token must be provided, starting with Bearer")
+	ErrInvalidCredentials = utils.NewError(utils.KindUnauthorized, "invalid_credentials", "email or password not valid")
+	ErrInvalidToken       = utils.NewError(utils.KindUnauthorized, "invalid_token", "token not valid")
+	ErrTokenRevoked       = utils.NewError(utils.KindUnauthorized, "token_revoked", "token revoked")
+)
 
-	if refreshTokenErr != nil {
-		err = refreshTokenErr
+// Function that authenticates a user, returning a new access token and refresh token
+func (services *Services) AuthenticateUser(ctx context.Context, userAuth UserAuthenticateBody, info SessionInfo) (accessToken *models.Token, refreshToken *models.Token, err error) {
+	user, credentialsErr := services.checkCredentials(ctx, userAuth)
+
+	if credentialsErr != nil {
+		err = credentialsErr
 		return
 	}
 
-	refreshToken = &models.Token{
-		TokenValue: refreshTokenString,
-		UserRefer:  user.ID,
-		Kind:       models.Refresh,
+	//Users with two-factor authentication get a challenge instead, to be exchanged at VerifyMFA
+	if user.TOTPEnabled {
+		challengeToken, challengeErr := services.createMFAChallenge(*user)
+
+		if challengeErr != nil {
+			err = challengeErr
+			return
+		}
+
+		err = &MFARequiredError{ChallengeToken: challengeToken}
+		return
 	}
 
-	CreateToken(accessToken)
-	CreateToken(refreshToken)
+	//Start a new session, keeping the user's other sessions active
+	return services.startSession(ctx, *user, info)
+}
 
-	return
+// Function that rotates a user refresh token, providing him a new access token and a new refresh token.
+// The refresh token used can't be used again: replaying it revokes every token of its family.
+func (services *Services) RefreshToken(ctx context.Context, request RefreshTokenRequest, info SessionInfo) (accessToken *models.Token, refreshToken *models.Token, err error) {
+	return services.rotateRefreshToken(ctx, request.RefreshToken, nil, info)
 }
 
-// Function that authenticates a user, returning a new access token and refresh token
-func AuthenticateUser(userAuth UserAuthenticateBody) (accessToken *models.Token, refreshToken *models.Token, err error) {
+// AUX FUNCTIONS
+
+// Function that returns the user with the given email and password, if it's allowed to log in
+func (services *Services) checkCredentials(ctx context.Context, userAuth UserAuthenticateBody) (*models.User, error) {
 	//Checking if user exists and if password matches
-	user, notFoundErr := GetUserByEmail(userAuth.Email)
+	user, notFoundErr := services.GetUserByEmail(ctx, userAuth.Email)
 
+	//Both fail the same way and take as long, so that it can't be used to find out which emails are registered
 	if notFoundErr != nil {
-		err = errors.New("user not found")
+		hashing.Verify(userAuth.Password, dummyPasswordHash())
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.ComparePassword(userAuth.Password) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if EmailVerificationRequired() && !user.EmailVerified {
+		return nil, utils.NewError(utils.KindForbidden, "email_not_verified", "email not verified. Please, follow the link sent to your email")
+	}
+
+	//Upgrade the stored password if it's a legacy one or was hashed with outdated parameters
+	if user.PasswordNeedsRehash() {
+		if rehashErr := services.rehashUserPassword(ctx, user, userAuth.Password); rehashErr != nil {
+			return nil, rehashErr
+		}
+	}
+
+	return user, nil
+}
+
+// Function that rotates a refresh token issued to the given OAuth client, or a first-party one if client is nil
+func (services *Services) rotateRefreshToken(ctx context.Context, tokenString string, client *models.OAuthClient, info SessionInfo) (accessToken *models.Token, refreshToken *models.Token, err error) {
+	// Check if refresh token is valid
+	if jwtErr := auth.ValidateToken(tokenString); jwtErr != nil {
+		err = ErrInvalidToken
 		return
-	} else if wrongPasswordErr := user.ComparePassword(userAuth.Password); wrongPasswordErr != nil {
-		err = wrongPasswordErr
+	}
+
+	//Check that the refresh token has not been revoked
+	oldRefreshToken, notFoundErr := services.tokenStorage.GetByValue(ctx, tokenString)
+
+	if notFoundErr != nil || oldRefreshToken.Kind != models.Refresh || oldRefreshToken.UserRefer == nil {
+		err = ErrTokenRevoked
 		return
 	}
 
-	//Revoke all user previous tokens
-	revokeAllUserTokens(*user)
+	//A refresh token can only be used by the client it was issued to
+	if !issuedTo(*oldRefreshToken, client) {
+		err = ErrInvalidToken
+		return
+	}
 
-	//Generate a new access token and refresh token
-	newAccessToken, accessTokenErr := auth.GenerateToken(*user, models.Access)
+	//Tokens issued before families existed start one now, so a replay never revokes other users' tokens
+	if oldRefreshToken.Family == "" {
+		if adoptErr := services.adoptLegacyToken(ctx, oldRefreshToken, info); adoptErr != nil {
+			err = adoptErr
+			return
+		}
+	}
 
-	if accessTokenErr != nil {
-		err = accessTokenErr
+	//Mark it as used. If it already was, someone is replaying it, so the whole family is revoked
+	firstUse, markErr := services.tokenStorage.MarkUsed(ctx, oldRefreshToken)
+
+	if markErr != nil {
+		err = markErr
 		return
 	}
 
-	accessToken = &models.Token{
-		TokenValue: newAccessToken,
-		UserRefer:  user.ID,
-		Kind:       models.Access,
+	if !firstUse {
+		if revokeErr := services.revokeFamily(ctx, oldRefreshToken.Family); revokeErr != nil {
+			err = revokeErr
+			return
+		}
+
+		err = utils.NewError(utils.KindUnauthorized, "refresh_token_reused", "refresh token reuse detected. All tokens of this session have been revoked")
+		return
+	}
+
+	user, notFoundErr := services.GetUserById(ctx, int(*oldRefreshToken.UserRefer))
+
+	if notFoundErr != nil {
+		err = notFoundErr
+		return
+	}
+
+	//Revoke the family's previous access tokens, since a new one is issued
+	if revokeErr := services.revokeFamilyAccessTokens(ctx, oldRefreshToken.Family); revokeErr != nil {
+		err = revokeErr
+		return
 	}
 
-	newRefreshToken, refreshTokenErr := auth.GenerateToken(*user, models.Refresh)
+	if touchErr := services.TouchSession(*oldRefreshToken); touchErr != nil {
+		err = touchErr
+		return
+	}
+
+	return services.issueTokenPair(ctx, *user, oldRefreshToken.Family, &oldRefreshToken.ID, client, oldRefreshToken.Scope)
+}
+
+// Function that ends the session the given access token belongs to, revoking it and its paired refresh token
+func (services *Services) Logout(ctx context.Context, accessToken models.Token) error {
+	// tokens issued before families existed have none, so only the presented one can be revoked
+	if accessToken.Family == "" {
+		return services.DeleteToken(ctx, &accessToken)
+	}
+
+	return services.revokeFamily(ctx, accessToken.Family)
+}
+
+// Function that ends every session of the given user
+func (services *Services) LogoutAll(ctx context.Context, user models.User) error {
+	sessions, getErr := services.sessionStorage.GetByUser(user.ID)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	for _, session := range sessions {
+		if err := services.sessionStorage.Delete(session); err != nil {
+			return err
+		}
+	}
+
+	return services.revokeAllUserTokens(ctx, user)
+}
+
+// Function that generates an access token and a refresh token of the given family and saves them to the database.
+// If client is not nil, the tokens are issued to that OAuth client with the given scope.
+func (services *Services) issueTokenPair(ctx context.Context, user models.User, family string, parent *uint, client *models.OAuthClient, scope string) (accessToken *models.Token, refreshToken *models.Token, err error) {
+	accessTokenString, accessTokenErr := generateToken(user, client, models.Access, scope)
 
 	if accessTokenErr != nil {
+		err = accessTokenErr
+		return
+	}
+
+	refreshTokenString, refreshTokenErr := generateToken(user, client, models.Refresh, scope)
+
+	if refreshTokenErr != nil {
 		err = refreshTokenErr
 		return
 	}
 
+	accessToken = &models.Token{
+		TokenValue:  accessTokenString,
+		UserRefer:   &user.ID,
+		Kind:        models.Access,
+		Family:      family,
+		ClientRefer: clientRefer(client),
+		Scope:       scope,
+	}
+
 	refreshToken = &models.Token{
-		TokenValue: newRefreshToken,
-		UserRefer:  user.ID,
-		Kind:       models.Refresh,
+		TokenValue:  refreshTokenString,
+		UserRefer:   &user.ID,
+		Kind:        models.Refresh,
+		Family:      family,
+		ParentRefer: parent,
+		ClientRefer: clientRefer(client),
+		Scope:       scope,
 	}
 
-	CreateToken(accessToken)
-	CreateToken(refreshToken)
+	if _, createErr := services.CreateToken(ctx, accessToken); createErr != nil {
+		err = createErr
+		return
+	}
+
+	_, err = services.CreateToken(ctx, refreshToken)
 
 	return
 }
 
-// Function that refresh a user access token, providing him a new one
-func RefreshToken(request RefreshTokenRequest) (accessToken *models.Token, err error) {
-	// Check if refresh token is valid
-	if jwtErr := auth.ValidateToken(request.RefreshToken); jwtErr != nil {
-		err = jwtErr
-		return
+// Function that generates a first-party token, or one issued to the OAuth client if there's one
+func generateToken(user models.User, client *models.OAuthClient, kind models.TokenKind, scope string) (string, error) {
+	if client == nil {
+		return auth.GenerateToken(user, kind)
 	}
 
-	//Get the refresh-token's user
-	claims, claimsErr := auth.GetClaims(request.RefreshToken)
+	return auth.GenerateClientToken(&user, client.ClientID, kind, scope)
+}
 
-	if claimsErr != nil {
-		err = claimsErr
-		return
+// Returns true if the token was issued to the given OAuth client, or is a first-party token if client is nil
+func issuedTo(token models.Token, client *models.OAuthClient) bool {
+	if client == nil {
+		return token.ClientRefer == nil
 	}
-	user, notFoundErr := GetUserByEmail(claims["email"].(string))
+
+	return token.ClientRefer != nil && *token.ClientRefer == client.ID
+}
+
+func clientRefer(client *models.OAuthClient) *uint {
+	if client == nil {
+		return nil
+	}
+
+	return &client.ID
+}
+
+// Function that revokes every token of a family, ending its session
+func (services *Services) revokeFamily(ctx context.Context, family string) error {
+	if session, notFoundErr := services.sessionStorage.GetByFamily(family); notFoundErr == nil {
+		return services.endSession(ctx, session)
+	}
+
+	tokens, getErr := services.tokenStorage.GetByFamily(ctx, family)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	for _, token := range tokens {
+		if err := services.DeleteToken(ctx, token); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Function that starts a family and a session for a refresh token issued before they existed.
+// The session counts towards the maximum of the user like the one of a new login.
+func (services *Services) adoptLegacyToken(ctx context.Context, token *models.Token, info SessionInfo) error {
+	user, notFoundErr := services.userStorage.Get(ctx, *token.UserRefer)
 
 	if notFoundErr != nil {
-		err = notFoundErr
-		return
+		return ErrTokenRevoked
 	}
 
-	//Get the user's bearer token and refresh it
-	database := database.GetInstance().GetDB()
+	if capErr := services.enforceSessionCap(ctx, *user); capErr != nil {
+		return capErr
+	}
 
-	database.Find(&accessToken, "user_refer = ? AND kind = ?", user.ID, models.Access)
-	newTokenString, tokenErr := auth.GenerateToken(*user, models.Access)
+	session, createErr := services.createSession(*token.UserRefer, info)
 
-	if tokenErr != nil {
-		err = tokenErr
-		return
+	if createErr != nil {
+		return createErr
 	}
 
-	accessToken.TokenValue = newTokenString
-	database.Save(&accessToken)
+	adopted, adoptErr := services.tokenStorage.AdoptFamily(ctx, token, session.Family)
 
-	return
+	if adoptErr != nil || adopted {
+		return adoptErr
+	}
+
+	//A concurrent refresh of the same token adopted it first, so the session isn't needed. The token is
+	//read again to get its family, for the replay to be detected and to revoke it.
+	if deleteErr := services.sessionStorage.Delete(session); deleteErr != nil {
+		return deleteErr
+	}
+
+	stored, notFoundErr := services.tokenStorage.Get(ctx, token.ID)
+
+	if notFoundErr != nil {
+		return ErrTokenRevoked
+	}
+
+	*token = *stored
+
+	return nil
 }
 
-// AUX FUNCTIONS
+// Function that revokes the access tokens of a token family, by deleting them
+func (services *Services) revokeFamilyAccessTokens(ctx context.Context, family string) error {
+	tokens, getErr := services.tokenStorage.GetByFamily(ctx, family)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	for _, token := range tokens {
+		if token.Kind != models.Access {
+			continue
+		}
+
+		if err := services.DeleteToken(ctx, token); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Function that generates a random identifier for a new token family
+func generateTokenFamily() (string, error) {
+	family := make([]byte, 16)
+
+	if _, readErr := rand.Read(family); readErr != nil {
+		return "", readErr
+	}
+
+	return hex.EncodeToString(family), nil
+}
 
 // Function that revokes all tokens of the specified user, by deleting them
-func revokeAllUserTokens(user models.User) error {
-	var tokens []*models.Token
-	database := database.GetInstance().GetDB()
+func (services *Services) revokeAllUserTokens(ctx context.Context, user models.User) error {
+	tokens, getErr := services.tokenStorage.GetByUser(ctx, user.ID)
 
-	database.Find(&tokens, "user_refer = ?", user.ID)
+	if getErr != nil {
+		return getErr
+	}
 
 	for _, token := range tokens {
 
-		if err := DeleteToken(token); err != nil {
+		if err := services.DeleteToken(ctx, token); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// Function that hashes the user's password again with the current hasher and saves it
+func (services *Services) rehashUserPassword(ctx context.Context, user *models.User, password string) error {
+	if encodeErr := user.EncodePassword(password); encodeErr != nil {
+		return encodeErr
+	}
+
+	return services.userStorage.UpdateColumns(ctx, user, "password")
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"gocker-api/auth"
+	"gocker-api/models"
+	"gocker-api/utils"
+	"strconv"
+)
+
+// Parameters of an introspection (RFC 7662 section 2.1) or revocation (RFC 7009 section 2.1) request
+type TokenCheckRequest struct {
+	Token string
+	// Only informative, since tokens are looked up by their value whatever their type
+	TokenTypeHint string
+	ClientID      string
+	ClientSecret  string
+}
+
+// Information about a token, as described in RFC 7662 section 2.2. Every field but Active
+// is empty when the token is not active.
+type TokenIntrospection struct {
+	Active    bool
+	Scope     string
+	ClientID  string
+	Username  string
+	TokenType string
+	Exp       int64
+	Sub       string
+}
+
+// Token types, as the token_type_hint parameter of RFC 7009 names them
+const (
+	AccessTokenType  = "access_token"
+	RefreshTokenType = "refresh_token"
+)
+
+// Function that tells a resource server whether a token is active, and what it was issued for.
+// Only confidential clients can introspect tokens, since they're the ones that can authenticate.
+func (services *Services) IntrospectToken(ctx context.Context, request TokenCheckRequest) (*TokenIntrospection, error) {
+	client, clientErr := services.authenticateClient(request.ClientID, request.ClientSecret)
+
+	if clientErr != nil {
+		return nil, clientErr
+	}
+
+	if !client.Confidential {
+		return nil, &OAuthError{"unauthorized_client", "only confidential clients can introspect tokens"}
+	}
+
+	token, user, activeErr := services.getActiveToken(ctx, request.Token)
+
+	if activeErr != nil {
+		return &TokenIntrospection{Active: false}, nil
+	}
+
+	claims, claimsErr := auth.GetClaims(request.Token)
+
+	if claimsErr != nil {
+		return &TokenIntrospection{Active: false}, nil
+	}
+
+	introspection := &TokenIntrospection{
+		Active:    true,
+		Scope:     token.Scope,
+		TokenType: AccessTokenType,
+	}
+
+	if token.Kind == models.Refresh {
+		introspection.TokenType = RefreshTokenType
+	}
+
+	if exp, ok := claims["exp"].(float64); ok {
+		introspection.Exp = int64(exp)
+	}
+
+	if clientId, ok := claims["client_id"].(string); ok {
+		introspection.ClientID = clientId
+	}
+
+	if user != nil {
+		introspection.Sub = strconv.FormatUint(uint64(user.ID), 10)
+		introspection.Username = user.Email
+	}
+
+	return introspection, nil
+}
+
+// Function that revokes a token issued to the client making the request. Revoking a refresh token
+// also revokes the access tokens of its family, as RFC 7009 section 2.1 suggests.
+// Unknown tokens, or tokens issued to other clients, are ignored, since the client can't tell them apart.
+func (services *Services) RevokeToken(ctx context.Context, request TokenCheckRequest) error {
+	client, clientErr := services.authenticateClient(request.ClientID, request.ClientSecret)
+
+	if clientErr != nil {
+		return clientErr
+	}
+
+	token, notFoundErr := services.GetTokenByValue(ctx, request.Token)
+
+	if notFoundErr != nil || !issuedTo(*token, client) {
+		return nil
+	}
+
+	if token.Kind == models.Refresh && token.Family != "" {
+		return services.revokeFamily(ctx, token.Family)
+	}
+
+	return services.DeleteToken(ctx, token)
+}
+
+// AUX FUNCTIONS
+
+// Function that returns a token if it's valid, has not been revoked or used and its user still exists.
+// The user is nil for tokens issued through the client credentials grant.
+func (services *Services) getActiveToken(ctx context.Context, tokenString string) (*models.Token, *models.User, error) {
+	if validationErr := auth.ValidateToken(tokenString); validationErr != nil {
+		return nil, nil, validationErr
+	}
+
+	token, notFoundErr := services.GetTokenByValue(ctx, tokenString)
+
+	// Login session tokens only live in the cookie of the browser, so clients never hold them
+	if notFoundErr != nil || token.Kind == models.Login {
+		return nil, nil, ErrInvalidToken
+	}
+
+	// Refresh tokens can only be used once, since they're rotated
+	if token.UsedAt != nil {
+		return nil, nil, utils.NewError(utils.KindUnauthorized, "token_already_used", "token already used")
+	}
+
+	if token.UserRefer == nil {
+		return token, nil, nil
+	}
+
+	user, userNotFoundErr := services.GetUserById(ctx, int(*token.UserRefer))
+
+	if userNotFoundErr != nil {
+		return nil, nil, userNotFoundErr
+	}
+
+	return token, user, nil
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"gocker-api/models"
+	"gocker-api/totp"
+	"gocker-api/utils"
+	"os"
+	"strings"
+	"time"
+)
+
+type MFACodeBody struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type MFAVerifyBody struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// Error returned by AuthenticateUser when the password is right but the user has to provide
+// a second factor. It carries the challenge token to exchange along with the code.
+type MFARequiredError struct {
+	ChallengeToken string
+}
+
+func (err *MFARequiredError) Error() string {
+	return "two-factor authentication required"
+}
+
+const recoveryCodesCount = 10
+
+const (
+	mfaChallengeDuration = 5 * time.Minute
+	// Attempts to answer a challenge, after which the user has to log in again to get a new one
+	maxMFAChallengeAttempts = 5
+)
+
+var (
+	ErrTOTPAlreadyEnabled = utils.NewError(utils.KindConflict, "totp_already_enabled", "two-factor authentication is already enabled")
+	ErrInvalidMFAToken    = utils.NewError(utils.KindUnauthorized, "invalid_mfa_token", "two-factor authentication token not valid")
+	ErrInvalidMFACode     = utils.NewError(utils.KindInvalid, "invalid_mfa_code", "two-factor authentication code not valid")
+	ErrMFACodeUsed        = utils.NewError(utils.KindInvalid, "mfa_code_already_used", "two-factor authentication code already used")
+	ErrTooManyMFAAttempts = utils.NewError(utils.KindUnauthorized, "too_many_mfa_attempts", "too many two-factor authentication attempts. Please, log in again")
+)
+
+// Function that generates a new TOTP secret for the user. It's not required to authenticate
+// until the enrollment is confirmed with a first code.
+func (services *Services) EnrollTOTP(ctx context.Context, user *models.User) (*TOTPEnrollment, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, secretErr := totp.GenerateSecret()
+
+	if secretErr != nil {
+		return nil, secretErr
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPLastCounter = 0
+
+	if updateErr := services.userStorage.UpdateColumns(ctx, user, "totp_secret", "totp_last_counter"); updateErr != nil {
+		return nil, updateErr
+	}
+
+	return &TOTPEnrollment{Secret: secret, URI: totp.URI(getTOTPIssuer(), user.Email, secret)}, nil
+}
+
+// Function that enables two-factor authentication once the user proves its authenticator works,
+// returning the recovery codes. They are only shown this time.
+func (services *Services) ConfirmTOTP(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	if user.TOTPSecret == "" {
+		return nil, utils.NewError(utils.KindConflict, "totp_enrollment_not_started", "two-factor authentication enrollment has not been started")
+	}
+
+	if codeErr := services.checkTOTPCode(ctx, user, code); codeErr != nil {
+		return nil, codeErr
+	}
+
+	user.TOTPEnabled = true
+
+	if updateErr := services.userStorage.UpdateColumns(ctx, user, "totp_enabled"); updateErr != nil {
+		return nil, updateErr
+	}
+
+	return services.generateRecoveryCodes(*user)
+}
+
+// Function that disables two-factor authentication, given a valid code
+func (services *Services) DisableTOTP(ctx context.Context, user *models.User, code string) error {
+	if !user.TOTPEnabled {
+		return utils.NewError(utils.KindConflict, "totp_not_enabled", "two-factor authentication is not enabled")
+	}
+
+	if codeErr := services.checkSecondFactor(ctx, user, code); codeErr != nil {
+		return codeErr
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastCounter = 0
+
+	if updateErr := services.userStorage.UpdateColumns(ctx, user, "totp_enabled", "totp_secret", "totp_last_counter"); updateErr != nil {
+		return updateErr
+	}
+
+	return services.recoveryCodeStorage.DeleteByUser(user.ID)
+}
+
+// Function that exchanges a challenge token and a TOTP or recovery code for an access token and a refresh token.
+// Every attempt counts, and the challenge can't be answered after maxMFAChallengeAttempts of them.
+func (services *Services) VerifyMFA(ctx context.Context, body MFAVerifyBody, info SessionInfo) (accessToken *models.Token, refreshToken *models.Token, err error) {
+	challenge, challengeErr := services.mfaChallengeStorage.GetValid(hashOpaqueToken(body.MFAToken))
+
+	if challengeErr != nil {
+		err = ErrInvalidMFAToken
+		return
+	}
+
+	// the attempt is counted before the code is checked, so that concurrent attempts can't get past the limit
+	if counted, countErr := services.mfaChallengeStorage.AddAttempt(challenge, maxMFAChallengeAttempts); countErr != nil {
+		err = countErr
+		return
+	} else if !counted {
+		err = ErrTooManyMFAAttempts
+		return
+	}
+
+	user, notFoundErr := services.GetUserById(ctx, int(challenge.UserRefer))
+
+	if notFoundErr != nil || !user.TOTPEnabled {
+		err = ErrInvalidMFAToken
+		return
+	}
+
+	if codeErr := services.checkSecondFactor(ctx, user, body.Code); codeErr != nil {
+		err = codeErr
+
+		//The code is what the user authenticates with at this step, so a wrong one fails the authentication
+		var codeAPIErr *utils.Error
+
+		if errors.As(codeErr, &codeAPIErr) && codeAPIErr.Kind == utils.KindInvalid {
+			authErr := *codeAPIErr
+			authErr.Kind = utils.KindUnauthorized
+			err = &authErr
+		}
+
+		return
+	}
+
+	// challenges can only be answered once
+	if deleteErr := services.mfaChallengeStorage.Delete(challenge); deleteErr != nil {
+		err = notFound(deleteErr, ErrInvalidMFAToken)
+		return
+	}
+
+	return services.startSession(ctx, *user, info)
+}
+
+// AUX FUNCTIONS
+
+// Function that stores a new challenge for the user, returning the token to answer it with
+func (services *Services) createMFAChallenge(user models.User) (string, error) {
+	token, tokenErr := generateOpaqueToken()
+
+	if tokenErr != nil {
+		return "", tokenErr
+	}
+
+	challenge := &models.MFAChallenge{
+		UserRefer: user.ID,
+		TokenHash: hashOpaqueToken(token),
+		ExpiresAt: time.Now().Add(mfaChallengeDuration),
+	}
+
+	if createErr := services.mfaChallengeStorage.Create(challenge); createErr != nil {
+		return "", createErr
+	}
+
+	return token, nil
+}
+
+// Function that checks a code, either a TOTP one or a recovery one
+func (services *Services) checkSecondFactor(ctx context.Context, user *models.User, code string) error {
+	code = strings.TrimSpace(code)
+
+	if len(code) == totp.Digits {
+		return services.checkTOTPCode(ctx, user, code)
+	}
+
+	return services.useRecoveryCode(*user, code)
+}
+
+// Function that checks a TOTP code, rejecting codes that have already been used
+func (services *Services) checkTOTPCode(ctx context.Context, user *models.User, code string) error {
+	counter, valid := totp.Validate(user.TOTPSecret, code, time.Now())
+
+	if !valid {
+		return ErrInvalidMFACode
+	}
+
+	if firstUse, updateErr := services.userStorage.UpdateTOTPCounter(ctx, user, counter); updateErr != nil {
+		return updateErr
+	} else if !firstUse {
+		return ErrMFACodeUsed
+	}
+
+	return nil
+}
+
+// Function that marks a recovery code of the user as used, if it's valid
+func (services *Services) useRecoveryCode(user models.User, code string) error {
+	recoveryCode, notFoundErr := services.recoveryCodeStorage.GetUnused(user.ID, hashRecoveryCode(code))
+
+	if notFoundErr != nil {
+		return ErrInvalidMFACode
+	}
+
+	if firstUse, markErr := services.recoveryCodeStorage.MarkUsed(recoveryCode); markErr != nil {
+		return markErr
+	} else if !firstUse {
+		return ErrMFACodeUsed
+	}
+
+	return nil
+}
+
+// Function that replaces the user's recovery codes with new ones, returning them in plain text
+func (services *Services) generateRecoveryCodes(user models.User) ([]string, error) {
+	if deleteErr := services.recoveryCodeStorage.DeleteByUser(user.ID); deleteErr != nil {
+		return nil, deleteErr
+	}
+
+	codes := make([]string, 0, recoveryCodesCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for i := 0; i < recoveryCodesCount; i++ {
+		random := make([]byte, 10)
+
+		if _, readErr := rand.Read(random); readErr != nil {
+			return nil, readErr
+		}
+
+		encoded := strings.ToLower(encoding.EncodeToString(random))
+		code := encoded[:8] + "-" + encoded[8:]
+
+		if createErr := services.recoveryCodeStorage.Create(&models.RecoveryCode{UserRefer: user.ID, CodeHash: hashRecoveryCode(code)}); createErr != nil {
+			return nil, createErr
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// Recovery codes are random enough for a fast hash to be safe, and it allows looking them up directly
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func getTOTPIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+
+	return "gocker-api"
+}
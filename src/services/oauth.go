@@ -0,0 +1,486 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"gocker-api/auth"
+	"gocker-api/hashing"
+	"gocker-api/models"
+	"gocker-api/utils"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Scopes OAuth clients can be granted
+const (
+	OpenIDScope     = "openid"
+	ProfileScope    = "profile"
+	EmailScope      = "email"
+	UsersReadScope  = "users:read"
+	UsersWriteScope = "users:write"
+)
+
+var ErrOAuthClientNotFound = utils.NewError(utils.KindNotFound, "client_not_found", "client not found")
+
+var supportedScopes = []string{OpenIDScope, ProfileScope, EmailScope, UsersReadScope, UsersWriteScope}
+
+const authorizationCodeDuration = 10 * time.Minute
+
+type OAuthClientBody struct {
+	Name         string   `json:"name" validate:"required,max=100"`
+	Confidential bool     `json:"confidential"`
+	RedirectURIs []string `json:"redirect_uris" validate:"dive,url"`
+	Scope        string   `json:"scope"`
+}
+
+// Parameters of a request to the authorize endpoint, as described in RFC 6749 section 4.1.1 and RFC 7636
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// OpenID Connect nonce, sent back in the ID token to mitigate replay attacks
+	Nonce string
+}
+
+// Tokens issued by the token endpoint. RefreshToken is nil and IDToken is empty when they're not issued.
+type IssuedTokens struct {
+	AccessToken  *models.Token
+	RefreshToken *models.Token
+	IDToken      string
+}
+
+// Parameters of a request to the token endpoint
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+	ClientID     string
+	ClientSecret string
+}
+
+// Error as described in RFC 6749 section 5.2. Code is one of the error codes defined there.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (err *OAuthError) Error() string {
+	return err.Code + ": " + err.Description
+}
+
+// Function that registers a new OAuth client, returning it along with its secret if it's confidential.
+// The secret is only returned this time, since only its hash is stored.
+// Clients are granted no scope unless the body says which ones.
+func (services *Services) RegisterOAuthClient(body OAuthClientBody) (client *models.OAuthClient, secret string, err error) {
+	scope := body.Scope
+
+	if !isSupportedScope(scope) {
+		err = unsupportedScopeError(supportedScopes)
+		return
+	}
+
+	for _, redirectURI := range body.RedirectURIs {
+		if parsedURI, parseErr := url.Parse(redirectURI); parseErr != nil || !parsedURI.IsAbs() || parsedURI.Fragment != "" {
+			err = utils.NewError(utils.KindInvalid, "invalid_redirect_uri", "redirect uris must be absolute and can't have a fragment")
+			return
+		}
+	}
+
+	clientId, idErr := generateClientId()
+
+	if idErr != nil {
+		err = idErr
+		return
+	}
+
+	client = &models.OAuthClient{
+		ClientID:     clientId,
+		Name:         body.Name,
+		Confidential: body.Confidential,
+		RedirectURIs: body.RedirectURIs,
+		Scopes:       scope,
+	}
+
+	if body.Confidential {
+		secret, err = generateOpaqueToken()
+
+		if err != nil {
+			return
+		}
+
+		if client.SecretHash, err = hashing.Default().Hash(secret); err != nil {
+			return
+		}
+	}
+
+	err = services.oauthClientStorage.Create(client)
+
+	return
+}
+
+// Function that returns all registered OAuth clients
+func (services *Services) GetAllOAuthClients() ([]*models.OAuthClient, error) {
+	return services.oauthClientStorage.GetAll()
+}
+
+// Returns true if the OAuth client with the given id has been granted the scope. Tokens issued through the client
+// credentials grant have no user whose role could grant them anything, so this is what authorizes them.
+func (services *Services) ClientGrantsScope(clientId uint, scope string) bool {
+	item, notFoundErr := services.oauthClientStorage.Get(int(clientId))
+
+	if notFoundErr != nil || scope == "" {
+		return false
+	}
+
+	return item.(*models.OAuthClient).AllowsScope(scope)
+}
+
+// Function that deletes an OAuth client, along with every token issued to it
+func (services *Services) DeleteOAuthClient(id int) error {
+	client, notFoundErr := services.oauthClientStorage.Get(id)
+
+	if notFoundErr != nil {
+		return notFound(notFoundErr, ErrOAuthClientNotFound)
+	}
+
+	return services.oauthClientStorage.Delete(client)
+}
+
+// Function that validates an authorization request, returning the client and the redirect URI to use.
+// If the returned redirect URI is empty the error can't be sent to the client, since it's not trusted.
+func (services *Services) ValidateAuthorizationRequest(request AuthorizationRequest) (client *models.OAuthClient, redirectURI string, err error) {
+	client, notFoundErr := services.oauthClientStorage.GetByClientId(request.ClientID)
+
+	if notFoundErr != nil {
+		err = &OAuthError{"invalid_request", "client not found"}
+		return
+	}
+
+	switch {
+	case request.RedirectURI != "" && client.HasRedirectURI(request.RedirectURI):
+		redirectURI = request.RedirectURI
+	case request.RedirectURI == "" && len(client.RedirectURIs) == 1:
+		redirectURI = client.RedirectURIs[0]
+	default:
+		err = &OAuthError{"invalid_request", "redirect_uri not registered for this client"}
+		return
+	}
+
+	if request.ResponseType != "code" {
+		err = &OAuthError{"unsupported_response_type", "only the code response type is supported"}
+		return
+	}
+
+	if request.Scope != "" && (!isSupportedScope(request.Scope) || !client.AllowsScope(request.Scope)) {
+		err = &OAuthError{"invalid_scope", "scope not allowed for this client"}
+		return
+	}
+
+	// ID tokens can't be issued without a signing key, so the openid scope isn't granted instead of failing later
+	if scope := authorizationScope(*client, request); HasScope(scope, OpenIDScope) && auth.IDTokenSigningAlg() == "" {
+		err = &OAuthError{"invalid_scope", "the openid scope can't be granted, since no key to sign ID tokens is configured"}
+		return
+	}
+
+	if request.CodeChallenge == "" && !client.Confidential {
+		err = &OAuthError{"invalid_request", "public clients must use PKCE"}
+		return
+	}
+
+	if request.CodeChallenge != "" && request.CodeChallengeMethod != "S256" {
+		err = &OAuthError{"invalid_request", "code_challenge_method must be S256"}
+		return
+	}
+
+	return
+}
+
+// Function that issues an authorization code once the user consents, returning the URI to redirect it to
+func (services *Services) Authorize(user models.User, request AuthorizationRequest) (string, error) {
+	client, redirectURI, validationErr := services.ValidateAuthorizationRequest(request)
+
+	if validationErr != nil {
+		return redirectURI, validationErr
+	}
+
+	code, codeErr := generateOpaqueToken()
+
+	if codeErr != nil {
+		return "", codeErr
+	}
+
+	authorizationCode := &models.AuthorizationCode{
+		CodeHash:            hashOpaqueToken(code),
+		ClientRefer:         client.ID,
+		UserRefer:           user.ID,
+		RedirectURI:         request.RedirectURI,
+		Scope:               authorizationScope(*client, request),
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		Nonce:               request.Nonce,
+		ExpiresAt:           time.Now().Add(authorizationCodeDuration),
+	}
+
+	if createErr := services.authorizationCodeStorage.Create(authorizationCode); createErr != nil {
+		return "", createErr
+	}
+
+	return buildRedirectURI(redirectURI, map[string]string{"code": code, "state": request.State}), nil
+}
+
+// Returns the URI to redirect the user to when it denies the authorization request
+func (services *Services) DenyAuthorization(request AuthorizationRequest) (string, error) {
+	_, redirectURI, validationErr := services.ValidateAuthorizationRequest(request)
+
+	if validationErr != nil {
+		return redirectURI, validationErr
+	}
+
+	return buildRedirectURI(redirectURI, map[string]string{
+		"error":             "access_denied",
+		"error_description": "the user denied the request",
+		"state":             request.State,
+	}), nil
+}
+
+// Returns the URI to redirect the user to with the given error, as described in RFC 6749 section 4.1.2.1
+func BuildErrorRedirectURI(redirectURI string, oauthErr *OAuthError, state string) string {
+	return buildRedirectURI(redirectURI, map[string]string{
+		"error":             oauthErr.Code,
+		"error_description": oauthErr.Description,
+		"state":             state,
+	})
+}
+
+// Function that handles a request to the token endpoint, issuing tokens according to its grant type.
+// Client credentials grants only issue an access token, and only grants with the openid scope issue an ID token.
+func (services *Services) ExchangeToken(ctx context.Context, request TokenRequest, info SessionInfo) (*IssuedTokens, error) {
+	client, clientErr := services.authenticateClient(request.ClientID, request.ClientSecret)
+
+	if clientErr != nil {
+		return nil, clientErr
+	}
+
+	switch request.GrantType {
+	case "authorization_code":
+		return services.exchangeAuthorizationCode(ctx, *client, request)
+	case "refresh_token":
+		accessToken, refreshToken, err := services.rotateRefreshToken(ctx, request.RefreshToken, client, info)
+
+		if err != nil {
+			return nil, &OAuthError{"invalid_grant", err.Error()}
+		}
+
+		// ID tokens issued on refresh carry no nonce, as OpenID Connect Core section 12.2 asks
+		return services.withIDToken(ctx, *client, accessToken, refreshToken, "")
+	case "client_credentials":
+		accessToken, err := services.issueClientToken(ctx, *client, request.Scope)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &IssuedTokens{AccessToken: accessToken}, nil
+	default:
+		return nil, &OAuthError{"unsupported_grant_type", "grant type must be authorization_code, refresh_token or client_credentials"}
+	}
+}
+
+// Returns true if the space separated list of scopes contains the given one
+func HasScope(scopes string, scope string) bool {
+	for _, granted := range strings.Fields(scopes) {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AUX FUNCTIONS
+
+// Function that authenticates the client making a token request. Public clients only send their id.
+func (services *Services) authenticateClient(clientId string, clientSecret string) (*models.OAuthClient, error) {
+	client, notFoundErr := services.oauthClientStorage.GetByClientId(clientId)
+
+	if notFoundErr != nil {
+		return nil, &OAuthError{"invalid_client", "client authentication failed"}
+	}
+
+	if client.Confidential {
+		if clientSecret == "" || hashing.Verify(clientSecret, client.SecretHash) != nil {
+			return nil, &OAuthError{"invalid_client", "client authentication failed"}
+		}
+	}
+
+	return client, nil
+}
+
+// Function that exchanges an authorization code for tokens. Replaying a code revokes the tokens it was exchanged for.
+func (services *Services) exchangeAuthorizationCode(ctx context.Context, client models.OAuthClient, request TokenRequest) (*IssuedTokens, error) {
+	code, notFoundErr := services.authorizationCodeStorage.GetByHash(hashOpaqueToken(request.Code))
+
+	if notFoundErr != nil || code.ClientRefer != client.ID {
+		return nil, &OAuthError{"invalid_grant", "authorization code not valid or expired"}
+	}
+
+	if code.RedirectURI != request.RedirectURI {
+		return nil, &OAuthError{"invalid_grant", "redirect_uri does not match the one of the authorization request"}
+	}
+
+	if !verifyCodeChallenge(*code, request.CodeVerifier) {
+		return nil, &OAuthError{"invalid_grant", "code_verifier not valid"}
+	}
+
+	user, userNotFoundErr := services.GetUserById(ctx, int(code.UserRefer))
+
+	if userNotFoundErr != nil {
+		return nil, &OAuthError{"invalid_grant", "authorization code not valid or expired"}
+	}
+
+	// the ID token is signed before the code is used, so that a failure signing it leaves the code usable
+	idToken, idTokenErr := generateIDToken(client, *user, code.Scope, code.Nonce)
+
+	if idTokenErr != nil {
+		return nil, idTokenErr
+	}
+
+	family, familyErr := generateTokenFamily()
+
+	if familyErr != nil {
+		return nil, familyErr
+	}
+
+	firstUse, markErr := services.authorizationCodeStorage.MarkUsed(code, family)
+
+	if markErr != nil {
+		return nil, markErr
+	}
+
+	if !firstUse {
+		if code.Family != "" {
+			if revokeErr := services.revokeFamily(ctx, code.Family); revokeErr != nil {
+				return nil, revokeErr
+			}
+		}
+
+		return nil, &OAuthError{"invalid_grant", "authorization code already used. The tokens issued for it have been revoked"}
+	}
+
+	accessToken, refreshToken, issueErr := services.issueTokenPair(ctx, *user, family, nil, &client, code.Scope)
+
+	if issueErr != nil {
+		return nil, issueErr
+	}
+
+	return &IssuedTokens{AccessToken: accessToken, RefreshToken: refreshToken, IDToken: idToken}, nil
+}
+
+// Function that issues an access token for the client itself, as described in RFC 6749 section 4.4
+func (services *Services) issueClientToken(ctx context.Context, client models.OAuthClient, scope string) (*models.Token, error) {
+	if !client.Confidential {
+		return nil, &OAuthError{"unauthorized_client", "only confidential clients can use the client_credentials grant"}
+	}
+
+	if scope == "" {
+		scope = client.Scopes
+	} else if !client.AllowsScope(scope) {
+		return nil, &OAuthError{"invalid_scope", "scope not allowed for this client"}
+	}
+
+	if scope == "" {
+		return nil, &OAuthError{"invalid_scope", "no scope has been granted to this client"}
+	}
+
+	tokenString, tokenErr := auth.GenerateClientToken(nil, client.ClientID, models.Access, scope)
+
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+
+	return services.CreateToken(ctx, &models.Token{
+		TokenValue:  tokenString,
+		Kind:        models.Access,
+		ClientRefer: &client.ID,
+		Scope:       scope,
+	})
+}
+
+// Returns the scope an authorization request asks for, which is every scope of the client if it asks for none
+func authorizationScope(client models.OAuthClient, request AuthorizationRequest) string {
+	if request.Scope == "" {
+		return client.Scopes
+	}
+
+	return request.Scope
+}
+
+// Function that checks the PKCE code verifier against the challenge sent to the authorize endpoint (RFC 7636)
+func verifyCodeChallenge(code models.AuthorizationCode, codeVerifier string) bool {
+	if code.CodeChallenge == "" {
+		return codeVerifier == ""
+	}
+
+	if len(codeVerifier) < 43 || len(codeVerifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) == 1
+}
+
+// Function that adds the given parameters to the query of a redirect URI, skipping empty ones
+func buildRedirectURI(redirectURI string, params map[string]string) string {
+	parsedURI, _ := url.Parse(redirectURI)
+	query := parsedURI.Query()
+
+	for key, value := range params {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	parsedURI.RawQuery = query.Encode()
+
+	return parsedURI.String()
+}
+
+// Function that returns the error of a scope that isn't one of the given ones
+func unsupportedScopeError(scopes []string) error {
+	return utils.NewError(utils.KindInvalid, "unsupported_scope", "scope not supported. Supported scopes are: "+strings.Join(scopes, " "))
+}
+
+// Returns true if every scope of the given space separated list is supported
+func isSupportedScope(scope string) bool {
+	for _, requested := range strings.Fields(scope) {
+		if !HasScope(strings.Join(supportedScopes, " "), requested) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func generateClientId() (string, error) {
+	id := make([]byte, 16)
+
+	if _, readErr := rand.Read(id); readErr != nil {
+		return "", readErr
+	}
+
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"gocker-api/auth"
+	"gocker-api/models"
+	"strconv"
+)
+
+// Claims OpenID Connect clients can get about users, depending on the scopes granted to them
+var supportedClaims = []string{"sub", "email", "email_verified", "name", "given_name"}
+
+// Returns the claims OpenID Connect clients can get through the userinfo endpoint or the ID token
+func GetSupportedClaims() []string {
+	return supportedClaims
+}
+
+// Returns the scopes OAuth clients can be granted
+func GetSupportedScopes() []string {
+	return supportedScopes
+}
+
+// Function that returns the claims about the user the given scope allows to release
+func GetUserInfo(user models.User, scope string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": strconv.FormatUint(uint64(user.ID), 10),
+	}
+
+	if HasScope(scope, EmailScope) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerified
+	}
+
+	if HasScope(scope, ProfileScope) {
+		claims["name"] = user.FirstName
+		claims["given_name"] = user.FirstName
+	}
+
+	return claims
+}
+
+// AUX FUNCTIONS
+
+// Function that adds an ID token to the tokens issued to the client, if the openid scope was granted
+func (services *Services) withIDToken(ctx context.Context, client models.OAuthClient, accessToken *models.Token, refreshToken *models.Token, nonce string) (*IssuedTokens, error) {
+	tokens := &IssuedTokens{AccessToken: accessToken, RefreshToken: refreshToken}
+
+	if !HasScope(accessToken.Scope, OpenIDScope) || accessToken.UserRefer == nil {
+		return tokens, nil
+	}
+
+	user, notFoundErr := services.GetUserById(ctx, int(*accessToken.UserRefer))
+
+	if notFoundErr != nil {
+		return nil, ErrUserNotFound
+	}
+
+	idToken, idTokenErr := generateIDToken(client, *user, accessToken.Scope, nonce)
+
+	if idTokenErr != nil {
+		return nil, idTokenErr
+	}
+
+	tokens.IDToken = idToken
+
+	return tokens, nil
+}
+
+// Returns the ID token of the user for the client, or an empty string if the given scope doesn't include openid
+func generateIDToken(client models.OAuthClient, user models.User, scope string, nonce string) (string, error) {
+	if !HasScope(scope, OpenIDScope) {
+		return "", nil
+	}
+
+	return auth.GenerateIDToken(strconv.FormatUint(uint64(user.ID), 10), client.ClientID, nonce, GetUserInfo(user, scope))
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"gocker-api/models"
+	"gocker-api/utils"
+)
+
+var (
+	ErrOrganizationNotFound  = utils.NewError(utils.KindNotFound, "organization_not_found", "organization not found")
+	ErrMemberNotFound        = utils.NewError(utils.KindNotFound, "member_not_found", "member not found")
+	ErrInvalidMembershipRole = utils.NewError(utils.KindInvalid, "invalid_membership_role", "role must be owner, admin or member")
+)
+
+type OrganizationBody struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type MembershipBody struct {
+	Role models.MembershipRole `json:"role" validate:"required"`
+}
+
+type SwitchOrganizationBody struct {
+	OrganizationID uint `json:"organization_id" validate:"required"`
+}
+
+// User of an organization, along with its membership
+type OrganizationMember struct {
+	User       models.User
+	Membership models.Membership
+}
+
+// Function that creates an organization, making the user its owner
+func (services *Services) CreateOrganization(ctx context.Context, owner models.User, body OrganizationBody) (*models.Organization, error) {
+	organization := &models.Organization{Name: body.Name}
+
+	if createErr := services.organizationStorage.Create(organization); createErr != nil {
+		return nil, createErr
+	}
+
+	return organization, services.JoinOrganization(ctx, organization.ID, owner, models.OwnerMembership)
+}
+
+// Function that returns the organizations the user is a member of
+func (services *Services) GetUserOrganizations(user models.User) ([]*models.Organization, error) {
+	return services.organizationStorage.GetByUser(user.ID)
+}
+
+func (services *Services) GetOrganizationById(id uint) (*models.Organization, error) {
+	organization, notFoundErr := services.organizationStorage.Get(id)
+
+	return organization, notFound(notFoundErr, ErrOrganizationNotFound)
+}
+
+func (services *Services) UpdateOrganization(id uint, body OrganizationBody) (*models.Organization, error) {
+	organization, notFoundErr := services.GetOrganizationById(id)
+
+	if notFoundErr != nil {
+		return nil, notFoundErr
+	}
+
+	organization.Name = body.Name
+
+	return organization, services.organizationStorage.Update(organization)
+}
+
+// Function that deletes an organization along with its memberships and invitations
+func (services *Services) DeleteOrganization(id uint) error {
+	organization, notFoundErr := services.GetOrganizationById(id)
+
+	if notFoundErr != nil {
+		return notFoundErr
+	}
+
+	return services.organizationStorage.Delete(organization)
+}
+
+// Function that returns the membership of the user in the organization, or an error if it's not a member
+func (services *Services) GetMembership(organizationId uint, userId uint) (*models.Membership, error) {
+	membership, notFoundErr := services.membershipStorage.Get(organizationId, userId)
+
+	return membership, notFound(notFoundErr, ErrMemberNotFound)
+}
+
+// Function that returns the members of an organization
+func (services *Services) GetOrganizationMembers(ctx context.Context, organizationId uint) ([]OrganizationMember, error) {
+	memberships, getErr := services.membershipStorage.GetByOrganization(organizationId)
+
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	members := make([]OrganizationMember, 0, len(memberships))
+
+	for _, membership := range memberships {
+		user, notFoundErr := services.GetUserById(ctx, int(membership.UserRefer))
+
+		if notFoundErr != nil {
+			continue
+		}
+
+		members = append(members, OrganizationMember{User: *user, Membership: *membership})
+	}
+
+	return members, nil
+}
+
+// Function that makes the user a member of the organization with the given role.
+// If the user had no active organization, this one becomes active for its next tokens.
+func (services *Services) JoinOrganization(ctx context.Context, organizationId uint, user models.User, role models.MembershipRole) error {
+	if _, notFoundErr := services.membershipStorage.Get(organizationId, user.ID); notFoundErr == nil {
+		return utils.NewError(utils.KindConflict, "already_member", "the user is already a member of the organization")
+	}
+
+	membership := &models.Membership{OrganizationRefer: organizationId, UserRefer: user.ID, Role: role}
+
+	if createErr := services.membershipStorage.Create(membership); createErr != nil {
+		return createErr
+	}
+
+	if user.ActiveOrganizationRefer == nil {
+		user.ActiveOrganizationRefer = &organizationId
+
+		return services.userStorage.UpdateColumns(ctx, &user, "active_organization_refer")
+	}
+
+	return nil
+}
+
+// Function that changes the role of a member. Only owners can make someone an owner or change
+// the role of another owner, and the last owner of an organization can't stop being one.
+func (services *Services) UpdateMembership(actor models.Membership, organizationId uint, userId uint, body MembershipBody) (*models.Membership, error) {
+	if !body.Role.Valid() {
+		return nil, ErrInvalidMembershipRole
+	}
+
+	membership, notFoundErr := services.GetMembership(organizationId, userId)
+
+	if notFoundErr != nil {
+		return nil, notFoundErr
+	}
+
+	if (membership.Role == models.OwnerMembership || body.Role == models.OwnerMembership) && actor.Role != models.OwnerMembership {
+		return nil, utils.NewError(utils.KindForbidden, "owner_required", "only owners can manage owners")
+	}
+
+	if membership.Role == models.OwnerMembership && body.Role != models.OwnerMembership {
+		if lastErr := services.checkNotLastOwner(organizationId); lastErr != nil {
+			return nil, lastErr
+		}
+	}
+
+	membership.Role = body.Role
+
+	return membership, services.membershipStorage.Update(membership)
+}
+
+// Function that removes a member from an organization. Members can leave by themselves, admins can
+// remove members and admins, and owners can remove anyone, as long as an owner remains.
+func (services *Services) RemoveMember(ctx context.Context, actor models.Membership, organizationId uint, userId uint) error {
+	membership, notFoundErr := services.GetMembership(organizationId, userId)
+
+	if notFoundErr != nil {
+		return notFoundErr
+	}
+
+	if actor.UserRefer != userId && !actor.Role.AtLeast(models.AdminMembership) {
+		return utils.NewError(utils.KindForbidden, "admin_required", "only admins can remove other members")
+	}
+
+	if membership.Role == models.OwnerMembership {
+		if actor.UserRefer != userId && actor.Role != models.OwnerMembership {
+			return utils.NewError(utils.KindForbidden, "owner_required", "only owners can manage owners")
+		}
+
+		if lastErr := services.checkNotLastOwner(organizationId); lastErr != nil {
+			return lastErr
+		}
+	}
+
+	return services.leaveOrganization(ctx, membership)
+}
+
+// Function that makes another organization the active one of the user, issuing new tokens for it
+// that replace the ones of the current session. Other sessions keep their organization until they refresh.
+func (services *Services) SwitchOrganization(ctx context.Context, user models.User, currentToken models.Token, body SwitchOrganizationBody) (accessToken *models.Token, refreshToken *models.Token, err error) {
+	if _, notFoundErr := services.membershipStorage.Get(body.OrganizationID, user.ID); notFoundErr != nil {
+		err = utils.NewError(utils.KindForbidden, "not_member", "the user is not a member of the organization")
+		return
+	}
+
+	if currentToken.Family == "" {
+		err = utils.NewError(utils.KindUnauthorized, "session_too_old", "the session is too old to switch organization. Please, log in again")
+		return
+	}
+
+	user.ActiveOrganizationRefer = &body.OrganizationID
+
+	if updateErr := services.userStorage.UpdateColumns(ctx, &user, "active_organization_refer"); updateErr != nil {
+		err = updateErr
+		return
+	}
+
+	tokens, getErr := services.tokenStorage.GetByFamily(ctx, currentToken.Family)
+
+	if getErr != nil {
+		err = getErr
+		return
+	}
+
+	for _, token := range tokens {
+		if deleteErr := services.DeleteToken(ctx, token); deleteErr != nil {
+			err = deleteErr
+			return
+		}
+	}
+
+	return services.issueTokenPair(ctx, user, currentToken.Family, nil, nil, "")
+}
+
+// AUX FUNCTIONS
+
+// Function that deletes a membership. If the organization was the active one of the user, its next tokens
+// fall back to another of its organizations, if it has any.
+func (services *Services) leaveOrganization(ctx context.Context, membership *models.Membership) error {
+	if deleteErr := services.membershipStorage.Delete(membership); deleteErr != nil {
+		return deleteErr
+	}
+
+	user, userNotFoundErr := services.GetUserById(ctx, int(membership.UserRefer))
+
+	if userNotFoundErr != nil || user.ActiveOrganizationRefer == nil || *user.ActiveOrganizationRefer != membership.OrganizationRefer {
+		return nil
+	}
+
+	user.ActiveOrganizationRefer = nil
+
+	if next, nextNotFoundErr := services.membershipStorage.GetFirstByUser(user.ID); nextNotFoundErr == nil {
+		user.ActiveOrganizationRefer = &next.OrganizationRefer
+	}
+
+	return services.userStorage.UpdateColumns(ctx, user, "active_organization_refer")
+}
+
+func (services *Services) checkNotLastOwner(organizationId uint) error {
+	if count, countErr := services.membershipStorage.CountByRole(organizationId, models.OwnerMembership); countErr != nil {
+		return countErr
+	} else if count <= 1 {
+		return utils.NewError(utils.KindConflict, "last_owner", "an organization must keep at least one owner")
+	}
+
+	return nil
+}
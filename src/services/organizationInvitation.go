@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"gocker-api/mail"
+	"gocker-api/models"
+	"gocker-api/utils"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type InvitationBody struct {
+	Email string                `json:"email" validate:"required,email,max=254"`
+	Role  models.MembershipRole `json:"role" validate:"required"`
+}
+
+type AcceptInvitationBody struct {
+	Token string `json:"token" validate:"required"`
+}
+
+const invitationDuration = 7 * 24 * time.Hour
+
+// Function that invites someone to join an organization with the given role, sending the invitation by email.
+// Only owners can invite other owners. Unlike user invitations, they don't create an account, so the invited person
+// accepts them with an account of its own.
+func (services *Services) InviteToOrganization(inviter models.Membership, organizationId uint, body InvitationBody) (*models.OrganizationInvitation, error) {
+	if !body.Role.Valid() {
+		return nil, ErrInvalidMembershipRole
+	}
+
+	if body.Role == models.OwnerMembership && inviter.Role != models.OwnerMembership {
+		return nil, utils.NewError(utils.KindForbidden, "owner_required", "only owners can invite owners")
+	}
+
+	organization, notFoundErr := services.GetOrganizationById(organizationId)
+
+	if notFoundErr != nil {
+		return nil, notFoundErr
+	}
+
+	token, tokenErr := generateOpaqueToken()
+
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+
+	invitation := &models.OrganizationInvitation{
+		OrganizationRefer: organizationId,
+		Email:             body.Email,
+		Role:              body.Role,
+		TokenHash:         hashOpaqueToken(token),
+		InvitedByRefer:    &inviter.UserRefer,
+		ExpiresAt:         time.Now().Add(invitationDuration),
+	}
+
+	if createErr := services.invitationStorage.Create(invitation); createErr != nil {
+		return nil, createErr
+	}
+
+	if sendErr := services.sendInvitationEmail(*organization, *invitation, token); sendErr != nil {
+		// an invitation that wasn't sent can't be accepted, so it's deleted for the email to be invited again
+		services.invitationStorage.Delete(invitation)
+
+		return nil, sendErr
+	}
+
+	return invitation, nil
+}
+
+// Function that returns the invitations of the organization that have not been accepted yet
+func (services *Services) GetPendingInvitations(organizationId uint) ([]*models.OrganizationInvitation, error) {
+	return services.invitationStorage.GetPendingByOrganization(organizationId)
+}
+
+// Function that revokes an invitation, by deleting it
+func (services *Services) RevokeInvitation(organizationId uint, id int) error {
+	invitation, notFoundErr := services.invitationStorage.Get(organizationId, id)
+
+	if notFoundErr != nil {
+		return notFound(notFoundErr, ErrInvitationNotFound)
+	}
+
+	return services.invitationStorage.Delete(invitation)
+}
+
+// Function that makes the user a member of the organization it was invited to. Invitations can only be
+// accepted by the user with the email they were sent to, and only once.
+func (services *Services) AcceptInvitation(ctx context.Context, user models.User, body AcceptInvitationBody) (*models.OrganizationInvitation, error) {
+	invitation, notFoundErr := services.invitationStorage.GetValid(hashOpaqueToken(body.Token))
+
+	if notFoundErr != nil || !strings.EqualFold(invitation.Email, user.Email) {
+		return nil, ErrInvalidInvitation
+	}
+
+	if firstUse, markErr := services.invitationStorage.MarkAccepted(invitation); markErr != nil {
+		return nil, markErr
+	} else if !firstUse {
+		return nil, ErrInvalidInvitation
+	}
+
+	return invitation, services.JoinOrganization(ctx, invitation.OrganizationRefer, user, invitation.Role)
+}
+
+// AUX FUNCTIONS
+
+func (services *Services) sendInvitationEmail(organization models.Organization, invitation models.OrganizationInvitation, token string) error {
+	link := getInvitationURL() + "?token=" + url.QueryEscape(token)
+
+	return services.mailer.Send(mail.Message{
+		To:      invitation.Email,
+		Subject: "You've been invited to join " + organization.Name,
+		Body: "Hi,\n\n" +
+			"You've been invited to join " + organization.Name + " as " + string(invitation.Role) + ". " +
+			"Follow this link to accept the invitation, which expires in 7 days:\n\n" +
+			link + "\n\n" +
+			"If you weren't expecting it, just ignore this email.\n",
+	})
+}
+
+// Returns the URL the invitation link points to, set by INVITATION_URL.
+// It's usually a frontend page that posts the token to /api/v1/organizations/invitations/accept.
+func getInvitationURL() string {
+	if invitationURL := os.Getenv("INVITATION_URL"); invitationURL != "" {
+		return invitationURL
+	}
+
+	return "http://localhost:8080/accept-invitation"
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"gocker-api/utils"
+)
+
+var ErrInvalidCursor = utils.NewError(utils.KindInvalid, "invalid_cursor", "cursor not valid. Please, start again from the first page")
+
+// Position in a sorted list: the order it's sorted by and the values of the order columns of the
+// last record of a page. Clients get it encoded, so that they don't depend on what's inside.
+type cursor struct {
+	Order  string            `json:"o"`
+	Values []json.RawMessage `json:"v"`
+}
+
+// Function that encodes the position after the record with the given values of the order columns
+func encodeCursor(order string, values []interface{}) (string, error) {
+	position := cursor{Order: order, Values: make([]json.RawMessage, 0, len(values))}
+
+	for _, value := range values {
+		encodedValue, encodeErr := json.Marshal(value)
+
+		if encodeErr != nil {
+			return "", encodeErr
+		}
+
+		position.Values = append(position.Values, encodedValue)
+	}
+
+	encoded, encodeErr := json.Marshal(position)
+
+	if encodeErr != nil {
+		return "", encodeErr
+	}
+
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// Function that decodes a cursor returned by encodeCursor, which must have been encoded for the same order.
+// Returns the raw values of its order columns.
+func decodeCursor(encoded string, order string) ([]json.RawMessage, error) {
+	var position cursor
+
+	decoded, decodeErr := base64.RawURLEncoding.DecodeString(encoded)
+
+	if decodeErr != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if unmarshalErr := json.Unmarshal(decoded, &position); unmarshalErr != nil || position.Order != order {
+		return nil, ErrInvalidCursor
+	}
+
+	return position.Values, nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"gocker-api/mail"
+	"gocker-api/models"
+	"gocker-api/utils"
+	"log"
+	"net/url"
+	"os"
+	"time"
+)
+
+var ErrInvalidResetToken = utils.NewError(utils.KindInvalid, "invalid_reset_token", "password reset token not valid or expired")
+
+type ForgotPasswordBody struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+type ResetPasswordBody struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=72,password"`
+}
+
+const passwordResetDuration = time.Hour
+
+// Function that sends a password reset link to the given email, if it belongs to a user.
+// It never reports whether it does, and the work is done in the background, so that
+// neither the response nor its timing can be used to find out which emails are registered.
+func (services *Services) ForgotPassword(ctx context.Context, body ForgotPasswordBody) {
+	//The work outlives the request, so it must not be cancelled when the response is sent
+	ctx = context.WithoutCancel(ctx)
+
+	go func() {
+		user, notFoundErr := services.GetUserByEmail(ctx, body.Email)
+
+		if notFoundErr != nil {
+			return
+		}
+
+		if sendErr := services.sendPasswordResetEmail(*user); sendErr != nil {
+			log.Printf("Could not send password reset email to user %d: %s\n", user.ID, sendErr)
+		}
+	}()
+}
+
+// Function that sets a new password for the user the reset token was sent to, revoking all its tokens
+// and personal access tokens
+func (services *Services) ResetPassword(ctx context.Context, body ResetPasswordBody) error {
+	passwordReset, notFoundErr := services.passwordResetStorage.GetValid(hashOpaqueToken(body.Token))
+
+	if notFoundErr != nil {
+		return ErrInvalidResetToken
+	}
+
+	if firstUse, markErr := services.passwordResetStorage.MarkUsed(passwordReset); markErr != nil {
+		return markErr
+	} else if !firstUse {
+		return ErrInvalidResetToken
+	}
+
+	user, userNotFoundErr := services.GetUserById(ctx, int(passwordReset.UserRefer))
+
+	if userNotFoundErr != nil {
+		return userNotFoundErr
+	}
+
+	if encodeErr := user.EncodePassword(body.Password); encodeErr != nil {
+		return encodeErr
+	}
+
+	// Following the link sent by email also proves the user owns it
+	user.EmailVerified = true
+
+	if updateErr := services.userStorage.UpdateColumns(ctx, user, "password", "email_verified"); updateErr != nil {
+		return updateErr
+	}
+
+	// Whoever knew the old password must not keep any session open, nor any personal access token it created
+	return services.revokeOtherSessions(ctx, *user, "")
+}
+
+// AUX FUNCTIONS
+
+// Function that issues a new password reset token for the user and sends it by email.
+// Previous tokens of the user are no longer valid once a new one is issued.
+func (services *Services) sendPasswordResetEmail(user models.User) error {
+	token, tokenErr := generateOpaqueToken()
+
+	if tokenErr != nil {
+		return tokenErr
+	}
+
+	if deleteErr := services.passwordResetStorage.DeleteByUser(user.ID); deleteErr != nil {
+		return deleteErr
+	}
+
+	passwordReset := &models.PasswordReset{
+		UserRefer: user.ID,
+		TokenHash: hashOpaqueToken(token),
+		ExpiresAt: time.Now().Add(passwordResetDuration),
+	}
+
+	if createErr := services.passwordResetStorage.Create(passwordReset); createErr != nil {
+		return createErr
+	}
+
+	link := getPasswordResetURL() + "?token=" + url.QueryEscape(token)
+
+	return services.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + user.FirstName + ",\n\n" +
+			"Someone asked to reset your password. If it was you, follow this link, which expires in 1 hour:\n\n" +
+			link + "\n\n" +
+			"If it wasn't you, just ignore this email.\n",
+	})
+}
+
+// Returns the URL the reset link points to, set by PASSWORD_RESET_URL.
+// It's usually a frontend page that posts the token and the new password to /api/v1/auth/password/reset.
+func getPasswordResetURL() string {
+	if resetURL := os.Getenv("PASSWORD_RESET_URL"); resetURL != "" {
+		return resetURL
+	}
+
+	return "http://localhost:8080/reset-password"
+}
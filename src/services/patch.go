@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocker-api/utils"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// Media type of a patch document, which tells how it's applied
+type PatchType string
+
+const (
+	// JSON Merge Patch (RFC 7396): an object with the fields to change, null removing them
+	MergePatch PatchType = "application/merge-patch+json"
+	// JSON Patch (RFC 6902): a list of operations applied in order, failing as a whole if any fails
+	JSONPatch PatchType = "application/json-patch+json"
+)
+
+// Media types of the patches resources can be patched with, as advertised by the Accept-Patch header
+var PatchTypes = []PatchType{MergePatch, JSONPatch}
+
+// Operations a JSON Patch can have
+var jsonPatchOperations = map[string]bool{"add": true, "remove": true, "replace": true, "move": true, "copy": true, "test": true}
+
+var (
+	ErrUnsupportedPatchType = utils.NewError(utils.KindUnsupported, "unsupported_patch_type", "patch type not supported. Please, use "+string(MergePatch)+" or "+string(JSONPatch))
+	ErrInvalidPatch         = utils.NewError(utils.KindInvalid, "invalid_patch", "patch not valid")
+	ErrPatchTestFailed      = utils.NewError(utils.KindConflict, "patch_test_failed", "a test operation of the patch failed")
+	ErrPatchNotApplicable   = utils.NewError(utils.KindUnprocessable, "patch_not_applicable", "patch can't be applied to the resource")
+	ErrInvalidPatchResult   = utils.NewError(utils.KindUnprocessable, "invalid_patch_result", "patched resource not valid")
+)
+
+// Function that applies a patch of the given type to a JSON document, returning the patched one.
+// Nothing is stored, so that the result can be validated before.
+func applyPatch(patchType PatchType, document []byte, patch []byte) ([]byte, error) {
+	switch patchType {
+	case MergePatch:
+		if !json.Valid(patch) {
+			return nil, ErrInvalidPatch
+		}
+
+		patched, mergeErr := jsonpatch.MergePatch(document, patch)
+
+		if mergeErr != nil {
+			return nil, fmt.Errorf("%w: %s", ErrPatchNotApplicable, mergeErr.Error())
+		}
+
+		return patched, nil
+	case JSONPatch:
+		operations, decodeErr := jsonpatch.DecodePatch(patch)
+
+		if decodeErr != nil {
+			return nil, ErrInvalidPatch
+		}
+
+		for _, operation := range operations {
+			if _, pathErr := operation.Path(); pathErr != nil || !jsonPatchOperations[operation.Kind()] {
+				return nil, fmt.Errorf("%w: every operation must have a path and be one of add, remove, replace, move, copy or test", ErrInvalidPatch)
+			}
+		}
+
+		patched, applyErr := operations.Apply(document)
+
+		if errors.Is(applyErr, jsonpatch.ErrTestFailed) {
+			return nil, fmt.Errorf("%w: %s", ErrPatchTestFailed, applyErr.Error())
+		}
+
+		if applyErr != nil {
+			return nil, fmt.Errorf("%w: %s", ErrPatchNotApplicable, applyErr.Error())
+		}
+
+		return patched, nil
+	default:
+		return nil, ErrUnsupportedPatchType
+	}
+}
+
+// Function that decodes a patched document into the value it must be, failing on any field it doesn't have
+func decodePatchResult(patched []byte, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+
+	if decodeErr := decoder.Decode(value); decodeErr != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPatchResult, decodeErr.Error())
+	}
+
+	return nil
+}
+
+// Function that validates a patched value, reporting the errors of its fields as ErrInvalidPatchResult
+func validatePatchResult(value interface{}) error {
+	validationErr := utils.ValidateBody(value)
+
+	var fieldsErr *utils.Error
+
+	if errors.As(validationErr, &fieldsErr) {
+		resultErr := *ErrInvalidPatchResult
+		resultErr.Fields = fieldsErr.Fields
+
+		return &resultErr
+	}
+
+	return validationErr
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"gocker-api/models"
+	"gocker-api/utils"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPersonalAccessTokenNotFound = utils.NewError(utils.KindNotFound, "token_not_found", "token not found")
+	ErrInvalidExpiration           = utils.NewError(utils.KindInvalid, "invalid_expiration", "expires_at must be in the future")
+)
+
+type PersonalAccessTokenBody struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scope     string     `json:"scope" validate:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Personal access tokens start with this prefix, so that they can be told apart from JWTs
+// (and found by secret scanners if they're leaked)
+const PersonalAccessTokenPrefix = "gpat_"
+
+// Scopes personal access tokens can have, which are the supported ones but openid, since they're not issued for a login
+var personalAccessTokenScopes = slices.DeleteFunc(slices.Clone(supportedScopes), func(scope string) bool {
+	return scope == OpenIDScope
+})
+
+// Personal access tokens are not marked as used more than once per this period
+const personalAccessTokenTouchThreshold = time.Minute
+
+// Function that creates a personal access token for the user, returning it along with its plaintext value.
+// The value is only returned this time, since only its hash is stored.
+func (services *Services) CreatePersonalAccessToken(user models.User, body PersonalAccessTokenBody) (*models.PersonalAccessToken, string, error) {
+	if validateErr := validatePersonalAccessTokenBody(body); validateErr != nil {
+		return nil, "", validateErr
+	}
+
+	random, randomErr := generateOpaqueToken()
+
+	if randomErr != nil {
+		return nil, "", randomErr
+	}
+
+	value := PersonalAccessTokenPrefix + random
+	token := &models.PersonalAccessToken{
+		UserRefer: user.ID,
+		Name:      body.Name,
+		TokenHash: hashOpaqueToken(value),
+		Scopes:    body.Scope,
+		ExpiresAt: body.ExpiresAt,
+	}
+
+	if createErr := services.personalAccessTokenStorage.Create(token); createErr != nil {
+		return nil, "", createErr
+	}
+
+	return token, value, nil
+}
+
+// Function that returns all personal access tokens of a user
+func (services *Services) GetPersonalAccessTokens(user models.User) ([]*models.PersonalAccessToken, error) {
+	return services.personalAccessTokenStorage.GetByUser(user.ID)
+}
+
+func (services *Services) GetPersonalAccessToken(user models.User, id int) (*models.PersonalAccessToken, error) {
+	token, notFoundErr := services.personalAccessTokenStorage.GetByUserAndId(user.ID, id)
+
+	return token, notFound(notFoundErr, ErrPersonalAccessTokenNotFound)
+}
+
+// Function that changes the name, scope and expiry of a personal access token. Its value doesn't change.
+func (services *Services) UpdatePersonalAccessToken(user models.User, id int, body PersonalAccessTokenBody) (*models.PersonalAccessToken, error) {
+	token, notFoundErr := services.GetPersonalAccessToken(user, id)
+
+	if notFoundErr != nil {
+		return nil, notFoundErr
+	}
+
+	if validateErr := validatePersonalAccessTokenBody(body); validateErr != nil {
+		return nil, validateErr
+	}
+
+	token.Name = body.Name
+	token.Scopes = body.Scope
+	token.ExpiresAt = body.ExpiresAt
+
+	if updateErr := services.personalAccessTokenStorage.Update(token); updateErr != nil {
+		return nil, updateErr
+	}
+
+	return token, nil
+}
+
+// Function that revokes a personal access token, by deleting it
+func (services *Services) DeletePersonalAccessToken(user models.User, id int) error {
+	token, notFoundErr := services.GetPersonalAccessToken(user, id)
+
+	if notFoundErr != nil {
+		return notFoundErr
+	}
+
+	return services.personalAccessTokenStorage.Delete(token)
+}
+
+// Function that authenticates a request made with a personal access token, returning its user and
+// a token of the Personal kind that represents it. That token is not stored, so it can't be revoked
+// like access tokens are.
+func (services *Services) AuthenticatePersonalAccessToken(ctx context.Context, value string) (*models.User, *models.Token, error) {
+	token, notFoundErr := services.personalAccessTokenStorage.GetByHash(hashOpaqueToken(value))
+
+	if notFoundErr != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	if token.Expired() {
+		return nil, nil, utils.NewError(utils.KindUnauthorized, "token_expired", "token expired. Please, create a new one")
+	}
+
+	user, userNotFoundErr := services.GetUserById(ctx, int(token.UserRefer))
+
+	if userNotFoundErr != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	//Keep track of when the token was last used. Failing to do so must not deny the request
+	services.personalAccessTokenStorage.Touch(token, personalAccessTokenTouchThreshold)
+
+	return user, &models.Token{UserRefer: &user.ID, Kind: models.Personal, Scope: token.Scopes}, nil
+}
+
+// AUX FUNCTIONS
+
+func validatePersonalAccessTokenBody(body PersonalAccessTokenBody) error {
+	for _, requested := range strings.Fields(body.Scope) {
+		if !slices.Contains(personalAccessTokenScopes, requested) {
+			return unsupportedScopeError(personalAccessTokenScopes)
+		}
+	}
+
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		return ErrInvalidExpiration
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"gocker-api/utils"
+	"os"
+)
+
+// Who can create an account, as set by REGISTRATION_MODE
+type RegistrationMode string
+
+const (
+	// Anyone can register at /auth/register
+	OpenRegistration RegistrationMode = "open"
+	// Only people invited by an admin can create an account, by accepting their invitation
+	InviteOnlyRegistration RegistrationMode = "invite-only"
+	// Nobody can create an account by themselves. Admins can still create users directly
+	ClosedRegistration RegistrationMode = "closed"
+)
+
+var ErrRegistrationNotOpen = utils.NewError(utils.KindForbidden, "registration_not_open", "registration is not open. Please, ask an admin for an invitation")
+
+// Returns the registration mode set by REGISTRATION_MODE, open by default
+func GetRegistrationMode() RegistrationMode {
+	switch mode := RegistrationMode(os.Getenv("REGISTRATION_MODE")); mode {
+	case InviteOnlyRegistration, ClosedRegistration:
+		return mode
+	default:
+		return OpenRegistration
+	}
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"gocker-api/models"
+	"gocker-api/utils"
+)
+
+var ErrRoleNotFound = utils.NewError(utils.KindNotFound, "role_not_found", "role not found")
+
+type RoleBody struct {
+	Name        string              `json:"name" validate:"required,max=100"`
+	Description string              `json:"description"`
+	Permissions []models.Permission `json:"permissions" validate:"required"`
+}
+
+type AssignRoleBody struct {
+	RoleID int `json:"role_id" validate:"required"`
+}
+
+// Function that returns true if the role of the user grants the given permission. The role is read
+// on every call, so that changes to it apply to every token of its users straight away.
+func (services *Services) HasPermission(user models.User, permission models.Permission) bool {
+	role, notFoundErr := services.roleStorage.Get(int(user.Role))
+
+	if notFoundErr != nil {
+		return false
+	}
+
+	return role.HasPermission(permission)
+}
+
+func (services *Services) GetAllRoles() ([]*models.Role, error) {
+	return services.roleStorage.GetAll()
+}
+
+func (services *Services) GetRoleById(id int) (*models.Role, error) {
+	role, notFoundErr := services.roleStorage.Get(id)
+
+	return role, notFound(notFoundErr, ErrRoleNotFound)
+}
+
+// Function that creates a role, recording who did it
+func (services *Services) CreateRole(actor models.User, body RoleBody) (*models.Role, error) {
+	if validateErr := services.validateRoleBody(body, 0); validateErr != nil {
+		return nil, validateErr
+	}
+
+	role := &models.Role{Name: body.Name, Description: body.Description, Permissions: body.Permissions}
+
+	// the role has no id yet, so the storage sets the target of the record
+	auditLog, auditErr := newAuditLog(&actor, "role.create", RoleAuditTarget, 0, body)
+
+	if auditErr != nil {
+		return nil, auditErr
+	}
+
+	if createErr := services.roleStorage.Create(role, auditLog); createErr != nil {
+		return nil, createErr
+	}
+
+	return role, nil
+}
+
+// Function that changes the name, description and permissions of a role, recording who did it.
+// The permissions of the admin role can't change, so that there's always someone who can manage roles.
+func (services *Services) UpdateRole(actor models.User, id int, body RoleBody) (*models.Role, error) {
+	role, notFoundErr := services.GetRoleById(id)
+
+	if notFoundErr != nil {
+		return nil, notFoundErr
+	}
+
+	if validateErr := services.validateRoleBody(body, role.ID); validateErr != nil {
+		return nil, validateErr
+	}
+
+	if role.ID == models.Admin && !samePermissions(role.Permissions, body.Permissions) {
+		return nil, utils.NewError(utils.KindForbidden, "built_in_role", "the permissions of the admin role can't be changed")
+	}
+
+	previous := *role
+	role.Name = body.Name
+	role.Description = body.Description
+	role.Permissions = body.Permissions
+
+	auditLog, auditErr := newAuditLog(&actor, "role.update", RoleAuditTarget, uint(role.ID), map[string]models.Role{"from": previous, "to": *role})
+
+	if auditErr != nil {
+		return nil, auditErr
+	}
+
+	if updateErr := services.roleStorage.Update(role, auditLog); updateErr != nil {
+		return nil, updateErr
+	}
+
+	return role, nil
+}
+
+// Function that deletes a role, recording who did it. Built-in roles and roles users still have can't be deleted.
+func (services *Services) DeleteRole(ctx context.Context, actor models.User, id int) error {
+	role, notFoundErr := services.GetRoleById(id)
+
+	if notFoundErr != nil {
+		return notFoundErr
+	}
+
+	if role.BuiltIn {
+		return utils.NewError(utils.KindForbidden, "built_in_role", "built-in roles can't be deleted")
+	}
+
+	if count, countErr := services.userStorage.CountByRole(ctx, role.ID); countErr != nil {
+		return countErr
+	} else if count > 0 {
+		return utils.NewError(utils.KindConflict, "role_in_use", "the role is assigned to some users. Assign them another role first")
+	}
+
+	auditLog, auditErr := newAuditLog(&actor, "role.delete", RoleAuditTarget, uint(role.ID), role)
+
+	if auditErr != nil {
+		return auditErr
+	}
+
+	return services.roleStorage.Delete(role, auditLog)
+}
+
+// Function that assigns a role to a user, recording who did it. The last admin can't lose its role.
+func (services *Services) AssignRole(ctx context.Context, actor models.User, userId int, body AssignRoleBody) (*models.User, error) {
+	user, userNotFoundErr := services.GetUserById(ctx, userId)
+
+	if userNotFoundErr != nil {
+		return nil, userNotFoundErr
+	}
+
+	role, roleNotFoundErr := services.GetRoleById(body.RoleID)
+
+	if roleNotFoundErr != nil {
+		return nil, roleNotFoundErr
+	}
+
+	if user.Role == models.Admin && role.ID != models.Admin {
+		if count, countErr := services.userStorage.CountByRole(ctx, models.Admin); countErr != nil {
+			return nil, countErr
+		} else if count <= 1 {
+			return nil, utils.NewError(utils.KindConflict, "last_admin", "the last admin can't lose its role")
+		}
+	}
+
+	auditLog, auditErr := newAuditLog(&actor, "user.role.assign", UserAuditTarget, user.ID, map[string]models.UserRole{"from": user.Role, "to": role.ID})
+
+	if auditErr != nil {
+		return nil, auditErr
+	}
+
+	user.Role = role.ID
+
+	if assignErr := services.roleStorage.Assign(ctx, user, auditLog); assignErr != nil {
+		return nil, assignErr
+	}
+
+	return user, nil
+}
+
+// AUX FUNCTIONS
+
+// Function that checks that every permission is known and that no other role has the same name
+func (services *Services) validateRoleBody(body RoleBody, id models.UserRole) error {
+	for _, permission := range body.Permissions {
+		if !permission.Valid() {
+			return utils.NewError(utils.KindInvalid, "unsupported_permission", "permission "+string(permission)+" not supported")
+		}
+	}
+
+	if existing, notFoundErr := services.roleStorage.GetByName(body.Name); notFoundErr == nil && existing.ID != id {
+		return utils.NewError(utils.KindConflict, "role_name_taken", "there is already a role with that name")
+	}
+
+	return nil
+}
+
+// Returns true if both lists hold the same permissions, whatever their order
+func samePermissions(a []models.Permission, b []models.Permission) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, permission := range b {
+		if !(models.Role{Permissions: a}).HasPermission(permission) {
+			return false
+		}
+	}
+
+	return true
+}
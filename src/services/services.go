@@ -0,0 +1,61 @@
+package services
+
+import (
+	"errors"
+	"gocker-api/mail"
+	"gocker-api/storage"
+)
+
+// Dependencies of the business logic of the API. Every operation that needs them is a method,
+// so that several configurations can live in the same process (e.g. a test database).
+type Services struct {
+	userStorage                storage.UserRepository
+	tokenStorage               storage.TokenRepository
+	sessionStorage             storage.SessionRepository
+	recoveryCodeStorage        storage.RecoveryCodeRepository
+	passwordResetStorage       storage.PasswordResetRepository
+	mfaChallengeStorage        storage.MFAChallengeRepository
+	oauthClientStorage         storage.OAuthClientRepository
+	authorizationCodeStorage   storage.AuthorizationCodeRepository
+	personalAccessTokenStorage storage.PersonalAccessTokenRepository
+	roleStorage                storage.RoleRepository
+	auditLogStorage            storage.AuditLogRepository
+	organizationStorage        storage.OrganizationRepository
+	membershipStorage          storage.MembershipRepository
+	invitationStorage          storage.OrganizationInvitationRepository
+	userInvitationStorage      storage.UserInvitationRepository
+	mailer                     mail.Mailer
+}
+
+// Returns the services working with the given storages, sending emails through the given mailer
+func New(repositories *storage.Repositories, mailer mail.Mailer) *Services {
+	return &Services{
+		userStorage:                repositories.Users,
+		tokenStorage:               repositories.Tokens,
+		sessionStorage:             repositories.Sessions,
+		recoveryCodeStorage:        repositories.RecoveryCodes,
+		passwordResetStorage:       repositories.PasswordResets,
+		mfaChallengeStorage:        repositories.MFAChallenges,
+		oauthClientStorage:         repositories.OAuthClients,
+		authorizationCodeStorage:   repositories.AuthorizationCodes,
+		personalAccessTokenStorage: repositories.PersonalAccessTokens,
+		roleStorage:                repositories.Roles,
+		auditLogStorage:            repositories.AuditLogs,
+		organizationStorage:        repositories.Organizations,
+		membershipStorage:          repositories.Memberships,
+		invitationStorage:          repositories.OrganizationInvitations,
+		userInvitationStorage:      repositories.UserInvitations,
+		mailer:                     mailer,
+	}
+}
+
+// AUX FUNCTIONS
+
+// Function that turns the not found error of the storages into the given one, which tells what wasn't found
+func notFound(err error, notFoundErr error) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return notFoundErr
+	}
+
+	return err
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"gocker-api/auth"
+	"gocker-api/models"
+	"gocker-api/utils"
+	"os"
+	"strconv"
+	"time"
+)
+
+var ErrSessionNotFound = utils.NewError(utils.KindNotFound, "session_not_found", "session not found")
+
+// Metadata of the device starting a session
+type SessionInfo struct {
+	UserAgent string
+	IPAddress string
+}
+
+// Credentials posted to the login page. Code is the second factor of the users that enabled it.
+type LoginBody struct {
+	Email    string
+	Password string
+	Code     string
+}
+
+// Sessions are not marked as used more than once per this period
+const sessionTouchThreshold = time.Minute
+
+// Login sessions are valid for this period, after which the user has to log in again to authorize clients
+const LoginSessionDuration = 12 * time.Hour
+
+var ErrMFACodeRequired = utils.NewError(utils.KindUnauthorized, "mfa_code_required", "two-factor authentication code required")
+
+// Function that returns all sessions of a user
+func (services *Services) GetUserSessions(user models.User) ([]*models.Session, error) {
+	return services.sessionStorage.GetByUser(user.ID)
+}
+
+// Function that returns the session a token belongs to
+func (services *Services) GetSessionByToken(token models.Token) (*models.Session, error) {
+	if token.Family == "" {
+		return nil, ErrSessionNotFound
+	}
+
+	session, notFoundErr := services.sessionStorage.GetByFamily(token.Family)
+
+	return session, notFound(notFoundErr, ErrSessionNotFound)
+}
+
+// Function that revokes one of the user's sessions, along with all its tokens
+func (services *Services) RevokeSession(ctx context.Context, user models.User, id int) error {
+	item, notFoundErr := services.sessionStorage.Get(id)
+
+	if notFoundErr != nil {
+		return notFound(notFoundErr, ErrSessionNotFound)
+	}
+
+	session := item.(*models.Session)
+
+	// Don't let users know about other users' sessions
+	if session.UserRefer != user.ID {
+		return ErrSessionNotFound
+	}
+
+	return services.endSession(ctx, session)
+}
+
+// Function that updates the last used time of the session a token belongs to
+func (services *Services) TouchSession(token models.Token) error {
+	if token.Family == "" {
+		return nil
+	}
+
+	return services.sessionStorage.Touch(token.Family, sessionTouchThreshold)
+}
+
+// Function that logs a user in through the login page, starting a session whose token the browser keeps in a cookie.
+// It's only used to authorize OAuth clients, so no access or refresh token is issued for it.
+func (services *Services) StartLoginSession(ctx context.Context, body LoginBody, info SessionInfo) (string, error) {
+	user, credentialsErr := services.checkCredentials(ctx, UserAuthenticateBody{Email: body.Email, Password: body.Password})
+
+	if credentialsErr != nil {
+		return "", credentialsErr
+	}
+
+	if user.TOTPEnabled {
+		if body.Code == "" {
+			return "", ErrMFACodeRequired
+		}
+
+		if codeErr := services.checkSecondFactor(ctx, user, body.Code); codeErr != nil {
+			return "", codeErr
+		}
+	}
+
+	if capErr := services.enforceSessionCap(ctx, *user); capErr != nil {
+		return "", capErr
+	}
+
+	session, createErr := services.createSession(user.ID, info)
+
+	if createErr != nil {
+		return "", createErr
+	}
+
+	value, tokenErr := auth.GeneratePurposeToken(*user, auth.LoginSessionPurpose, LoginSessionDuration)
+
+	if tokenErr != nil {
+		return "", tokenErr
+	}
+
+	_, createErr = services.CreateToken(ctx, &models.Token{TokenValue: value, UserRefer: &user.ID, Kind: models.Login, Family: session.Family})
+
+	return value, createErr
+}
+
+// Function that returns the user of the login session whose token is given. Ending the session revokes its token.
+func (services *Services) AuthenticateLoginSession(ctx context.Context, value string) (*models.User, error) {
+	if _, validationErr := auth.ValidatePurposeToken(value, auth.LoginSessionPurpose); validationErr != nil {
+		return nil, ErrInvalidToken
+	}
+
+	token, notFoundErr := services.GetTokenByValue(ctx, value)
+
+	if notFoundErr != nil || token.Kind != models.Login || token.UserRefer == nil {
+		return nil, ErrTokenRevoked
+	}
+
+	user, userNotFoundErr := services.GetUserById(ctx, int(*token.UserRefer))
+
+	if userNotFoundErr != nil {
+		return nil, ErrInvalidToken
+	}
+
+	//Keep track of when the session was last used. Failing to do so must not deny the request
+	services.TouchSession(*token)
+
+	return user, nil
+}
+
+// AUX FUNCTIONS
+
+// Function that starts a new session for the user, issuing its access token and refresh token.
+// If the user has reached its maximum of active sessions, the least recently used ones are ended.
+func (services *Services) startSession(ctx context.Context, user models.User, info SessionInfo) (accessToken *models.Token, refreshToken *models.Token, err error) {
+	if capErr := services.enforceSessionCap(ctx, user); capErr != nil {
+		err = capErr
+		return
+	}
+
+	session, createErr := services.createSession(user.ID, info)
+
+	if createErr != nil {
+		err = createErr
+		return
+	}
+
+	return services.issueTokenPair(ctx, user, session.Family, nil, nil, "")
+}
+
+// Function that saves a new session of the user, with a new token family
+func (services *Services) createSession(userId uint, info SessionInfo) (*models.Session, error) {
+	family, familyErr := generateTokenFamily()
+
+	if familyErr != nil {
+		return nil, familyErr
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserRefer:  userId,
+		Family:     family,
+		UserAgent:  info.UserAgent,
+		IPAddress:  info.IPAddress,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+
+	if createErr := services.sessionStorage.Create(session); createErr != nil {
+		return nil, createErr
+	}
+
+	return session, nil
+}
+
+// Function that ends a session, revoking every token of its family
+func (services *Services) endSession(ctx context.Context, session *models.Session) error {
+	tokens, getErr := services.tokenStorage.GetByFamily(ctx, session.Family)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	for _, token := range tokens {
+		if err := services.DeleteToken(ctx, token); err != nil {
+			return err
+		}
+	}
+
+	return services.sessionStorage.Delete(session)
+}
+
+// Function that ends every session of the user but the one of the given family (if it's not empty), revoking
+// every token issued outside of it and every personal access token of the user
+func (services *Services) revokeOtherSessions(ctx context.Context, user models.User, family string) error {
+	sessions, getErr := services.sessionStorage.GetByUser(user.ID)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	for _, session := range sessions {
+		if family == "" || session.Family != family {
+			if endErr := services.endSession(ctx, session); endErr != nil {
+				return endErr
+			}
+		}
+	}
+
+	// tokens issued to OAuth clients and before families existed have no session
+	tokens, getErr := services.tokenStorage.GetByUser(ctx, user.ID)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	for _, token := range tokens {
+		if family == "" || token.Family != family {
+			if deleteErr := services.DeleteToken(ctx, token); deleteErr != nil {
+				return deleteErr
+			}
+		}
+	}
+
+	personalAccessTokens, getErr := services.personalAccessTokenStorage.GetByUser(user.ID)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	for _, token := range personalAccessTokens {
+		if deleteErr := services.personalAccessTokenStorage.Delete(token); deleteErr != nil {
+			return deleteErr
+		}
+	}
+
+	return nil
+}
+
+// Function that ends the least recently used sessions of a user, leaving room for a new one
+func (services *Services) enforceSessionCap(ctx context.Context, user models.User) error {
+	maxSessions := getMaxSessions(user)
+
+	if maxSessions <= 0 {
+		return nil
+	}
+
+	sessions, getErr := services.sessionStorage.GetByUser(user.ID)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	// sessions are sorted by most recently used, so the oldest ones are at the end
+	for len(sessions) >= maxSessions {
+		if endErr := services.endSession(ctx, sessions[len(sessions)-1]); endErr != nil {
+			return endErr
+		}
+
+		sessions = sessions[:len(sessions)-1]
+	}
+
+	return nil
+}
+
+// Returns the maximum number of sessions of the user, or 0 if it's unlimited
+func getMaxSessions(user models.User) int {
+	if user.MaxSessions != nil {
+		return *user.MaxSessions
+	}
+
+	maxSessions, parseErr := strconv.Atoi(os.Getenv("MAX_SESSIONS_PER_USER"))
+
+	if parseErr != nil {
+		return 0
+	}
+
+	return maxSessions
+}
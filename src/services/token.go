@@ -1,40 +1,26 @@
 package services
 
 import (
-	"errors"
-	"gocker-api/database"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"gocker-api/models"
-	"gocker-api/storage"
 )
 
-var tokenStorage storage.Storage = &storage.TokenStorage{}
-
-func GetTokenById(id int) (*models.Token, error) {
-	token, getTokenErr := tokenStorage.Get(id)
-
-	if getTokenErr != nil {
-		return nil, getTokenErr
-	}
-
-	return token.(*models.Token), nil
+func (services *Services) GetTokenById(ctx context.Context, id int) (*models.Token, error) {
+	return services.tokenStorage.Get(ctx, uint(id))
 }
 
 // Function that gets a token by its value
-func GetTokenByValue(tokenString string) (*models.Token, error) {
-	var token *models.Token
-	database := database.GetInstance().GetDB()
-	result := database.Find(&token, "token_value LIKE ?", tokenString)
-
-	if result.RowsAffected == 0 {
-		return nil, errors.New("token not found")
-	}
-
-	return token, nil
+func (services *Services) GetTokenByValue(ctx context.Context, tokenString string) (*models.Token, error) {
+	return services.tokenStorage.GetByValue(ctx, tokenString)
 }
 
 // Function that saves a token to the database
-func CreateToken(token *models.Token) (*models.Token, error) {
-	if createErr := tokenStorage.Create(token); createErr != nil {
+func (services *Services) CreateToken(ctx context.Context, token *models.Token) (*models.Token, error) {
+	if createErr := services.tokenStorage.Create(ctx, token); createErr != nil {
 		return nil, createErr
 	}
 
@@ -42,6 +28,26 @@ func CreateToken(token *models.Token) (*models.Token, error) {
 }
 
 // Function that deletes a token from the database
-func DeleteToken(token *models.Token) error {
-	return tokenStorage.Delete(token)
+func (services *Services) DeleteToken(ctx context.Context, token *models.Token) error {
+	return services.tokenStorage.Delete(ctx, token)
+}
+
+// AUX FUNCTIONS
+
+// Function that generates a random opaque token, to be sent to the user while only its hash is stored
+func generateOpaqueToken() (string, error) {
+	random := make([]byte, 32)
+
+	if _, readErr := rand.Read(random); readErr != nil {
+		return "", readErr
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// Opaque tokens are random enough for a fast hash to be safe, and it allows looking them up directly
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
 }
@@ -1,65 +1,188 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
-	"gocker-api/database"
 	"gocker-api/models"
 	"gocker-api/storage"
+	"gocker-api/utils"
+	"log"
 	"os"
+	"slices"
+	"strings"
+	"time"
 )
 
 type UserBody struct {
-	FirstName string `json:"first_name" validate:"required"`
-	Email     string `json:"email" validate:"required"`
-	Password  string `json:"password" validate:"required"`
+	FirstName string `json:"first_name" validate:"required,max=100"`
+	Email     string `json:"email" validate:"required,email,max=254"`
+	Password  string `json:"password" validate:"required,min=8,max=72,password"`
 }
 
-type UpdateUserBody struct {
-	FirstName string `json:"first_name"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
+// Writable fields of a user, replaced as a whole by ReplaceUser and patched by PatchUser.
+// The password is write-only: it's never part of the current document, and it's kept unless one is given.
+type UserDocument struct {
+	FirstName   string `json:"first_name" validate:"required,max=100"`
+	Email       string `json:"email" validate:"required,email,max=254"`
+	Password    string `json:"password,omitempty" validate:"omitempty,min=8,max=72,password"`
+	MaxSessions *int   `json:"max_sessions" validate:"omitempty,min=0"`
+	// Required to change the password or the email of the user's own account. It's never stored.
+	CurrentPassword string `json:"current_password,omitempty"`
 }
 
-var userStorage storage.Storage = &storage.UserStorage{}
+const (
+	DefaultUsersPageSize = 50
+	MaxUsersPageSize     = 200
+)
 
-func GetAllUsers() []models.User {
-	var users []models.User
+// Columns users can be sorted by
+var userSortColumns = map[string]bool{"id": true, "email": true, "first_name": true, "role": true, "created_at": true}
 
-	database := database.GetInstance().GetDB()
-	database.Find(&users)
+var (
+	ErrUserNotFound           = utils.NewError(utils.KindNotFound, "user_not_found", "user not found")
+	ErrInvalidUserSort        = utils.NewError(utils.KindInvalid, "invalid_sort", "users can only be sorted by id, email, first_name, role and created_at")
+	ErrEmailAlreadyRegistered = utils.NewError(utils.KindConflict, "email_already_registered", "email already registered")
+	ErrMaxSessionsForbidden   = utils.NewError(utils.KindForbidden, "permission_denied", "permission denied. "+string(models.UsersWritePermission)+" is required to change max_sessions")
+	ErrUserVersionMismatch    = utils.NewError(utils.KindPreconditionFailed, "version_mismatch", "user has been changed since it was read. Please, get it again and retry")
+	ErrWrongCurrentPassword   = utils.NewError(utils.KindForbidden, "wrong_current_password", "current_password must be the password of the user to change its password or email")
+)
 
-	return users
+// Users an operation can reach. Tenants only reach the members of their organization, so that they can never
+// read or change the users of another one.
+type UserScope struct {
+	// Organization whose members can be reached. Nobody can be reached if it's nil, unless All is set.
+	OrganizationID *uint
+	// Whether every user can be reached, whatever organizations they're in
+	All bool
 }
 
-func GetUserById(id int) (*models.User, error) {
+// Scope that reaches every user. It's only meant for superadmins and for users acting on themselves.
+var AllUsers = UserScope{All: true}
 
-	// don't check the type assertion, since we are sure that the Get method is returning *models.User
-	user, err := userStorage.Get(id)
+// Options of an update of a user
+type UserUpdateOptions struct {
+	// Whether the session limit can be changed, which only admins can do
+	CanLimitSessions bool
+	// Whether changing the password or the email requires the current password, which is the case when users change their
+	// own account, so that a stolen token isn't enough to take it over
+	RequireCurrentPassword bool
+	// Token family of the session making the change, which stays open when the password changes while every other one ends
+	Family string
+	// Versions the user must be at to be changed, usually the ETags of an If-Match header. Any version if it's nil.
+	Versions []uint
+}
 
-	if err != nil {
-		return nil, err
-	}
+type UserListOptions struct {
+	// Only list the users with exactly these values, when they're set
+	Email     string
+	FirstName string
+	Role      *models.UserRole
+	// Only list the users created within these times, when they're set
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Comma separated columns to sort by, descending if prefixed by a -, e.g. "-created_at,email".
+	// Users are sorted by id after them, so that the order is always the same.
+	Sort string
+	// Maximum number of users of the page, DefaultUsersPageSize if it's 0
+	Limit int
+	// Cursor of the page to list, as returned in the previous one. The first page is listed if it's empty.
+	After string
+	// Whether to count every user matching the filters, which costs one more query
+	IncludeTotal bool
+}
 
-	return user.(*models.User), nil
+type UserPage struct {
+	Users []*models.User
+	// Cursor of the next page, or empty if this is the last one
+	NextCursor string
+	// Number of users matching the filters, only set if it was asked for
+	Total *int64
 }
 
-func GetUserByEmail(email string) (user *models.User, err error) {
-	database := database.GetInstance().GetDB()
+// Function that returns a page of the users the given scope reaches, filtered and sorted as the options say
+func (services *Services) ListUsers(ctx context.Context, scope UserScope, options UserListOptions) (*UserPage, error) {
+	order, orderErr := parseUserSort(options.Sort)
+
+	if orderErr != nil {
+		return nil, orderErr
+	}
+
+	limit := options.Limit
+
+	if limit <= 0 {
+		limit = DefaultUsersPageSize
+	}
+
+	limit = min(limit, MaxUsersPageSize)
+
+	filter, conditions := userListFilter(options)
+	// one more user than the page holds tells whether there's a next page
+	listOptions := storage.ListOptions{Filter: filter, Conditions: conditions, Order: order, Limit: limit + 1}
+
+	if options.After != "" {
+		after, cursorErr := decodeUserCursor(options.After, order)
+
+		if cursorErr != nil {
+			return nil, cursorErr
+		}
+
+		listOptions.After = after
+	}
+
+	userStorage := services.usersIn(scope)
+	users, listErr := userStorage.List(ctx, listOptions)
+
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	page := &UserPage{Users: users}
+
+	if len(users) > limit {
+		page.Users = users[:limit]
+		nextCursor, cursorErr := encodeUserCursor(page.Users[limit-1], order)
 
-	if result := database.Find(&user, "email LIKE ?", email); result.RowsAffected == 0 {
-		err = errors.New("user not found")
+		if cursorErr != nil {
+			return nil, cursorErr
+		}
+
+		page.NextCursor = nextCursor
 	}
 
-	return
+	if options.IncludeTotal {
+		total, countErr := userStorage.Count(ctx, filter, conditions...)
+
+		if countErr != nil {
+			return nil, countErr
+		}
+
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+func (services *Services) GetUserById(ctx context.Context, id int) (*models.User, error) {
+	return services.GetUserInScope(ctx, AllUsers, id)
 }
 
-func CreateUser(userBody UserBody) (*models.User, error) {
+// Function that returns a user, only if the given scope reaches it
+func (services *Services) GetUserInScope(ctx context.Context, scope UserScope, id int) (*models.User, error) {
+	user, err := services.usersIn(scope).Get(ctx, uint(id))
 
-	// first check that the user email has not already been registered
-	if _, notFoundErr := GetUserByEmail(userBody.Email); notFoundErr == nil {
-		return nil, errors.New("email already registered")
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrUserNotFound
 	}
 
+	return user, err
+}
+
+func (services *Services) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return services.userStorage.GetByEmail(ctx, email)
+}
+
+func (services *Services) CreateUser(ctx context.Context, userBody UserBody) (*models.User, error) {
 	var userRole models.UserRole
 
 	// Set user properties
@@ -76,45 +199,345 @@ func CreateUser(userBody UserBody) (*models.User, error) {
 		Role:      userRole,
 	}
 
-	user.EncodePassword(userBody.Password)
-	createErr := userStorage.Create(user)
+	if encodeErr := user.EncodePassword(userBody.Password); encodeErr != nil {
+		return nil, encodeErr
+	}
+
+	// emails are unique, so an email that's already registered conflicts with its user
+	if createErr := services.userStorage.Create(ctx, user); createErr != nil {
+		return nil, emailConflictError(createErr)
+	}
+
+	return user, nil
+}
+
+// Function that replaces every writable field of a user with the given document, only if the given scope
+// reaches it. Fields missing from the document are cleared, but the password, which is kept unless the document has one.
+func (services *Services) ReplaceUser(ctx context.Context, scope UserScope, id int, document UserDocument, options UserUpdateOptions) (*models.User, error) {
+	user, getErr := services.getUserAtVersion(ctx, scope, id, options.Versions)
+
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	return services.saveUserDocument(ctx, scope, user, document, options)
+}
+
+// Function that applies a patch of the given type to the document of a user (see UserDocument), only if
+// the given scope reaches it. The patched document is validated before anything
+// is stored, and then stored at once, so that either the whole patch is applied or nothing is.
+func (services *Services) PatchUser(ctx context.Context, scope UserScope, id int, patchType PatchType, patch []byte, options UserUpdateOptions) (*models.User, error) {
+	user, getErr := services.getUserAtVersion(ctx, scope, id, options.Versions)
+
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	current, encodeErr := json.Marshal(UserDocument{FirstName: user.FirstName, Email: user.Email, MaxSessions: user.MaxSessions})
+
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+
+	patched, patchErr := applyPatch(patchType, current, patch)
+
+	if patchErr != nil {
+		return nil, patchErr
+	}
+
+	var document UserDocument
+
+	if decodeErr := decodePatchResult(patched, &document); decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	if validationErr := validatePatchResult(document); validationErr != nil {
+		return nil, validationErr
+	}
+
+	return services.saveUserDocument(ctx, scope, user, document, options)
+}
+
+// Function that deletes a user, only if the given scope reaches it and it's at one of the given versions
+// (when they're not nil). Users are shared by organizations, so within one it only removes the user from it,
+// and the user is only deleted once it's a member of no other organization.
+func (services *Services) DeleteUser(ctx context.Context, scope UserScope, id int, versions []uint) error {
+	user, getErr := services.getUserAtVersion(ctx, scope, id, versions)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	if !scope.All {
+		membership, notFoundErr := services.GetMembership(*scope.OrganizationID, user.ID)
+
+		if notFoundErr != nil {
+			return notFoundErr
+		}
+
+		if membership.Role == models.OwnerMembership {
+			if lastErr := services.checkNotLastOwner(membership.OrganizationRefer); lastErr != nil {
+				return lastErr
+			}
+		}
+
+		if count, countErr := services.membershipStorage.CountByUser(user.ID); countErr != nil {
+			return countErr
+		} else if count > 1 {
+			return services.leaveOrganization(ctx, membership)
+		}
+	}
+
+	return userVersionError(services.userStorage.Delete(ctx, user))
+}
+
+// Returns true if updates and deletes of users must say the version they apply to with If-Match,
+// as set by REQUIRE_IF_MATCH
+func IfMatchRequired() bool {
+	return os.Getenv("REQUIRE_IF_MATCH") == "true"
+}
+
+// AUX FUNCTIONS
+
+// Returns the storage of the users the scope reaches
+func (services *Services) usersIn(scope UserScope) storage.UserRepository {
+	if scope.All {
+		return services.userStorage
+	}
+
+	return services.userStorage.ForOrganization(scope.OrganizationID)
+}
+
+// Function that sets the fields of the document to the user and stores it with a single update
+func (services *Services) saveUserDocument(ctx context.Context, scope UserScope, user *models.User, document UserDocument, options UserUpdateOptions) (*models.User, error) {
+	//The session limit is set by admins, so users acting on themselves can't lift it
+	if !options.CanLimitSessions && !equalLimits(user.MaxSessions, document.MaxSessions) {
+		return nil, ErrMaxSessionsForbidden
+	}
+
+	emailChanged := document.Email != user.Email
+	passwordChanged := document.Password != ""
+
+	if options.RequireCurrentPassword && (emailChanged || passwordChanged) && user.ComparePassword(document.CurrentPassword) != nil {
+		return nil, ErrWrongCurrentPassword
+	}
+
+	if emailChanged {
+		user.Email = document.Email
+		user.EmailVerified = false
+	}
+
+	user.FirstName = document.FirstName
+	user.MaxSessions = document.MaxSessions
+
+	if passwordChanged {
+		if encodeErr := user.EncodePassword(document.Password); encodeErr != nil {
+			return nil, encodeErr
+		}
+	}
+
+	// the update only applies to the version the user was read at, so a concurrent one is never overwritten
+	if updateErr := services.usersIn(scope).Update(ctx, user); updateErr != nil {
+		return nil, userVersionError(emailConflictError(updateErr))
+	}
+
+	// whoever knew the old password must not keep any other session open nor any personal access token
+	if passwordChanged {
+		if revokeErr := services.revokeOtherSessions(ctx, *user, options.Family); revokeErr != nil {
+			return nil, revokeErr
+		}
+	}
+
+	// the new email has to be verified. Failing to send the link must not fail the update, since it can be sent again
+	if emailChanged {
+		if mailErr := services.sendVerificationEmail(*user); mailErr != nil {
+			log.Printf("Could not send verification email to user %d: %s\n", user.ID, mailErr)
+		}
+	}
 
-	return user, createErr
+	return user, nil
 }
 
-func UpdateUser(id int, updatedUser UpdateUserBody) (*models.User, error) {
-	var user *models.User
-	database := database.GetInstance().GetDB()
+// Function that returns a user the given scope reaches, only if it's at one of the given versions (when they're not nil)
+func (services *Services) getUserAtVersion(ctx context.Context, scope UserScope, id int, versions []uint) (*models.User, error) {
+	user, notFoundErr := services.GetUserInScope(ctx, scope, id)
 
-	if result := database.Find(&user, "id = ?", id); result.RowsAffected == 0 {
-		return nil, errors.New("user not found")
+	if notFoundErr != nil {
+		return nil, notFoundErr
 	}
 
-	if updatedUser.FirstName != "" {
-		user.FirstName = updatedUser.FirstName
+	if versions != nil && !slices.Contains(versions, user.Version) {
+		return nil, ErrUserVersionMismatch
 	}
-	if updatedUser.Email != "" {
-		user.Email = updatedUser.Email
+
+	return user, nil
+}
+
+// Function that turns the errors of the storage about the version of a user into the ones of the service
+func userVersionError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrStaleVersion):
+		return ErrUserVersionMismatch
+	case errors.Is(err, storage.ErrNotFound):
+		return ErrUserNotFound
+	default:
+		return err
 	}
-	if updatedUser.Password != "" {
-		user.EncodePassword(updatedUser.Password)
+}
+
+// Function that turns the conflicts of the storage into ErrEmailAlreadyRegistered, since the email is the only unique field of users
+func emailConflictError(err error) error {
+	if errors.Is(err, storage.ErrConflict) {
+		return ErrEmailAlreadyRegistered
 	}
 
-	updateErr := userStorage.Update(user)
+	return err
+}
+
+func equalLimits(a *int, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
 
-	return user, updateErr
+	return *a == *b
 }
 
-func DeleteUser(id int) (err error) {
-	var user *models.User
-	database := database.GetInstance().GetDB()
+// Function that turns a sort like "-created_at,email" into an order clause, ending with the id
+// if it's not sorted by it already
+func parseUserSort(sort string) (string, error) {
+	columns := make([]string, 0)
+	sortedById := false
+
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+
+		if field == "" {
+			continue
+		}
+
+		column, descending := strings.CutPrefix(field, "-")
+
+		if !userSortColumns[column] {
+			return "", ErrInvalidUserSort
+		}
+
+		sortedById = sortedById || column == "id"
+
+		if descending {
+			column += " DESC"
+		}
+
+		columns = append(columns, column)
+	}
 
-	if result := database.Find(user, "id = ?", id); result.Error != nil {
-		err = errors.New("user not found")
-		return
+	if !sortedById {
+		columns = append(columns, "id")
 	}
 
-	err = userStorage.Delete(user)
+	return strings.Join(columns, ", "), nil
+}
+
+func userListFilter(options UserListOptions) (storage.Filter, []storage.Condition) {
+	filter := storage.Filter{}
+	conditions := make([]storage.Condition, 0)
+
+	if options.Email != "" {
+		filter["email"] = options.Email
+	}
+
+	if options.FirstName != "" {
+		filter["first_name"] = options.FirstName
+	}
+
+	if options.Role != nil {
+		filter["role"] = *options.Role
+	}
+
+	if options.CreatedAfter != nil {
+		conditions = append(conditions, storage.Condition{Column: "created_at", Operator: ">=", Value: *options.CreatedAfter})
+	}
+
+	if options.CreatedBefore != nil {
+		conditions = append(conditions, storage.Condition{Column: "created_at", Operator: "<", Value: *options.CreatedBefore})
+	}
+
+	return filter, conditions
+}
+
+// Function that encodes the position after the user in the given order
+func encodeUserCursor(user *models.User, order string) (string, error) {
+	orderColumns, parseErr := storage.ParseOrder(order)
+
+	if parseErr != nil {
+		return "", parseErr
+	}
+
+	values := make([]interface{}, 0, len(orderColumns))
+
+	for _, orderColumn := range orderColumns {
+		switch orderColumn.Column {
+		case "email":
+			values = append(values, user.Email)
+		case "first_name":
+			values = append(values, user.FirstName)
+		case "role":
+			values = append(values, user.Role)
+		case "created_at":
+			values = append(values, user.CreatedAt)
+		default:
+			values = append(values, user.ID)
+		}
+	}
+
+	return encodeCursor(order, values)
+}
+
+// Function that decodes a cursor of encodeUserCursor into the values of the order columns, with their types
+func decodeUserCursor(encoded string, order string) ([]interface{}, error) {
+	orderColumns, parseErr := storage.ParseOrder(order)
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	rawValues, decodeErr := decodeCursor(encoded, order)
+
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	if len(rawValues) != len(orderColumns) {
+		return nil, ErrInvalidCursor
+	}
+
+	values := make([]interface{}, 0, len(orderColumns))
+
+	for i, orderColumn := range orderColumns {
+		var unmarshalErr error
+
+		switch orderColumn.Column {
+		case "email", "first_name":
+			var value string
+			unmarshalErr = json.Unmarshal(rawValues[i], &value)
+			values = append(values, value)
+		case "role":
+			var value models.UserRole
+			unmarshalErr = json.Unmarshal(rawValues[i], &value)
+			values = append(values, value)
+		case "created_at":
+			var value time.Time
+			unmarshalErr = json.Unmarshal(rawValues[i], &value)
+			values = append(values, value)
+		default:
+			var value uint
+			unmarshalErr = json.Unmarshal(rawValues[i], &value)
+			values = append(values, value)
+		}
+
+		if unmarshalErr != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
 
-	return
+	return values, nil
 }
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"gocker-api/mail"
+	"gocker-api/models"
+	"gocker-api/utils"
+	"net/url"
+	"os"
+	"time"
+)
+
+var (
+	ErrInvitationNotFound = utils.NewError(utils.KindNotFound, "invitation_not_found", "invitation not found")
+	ErrInvalidInvitation  = utils.NewError(utils.KindInvalid, "invalid_invitation", "invitation not valid or expired")
+)
+
+type UserInvitationBody struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+	// Role the user gets once it accepts. The standard one if it's not set
+	RoleID    int        `json:"role_id"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type AcceptUserInvitationBody struct {
+	Token     string `json:"token" validate:"required"`
+	FirstName string `json:"first_name" validate:"required,max=100"`
+	Password  string `json:"password" validate:"required,min=8,max=72,password"`
+}
+
+const defaultUserInvitationDuration = 7 * 24 * time.Hour
+
+// Function that invites someone to create an account with the given role, sending the invitation by email.
+// Pre-assigning a role other than the standard one requires the roles:write permission.
+// The account doesn't join any organization, which organization invitations are for.
+func (services *Services) InviteUser(ctx context.Context, inviter models.User, body UserInvitationBody) (*models.UserInvitation, error) {
+	if GetRegistrationMode() == ClosedRegistration {
+		return nil, utils.NewError(utils.KindForbidden, "registration_closed", "registration is closed, so invitations can't be accepted")
+	}
+
+	if _, notFoundErr := services.GetUserByEmail(ctx, body.Email); notFoundErr == nil {
+		return nil, ErrEmailAlreadyRegistered
+	}
+
+	if _, notFoundErr := services.userInvitationStorage.GetPendingByEmail(body.Email); notFoundErr == nil {
+		return nil, utils.NewError(utils.KindConflict, "email_already_invited", "email already invited. Resend the invitation instead")
+	}
+
+	role := models.Standard
+
+	if body.RoleID != 0 {
+		if _, notFoundErr := services.GetRoleById(body.RoleID); notFoundErr != nil {
+			return nil, notFoundErr
+		}
+
+		role = models.UserRole(body.RoleID)
+	}
+
+	if role != models.Standard && !services.HasPermission(inviter, models.RolesWritePermission) {
+		return nil, utils.NewError(utils.KindForbidden, "permission_denied", "permission denied. "+string(models.RolesWritePermission)+" is required to pre-assign a role")
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(defaultUserInvitationDuration)
+
+	if body.ExpiresAt != nil {
+		if body.ExpiresAt.Before(now) {
+			return nil, ErrInvalidExpiration
+		}
+
+		expiresAt = *body.ExpiresAt
+	}
+
+	invitation := &models.UserInvitation{
+		Email:          body.Email,
+		Role:           role,
+		InvitedByRefer: &inviter.ID,
+		ExpiresAt:      expiresAt,
+		SentAt:         now,
+	}
+
+	if sendErr := services.sendUserInvitation(invitation); sendErr != nil {
+		// an invitation that wasn't sent can't be accepted, so it's deleted for the email to be invited again
+		if invitation.ID != 0 {
+			services.userInvitationStorage.Delete(invitation)
+		}
+
+		return nil, sendErr
+	}
+
+	return invitation, nil
+}
+
+// Function that returns the invitations that have not been accepted yet
+func (services *Services) GetPendingUserInvitations() ([]*models.UserInvitation, error) {
+	return services.userInvitationStorage.GetPending()
+}
+
+// Function that sends an invitation again, with a new token. The previous one is no longer valid.
+// The invitation is valid again for as long as it was when it was last sent, counting from now.
+func (services *Services) ResendUserInvitation(id int) (*models.UserInvitation, error) {
+	invitation, notFoundErr := services.userInvitationStorage.Get(id)
+
+	if notFoundErr != nil {
+		return nil, notFound(notFoundErr, ErrInvitationNotFound)
+	}
+
+	if invitation.AcceptedAt != nil {
+		return nil, utils.NewError(utils.KindConflict, "invitation_already_accepted", "invitation already accepted")
+	}
+
+	now := time.Now()
+	invitation.ExpiresAt = now.Add(invitation.ExpiresAt.Sub(invitation.SentAt))
+	invitation.SentAt = now
+
+	return invitation, services.sendUserInvitation(invitation)
+}
+
+// Function that revokes an invitation, by deleting it
+func (services *Services) RevokeUserInvitation(id int) error {
+	invitation, notFoundErr := services.userInvitationStorage.Get(id)
+
+	if notFoundErr != nil {
+		return notFound(notFoundErr, ErrInvitationNotFound)
+	}
+
+	return services.userInvitationStorage.Delete(invitation)
+}
+
+// Function that creates the account of an invited user with the password it chose, starting a session for it.
+// Its email is verified, since the invitation was sent to it. Not allowed if the registration mode is closed.
+func (services *Services) AcceptUserInvitation(ctx context.Context, body AcceptUserInvitationBody, info SessionInfo) (accessToken *models.Token, refreshToken *models.Token, err error) {
+	if GetRegistrationMode() == ClosedRegistration {
+		err = ErrRegistrationNotOpen
+		return
+	}
+
+	invitation, notFoundErr := services.userInvitationStorage.GetValid(hashOpaqueToken(body.Token))
+
+	if notFoundErr != nil {
+		err = ErrInvalidInvitation
+		return
+	}
+
+	user := &models.User{
+		FirstName:     body.FirstName,
+		Email:         invitation.Email,
+		Role:          invitation.Role,
+		EmailVerified: true,
+	}
+
+	if encodeErr := user.EncodePassword(body.Password); encodeErr != nil {
+		err = encodeErr
+		return
+	}
+
+	// the user is created before the invitation is marked as accepted, so that a failure creating it
+	// leaves the invitation valid. Emails are unique, so the invitation can't create two users.
+	if createErr := services.userStorage.Create(ctx, user); createErr != nil {
+		err = emailConflictError(createErr)
+		return
+	}
+
+	if firstUse, markErr := services.userInvitationStorage.MarkAccepted(invitation); markErr != nil || !firstUse {
+		// the invitation couldn't be accepted, e.g. because it was revoked meanwhile, so its user isn't kept
+		services.userStorage.Delete(ctx, user)
+
+		if err = markErr; markErr == nil {
+			err = ErrInvalidInvitation
+		}
+
+		return
+	}
+
+	return services.startSession(ctx, *user, info)
+}
+
+// AUX FUNCTIONS
+
+// Function that issues a new token for the invitation, saves it and sends it by email. The caller sets
+// when it's sent and when it expires.
+func (services *Services) sendUserInvitation(invitation *models.UserInvitation) error {
+	token, tokenErr := generateOpaqueToken()
+
+	if tokenErr != nil {
+		return tokenErr
+	}
+
+	invitation.TokenHash = hashOpaqueToken(token)
+
+	if saveErr := services.userInvitationStorage.Update(invitation); saveErr != nil {
+		return saveErr
+	}
+
+	link := getUserInvitationURL() + "?token=" + url.QueryEscape(token)
+
+	return services.mailer.Send(mail.Message{
+		To:      invitation.Email,
+		Subject: "You've been invited to gocker-api",
+		Body: "Hi,\n\n" +
+			"You've been invited to create an account. Follow this link to choose your password, " +
+			"before " + invitation.ExpiresAt.Format(time.RFC1123) + ":\n\n" +
+			link + "\n\n" +
+			"If you weren't expecting it, just ignore this email.\n",
+	})
+}
+
+// Returns the URL the invitation link points to, set by USER_INVITATION_URL.
+// It's usually a frontend page that posts the token and the password to /api/v1/auth/invitations/accept.
+func getUserInvitationURL() string {
+	if invitationURL := os.Getenv("USER_INVITATION_URL"); invitationURL != "" {
+		return invitationURL
+	}
+
+	return "http://localhost:8080/accept-user-invitation"
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"gocker-api/auth"
+	"gocker-api/mail"
+	"gocker-api/models"
+	"gocker-api/utils"
+	"log"
+	"net/url"
+	"os"
+	"time"
+)
+
+type VerifyEmailBody struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerificationBody struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+const emailVerificationDuration = 24 * time.Hour
+
+// Function that marks the email of a user as verified, given the token sent to it
+func (services *Services) VerifyEmail(ctx context.Context, body VerifyEmailBody) error {
+	email, tokenErr := auth.ValidatePurposeToken(body.Token, auth.EmailVerificationPurpose)
+
+	if tokenErr != nil {
+		return utils.NewError(utils.KindInvalid, "invalid_verification_token", "verification token not valid")
+	}
+
+	// the token carries the email it was sent to, so it's no longer valid if the user changes it
+	user, notFoundErr := services.GetUserByEmail(ctx, email)
+
+	if notFoundErr != nil {
+		return utils.NewError(utils.KindInvalid, "invalid_verification_token", "verification token not valid")
+	}
+
+	if user.EmailVerified {
+		return nil
+	}
+
+	user.EmailVerified = true
+
+	return services.userStorage.UpdateColumns(ctx, user, "email_verified")
+}
+
+// Function that sends a new verification link to the given email, if it belongs to a user that hasn't verified it yet.
+// Like ForgotPassword, it never reports whether it does and works in the background, so that it can't be used
+// to find out which emails are registered.
+func (services *Services) ResendVerificationEmail(ctx context.Context, body ResendVerificationBody) {
+	//The work outlives the request, so it must not be cancelled when the response is sent
+	ctx = context.WithoutCancel(ctx)
+
+	go func() {
+		user, notFoundErr := services.GetUserByEmail(ctx, body.Email)
+
+		if notFoundErr != nil || user.EmailVerified {
+			return
+		}
+
+		if sendErr := services.sendVerificationEmail(*user); sendErr != nil {
+			log.Printf("Could not send verification email to user %d: %s\n", user.ID, sendErr)
+		}
+	}()
+}
+
+// Returns true if users must verify their email before authenticating, as set by REQUIRE_EMAIL_VERIFICATION
+func EmailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+// AUX FUNCTIONS
+
+// Function that sends the user an email with a signed link to verify its email address
+func (services *Services) sendVerificationEmail(user models.User) error {
+	token, tokenErr := auth.GeneratePurposeToken(user, auth.EmailVerificationPurpose, emailVerificationDuration)
+
+	if tokenErr != nil {
+		return tokenErr
+	}
+
+	link := getEmailVerificationURL() + "?token=" + url.QueryEscape(token)
+
+	return services.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: "Hi " + user.FirstName + ",\n\n" +
+			"Please verify your email address by following this link, which expires in 24 hours:\n\n" +
+			link + "\n",
+	})
+}
+
+// Returns the URL the verification link points to, set by EMAIL_VERIFICATION_URL.
+// It's usually a frontend page that posts the token to /api/v1/auth/verify-email.
+func getEmailVerificationURL() string {
+	if verificationURL := os.Getenv("EMAIL_VERIFICATION_URL"); verificationURL != "" {
+		return verificationURL
+	}
+
+	return "http://localhost:8080/verify-email"
+}
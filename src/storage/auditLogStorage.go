@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"gocker-api/models"
+
+	"gorm.io/gorm"
+)
+
+type AuditLogStorage struct {
+	db *gorm.DB
+}
+
+func NewAuditLogStorage(db *gorm.DB) *AuditLogStorage {
+	return &AuditLogStorage{db: db}
+}
+
+func (auditLogStorage *AuditLogStorage) Create(auditLog *models.AuditLog) error {
+	database := auditLogStorage.db
+
+	return translateError(database.Create(auditLog).Error)
+}
+
+// Returns the records of changes made to the given types of targets, the newest first
+func (auditLogStorage *AuditLogStorage) GetByTargetTypes(targetTypes []string) ([]*models.AuditLog, error) {
+	var auditLogs []*models.AuditLog
+	database := auditLogStorage.db
+
+	if result := database.Order("created_at DESC").Find(&auditLogs, "target_type IN ?", targetTypes); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return auditLogs, nil
+}
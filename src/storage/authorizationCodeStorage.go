@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"gocker-api/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuthorizationCodeStorage struct {
+	db *gorm.DB
+}
+
+func NewAuthorizationCodeStorage(db *gorm.DB) *AuthorizationCodeStorage {
+	return &AuthorizationCodeStorage{db: db}
+}
+
+func (authorizationCodeStorage *AuthorizationCodeStorage) Create(code *models.AuthorizationCode) error {
+	database := authorizationCodeStorage.db
+
+	return translateError(database.Create(code).Error)
+}
+
+// Returns the authorization code with the given hash, used or not, as long as it has not expired
+func (authorizationCodeStorage *AuthorizationCodeStorage) GetByHash(codeHash string) (*models.AuthorizationCode, error) {
+	var code *models.AuthorizationCode
+	database := authorizationCodeStorage.db
+	result := database.Find(&code, "code_hash = ? AND expires_at > ?", codeHash, time.Now())
+
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return code, nil
+}
+
+// Marks the code as used by the given token family, only if it had not been used before.
+// Returns false if it had.
+func (authorizationCodeStorage *AuthorizationCodeStorage) MarkUsed(code *models.AuthorizationCode, family string) (bool, error) {
+	now := time.Now()
+	database := authorizationCodeStorage.db
+	result := database.Model(&models.AuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", code.ID).
+		Updates(map[string]interface{}{"used_at": now, "family": family})
+
+	if result.Error != nil {
+		return false, translateError(result.Error)
+	}
+
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"gocker-api/models"
+
+	"gorm.io/gorm"
+)
+
+type MembershipStorage struct {
+	db *gorm.DB
+}
+
+func NewMembershipStorage(db *gorm.DB) *MembershipStorage {
+	return &MembershipStorage{db: db}
+}
+
+// Returns the membership of the user in the organization
+func (membershipStorage *MembershipStorage) Get(organizationId uint, userId uint) (*models.Membership, error) {
+	var membership *models.Membership
+	database := membershipStorage.db
+
+	if result := database.Find(&membership, "organization_refer = ? AND user_refer = ?", organizationId, userId); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return membership, nil
+}
+
+// Returns every membership of the organization, the oldest first
+func (membershipStorage *MembershipStorage) GetByOrganization(organizationId uint) ([]*models.Membership, error) {
+	var memberships []*models.Membership
+	database := membershipStorage.db
+	result := database.Order("created_at").Find(&memberships, "organization_refer = ?", organizationId)
+
+	return memberships, result.Error
+}
+
+// Returns the membership of the user that was created first, if it has any
+func (membershipStorage *MembershipStorage) GetFirstByUser(userId uint) (*models.Membership, error) {
+	var membership *models.Membership
+	database := membershipStorage.db
+
+	if result := database.Order("created_at").Limit(1).Find(&membership, "user_refer = ?", userId); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return membership, nil
+}
+
+// Returns how many members of the organization have the given role
+func (membershipStorage *MembershipStorage) CountByRole(organizationId uint, role models.MembershipRole) (int64, error) {
+	var count int64
+	database := membershipStorage.db
+	result := database.Model(&models.Membership{}).Where("organization_refer = ? AND role = ?", organizationId, role).Count(&count)
+
+	return count, result.Error
+}
+
+// Returns how many organizations the user is a member of
+func (membershipStorage *MembershipStorage) CountByUser(userId uint) (int64, error) {
+	var count int64
+	database := membershipStorage.db
+	result := database.Model(&models.Membership{}).Where("user_refer = ?", userId).Count(&count)
+
+	return count, result.Error
+}
+
+func (membershipStorage *MembershipStorage) Create(membership *models.Membership) error {
+	database := membershipStorage.db
+
+	return translateError(database.Create(membership).Error)
+}
+
+func (membershipStorage *MembershipStorage) Update(membership *models.Membership) error {
+	database := membershipStorage.db
+
+	return translateError(database.Save(membership).Error)
+}
+
+func (membershipStorage *MembershipStorage) Delete(membership *models.Membership) error {
+	database := membershipStorage.db
+
+	return translateError(database.Delete(membership).Error)
+}
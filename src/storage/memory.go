@@ -0,0 +1,724 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"gocker-api/models"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/schema"
+)
+
+// Data of the memory storages. Every table is guarded by the same mutex, so that a delete and
+// its cascades happen at once, like they would in a database.
+type memoryDatabase struct {
+	mutex                   sync.RWMutex
+	users                   *memoryTable[models.User]
+	tokens                  *memoryTable[models.Token]
+	sessions                *memoryTable[models.Session]
+	recoveryCodes           *memoryTable[models.RecoveryCode]
+	passwordResets          *memoryTable[models.PasswordReset]
+	mfaChallenges           *memoryTable[models.MFAChallenge]
+	oauthClients            *memoryTable[models.OAuthClient]
+	authorizationCodes      *memoryTable[models.AuthorizationCode]
+	personalAccessTokens    *memoryTable[models.PersonalAccessToken]
+	roles                   *memoryTable[models.Role]
+	auditLogs               *memoryTable[models.AuditLog]
+	organizations           *memoryTable[models.Organization]
+	memberships             *memoryTable[models.Membership]
+	organizationInvitations *memoryTable[models.OrganizationInvitation]
+	userInvitations         *memoryTable[models.UserInvitation]
+}
+
+// Returns storages that keep everything in memory, seeded with the built-in roles like the
+// migrations seed a database. Nothing outlives the process, so they're meant for tests and demos.
+func NewMemoryRepositories() *Repositories {
+	database := &memoryDatabase{
+		users:          newMemoryTable(func(user *models.User) []string { return []string{user.Email} }),
+		tokens:         newMemoryTable[models.Token](nil),
+		sessions:       newMemoryTable(func(session *models.Session) []string { return []string{session.Family} }),
+		recoveryCodes:  newMemoryTable[models.RecoveryCode](nil),
+		passwordResets: newMemoryTable(func(reset *models.PasswordReset) []string { return []string{reset.TokenHash} }),
+		mfaChallenges: newMemoryTable(func(challenge *models.MFAChallenge) []string {
+			return []string{challenge.TokenHash}
+		}),
+		oauthClients: newMemoryTable(func(client *models.OAuthClient) []string { return []string{client.ClientID} }),
+		authorizationCodes: newMemoryTable(func(code *models.AuthorizationCode) []string {
+			return []string{code.CodeHash}
+		}),
+		personalAccessTokens: newMemoryTable(func(token *models.PersonalAccessToken) []string {
+			return []string{token.TokenHash}
+		}),
+		roles:         newMemoryTable(func(role *models.Role) []string { return []string{role.Name} }),
+		auditLogs:     newMemoryTable[models.AuditLog](nil),
+		organizations: newMemoryTable[models.Organization](nil),
+		memberships: newMemoryTable(func(membership *models.Membership) []string {
+			return []string{fmt.Sprintf("%d/%d", membership.OrganizationRefer, membership.UserRefer)}
+		}),
+		organizationInvitations: newMemoryTable(func(invitation *models.OrganizationInvitation) []string {
+			return []string{invitation.TokenHash}
+		}),
+		userInvitations: newMemoryTable(func(invitation *models.UserInvitation) []string {
+			return []string{invitation.TokenHash}
+		}),
+	}
+
+	for _, role := range models.BuiltInRoles() {
+		role := role
+		database.roles.insert(&role)
+	}
+
+	return &Repositories{
+		Users:                   &memoryUserStorage{memoryRepository: newMemoryRepository(database, database.users, nil, database.deleteUser)},
+		Tokens:                  &memoryTokenStorage{memoryRepository: newMemoryRepository(database, database.tokens, nil, database.deleteToken)},
+		Sessions:                &memorySessionStorage{database: database},
+		RecoveryCodes:           &memoryRecoveryCodeStorage{database: database},
+		PasswordResets:          &memoryPasswordResetStorage{database: database},
+		MFAChallenges:           &memoryMFAChallengeStorage{database: database},
+		OAuthClients:            &memoryOAuthClientStorage{database: database},
+		AuthorizationCodes:      &memoryAuthorizationCodeStorage{database: database},
+		PersonalAccessTokens:    &memoryPersonalAccessTokenStorage{database: database},
+		Roles:                   &memoryRoleStorage{database: database},
+		AuditLogs:               &memoryAuditLogStorage{database: database},
+		Organizations:           &memoryOrganizationStorage{database: database},
+		Memberships:             &memoryMembershipStorage{database: database},
+		OrganizationInvitations: &memoryOrganizationInvitationStorage{database: database},
+		UserInvitations:         &memoryUserInvitationStorage{database: database},
+	}
+}
+
+// Deletes a user along with its tokens, sessions, recovery codes, memberships and authorization codes,
+// like the foreign keys of the schema do
+func (database *memoryDatabase) deleteUser(id uint) bool {
+	if !database.users.delete(id) {
+		return false
+	}
+
+	for _, token := range database.tokens.where(func(token *models.Token) bool { return equalRefer(token.UserRefer, id) }) {
+		database.deleteToken(token.ID)
+	}
+
+	database.sessions.deleteWhere(func(session *models.Session) bool { return session.UserRefer == id })
+	database.recoveryCodes.deleteWhere(func(code *models.RecoveryCode) bool { return code.UserRefer == id })
+	database.mfaChallenges.deleteWhere(func(challenge *models.MFAChallenge) bool { return challenge.UserRefer == id })
+	database.memberships.deleteWhere(func(membership *models.Membership) bool { return membership.UserRefer == id })
+	database.authorizationCodes.deleteWhere(func(code *models.AuthorizationCode) bool { return code.UserRefer == id })
+
+	return true
+}
+
+// Deletes a token, leaving the tokens that were issued to replace it without a parent
+func (database *memoryDatabase) deleteToken(id uint) bool {
+	if !database.tokens.delete(id) {
+		return false
+	}
+
+	database.tokens.each(func(token *models.Token) {
+		if equalRefer(token.ParentRefer, id) {
+			token.ParentRefer = nil
+		}
+	})
+
+	return true
+}
+
+// Deletes an OAuth client along with its tokens and authorization codes
+func (database *memoryDatabase) deleteOAuthClient(id uint) bool {
+	if !database.oauthClients.delete(id) {
+		return false
+	}
+
+	for _, token := range database.tokens.where(func(token *models.Token) bool { return equalRefer(token.ClientRefer, id) }) {
+		database.deleteToken(token.ID)
+	}
+
+	database.authorizationCodes.deleteWhere(func(code *models.AuthorizationCode) bool { return code.ClientRefer == id })
+
+	return true
+}
+
+// Deletes an organization along with its memberships and invitations, leaving the users
+// that had it active without an active organization
+func (database *memoryDatabase) deleteOrganization(id uint) bool {
+	if !database.organizations.delete(id) {
+		return false
+	}
+
+	database.memberships.deleteWhere(func(membership *models.Membership) bool { return membership.OrganizationRefer == id })
+	database.organizationInvitations.deleteWhere(func(invitation *models.OrganizationInvitation) bool {
+		return invitation.OrganizationRefer == id
+	})
+	database.users.each(func(user *models.User) {
+		if equalRefer(user.ActiveOrganizationRefer, id) {
+			user.ActiveOrganizationRefer = nil
+		}
+	})
+
+	return true
+}
+
+// Records of one type, by id. The table keeps its own copies, so that changing a record
+// returned by it doesn't change the stored one until it's saved. Callers must hold the mutex of the database.
+type memoryTable[T any] struct {
+	rows   map[uint]*T
+	lastId uint
+	// Returns the values of the unique columns of a record, which no other record can share.
+	// Empty values are ignored, like NULLs are by unique indexes.
+	uniqueValues func(item *T) []string
+}
+
+func newMemoryTable[T any](uniqueValues func(item *T) []string) *memoryTable[T] {
+	return &memoryTable[T]{rows: map[uint]*T{}, uniqueValues: uniqueValues}
+}
+
+// Returns a copy of the record with the given id
+func (table *memoryTable[T]) get(id uint) (*T, bool) {
+	row, ok := table.rows[id]
+
+	if !ok {
+		return nil, false
+	}
+
+	item := *row
+
+	return &item, true
+}
+
+// Returns copies of the records that match, ordered by id. Every record matches if match is nil.
+func (table *memoryTable[T]) where(match func(item *T) bool) []*T {
+	items := []*T{}
+
+	for _, row := range table.rows {
+		if match == nil || match(row) {
+			item := *row
+			items = append(items, &item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool { return recordId(items[i]) < recordId(items[j]) })
+
+	return items
+}
+
+// Returns the first record that matches, by id
+func (table *memoryTable[T]) first(match func(item *T) bool) (*T, bool) {
+	items := table.where(match)
+
+	if len(items) == 0 {
+		return nil, false
+	}
+
+	return items[0], true
+}
+
+// Calls the function with every stored record, so that it can change them in place
+func (table *memoryTable[T]) each(function func(row *T)) {
+	for _, row := range table.rows {
+		function(row)
+	}
+}
+
+// Stores a new record, assigning it the next id if it has none, and sets its timestamps
+func (table *memoryTable[T]) insert(item *T) error {
+	id := recordId(item)
+
+	if id == 0 {
+		id = table.lastId + 1
+	} else if _, exists := table.rows[id]; exists {
+		return ErrConflict
+	}
+
+	if table.conflicts(item, id) {
+		return ErrConflict
+	}
+
+	setRecordId(item, id)
+	setTimestamps(item, time.Now())
+
+	if id > table.lastId {
+		table.lastId = id
+	}
+
+	row := *item
+	table.rows[id] = &row
+
+	return nil
+}
+
+// Replaces an existing record, or returns ErrNotFound
+func (table *memoryTable[T]) update(item *T) error {
+	id := recordId(item)
+
+	if _, exists := table.rows[id]; !exists {
+		return ErrNotFound
+	}
+
+	if table.conflicts(item, id) {
+		return ErrConflict
+	}
+
+	setTimestamps(item, time.Now())
+
+	row := *item
+	table.rows[id] = &row
+
+	return nil
+}
+
+// Replaces the record if it exists and inserts it otherwise, like the Save of gorm
+func (table *memoryTable[T]) save(item *T) error {
+	if _, exists := table.rows[recordId(item)]; exists {
+		return table.update(item)
+	}
+
+	return table.insert(item)
+}
+
+// Deletes the record with the given id. Returns false if there was none.
+func (table *memoryTable[T]) delete(id uint) bool {
+	if _, exists := table.rows[id]; !exists {
+		return false
+	}
+
+	delete(table.rows, id)
+
+	return true
+}
+
+// Deletes every record that matches
+func (table *memoryTable[T]) deleteWhere(match func(item *T) bool) {
+	for id, row := range table.rows {
+		if match(row) {
+			delete(table.rows, id)
+		}
+	}
+}
+
+// Returns true if a record other than the one with the given id has any of the unique values of the item
+func (table *memoryTable[T]) conflicts(item *T, id uint) bool {
+	if table.uniqueValues == nil {
+		return false
+	}
+
+	for _, value := range table.uniqueValues(item) {
+		if value == "" {
+			continue
+		}
+
+		for rowId, row := range table.rows {
+			if rowId == id {
+				continue
+			}
+
+			for _, rowValue := range table.uniqueValues(row) {
+				if rowValue == value {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// Repository of records kept in a memory table. If scope is set, it only sees the records the scope allows.
+type memoryRepository[T any] struct {
+	database *memoryDatabase
+	table    *memoryTable[T]
+	// Used to find the fields of the columns filters and orders refer to
+	schema *schema.Schema
+	scope  func(item *T) bool
+	// Deletes the record with the given id along with what depends on it, returning false if there was none
+	delete func(id uint) bool
+}
+
+var memorySchemas sync.Map
+
+func newMemoryRepository[T any](database *memoryDatabase, table *memoryTable[T], scope func(item *T) bool, delete func(id uint) bool) *memoryRepository[T] {
+	itemSchema, parseErr := schema.Parse(new(T), &memorySchemas, schema.NamingStrategy{})
+
+	// models are known at compile time, so they can only fail to parse because of a programming error
+	if parseErr != nil {
+		panic(parseErr)
+	}
+
+	return &memoryRepository[T]{database: database, table: table, schema: itemSchema, scope: scope, delete: delete}
+}
+
+func (repository *memoryRepository[T]) Get(ctx context.Context, id uint) (*T, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	repository.database.mutex.RLock()
+	defer repository.database.mutex.RUnlock()
+
+	item, ok := repository.table.get(id)
+
+	if !ok || !repository.visible(item) {
+		return nil, ErrNotFound
+	}
+
+	return item, nil
+}
+
+func (repository *memoryRepository[T]) FindBy(ctx context.Context, filter Filter) (*T, error) {
+	items, listErr := repository.List(ctx, ListOptions{Filter: filter, Limit: 1})
+
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return items[0], nil
+}
+
+func (repository *memoryRepository[T]) List(ctx context.Context, options ListOptions) ([]*T, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	match, filterErr := repository.matcher(options.Filter, options.Conditions)
+
+	if filterErr != nil {
+		return nil, filterErr
+	}
+
+	columns, orderErr := repository.orderColumns(options.Order)
+
+	if orderErr != nil {
+		return nil, orderErr
+	}
+
+	if options.After != nil {
+		if len(columns) == 0 || len(columns) != len(options.After) {
+			return nil, errors.New("the values to list after must match the order columns")
+		}
+
+		filterMatch := match
+		match = func(item *T) bool {
+			return filterMatch(item) && compareToValues(reflect.ValueOf(item).Elem(), columns, options.After) > 0
+		}
+	}
+
+	repository.database.mutex.RLock()
+	items := repository.table.where(match)
+	repository.database.mutex.RUnlock()
+
+	// records that are equal by every column keep their order, which is by id
+	sort.SliceStable(items, func(i, j int) bool {
+		return compareRecords(reflect.ValueOf(items[i]).Elem(), reflect.ValueOf(items[j]).Elem(), columns) < 0
+	})
+
+	if options.Offset > 0 {
+		items = items[min(options.Offset, len(items)):]
+	}
+
+	if options.Limit > 0 {
+		items = items[:min(options.Limit, len(items))]
+	}
+
+	return items, nil
+}
+
+func (repository *memoryRepository[T]) Count(ctx context.Context, filter Filter, conditions ...Condition) (int64, error) {
+	items, listErr := repository.List(ctx, ListOptions{Filter: filter, Conditions: conditions})
+
+	return int64(len(items)), listErr
+}
+
+func (repository *memoryRepository[T]) Create(ctx context.Context, item *T) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	repository.database.mutex.Lock()
+	defer repository.database.mutex.Unlock()
+
+	return repository.table.insert(item)
+}
+
+func (repository *memoryRepository[T]) Update(ctx context.Context, item *T) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	repository.database.mutex.Lock()
+	defer repository.database.mutex.Unlock()
+
+	if stored, ok := repository.table.get(recordId(item)); !ok || !repository.visible(stored) {
+		return ErrNotFound
+	}
+
+	return repository.table.update(item)
+}
+
+func (repository *memoryRepository[T]) Delete(ctx context.Context, item *T) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	repository.database.mutex.Lock()
+	defer repository.database.mutex.Unlock()
+
+	if stored, ok := repository.table.get(recordId(item)); !ok || !repository.visible(stored) {
+		return ErrNotFound
+	}
+
+	repository.delete(recordId(item))
+
+	return nil
+}
+
+// AUX FUNCTIONS
+
+// Returns true if the scope of the repository allows the record, which is always the case without a scope
+func (repository *memoryRepository[T]) visible(item *T) bool {
+	return repository.scope == nil || repository.scope(item)
+}
+
+// Returns a function that matches the visible records whose columns are equal to the values of the filter
+// and satisfy every condition
+func (repository *memoryRepository[T]) matcher(filter Filter, conditions []Condition) (func(item *T) bool, error) {
+	for column, value := range filter {
+		conditions = append(conditions, Condition{Column: column, Operator: "=", Value: value})
+	}
+
+	fields := make([]*schema.Field, len(conditions))
+
+	for i, condition := range conditions {
+		fields[i] = repository.schema.LookUpField(condition.Column)
+
+		if fields[i] == nil {
+			return nil, fmt.Errorf("unknown column %s", condition.Column)
+		}
+
+		if !conditionOperators[condition.Operator] {
+			return nil, fmt.Errorf("invalid condition %s %s", condition.Column, condition.Operator)
+		}
+	}
+
+	return func(item *T) bool {
+		if !repository.visible(item) {
+			return false
+		}
+
+		row := reflect.ValueOf(item).Elem()
+
+		for i, condition := range conditions {
+			columnValue := comparableValue(row.FieldByIndex(fields[i].StructField.Index))
+			value := comparableValue(reflect.ValueOf(condition.Value))
+
+			if !satisfies(columnValue, condition.Operator, value) {
+				return false
+			}
+		}
+
+		return true
+	}, nil
+}
+
+type memoryOrderColumn struct {
+	field      *schema.Field
+	descending bool
+}
+
+// Returns the fields of the columns of an order clause like "created_at DESC, id"
+func (repository *memoryRepository[T]) orderColumns(order string) ([]memoryOrderColumn, error) {
+	orderColumns, parseErr := ParseOrder(order)
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	columns := make([]memoryOrderColumn, 0, len(orderColumns))
+
+	for _, orderColumn := range orderColumns {
+		field := repository.schema.LookUpField(orderColumn.Column)
+
+		if field == nil {
+			return nil, fmt.Errorf("unknown column %s", orderColumn.Column)
+		}
+
+		columns = append(columns, memoryOrderColumn{field: field, descending: orderColumn.Descending})
+	}
+
+	return columns, nil
+}
+
+// Returns -1, 0 or 1 depending on whether the first record goes before, with or after the second one in the order
+func compareRecords(first reflect.Value, second reflect.Value, columns []memoryOrderColumn) int {
+	values := make([]interface{}, len(columns))
+
+	for i, column := range columns {
+		values[i] = second.FieldByIndex(column.field.StructField.Index).Interface()
+	}
+
+	return compareToValues(first, columns, values)
+}
+
+// Returns -1, 0 or 1 depending on whether the record goes before, with or after one with the given values
+// of the order columns
+func compareToValues(record reflect.Value, columns []memoryOrderColumn, values []interface{}) int {
+	for i, column := range columns {
+		comparison := compareValues(
+			comparableValue(record.FieldByIndex(column.field.StructField.Index)),
+			comparableValue(reflect.ValueOf(values[i])),
+		)
+
+		if comparison != 0 {
+			if column.descending {
+				return -comparison
+			}
+
+			return comparison
+		}
+	}
+
+	return 0
+}
+
+// Returns true if the comparison holds. Like in SQL, nothing holds against a NULL, except that
+// equality with nil matches NULLs, like filters do.
+func satisfies(columnValue interface{}, operator string, value interface{}) bool {
+	if columnValue == nil || value == nil {
+		return operator == "=" && columnValue == value
+	}
+
+	comparison := compareValues(columnValue, value)
+
+	switch operator {
+	case "=":
+		return comparison == 0
+	case "<>":
+		return comparison != 0
+	case "<":
+		return comparison < 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	default:
+		return comparison >= 0
+	}
+}
+
+// Returns the value a column holds in a form that can be compared with ==, so that e.g. a uint
+// filter matches a *uint field and a UserRole one matches an int. Nil pointers become nil, like NULLs.
+func comparableValue(value reflect.Value) interface{} {
+	for value.IsValid() && (value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface) {
+		if value.IsNil() {
+			return nil
+		}
+
+		value = value.Elem()
+	}
+
+	if !value.IsValid() {
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint())
+	case reflect.String:
+		return value.String()
+	case reflect.Bool:
+		return value.Bool()
+	}
+
+	if timeValue, ok := value.Interface().(time.Time); ok {
+		return timeValue.UnixNano()
+	}
+
+	return fmt.Sprint(value.Interface())
+}
+
+// Returns -1, 0 or 1 depending on whether the first value goes before, with or after the second one.
+// Nil goes before anything else, and values of different types are compared as text.
+func compareValues(first interface{}, second interface{}) int {
+	switch {
+	case first == nil && second == nil:
+		return 0
+	case first == nil:
+		return -1
+	case second == nil:
+		return 1
+	}
+
+	switch firstValue := first.(type) {
+	case int64:
+		if secondValue, ok := second.(int64); ok {
+			return cmp.Compare(firstValue, secondValue)
+		}
+	case string:
+		if secondValue, ok := second.(string); ok {
+			return strings.Compare(firstValue, secondValue)
+		}
+	case bool:
+		if secondValue, ok := second.(bool); ok {
+			return cmp.Compare(boolRank(firstValue), boolRank(secondValue))
+		}
+	}
+
+	return strings.Compare(fmt.Sprint(first), fmt.Sprint(second))
+}
+
+func boolRank(value bool) int {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+// Returns the id of a record, which every model has in its ID field
+func recordId(item interface{}) uint {
+	field := reflect.ValueOf(item).Elem().FieldByName("ID")
+
+	if field.CanUint() {
+		return uint(field.Uint())
+	}
+
+	return uint(field.Int())
+}
+
+func setRecordId(item interface{}, id uint) {
+	field := reflect.ValueOf(item).Elem().FieldByName("ID")
+
+	if field.CanUint() {
+		field.SetUint(uint64(id))
+	} else {
+		field.SetInt(int64(id))
+	}
+}
+
+// Sets the CreatedAt field of a record if it has one and it's not set yet, and its UpdatedAt field if it has one,
+// like gorm does
+func setTimestamps(item interface{}, now time.Time) {
+	record := reflect.ValueOf(item).Elem()
+
+	if createdAt := record.FieldByName("CreatedAt"); createdAt.IsValid() && createdAt.Interface().(time.Time).IsZero() {
+		createdAt.Set(reflect.ValueOf(now))
+	}
+
+	if updatedAt := record.FieldByName("UpdatedAt"); updatedAt.IsValid() {
+		updatedAt.Set(reflect.ValueOf(now))
+	}
+}
+
+// Returns true if the optional reference points to the given id
+func equalRefer(refer *uint, id uint) bool {
+	return refer != nil && *refer == id
+}
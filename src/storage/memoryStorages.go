@@ -0,0 +1,1197 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gocker-api/models"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+type memoryUserStorage struct {
+	*memoryRepository[models.User]
+}
+
+var _ UserRepository = (*memoryUserStorage)(nil)
+
+func (userStorage *memoryUserStorage) ForOrganization(organizationId *uint) UserRepository {
+	database := userStorage.database
+
+	if organizationId == nil {
+		return &memoryUserStorage{memoryRepository: newMemoryRepository(database, database.users, func(*models.User) bool { return false }, database.deleteUser)}
+	}
+
+	scope := func(user *models.User) bool {
+		_, isMember := database.memberships.first(func(membership *models.Membership) bool {
+			return membership.OrganizationRefer == *organizationId && membership.UserRefer == user.ID
+		})
+
+		return isMember
+	}
+
+	return &memoryUserStorage{memoryRepository: newMemoryRepository(database, database.users, scope, database.deleteUser)}
+}
+
+func (userStorage *memoryUserStorage) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return userStorage.FindBy(ctx, Filter{"email": email})
+}
+
+func (userStorage *memoryUserStorage) Update(ctx context.Context, user *models.User) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	userStorage.database.mutex.Lock()
+	defer userStorage.database.mutex.Unlock()
+
+	if staleErr := userStorage.checkVersion(user); staleErr != nil {
+		return staleErr
+	}
+
+	updated := *user
+	updated.Version++
+
+	if updateErr := userStorage.table.update(&updated); updateErr != nil {
+		return updateErr
+	}
+
+	user.Version = updated.Version
+
+	return nil
+}
+
+func (userStorage *memoryUserStorage) Delete(ctx context.Context, user *models.User) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	userStorage.database.mutex.Lock()
+	defer userStorage.database.mutex.Unlock()
+
+	if staleErr := userStorage.checkVersion(user); staleErr != nil {
+		return staleErr
+	}
+
+	userStorage.delete(user.ID)
+
+	return nil
+}
+
+func (userStorage *memoryUserStorage) UpdateColumns(ctx context.Context, user *models.User, columns ...string) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	userStorage.database.mutex.Lock()
+	defer userStorage.database.mutex.Unlock()
+
+	row, exists := userStorage.table.rows[user.ID]
+
+	if !exists || !userStorage.visible(row) {
+		return ErrNotFound
+	}
+
+	updated := *row
+	source, target := reflect.ValueOf(user).Elem(), reflect.ValueOf(&updated).Elem()
+
+	for _, column := range columns {
+		field := userStorage.schema.LookUpField(column)
+
+		if field == nil {
+			return fmt.Errorf("unknown column %s", column)
+		}
+
+		target.FieldByIndex(field.StructField.Index).Set(source.FieldByIndex(field.StructField.Index))
+	}
+
+	updated.Version++
+
+	if updateErr := userStorage.table.update(&updated); updateErr != nil {
+		return updateErr
+	}
+
+	user.Version = updated.Version
+
+	return nil
+}
+
+// Returns ErrNotFound if the user isn't stored (or the scope doesn't see it), and ErrStaleVersion if it's stored
+// at another version. The database must be locked.
+func (userStorage *memoryUserStorage) checkVersion(user *models.User) error {
+	stored, exists := userStorage.table.get(user.ID)
+
+	if !exists || !userStorage.visible(stored) {
+		return ErrNotFound
+	}
+
+	if stored.Version != user.Version {
+		return ErrStaleVersion
+	}
+
+	return nil
+}
+
+func (userStorage *memoryUserStorage) UpdateTOTPCounter(ctx context.Context, user *models.User, counter int64) (bool, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return false, ctxErr
+	}
+
+	userStorage.database.mutex.Lock()
+	defer userStorage.database.mutex.Unlock()
+
+	row, exists := userStorage.table.rows[user.ID]
+
+	if !exists || !userStorage.visible(row) || row.TOTPLastCounter >= counter {
+		return false, nil
+	}
+
+	row.TOTPLastCounter = counter
+	user.TOTPLastCounter = counter
+
+	return true, nil
+}
+
+func (userStorage *memoryUserStorage) CountByRole(ctx context.Context, role models.UserRole) (int64, error) {
+	return userStorage.Count(ctx, Filter{"role": role})
+}
+
+type memoryTokenStorage struct {
+	*memoryRepository[models.Token]
+}
+
+var _ TokenRepository = (*memoryTokenStorage)(nil)
+
+func (tokenStorage *memoryTokenStorage) GetByValue(ctx context.Context, value string) (*models.Token, error) {
+	return tokenStorage.FindBy(ctx, Filter{"token_value": value})
+}
+
+func (tokenStorage *memoryTokenStorage) GetByFamily(ctx context.Context, family string) ([]*models.Token, error) {
+	return tokenStorage.List(ctx, ListOptions{Filter: Filter{"family": family}})
+}
+
+func (tokenStorage *memoryTokenStorage) GetByUser(ctx context.Context, userId uint) ([]*models.Token, error) {
+	return tokenStorage.List(ctx, ListOptions{Filter: Filter{"user_refer": userId}})
+}
+
+func (tokenStorage *memoryTokenStorage) DeleteByFamily(ctx context.Context, family string) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	tokenStorage.database.mutex.Lock()
+	defer tokenStorage.database.mutex.Unlock()
+
+	for _, token := range tokenStorage.table.where(func(token *models.Token) bool { return token.Family == family }) {
+		tokenStorage.database.deleteToken(token.ID)
+	}
+
+	return nil
+}
+
+func (tokenStorage *memoryTokenStorage) MarkUsed(ctx context.Context, token *models.Token) (bool, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return false, ctxErr
+	}
+
+	tokenStorage.database.mutex.Lock()
+	defer tokenStorage.database.mutex.Unlock()
+
+	row, exists := tokenStorage.table.rows[token.ID]
+
+	if !exists || row.UsedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	row.UsedAt = &now
+	token.UsedAt = &now
+
+	return true, nil
+}
+
+func (tokenStorage *memoryTokenStorage) AdoptFamily(ctx context.Context, token *models.Token, family string) (bool, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return false, ctxErr
+	}
+
+	tokenStorage.database.mutex.Lock()
+	defer tokenStorage.database.mutex.Unlock()
+
+	row, exists := tokenStorage.table.rows[token.ID]
+
+	if !exists || row.Family != "" || row.UsedAt != nil {
+		return false, nil
+	}
+
+	row.Family = family
+	token.Family = family
+
+	return true, nil
+}
+
+type memorySessionStorage struct {
+	database *memoryDatabase
+}
+
+var _ SessionRepository = (*memorySessionStorage)(nil)
+
+func (sessionStorage *memorySessionStorage) Get(id int) (interface{}, error) {
+	sessionStorage.database.mutex.RLock()
+	defer sessionStorage.database.mutex.RUnlock()
+
+	session, ok := sessionStorage.database.sessions.get(uint(id))
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return session, nil
+}
+
+func (sessionStorage *memorySessionStorage) Create(item interface{}) error {
+	session, ok := item.(*models.Session)
+
+	if !ok {
+		return errors.New(sessionTypeMismatchErr)
+	}
+
+	sessionStorage.database.mutex.Lock()
+	defer sessionStorage.database.mutex.Unlock()
+
+	return sessionStorage.database.sessions.insert(session)
+}
+
+func (sessionStorage *memorySessionStorage) Update(item interface{}) error {
+	session, ok := item.(*models.Session)
+
+	if !ok {
+		return errors.New(sessionTypeMismatchErr)
+	}
+
+	sessionStorage.database.mutex.Lock()
+	defer sessionStorage.database.mutex.Unlock()
+
+	return sessionStorage.database.sessions.save(session)
+}
+
+func (sessionStorage *memorySessionStorage) Delete(item interface{}) error {
+	session, ok := item.(*models.Session)
+
+	if !ok {
+		return errors.New(sessionTypeMismatchErr)
+	}
+
+	sessionStorage.database.mutex.Lock()
+	defer sessionStorage.database.mutex.Unlock()
+
+	sessionStorage.database.sessions.delete(session.ID)
+
+	return nil
+}
+
+func (sessionStorage *memorySessionStorage) GetByFamily(family string) (*models.Session, error) {
+	sessionStorage.database.mutex.RLock()
+	defer sessionStorage.database.mutex.RUnlock()
+
+	session, ok := sessionStorage.database.sessions.first(func(session *models.Session) bool { return session.Family == family })
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return session, nil
+}
+
+func (sessionStorage *memorySessionStorage) GetByUser(userId uint) ([]*models.Session, error) {
+	sessionStorage.database.mutex.RLock()
+	defer sessionStorage.database.mutex.RUnlock()
+
+	sessions := sessionStorage.database.sessions.where(func(session *models.Session) bool { return session.UserRefer == userId })
+	sortNewestFirst(sessions, func(session *models.Session) time.Time { return session.LastUsedAt })
+
+	return sessions, nil
+}
+
+func (sessionStorage *memorySessionStorage) Touch(family string, threshold time.Duration) error {
+	now := time.Now()
+
+	sessionStorage.database.mutex.Lock()
+	defer sessionStorage.database.mutex.Unlock()
+
+	sessionStorage.database.sessions.each(func(session *models.Session) {
+		if session.Family == family && session.LastUsedAt.Before(now.Add(-threshold)) {
+			session.LastUsedAt = now
+		}
+	})
+
+	return nil
+}
+
+type memoryRecoveryCodeStorage struct {
+	database *memoryDatabase
+}
+
+var _ RecoveryCodeRepository = (*memoryRecoveryCodeStorage)(nil)
+
+func (recoveryCodeStorage *memoryRecoveryCodeStorage) Create(code *models.RecoveryCode) error {
+	recoveryCodeStorage.database.mutex.Lock()
+	defer recoveryCodeStorage.database.mutex.Unlock()
+
+	return recoveryCodeStorage.database.recoveryCodes.insert(code)
+}
+
+func (recoveryCodeStorage *memoryRecoveryCodeStorage) GetUnused(userId uint, codeHash string) (*models.RecoveryCode, error) {
+	recoveryCodeStorage.database.mutex.RLock()
+	defer recoveryCodeStorage.database.mutex.RUnlock()
+
+	code, ok := recoveryCodeStorage.database.recoveryCodes.first(func(code *models.RecoveryCode) bool {
+		return code.UserRefer == userId && code.CodeHash == codeHash && code.UsedAt == nil
+	})
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return code, nil
+}
+
+func (recoveryCodeStorage *memoryRecoveryCodeStorage) MarkUsed(code *models.RecoveryCode) (bool, error) {
+	recoveryCodeStorage.database.mutex.Lock()
+	defer recoveryCodeStorage.database.mutex.Unlock()
+
+	row, exists := recoveryCodeStorage.database.recoveryCodes.rows[code.ID]
+
+	if !exists || row.UsedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	row.UsedAt = &now
+
+	return true, nil
+}
+
+func (recoveryCodeStorage *memoryRecoveryCodeStorage) DeleteByUser(userId uint) error {
+	recoveryCodeStorage.database.mutex.Lock()
+	defer recoveryCodeStorage.database.mutex.Unlock()
+
+	recoveryCodeStorage.database.recoveryCodes.deleteWhere(func(code *models.RecoveryCode) bool { return code.UserRefer == userId })
+
+	return nil
+}
+
+type memoryPasswordResetStorage struct {
+	database *memoryDatabase
+}
+
+var _ PasswordResetRepository = (*memoryPasswordResetStorage)(nil)
+
+func (passwordResetStorage *memoryPasswordResetStorage) Create(passwordReset *models.PasswordReset) error {
+	passwordResetStorage.database.mutex.Lock()
+	defer passwordResetStorage.database.mutex.Unlock()
+
+	return passwordResetStorage.database.passwordResets.insert(passwordReset)
+}
+
+func (passwordResetStorage *memoryPasswordResetStorage) GetValid(tokenHash string) (*models.PasswordReset, error) {
+	now := time.Now()
+
+	passwordResetStorage.database.mutex.RLock()
+	defer passwordResetStorage.database.mutex.RUnlock()
+
+	passwordReset, ok := passwordResetStorage.database.passwordResets.first(func(passwordReset *models.PasswordReset) bool {
+		return passwordReset.TokenHash == tokenHash && passwordReset.UsedAt == nil && passwordReset.ExpiresAt.After(now)
+	})
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return passwordReset, nil
+}
+
+func (passwordResetStorage *memoryPasswordResetStorage) MarkUsed(passwordReset *models.PasswordReset) (bool, error) {
+	passwordResetStorage.database.mutex.Lock()
+	defer passwordResetStorage.database.mutex.Unlock()
+
+	row, exists := passwordResetStorage.database.passwordResets.rows[passwordReset.ID]
+
+	if !exists || row.UsedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	row.UsedAt = &now
+
+	return true, nil
+}
+
+func (passwordResetStorage *memoryPasswordResetStorage) DeleteByUser(userId uint) error {
+	passwordResetStorage.database.mutex.Lock()
+	defer passwordResetStorage.database.mutex.Unlock()
+
+	passwordResetStorage.database.passwordResets.deleteWhere(func(passwordReset *models.PasswordReset) bool {
+		return passwordReset.UserRefer == userId
+	})
+
+	return nil
+}
+
+type memoryMFAChallengeStorage struct {
+	database *memoryDatabase
+}
+
+var _ MFAChallengeRepository = (*memoryMFAChallengeStorage)(nil)
+
+func (mfaChallengeStorage *memoryMFAChallengeStorage) Create(challenge *models.MFAChallenge) error {
+	now := time.Now()
+
+	mfaChallengeStorage.database.mutex.Lock()
+	defer mfaChallengeStorage.database.mutex.Unlock()
+
+	mfaChallengeStorage.database.mfaChallenges.deleteWhere(func(challenge *models.MFAChallenge) bool {
+		return !challenge.ExpiresAt.After(now)
+	})
+
+	return mfaChallengeStorage.database.mfaChallenges.insert(challenge)
+}
+
+func (mfaChallengeStorage *memoryMFAChallengeStorage) GetValid(tokenHash string) (*models.MFAChallenge, error) {
+	now := time.Now()
+
+	mfaChallengeStorage.database.mutex.RLock()
+	defer mfaChallengeStorage.database.mutex.RUnlock()
+
+	challenge, ok := mfaChallengeStorage.database.mfaChallenges.first(func(challenge *models.MFAChallenge) bool {
+		return challenge.TokenHash == tokenHash && challenge.ExpiresAt.After(now)
+	})
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return challenge, nil
+}
+
+func (mfaChallengeStorage *memoryMFAChallengeStorage) AddAttempt(challenge *models.MFAChallenge, maxAttempts int) (bool, error) {
+	mfaChallengeStorage.database.mutex.Lock()
+	defer mfaChallengeStorage.database.mutex.Unlock()
+
+	row, exists := mfaChallengeStorage.database.mfaChallenges.rows[challenge.ID]
+
+	if !exists || row.Attempts >= maxAttempts {
+		return false, nil
+	}
+
+	row.Attempts++
+
+	return true, nil
+}
+
+func (mfaChallengeStorage *memoryMFAChallengeStorage) Delete(challenge *models.MFAChallenge) error {
+	mfaChallengeStorage.database.mutex.Lock()
+	defer mfaChallengeStorage.database.mutex.Unlock()
+
+	if !mfaChallengeStorage.database.mfaChallenges.delete(challenge.ID) {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+type memoryOAuthClientStorage struct {
+	database *memoryDatabase
+}
+
+var _ OAuthClientRepository = (*memoryOAuthClientStorage)(nil)
+
+func (oauthClientStorage *memoryOAuthClientStorage) Get(id int) (interface{}, error) {
+	oauthClientStorage.database.mutex.RLock()
+	defer oauthClientStorage.database.mutex.RUnlock()
+
+	client, ok := oauthClientStorage.database.oauthClients.get(uint(id))
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return client, nil
+}
+
+func (oauthClientStorage *memoryOAuthClientStorage) Create(item interface{}) error {
+	client, ok := item.(*models.OAuthClient)
+
+	if !ok {
+		return errors.New(oauthClientTypeMismatchErr)
+	}
+
+	oauthClientStorage.database.mutex.Lock()
+	defer oauthClientStorage.database.mutex.Unlock()
+
+	return oauthClientStorage.database.oauthClients.insert(client)
+}
+
+func (oauthClientStorage *memoryOAuthClientStorage) Update(item interface{}) error {
+	client, ok := item.(*models.OAuthClient)
+
+	if !ok {
+		return errors.New(oauthClientTypeMismatchErr)
+	}
+
+	oauthClientStorage.database.mutex.Lock()
+	defer oauthClientStorage.database.mutex.Unlock()
+
+	return oauthClientStorage.database.oauthClients.save(client)
+}
+
+func (oauthClientStorage *memoryOAuthClientStorage) Delete(item interface{}) error {
+	client, ok := item.(*models.OAuthClient)
+
+	if !ok {
+		return errors.New(oauthClientTypeMismatchErr)
+	}
+
+	oauthClientStorage.database.mutex.Lock()
+	defer oauthClientStorage.database.mutex.Unlock()
+
+	oauthClientStorage.database.deleteOAuthClient(client.ID)
+
+	return nil
+}
+
+func (oauthClientStorage *memoryOAuthClientStorage) GetAll() ([]*models.OAuthClient, error) {
+	oauthClientStorage.database.mutex.RLock()
+	defer oauthClientStorage.database.mutex.RUnlock()
+
+	return oauthClientStorage.database.oauthClients.where(nil), nil
+}
+
+func (oauthClientStorage *memoryOAuthClientStorage) GetByClientId(clientId string) (*models.OAuthClient, error) {
+	oauthClientStorage.database.mutex.RLock()
+	defer oauthClientStorage.database.mutex.RUnlock()
+
+	client, ok := oauthClientStorage.database.oauthClients.first(func(client *models.OAuthClient) bool { return client.ClientID == clientId })
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return client, nil
+}
+
+type memoryAuthorizationCodeStorage struct {
+	database *memoryDatabase
+}
+
+var _ AuthorizationCodeRepository = (*memoryAuthorizationCodeStorage)(nil)
+
+func (authorizationCodeStorage *memoryAuthorizationCodeStorage) Create(code *models.AuthorizationCode) error {
+	authorizationCodeStorage.database.mutex.Lock()
+	defer authorizationCodeStorage.database.mutex.Unlock()
+
+	return authorizationCodeStorage.database.authorizationCodes.insert(code)
+}
+
+func (authorizationCodeStorage *memoryAuthorizationCodeStorage) GetByHash(codeHash string) (*models.AuthorizationCode, error) {
+	now := time.Now()
+
+	authorizationCodeStorage.database.mutex.RLock()
+	defer authorizationCodeStorage.database.mutex.RUnlock()
+
+	code, ok := authorizationCodeStorage.database.authorizationCodes.first(func(code *models.AuthorizationCode) bool {
+		return code.CodeHash == codeHash && code.ExpiresAt.After(now)
+	})
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return code, nil
+}
+
+func (authorizationCodeStorage *memoryAuthorizationCodeStorage) MarkUsed(code *models.AuthorizationCode, family string) (bool, error) {
+	authorizationCodeStorage.database.mutex.Lock()
+	defer authorizationCodeStorage.database.mutex.Unlock()
+
+	row, exists := authorizationCodeStorage.database.authorizationCodes.rows[code.ID]
+
+	if !exists || row.UsedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	row.UsedAt = &now
+	row.Family = family
+
+	return true, nil
+}
+
+type memoryPersonalAccessTokenStorage struct {
+	database *memoryDatabase
+}
+
+var _ PersonalAccessTokenRepository = (*memoryPersonalAccessTokenStorage)(nil)
+
+func (personalAccessTokenStorage *memoryPersonalAccessTokenStorage) Create(token *models.PersonalAccessToken) error {
+	personalAccessTokenStorage.database.mutex.Lock()
+	defer personalAccessTokenStorage.database.mutex.Unlock()
+
+	return personalAccessTokenStorage.database.personalAccessTokens.insert(token)
+}
+
+func (personalAccessTokenStorage *memoryPersonalAccessTokenStorage) Update(token *models.PersonalAccessToken) error {
+	personalAccessTokenStorage.database.mutex.Lock()
+	defer personalAccessTokenStorage.database.mutex.Unlock()
+
+	return personalAccessTokenStorage.database.personalAccessTokens.save(token)
+}
+
+func (personalAccessTokenStorage *memoryPersonalAccessTokenStorage) Delete(token *models.PersonalAccessToken) error {
+	personalAccessTokenStorage.database.mutex.Lock()
+	defer personalAccessTokenStorage.database.mutex.Unlock()
+
+	personalAccessTokenStorage.database.personalAccessTokens.delete(token.ID)
+
+	return nil
+}
+
+func (personalAccessTokenStorage *memoryPersonalAccessTokenStorage) GetByUserAndId(userId uint, id int) (*models.PersonalAccessToken, error) {
+	personalAccessTokenStorage.database.mutex.RLock()
+	defer personalAccessTokenStorage.database.mutex.RUnlock()
+
+	token, ok := personalAccessTokenStorage.database.personalAccessTokens.get(uint(id))
+
+	if !ok || token.UserRefer != userId {
+		return nil, ErrNotFound
+	}
+
+	return token, nil
+}
+
+func (personalAccessTokenStorage *memoryPersonalAccessTokenStorage) GetByUser(userId uint) ([]*models.PersonalAccessToken, error) {
+	personalAccessTokenStorage.database.mutex.RLock()
+	defer personalAccessTokenStorage.database.mutex.RUnlock()
+
+	tokens := personalAccessTokenStorage.database.personalAccessTokens.where(func(token *models.PersonalAccessToken) bool {
+		return token.UserRefer == userId
+	})
+	sortNewestFirst(tokens, func(token *models.PersonalAccessToken) time.Time { return token.CreatedAt })
+
+	return tokens, nil
+}
+
+func (personalAccessTokenStorage *memoryPersonalAccessTokenStorage) GetByHash(tokenHash string) (*models.PersonalAccessToken, error) {
+	personalAccessTokenStorage.database.mutex.RLock()
+	defer personalAccessTokenStorage.database.mutex.RUnlock()
+
+	token, ok := personalAccessTokenStorage.database.personalAccessTokens.first(func(token *models.PersonalAccessToken) bool {
+		return token.TokenHash == tokenHash
+	})
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return token, nil
+}
+
+func (personalAccessTokenStorage *memoryPersonalAccessTokenStorage) Touch(token *models.PersonalAccessToken, threshold time.Duration) error {
+	now := time.Now()
+
+	personalAccessTokenStorage.database.mutex.Lock()
+	defer personalAccessTokenStorage.database.mutex.Unlock()
+
+	if row, exists := personalAccessTokenStorage.database.personalAccessTokens.rows[token.ID]; exists {
+		if row.LastUsedAt == nil || row.LastUsedAt.Before(now.Add(-threshold)) {
+			row.LastUsedAt = &now
+		}
+	}
+
+	return nil
+}
+
+type memoryRoleStorage struct {
+	database *memoryDatabase
+}
+
+var _ RoleRepository = (*memoryRoleStorage)(nil)
+
+func (roleStorage *memoryRoleStorage) Get(id int) (*models.Role, error) {
+	roleStorage.database.mutex.RLock()
+	defer roleStorage.database.mutex.RUnlock()
+
+	role, ok := roleStorage.database.roles.get(uint(id))
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return role, nil
+}
+
+func (roleStorage *memoryRoleStorage) GetAll() ([]*models.Role, error) {
+	roleStorage.database.mutex.RLock()
+	defer roleStorage.database.mutex.RUnlock()
+
+	return roleStorage.database.roles.where(nil), nil
+}
+
+func (roleStorage *memoryRoleStorage) GetByName(name string) (*models.Role, error) {
+	roleStorage.database.mutex.RLock()
+	defer roleStorage.database.mutex.RUnlock()
+
+	role, ok := roleStorage.database.roles.first(func(role *models.Role) bool { return role.Name == name })
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return role, nil
+}
+
+func (roleStorage *memoryRoleStorage) Create(role *models.Role, auditLog *models.AuditLog) error {
+	roleStorage.database.mutex.Lock()
+	defer roleStorage.database.mutex.Unlock()
+
+	if insertErr := roleStorage.database.roles.insert(role); insertErr != nil {
+		return insertErr
+	}
+
+	auditLog.TargetID = uint(role.ID)
+
+	return roleStorage.database.auditLogs.insert(auditLog)
+}
+
+func (roleStorage *memoryRoleStorage) Update(role *models.Role, auditLog *models.AuditLog) error {
+	roleStorage.database.mutex.Lock()
+	defer roleStorage.database.mutex.Unlock()
+
+	if saveErr := roleStorage.database.roles.save(role); saveErr != nil {
+		return saveErr
+	}
+
+	return roleStorage.database.auditLogs.insert(auditLog)
+}
+
+func (roleStorage *memoryRoleStorage) Delete(role *models.Role, auditLog *models.AuditLog) error {
+	roleStorage.database.mutex.Lock()
+	defer roleStorage.database.mutex.Unlock()
+
+	roleStorage.database.roles.delete(uint(role.ID))
+
+	return roleStorage.database.auditLogs.insert(auditLog)
+}
+
+func (roleStorage *memoryRoleStorage) Assign(ctx context.Context, user *models.User, auditLog *models.AuditLog) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	roleStorage.database.mutex.Lock()
+	defer roleStorage.database.mutex.Unlock()
+
+	row, exists := roleStorage.database.users.get(user.ID)
+
+	if !exists {
+		return ErrNotFound
+	}
+
+	updated := *row
+	updated.Role = user.Role
+	updated.Version++
+
+	if updateErr := roleStorage.database.users.update(&updated); updateErr != nil {
+		return updateErr
+	}
+
+	user.Version = updated.Version
+
+	return roleStorage.database.auditLogs.insert(auditLog)
+}
+
+type memoryAuditLogStorage struct {
+	database *memoryDatabase
+}
+
+var _ AuditLogRepository = (*memoryAuditLogStorage)(nil)
+
+func (auditLogStorage *memoryAuditLogStorage) Create(auditLog *models.AuditLog) error {
+	auditLogStorage.database.mutex.Lock()
+	defer auditLogStorage.database.mutex.Unlock()
+
+	return auditLogStorage.database.auditLogs.insert(auditLog)
+}
+
+func (auditLogStorage *memoryAuditLogStorage) GetByTargetTypes(targetTypes []string) ([]*models.AuditLog, error) {
+	auditLogStorage.database.mutex.RLock()
+	defer auditLogStorage.database.mutex.RUnlock()
+
+	auditLogs := auditLogStorage.database.auditLogs.where(func(auditLog *models.AuditLog) bool {
+		for _, targetType := range targetTypes {
+			if auditLog.TargetType == targetType {
+				return true
+			}
+		}
+
+		return false
+	})
+	sortNewestFirst(auditLogs, func(auditLog *models.AuditLog) time.Time { return auditLog.CreatedAt })
+
+	return auditLogs, nil
+}
+
+type memoryOrganizationStorage struct {
+	database *memoryDatabase
+}
+
+var _ OrganizationRepository = (*memoryOrganizationStorage)(nil)
+
+func (organizationStorage *memoryOrganizationStorage) Get(id uint) (*models.Organization, error) {
+	organizationStorage.database.mutex.RLock()
+	defer organizationStorage.database.mutex.RUnlock()
+
+	organization, ok := organizationStorage.database.organizations.get(id)
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return organization, nil
+}
+
+func (organizationStorage *memoryOrganizationStorage) GetByUser(userId uint) ([]*models.Organization, error) {
+	organizationStorage.database.mutex.RLock()
+	defer organizationStorage.database.mutex.RUnlock()
+
+	return organizationStorage.database.organizations.where(func(organization *models.Organization) bool {
+		_, isMember := organizationStorage.database.memberships.first(func(membership *models.Membership) bool {
+			return membership.OrganizationRefer == organization.ID && membership.UserRefer == userId
+		})
+
+		return isMember
+	}), nil
+}
+
+func (organizationStorage *memoryOrganizationStorage) Create(organization *models.Organization) error {
+	organizationStorage.database.mutex.Lock()
+	defer organizationStorage.database.mutex.Unlock()
+
+	return organizationStorage.database.organizations.insert(organization)
+}
+
+func (organizationStorage *memoryOrganizationStorage) Update(organization *models.Organization) error {
+	organizationStorage.database.mutex.Lock()
+	defer organizationStorage.database.mutex.Unlock()
+
+	return organizationStorage.database.organizations.save(organization)
+}
+
+func (organizationStorage *memoryOrganizationStorage) Delete(organization *models.Organization) error {
+	organizationStorage.database.mutex.Lock()
+	defer organizationStorage.database.mutex.Unlock()
+
+	organizationStorage.database.deleteOrganization(organization.ID)
+
+	return nil
+}
+
+type memoryMembershipStorage struct {
+	database *memoryDatabase
+}
+
+var _ MembershipRepository = (*memoryMembershipStorage)(nil)
+
+func (membershipStorage *memoryMembershipStorage) Get(organizationId uint, userId uint) (*models.Membership, error) {
+	membershipStorage.database.mutex.RLock()
+	defer membershipStorage.database.mutex.RUnlock()
+
+	membership, ok := membershipStorage.database.memberships.first(func(membership *models.Membership) bool {
+		return membership.OrganizationRefer == organizationId && membership.UserRefer == userId
+	})
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return membership, nil
+}
+
+func (membershipStorage *memoryMembershipStorage) GetByOrganization(organizationId uint) ([]*models.Membership, error) {
+	membershipStorage.database.mutex.RLock()
+	defer membershipStorage.database.mutex.RUnlock()
+
+	memberships := membershipStorage.database.memberships.where(func(membership *models.Membership) bool {
+		return membership.OrganizationRefer == organizationId
+	})
+	sortOldestFirst(memberships, func(membership *models.Membership) time.Time { return membership.CreatedAt })
+
+	return memberships, nil
+}
+
+func (membershipStorage *memoryMembershipStorage) GetFirstByUser(userId uint) (*models.Membership, error) {
+	membershipStorage.database.mutex.RLock()
+	defer membershipStorage.database.mutex.RUnlock()
+
+	memberships := membershipStorage.database.memberships.where(func(membership *models.Membership) bool {
+		return membership.UserRefer == userId
+	})
+	sortOldestFirst(memberships, func(membership *models.Membership) time.Time { return membership.CreatedAt })
+
+	if len(memberships) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return memberships[0], nil
+}
+
+func (membershipStorage *memoryMembershipStorage) CountByRole(organizationId uint, role models.MembershipRole) (int64, error) {
+	membershipStorage.database.mutex.RLock()
+	defer membershipStorage.database.mutex.RUnlock()
+
+	memberships := membershipStorage.database.memberships.where(func(membership *models.Membership) bool {
+		return membership.OrganizationRefer == organizationId && membership.Role == role
+	})
+
+	return int64(len(memberships)), nil
+}
+
+func (membershipStorage *memoryMembershipStorage) CountByUser(userId uint) (int64, error) {
+	membershipStorage.database.mutex.RLock()
+	defer membershipStorage.database.mutex.RUnlock()
+
+	memberships := membershipStorage.database.memberships.where(func(membership *models.Membership) bool {
+		return membership.UserRefer == userId
+	})
+
+	return int64(len(memberships)), nil
+}
+
+func (membershipStorage *memoryMembershipStorage) Create(membership *models.Membership) error {
+	membershipStorage.database.mutex.Lock()
+	defer membershipStorage.database.mutex.Unlock()
+
+	return membershipStorage.database.memberships.insert(membership)
+}
+
+func (membershipStorage *memoryMembershipStorage) Update(membership *models.Membership) error {
+	membershipStorage.database.mutex.Lock()
+	defer membershipStorage.database.mutex.Unlock()
+
+	return membershipStorage.database.memberships.save(membership)
+}
+
+func (membershipStorage *memoryMembershipStorage) Delete(membership *models.Membership) error {
+	membershipStorage.database.mutex.Lock()
+	defer membershipStorage.database.mutex.Unlock()
+
+	membershipStorage.database.memberships.delete(membership.ID)
+
+	return nil
+}
+
+type memoryOrganizationInvitationStorage struct {
+	database *memoryDatabase
+}
+
+var _ OrganizationInvitationRepository = (*memoryOrganizationInvitationStorage)(nil)
+
+func (invitationStorage *memoryOrganizationInvitationStorage) Create(invitation *models.OrganizationInvitation) error {
+	invitationStorage.database.mutex.Lock()
+	defer invitationStorage.database.mutex.Unlock()
+
+	return invitationStorage.database.organizationInvitations.insert(invitation)
+}
+
+func (invitationStorage *memoryOrganizationInvitationStorage) Update(invitation *models.OrganizationInvitation) error {
+	invitationStorage.database.mutex.Lock()
+	defer invitationStorage.database.mutex.Unlock()
+
+	return invitationStorage.database.organizationInvitations.save(invitation)
+}
+
+func (invitationStorage *memoryOrganizationInvitationStorage) Delete(invitation *models.OrganizationInvitation) error {
+	invitationStorage.database.mutex.Lock()
+	defer invitationStorage.database.mutex.Unlock()
+
+	invitationStorage.database.organizationInvitations.delete(invitation.ID)
+
+	return nil
+}
+
+func (invitationStorage *memoryOrganizationInvitationStorage) Get(organizationId uint, id int) (*models.OrganizationInvitation, error) {
+	invitationStorage.database.mutex.RLock()
+	defer invitationStorage.database.mutex.RUnlock()
+
+	invitation, ok := invitationStorage.database.organizationInvitations.get(uint(id))
+
+	if !ok || invitation.OrganizationRefer != organizationId {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+func (invitationStorage *memoryOrganizationInvitationStorage) GetPendingByOrganization(organizationId uint) ([]*models.OrganizationInvitation, error) {
+	invitationStorage.database.mutex.RLock()
+	defer invitationStorage.database.mutex.RUnlock()
+
+	invitations := invitationStorage.database.organizationInvitations.where(func(invitation *models.OrganizationInvitation) bool {
+		return invitation.OrganizationRefer == organizationId && invitation.AcceptedAt == nil
+	})
+	sortNewestFirst(invitations, func(invitation *models.OrganizationInvitation) time.Time { return invitation.CreatedAt })
+
+	return invitations, nil
+}
+
+func (invitationStorage *memoryOrganizationInvitationStorage) GetValid(tokenHash string) (*models.OrganizationInvitation, error) {
+	now := time.Now()
+
+	invitationStorage.database.mutex.RLock()
+	defer invitationStorage.database.mutex.RUnlock()
+
+	invitation, ok := invitationStorage.database.organizationInvitations.first(func(invitation *models.OrganizationInvitation) bool {
+		return invitation.TokenHash == tokenHash && invitation.AcceptedAt == nil && invitation.ExpiresAt.After(now)
+	})
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+func (invitationStorage *memoryOrganizationInvitationStorage) MarkAccepted(invitation *models.OrganizationInvitation) (bool, error) {
+	invitationStorage.database.mutex.Lock()
+	defer invitationStorage.database.mutex.Unlock()
+
+	row, exists := invitationStorage.database.organizationInvitations.rows[invitation.ID]
+
+	if !exists || row.AcceptedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	row.AcceptedAt = &now
+	invitation.AcceptedAt = &now
+
+	return true, nil
+}
+
+type memoryUserInvitationStorage struct {
+	database *memoryDatabase
+}
+
+var _ UserInvitationRepository = (*memoryUserInvitationStorage)(nil)
+
+func (invitationStorage *memoryUserInvitationStorage) Get(id int) (*models.UserInvitation, error) {
+	invitationStorage.database.mutex.RLock()
+	defer invitationStorage.database.mutex.RUnlock()
+
+	invitation, ok := invitationStorage.database.userInvitations.get(uint(id))
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+func (invitationStorage *memoryUserInvitationStorage) Create(invitation *models.UserInvitation) error {
+	invitationStorage.database.mutex.Lock()
+	defer invitationStorage.database.mutex.Unlock()
+
+	return invitationStorage.database.userInvitations.insert(invitation)
+}
+
+func (invitationStorage *memoryUserInvitationStorage) Update(invitation *models.UserInvitation) error {
+	invitationStorage.database.mutex.Lock()
+	defer invitationStorage.database.mutex.Unlock()
+
+	return invitationStorage.database.userInvitations.save(invitation)
+}
+
+func (invitationStorage *memoryUserInvitationStorage) Delete(invitation *models.UserInvitation) error {
+	invitationStorage.database.mutex.Lock()
+	defer invitationStorage.database.mutex.Unlock()
+
+	invitationStorage.database.userInvitations.delete(invitation.ID)
+
+	return nil
+}
+
+func (invitationStorage *memoryUserInvitationStorage) GetPending() ([]*models.UserInvitation, error) {
+	invitationStorage.database.mutex.RLock()
+	defer invitationStorage.database.mutex.RUnlock()
+
+	invitations := invitationStorage.database.userInvitations.where(func(invitation *models.UserInvitation) bool {
+		return invitation.AcceptedAt == nil
+	})
+	sortNewestFirst(invitations, func(invitation *models.UserInvitation) time.Time { return invitation.CreatedAt })
+
+	return invitations, nil
+}
+
+func (invitationStorage *memoryUserInvitationStorage) GetPendingByEmail(email string) (*models.UserInvitation, error) {
+	invitationStorage.database.mutex.RLock()
+	defer invitationStorage.database.mutex.RUnlock()
+
+	// the database compares emails with LIKE, which SQLite does case insensitively
+	invitation, ok := invitationStorage.database.userInvitations.first(func(invitation *models.UserInvitation) bool {
+		return strings.EqualFold(invitation.Email, email) && invitation.AcceptedAt == nil
+	})
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+func (invitationStorage *memoryUserInvitationStorage) GetValid(tokenHash string) (*models.UserInvitation, error) {
+	now := time.Now()
+
+	invitationStorage.database.mutex.RLock()
+	defer invitationStorage.database.mutex.RUnlock()
+
+	invitation, ok := invitationStorage.database.userInvitations.first(func(invitation *models.UserInvitation) bool {
+		return invitation.TokenHash == tokenHash && invitation.AcceptedAt == nil && invitation.ExpiresAt.After(now)
+	})
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+func (invitationStorage *memoryUserInvitationStorage) MarkAccepted(invitation *models.UserInvitation) (bool, error) {
+	invitationStorage.database.mutex.Lock()
+	defer invitationStorage.database.mutex.Unlock()
+
+	row, exists := invitationStorage.database.userInvitations.rows[invitation.ID]
+
+	if !exists || row.AcceptedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	row.AcceptedAt = &now
+	invitation.AcceptedAt = &now
+
+	return true, nil
+}
+
+// AUX FUNCTIONS
+
+// Sorts records by the given time, the newest first. Records with the same time keep their order.
+func sortNewestFirst[T any](items []*T, timeOf func(item *T) time.Time) {
+	sort.SliceStable(items, func(i, j int) bool { return timeOf(items[i]).After(timeOf(items[j])) })
+}
+
+// Sorts records by the given time, the oldest first. Records with the same time keep their order.
+func sortOldestFirst[T any](items []*T, timeOf func(item *T) time.Time) {
+	sort.SliceStable(items, func(i, j int) bool { return timeOf(items[i]).Before(timeOf(items[j])) })
+}
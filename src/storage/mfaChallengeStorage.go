@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"gocker-api/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type MFAChallengeStorage struct {
+	db *gorm.DB
+}
+
+func NewMFAChallengeStorage(db *gorm.DB) *MFAChallengeStorage {
+	return &MFAChallengeStorage{db: db}
+}
+
+// Creates the challenge, deleting the expired ones along the way
+func (mfaChallengeStorage *MFAChallengeStorage) Create(challenge *models.MFAChallenge) error {
+	database := mfaChallengeStorage.db
+
+	if deleteErr := database.Where("expires_at <= ?", time.Now()).Delete(&models.MFAChallenge{}).Error; deleteErr != nil {
+		return translateError(deleteErr)
+	}
+
+	return translateError(database.Create(challenge).Error)
+}
+
+// Returns the not expired challenge with the given token hash
+func (mfaChallengeStorage *MFAChallengeStorage) GetValid(tokenHash string) (*models.MFAChallenge, error) {
+	var challenge *models.MFAChallenge
+	database := mfaChallengeStorage.db
+	result := database.Find(&challenge, "token_hash = ? AND expires_at > ?", tokenHash, time.Now())
+
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return challenge, nil
+}
+
+// Counts an attempt to answer the challenge, only if it had less than the given ones. Returns false if it had them already.
+func (mfaChallengeStorage *MFAChallengeStorage) AddAttempt(challenge *models.MFAChallenge, maxAttempts int) (bool, error) {
+	database := mfaChallengeStorage.db
+	result := database.Model(&models.MFAChallenge{}).
+		Where("id = ? AND attempts < ?", challenge.ID, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+
+	if result.Error != nil {
+		return false, translateError(result.Error)
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// Deletes the challenge, or returns ErrNotFound if it had already been deleted
+func (mfaChallengeStorage *MFAChallengeStorage) Delete(challenge *models.MFAChallenge) error {
+	database := mfaChallengeStorage.db
+	result := database.Delete(&models.MFAChallenge{}, challenge.ID)
+
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"errors"
+	"gocker-api/models"
+
+	"gorm.io/gorm"
+)
+
+const oauthClientTypeMismatchErr = "type must be oauth client"
+
+type OAuthClientStorage struct {
+	db *gorm.DB
+}
+
+func NewOAuthClientStorage(db *gorm.DB) *OAuthClientStorage {
+	return &OAuthClientStorage{db: db}
+}
+
+func (oauthClientStorage *OAuthClientStorage) Get(id int) (interface{}, error) {
+	var client *models.OAuthClient
+	database := oauthClientStorage.db
+
+	if result := database.Find(&client, "id = ?", id); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return client, nil
+}
+
+func (oauthClientStorage *OAuthClientStorage) Create(item interface{}) error {
+	client, ok := item.(*models.OAuthClient)
+
+	if !ok {
+		return errors.New(oauthClientTypeMismatchErr)
+	}
+
+	database := oauthClientStorage.db
+
+	return translateError(database.Create(client).Error)
+}
+
+func (oauthClientStorage *OAuthClientStorage) Update(item interface{}) error {
+	client, ok := item.(*models.OAuthClient)
+
+	if !ok {
+		return errors.New(oauthClientTypeMismatchErr)
+	}
+
+	database := oauthClientStorage.db
+
+	return translateError(database.Save(client).Error)
+}
+
+func (oauthClientStorage *OAuthClientStorage) Delete(item interface{}) error {
+	client, ok := item.(*models.OAuthClient)
+
+	if !ok {
+		return errors.New(oauthClientTypeMismatchErr)
+	}
+
+	database := oauthClientStorage.db
+
+	return translateError(database.Delete(client).Error)
+}
+
+// Returns all registered clients
+func (oauthClientStorage *OAuthClientStorage) GetAll() ([]*models.OAuthClient, error) {
+	var clients []*models.OAuthClient
+	database := oauthClientStorage.db
+
+	if result := database.Order("id").Find(&clients); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return clients, nil
+}
+
+// Returns the client with the given public client id
+func (oauthClientStorage *OAuthClientStorage) GetByClientId(clientId string) (*models.OAuthClient, error) {
+	var client *models.OAuthClient
+	database := oauthClientStorage.db
+
+	if result := database.Find(&client, "client_id = ?", clientId); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return client, nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"gocker-api/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type OrganizationInvitationStorage struct {
+	db *gorm.DB
+}
+
+func NewOrganizationInvitationStorage(db *gorm.DB) *OrganizationInvitationStorage {
+	return &OrganizationInvitationStorage{db: db}
+}
+
+func (invitationStorage *OrganizationInvitationStorage) Create(invitation *models.OrganizationInvitation) error {
+	database := invitationStorage.db
+
+	return translateError(database.Create(invitation).Error)
+}
+
+func (invitationStorage *OrganizationInvitationStorage) Update(invitation *models.OrganizationInvitation) error {
+	database := invitationStorage.db
+
+	return translateError(database.Save(invitation).Error)
+}
+
+func (invitationStorage *OrganizationInvitationStorage) Delete(invitation *models.OrganizationInvitation) error {
+	database := invitationStorage.db
+
+	return translateError(database.Delete(invitation).Error)
+}
+
+// Returns the invitation of the organization with the given id
+func (invitationStorage *OrganizationInvitationStorage) Get(organizationId uint, id int) (*models.OrganizationInvitation, error) {
+	var invitation *models.OrganizationInvitation
+	database := invitationStorage.db
+
+	if result := database.Find(&invitation, "id = ? AND organization_refer = ?", id, organizationId); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+// Returns the invitations of the organization that have not been accepted yet, the newest first
+func (invitationStorage *OrganizationInvitationStorage) GetPendingByOrganization(organizationId uint) ([]*models.OrganizationInvitation, error) {
+	var invitations []*models.OrganizationInvitation
+	database := invitationStorage.db
+	result := database.Order("created_at DESC").Find(&invitations, "organization_refer = ? AND accepted_at IS NULL", organizationId)
+
+	return invitations, result.Error
+}
+
+// Returns the not accepted and not expired invitation with the given token hash
+func (invitationStorage *OrganizationInvitationStorage) GetValid(tokenHash string) (*models.OrganizationInvitation, error) {
+	var invitation *models.OrganizationInvitation
+	database := invitationStorage.db
+	result := database.Find(&invitation, "token_hash = ? AND accepted_at IS NULL AND expires_at > ?", tokenHash, time.Now())
+
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+// Marks the invitation as accepted, only if it had not been accepted before. Returns false if it had.
+func (invitationStorage *OrganizationInvitationStorage) MarkAccepted(invitation *models.OrganizationInvitation) (bool, error) {
+	now := time.Now()
+	database := invitationStorage.db
+	result := database.Model(&models.OrganizationInvitation{}).
+		Where("id = ? AND accepted_at IS NULL", invitation.ID).
+		Update("accepted_at", now)
+
+	if result.Error != nil {
+		return false, translateError(result.Error)
+	}
+
+	if result.RowsAffected == 1 {
+		invitation.AcceptedAt = &now
+	}
+
+	return result.RowsAffected == 1, nil
+}
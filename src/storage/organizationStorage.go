@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"gocker-api/models"
+
+	"gorm.io/gorm"
+)
+
+type OrganizationStorage struct {
+	db *gorm.DB
+}
+
+func NewOrganizationStorage(db *gorm.DB) *OrganizationStorage {
+	return &OrganizationStorage{db: db}
+}
+
+func (organizationStorage *OrganizationStorage) Get(id uint) (*models.Organization, error) {
+	var organization *models.Organization
+	database := organizationStorage.db
+
+	if result := database.Find(&organization, "id = ?", id); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return organization, nil
+}
+
+// Returns the organizations the user is a member of
+func (organizationStorage *OrganizationStorage) GetByUser(userId uint) ([]*models.Organization, error) {
+	var organizations []*models.Organization
+	database := organizationStorage.db
+	result := database.
+		Where("id IN (?)", database.Model(&models.Membership{}).Select("organization_refer").Where("user_refer = ?", userId)).
+		Order("id").
+		Find(&organizations)
+
+	return organizations, result.Error
+}
+
+func (organizationStorage *OrganizationStorage) Create(organization *models.Organization) error {
+	database := organizationStorage.db
+
+	return translateError(database.Create(organization).Error)
+}
+
+func (organizationStorage *OrganizationStorage) Update(organization *models.Organization) error {
+	database := organizationStorage.db
+
+	return translateError(database.Save(organization).Error)
+}
+
+func (organizationStorage *OrganizationStorage) Delete(organization *models.Organization) error {
+	database := organizationStorage.db
+
+	return translateError(database.Delete(organization).Error)
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"gocker-api/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PasswordResetStorage struct {
+	db *gorm.DB
+}
+
+func NewPasswordResetStorage(db *gorm.DB) *PasswordResetStorage {
+	return &PasswordResetStorage{db: db}
+}
+
+func (passwordResetStorage *PasswordResetStorage) Create(passwordReset *models.PasswordReset) error {
+	database := passwordResetStorage.db
+
+	return translateError(database.Create(passwordReset).Error)
+}
+
+// Returns the unused and not expired password reset with the given token hash
+func (passwordResetStorage *PasswordResetStorage) GetValid(tokenHash string) (*models.PasswordReset, error) {
+	var passwordReset *models.PasswordReset
+	database := passwordResetStorage.db
+	result := database.Find(&passwordReset, "token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now())
+
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return passwordReset, nil
+}
+
+// Marks the password reset as used, only if it had not been used before. Returns false if it had.
+func (passwordResetStorage *PasswordResetStorage) MarkUsed(passwordReset *models.PasswordReset) (bool, error) {
+	now := time.Now()
+	database := passwordResetStorage.db
+	result := database.Model(&models.PasswordReset{}).
+		Where("id = ? AND used_at IS NULL", passwordReset.ID).
+		Update("used_at", now)
+
+	if result.Error != nil {
+		return false, translateError(result.Error)
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// Deletes every password reset of the user
+func (passwordResetStorage *PasswordResetStorage) DeleteByUser(userId uint) error {
+	database := passwordResetStorage.db
+
+	return translateError(database.Where("user_refer = ?", userId).Delete(&models.PasswordReset{}).Error)
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"gocker-api/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PersonalAccessTokenStorage struct {
+	db *gorm.DB
+}
+
+func NewPersonalAccessTokenStorage(db *gorm.DB) *PersonalAccessTokenStorage {
+	return &PersonalAccessTokenStorage{db: db}
+}
+
+func (personalAccessTokenStorage *PersonalAccessTokenStorage) Create(token *models.PersonalAccessToken) error {
+	database := personalAccessTokenStorage.db
+
+	return translateError(database.Create(token).Error)
+}
+
+func (personalAccessTokenStorage *PersonalAccessTokenStorage) Update(token *models.PersonalAccessToken) error {
+	database := personalAccessTokenStorage.db
+
+	return translateError(database.Save(token).Error)
+}
+
+func (personalAccessTokenStorage *PersonalAccessTokenStorage) Delete(token *models.PersonalAccessToken) error {
+	database := personalAccessTokenStorage.db
+
+	return translateError(database.Delete(token).Error)
+}
+
+// Returns the token of the user with the given id
+func (personalAccessTokenStorage *PersonalAccessTokenStorage) GetByUserAndId(userId uint, id int) (*models.PersonalAccessToken, error) {
+	var token *models.PersonalAccessToken
+	database := personalAccessTokenStorage.db
+	result := database.Find(&token, "id = ? AND user_refer = ?", id, userId)
+
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return token, nil
+}
+
+// Returns every token of the user, the newest first
+func (personalAccessTokenStorage *PersonalAccessTokenStorage) GetByUser(userId uint) ([]*models.PersonalAccessToken, error) {
+	var tokens []*models.PersonalAccessToken
+	database := personalAccessTokenStorage.db
+	result := database.Order("created_at DESC").Find(&tokens, "user_refer = ?", userId)
+
+	return tokens, result.Error
+}
+
+// Returns the token with the given hash, whether it has expired or not
+func (personalAccessTokenStorage *PersonalAccessTokenStorage) GetByHash(tokenHash string) (*models.PersonalAccessToken, error) {
+	var token *models.PersonalAccessToken
+	database := personalAccessTokenStorage.db
+	result := database.Find(&token, "token_hash = ?", tokenHash)
+
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return token, nil
+}
+
+// Updates the last time the token was used, unless it was already updated within the threshold,
+// so that busy scripts don't write on every request
+func (personalAccessTokenStorage *PersonalAccessTokenStorage) Touch(token *models.PersonalAccessToken, threshold time.Duration) error {
+	now := time.Now()
+	database := personalAccessTokenStorage.db
+
+	return database.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", token.ID, now.Add(-threshold)).
+		Update("last_used_at", now).Error
+}
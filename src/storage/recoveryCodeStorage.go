@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"gocker-api/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RecoveryCodeStorage struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeStorage(db *gorm.DB) *RecoveryCodeStorage {
+	return &RecoveryCodeStorage{db: db}
+}
+
+func (recoveryCodeStorage *RecoveryCodeStorage) Create(code *models.RecoveryCode) error {
+	database := recoveryCodeStorage.db
+
+	return translateError(database.Create(code).Error)
+}
+
+// Returns the unused recovery code of the user with the given hash
+func (recoveryCodeStorage *RecoveryCodeStorage) GetUnused(userId uint, codeHash string) (*models.RecoveryCode, error) {
+	var code *models.RecoveryCode
+	database := recoveryCodeStorage.db
+
+	if result := database.Find(&code, "user_refer = ? AND code_hash = ? AND used_at IS NULL", userId, codeHash); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return code, nil
+}
+
+// Marks the recovery code as used, only if it had not been used before. Returns false if it had.
+func (recoveryCodeStorage *RecoveryCodeStorage) MarkUsed(code *models.RecoveryCode) (bool, error) {
+	now := time.Now()
+	database := recoveryCodeStorage.db
+	result := database.Model(&models.RecoveryCode{}).
+		Where("id = ? AND used_at IS NULL", code.ID).
+		Update("used_at", now)
+
+	if result.Error != nil {
+		return false, translateError(result.Error)
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// Deletes every recovery code of the user
+func (recoveryCodeStorage *RecoveryCodeStorage) DeleteByUser(userId uint) error {
+	database := recoveryCodeStorage.db
+
+	return translateError(database.Where("user_refer = ?", userId).Delete(&models.RecoveryCode{}).Error)
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"gocker-api/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserRepository interface {
+	Repository[models.User]
+	// Returns a repository that only sees the members of the given organization, or no user if it's nil
+	ForOrganization(organizationId *uint) UserRepository
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	// Saves only the given columns of the user, whatever version it's at, and moves it to the next one.
+	// It's meant for changes the API makes on its own, which must not fail because of a concurrent update.
+	UpdateColumns(ctx context.Context, user *models.User, columns ...string) error
+	UpdateTOTPCounter(ctx context.Context, user *models.User, counter int64) (bool, error)
+	CountByRole(ctx context.Context, role models.UserRole) (int64, error)
+}
+
+type TokenRepository interface {
+	Repository[models.Token]
+	GetByValue(ctx context.Context, value string) (*models.Token, error)
+	GetByFamily(ctx context.Context, family string) ([]*models.Token, error)
+	GetByUser(ctx context.Context, userId uint) ([]*models.Token, error)
+	DeleteByFamily(ctx context.Context, family string) error
+	MarkUsed(ctx context.Context, token *models.Token) (bool, error)
+	AdoptFamily(ctx context.Context, token *models.Token, family string) (bool, error)
+}
+
+type SessionRepository interface {
+	Storage
+	GetByFamily(family string) (*models.Session, error)
+	GetByUser(userId uint) ([]*models.Session, error)
+	Touch(family string, threshold time.Duration) error
+}
+
+type RecoveryCodeRepository interface {
+	Create(code *models.RecoveryCode) error
+	GetUnused(userId uint, codeHash string) (*models.RecoveryCode, error)
+	MarkUsed(code *models.RecoveryCode) (bool, error)
+	DeleteByUser(userId uint) error
+}
+
+type PasswordResetRepository interface {
+	Create(passwordReset *models.PasswordReset) error
+	GetValid(tokenHash string) (*models.PasswordReset, error)
+	MarkUsed(passwordReset *models.PasswordReset) (bool, error)
+	DeleteByUser(userId uint) error
+}
+
+type MFAChallengeRepository interface {
+	Create(challenge *models.MFAChallenge) error
+	GetValid(tokenHash string) (*models.MFAChallenge, error)
+	AddAttempt(challenge *models.MFAChallenge, maxAttempts int) (bool, error)
+	Delete(challenge *models.MFAChallenge) error
+}
+
+type OAuthClientRepository interface {
+	Storage
+	GetAll() ([]*models.OAuthClient, error)
+	GetByClientId(clientId string) (*models.OAuthClient, error)
+}
+
+type AuthorizationCodeRepository interface {
+	Create(code *models.AuthorizationCode) error
+	GetByHash(codeHash string) (*models.AuthorizationCode, error)
+	MarkUsed(code *models.AuthorizationCode, family string) (bool, error)
+}
+
+type PersonalAccessTokenRepository interface {
+	Create(token *models.PersonalAccessToken) error
+	Update(token *models.PersonalAccessToken) error
+	Delete(token *models.PersonalAccessToken) error
+	GetByUserAndId(userId uint, id int) (*models.PersonalAccessToken, error)
+	GetByUser(userId uint) ([]*models.PersonalAccessToken, error)
+	GetByHash(tokenHash string) (*models.PersonalAccessToken, error)
+	Touch(token *models.PersonalAccessToken, threshold time.Duration) error
+}
+
+// Storage of roles. Every change is stored along with the audit log recording it, in the same transaction,
+// so that no change goes unrecorded.
+type RoleRepository interface {
+	Get(id int) (*models.Role, error)
+	GetAll() ([]*models.Role, error)
+	GetByName(name string) (*models.Role, error)
+	// Creates the role, setting it as the target of the audit log
+	Create(role *models.Role, auditLog *models.AuditLog) error
+	Update(role *models.Role, auditLog *models.AuditLog) error
+	Delete(role *models.Role, auditLog *models.AuditLog) error
+	// Saves the role of the user and moves it to the next version, which is set on the user.
+	// Returns ErrNotFound if the user isn't stored.
+	Assign(ctx context.Context, user *models.User, auditLog *models.AuditLog) error
+}
+
+type AuditLogRepository interface {
+	Create(auditLog *models.AuditLog) error
+	GetByTargetTypes(targetTypes []string) ([]*models.AuditLog, error)
+}
+
+type OrganizationRepository interface {
+	Get(id uint) (*models.Organization, error)
+	GetByUser(userId uint) ([]*models.Organization, error)
+	Create(organization *models.Organization) error
+	Update(organization *models.Organization) error
+	Delete(organization *models.Organization) error
+}
+
+type MembershipRepository interface {
+	Get(organizationId uint, userId uint) (*models.Membership, error)
+	GetByOrganization(organizationId uint) ([]*models.Membership, error)
+	GetFirstByUser(userId uint) (*models.Membership, error)
+	CountByRole(organizationId uint, role models.MembershipRole) (int64, error)
+	CountByUser(userId uint) (int64, error)
+	Create(membership *models.Membership) error
+	Update(membership *models.Membership) error
+	Delete(membership *models.Membership) error
+}
+
+type OrganizationInvitationRepository interface {
+	Create(invitation *models.OrganizationInvitation) error
+	Update(invitation *models.OrganizationInvitation) error
+	Delete(invitation *models.OrganizationInvitation) error
+	Get(organizationId uint, id int) (*models.OrganizationInvitation, error)
+	GetPendingByOrganization(organizationId uint) ([]*models.OrganizationInvitation, error)
+	GetValid(tokenHash string) (*models.OrganizationInvitation, error)
+	MarkAccepted(invitation *models.OrganizationInvitation) (bool, error)
+}
+
+type UserInvitationRepository interface {
+	Get(id int) (*models.UserInvitation, error)
+	Create(invitation *models.UserInvitation) error
+	Update(invitation *models.UserInvitation) error
+	Delete(invitation *models.UserInvitation) error
+	GetPending() ([]*models.UserInvitation, error)
+	GetPendingByEmail(email string) (*models.UserInvitation, error)
+	GetValid(tokenHash string) (*models.UserInvitation, error)
+	MarkAccepted(invitation *models.UserInvitation) (bool, error)
+}
+
+// Every storage the services work with. They're interfaces, so that the services don't depend
+// on where the data is kept: in a database (see NewRepositories) or in memory (see NewMemoryRepositories).
+type Repositories struct {
+	Users                   UserRepository
+	Tokens                  TokenRepository
+	Sessions                SessionRepository
+	RecoveryCodes           RecoveryCodeRepository
+	PasswordResets          PasswordResetRepository
+	MFAChallenges           MFAChallengeRepository
+	OAuthClients            OAuthClientRepository
+	AuthorizationCodes      AuthorizationCodeRepository
+	PersonalAccessTokens    PersonalAccessTokenRepository
+	Roles                   RoleRepository
+	AuditLogs               AuditLogRepository
+	Organizations           OrganizationRepository
+	Memberships             MembershipRepository
+	OrganizationInvitations OrganizationInvitationRepository
+	UserInvitations         UserInvitationRepository
+}
+
+// Returns the storages backed by the given database, either Postgres or SQLite
+func NewRepositories(db *gorm.DB) *Repositories {
+	return &Repositories{
+		Users:                   NewUserStorage(db),
+		Tokens:                  NewTokenStorage(db),
+		Sessions:                NewSessionStorage(db),
+		RecoveryCodes:           NewRecoveryCodeStorage(db),
+		PasswordResets:          NewPasswordResetStorage(db),
+		MFAChallenges:           NewMFAChallengeStorage(db),
+		OAuthClients:            NewOAuthClientStorage(db),
+		AuthorizationCodes:      NewAuthorizationCodeStorage(db),
+		PersonalAccessTokens:    NewPersonalAccessTokenStorage(db),
+		Roles:                   NewRoleStorage(db),
+		AuditLogs:               NewAuditLogStorage(db),
+		Organizations:           NewOrganizationStorage(db),
+		Memberships:             NewMembershipStorage(db),
+		OrganizationInvitations: NewOrganizationInvitationStorage(db),
+		UserInvitations:         NewUserInvitationStorage(db),
+	}
+}
@@ -0,0 +1,273 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"gocker-api/models"
+	"gocker-api/storage"
+	"gocker-api/storage/storagetest"
+	"testing"
+	"time"
+)
+
+// Runs the same checks against the memory and SQLite storages, so that both behave like the database does
+func TestRepositories(t *testing.T) {
+	storagetest.ForEachBackend(t, testRepositories)
+}
+
+func testRepositories(t *testing.T, repositories *storage.Repositories) {
+	ctx := context.Background()
+
+	if roles, rolesErr := repositories.Roles.GetAll(); rolesErr != nil || len(roles) != 2 {
+		t.Fatalf("expected the 2 built-in roles and got %d (%v)", len(roles), rolesErr)
+	}
+
+	users := []*models.User{
+		{FirstName: "first", Email: "first@gmail.com", Role: models.Admin},
+		{FirstName: "second", Email: "second@gmail.com", Role: models.Standard},
+		{FirstName: "third", Email: "third@gmail.com", Role: models.Standard},
+	}
+
+	for _, user := range users {
+		if createErr := repositories.Users.Create(ctx, user); createErr != nil {
+			t.Fatal(createErr)
+		}
+	}
+
+	// emails are unique
+	if createErr := repositories.Users.Create(ctx, &models.User{FirstName: "copy", Email: "first@gmail.com"}); !errors.Is(createErr, storage.ErrConflict) {
+		t.Errorf("expected ErrConflict creating a user with a registered email and got %v", createErr)
+	}
+
+	duplicated := *users[2]
+	duplicated.Email = users[1].Email
+
+	if updateErr := repositories.Users.Update(ctx, &duplicated); !errors.Is(updateErr, storage.ErrConflict) {
+		t.Errorf("expected ErrConflict updating a user to a registered email and got %v", updateErr)
+	}
+
+	if _, getErr := repositories.Users.Get(ctx, 10000); !errors.Is(getErr, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a not existent user and got %v", getErr)
+	}
+
+	if count, countErr := repositories.Users.CountByRole(ctx, models.Standard); countErr != nil || count != 2 {
+		t.Errorf("expected 2 standard users and got %d (%v)", count, countErr)
+	}
+
+	listed, listErr := repositories.Users.List(ctx, storage.ListOptions{Filter: storage.Filter{"role": models.Standard}, Order: "first_name DESC", Limit: 1})
+
+	if listErr != nil || len(listed) != 1 || listed[0].ID != users[2].ID {
+		t.Errorf("expected to list the third user only and got %v (%v)", listed, listErr)
+	}
+
+	// only the members of an organization are seen through its scope
+	organization := &models.Organization{Name: "organization"}
+
+	if createErr := repositories.Organizations.Create(organization); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	membership := &models.Membership{OrganizationRefer: organization.ID, UserRefer: users[1].ID, Role: models.MemberMembership}
+
+	if createErr := repositories.Memberships.Create(membership); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	scoped := repositories.Users.ForOrganization(&organization.ID)
+
+	if members, membersErr := scoped.List(ctx, storage.ListOptions{}); membersErr != nil || len(members) != 1 || members[0].ID != users[1].ID {
+		t.Errorf("expected the second user to be the only member and got %v (%v)", members, membersErr)
+	}
+
+	if updateErr := scoped.Update(ctx, users[0]); !errors.Is(updateErr, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating a user out of the organization and got %v", updateErr)
+	}
+
+	// and nobody is seen without an organization
+	if members, membersErr := repositories.Users.ForOrganization(nil).List(ctx, storage.ListOptions{}); membersErr != nil || len(members) != 0 {
+		t.Errorf("expected no user to be seen without an organization and got %v (%v)", members, membersErr)
+	}
+
+	if count, countErr := repositories.Memberships.CountByUser(users[1].ID); countErr != nil || count != 1 {
+		t.Errorf("expected the second user to have 1 membership and got %d (%v)", count, countErr)
+	}
+
+	// a user read before an update can't be updated nor deleted, so that the update isn't overwritten
+	stale := *users[0]
+	users[0].FirstName = "updated"
+
+	if updateErr := repositories.Users.Update(ctx, users[0]); updateErr != nil {
+		t.Fatal(updateErr)
+	}
+
+	if updateErr := repositories.Users.Update(ctx, &stale); !errors.Is(updateErr, storage.ErrStaleVersion) {
+		t.Errorf("expected ErrStaleVersion updating a stale user and got %v", updateErr)
+	}
+
+	if deleteErr := repositories.Users.Delete(ctx, &stale); !errors.Is(deleteErr, storage.ErrStaleVersion) {
+		t.Errorf("expected ErrStaleVersion deleting a stale user and got %v", deleteErr)
+	}
+
+	if stored, _ := repositories.Users.Get(ctx, users[0].ID); stored == nil || stored.FirstName != "updated" || stored.Version != users[0].Version {
+		t.Errorf("expected the first update to be kept and got %v", stored)
+	}
+
+	// changes the API makes on its own only save their columns, whatever version the user was read at
+	stale = *users[0]
+	stale.FirstName = "not saved"
+	stale.EmailVerified = true
+
+	if updateErr := repositories.Users.UpdateColumns(ctx, &stale, "email_verified"); updateErr != nil || stale.Version != users[0].Version+1 {
+		t.Errorf("expected the columns of a stale user to be saved at the next version and got %d (%v)", stale.Version, updateErr)
+	}
+
+	if stored, _ := repositories.Users.Get(ctx, users[0].ID); stored == nil || stored.FirstName != "updated" || !stored.EmailVerified || stored.Version != stale.Version {
+		t.Errorf("expected only the given columns to be saved and got %v", stored)
+	}
+
+	if updateErr := repositories.Users.UpdateColumns(ctx, &models.User{ID: 10000}, "email_verified"); !errors.Is(updateErr, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating the columns of a not existent user and got %v", updateErr)
+	}
+
+	users[0], _ = repositories.Users.Get(ctx, users[0].ID)
+
+	// TOTP counters only move forward
+	if updated, updateErr := repositories.Users.UpdateTOTPCounter(ctx, users[0], 5); !updated || updateErr != nil {
+		t.Errorf("expected the TOTP counter to be updated (%v)", updateErr)
+	}
+
+	if updated, _ := repositories.Users.UpdateTOTPCounter(ctx, users[0], 5); updated {
+		t.Error("expected the TOTP counter not to be updated with the same value")
+	}
+
+	// two-factor authentication challenges can only be attempted a limited number of times
+	challenge := &models.MFAChallenge{UserRefer: users[0].ID, TokenHash: "challenge", ExpiresAt: time.Now().Add(time.Minute)}
+
+	if createErr := repositories.MFAChallenges.Create(challenge); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if counted, _ := repositories.MFAChallenges.AddAttempt(challenge, 2); counted != (attempt <= 2) {
+			t.Errorf("attempt %d: expected the attempt to be counted only within the limit", attempt)
+		}
+	}
+
+	if deleteErr := repositories.MFAChallenges.Delete(challenge); deleteErr != nil {
+		t.Fatal(deleteErr)
+	}
+
+	if deleteErr := repositories.MFAChallenges.Delete(challenge); !errors.Is(deleteErr, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting a deleted challenge and got %v", deleteErr)
+	}
+
+	// a legacy token only adopts a family while it has none and hasn't been used
+	legacyToken := &models.Token{TokenValue: "legacy", UserRefer: &users[0].ID, Kind: models.Refresh}
+
+	if createErr := repositories.Tokens.Create(ctx, legacyToken); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	if adopted, adoptErr := repositories.Tokens.AdoptFamily(ctx, legacyToken, "adopted"); !adopted || adoptErr != nil {
+		t.Errorf("expected the legacy token to adopt a family (%v)", adoptErr)
+	}
+
+	if adopted, _ := repositories.Tokens.AdoptFamily(ctx, &models.Token{ID: legacyToken.ID}, "other"); adopted {
+		t.Error("expected a token with a family not to adopt another one")
+	}
+
+	if used, _ := repositories.Tokens.MarkUsed(ctx, legacyToken); !used {
+		t.Error("expected the adopted token to be marked as used")
+	}
+
+	if stored, _ := repositories.Tokens.GetByValue(ctx, "legacy"); stored == nil || stored.Family != "adopted" || stored.UsedAt == nil {
+		t.Errorf("expected the token to keep its family once used and got %v", stored)
+	}
+
+	// role changes are stored along with their audit logs, and changes that fail leave no record
+	role := &models.Role{Name: "auditor", Permissions: []models.Permission{models.UsersReadPermission}}
+
+	if createErr := repositories.Roles.Create(role, &models.AuditLog{Action: "role.create", TargetType: "role"}); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	duplicate := &models.Role{Name: "auditor"}
+
+	if createErr := repositories.Roles.Create(duplicate, &models.AuditLog{Action: "role.create", TargetType: "role"}); !errors.Is(createErr, storage.ErrConflict) {
+		t.Errorf("expected ErrConflict creating a role with a taken name and got %v", createErr)
+	}
+
+	assigned := *users[2]
+	assigned.Role = role.ID
+
+	if assignErr := repositories.Roles.Assign(ctx, &assigned, &models.AuditLog{Action: "user.role.assign", TargetType: "user", TargetID: assigned.ID}); assignErr != nil || assigned.Version != users[2].Version+1 {
+		t.Errorf("expected the role to be assigned at the next version and got version %d (%v)", assigned.Version, assignErr)
+	}
+
+	if stored, _ := repositories.Users.Get(ctx, assigned.ID); stored == nil || stored.Role != role.ID {
+		t.Errorf("expected the user to have the assigned role and got %v", stored)
+	}
+
+	auditLogs, getErr := repositories.AuditLogs.GetByTargetTypes([]string{"role", "user"})
+
+	if getErr != nil || len(auditLogs) != 2 {
+		t.Errorf("expected 2 audit logs and got %d (%v)", len(auditLogs), getErr)
+	}
+
+	for _, auditLog := range auditLogs {
+		if auditLog.Action == "role.create" && auditLog.TargetID != uint(role.ID) {
+			t.Errorf("expected the audit log of the creation to target the new role and got %v", auditLog)
+		}
+	}
+
+	// deleting a user deletes its tokens, sessions and memberships
+	token := &models.Token{TokenValue: "token", UserRefer: &users[1].ID, Kind: models.Access, Family: "family"}
+
+	if createErr := repositories.Tokens.Create(ctx, token); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	if createErr := repositories.Sessions.Create(&models.Session{UserRefer: users[1].ID, Family: "family"}); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	if deleteErr := repositories.Users.Delete(ctx, users[1]); deleteErr != nil {
+		t.Fatal(deleteErr)
+	}
+
+	if _, getErr := repositories.Tokens.GetByValue(ctx, "token"); !errors.Is(getErr, storage.ErrNotFound) {
+		t.Errorf("expected the token to be deleted along with its user and got %v", getErr)
+	}
+
+	if _, getErr := repositories.Sessions.GetByFamily("family"); !errors.Is(getErr, storage.ErrNotFound) {
+		t.Errorf("expected the session to be deleted along with its user and got %v", getErr)
+	}
+
+	if _, getErr := repositories.Memberships.Get(organization.ID, users[1].ID); !errors.Is(getErr, storage.ErrNotFound) {
+		t.Errorf("expected the membership to be deleted along with its user and got %v", getErr)
+	}
+
+	if deleteErr := repositories.Users.Delete(ctx, users[1]); !errors.Is(deleteErr, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting a deleted user and got %v", deleteErr)
+	}
+
+	// every storage tells that something is missing with storage.ErrNotFound, so that services can tell it from a failure
+	notFoundChecks := map[string]func() error{
+		"organization": func() error { _, err := repositories.Organizations.Get(10000); return err },
+		"role":         func() error { _, err := repositories.Roles.Get(10000); return err },
+		"session":      func() error { _, err := repositories.Sessions.Get(10000); return err },
+		"oauth client": func() error { _, err := repositories.OAuthClients.Get(10000); return err },
+		"personal access token": func() error {
+			_, err := repositories.PersonalAccessTokens.GetByUserAndId(users[0].ID, 10000)
+			return err
+		},
+		"organization invitation": func() error { _, err := repositories.OrganizationInvitations.Get(organization.ID, 10000); return err },
+		"user invitation":         func() error { _, err := repositories.UserInvitations.Get(10000); return err },
+	}
+
+	for name, check := range notFoundChecks {
+		if err := check(); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected ErrNotFound for a not existent %s and got %v", name, err)
+		}
+	}
+}
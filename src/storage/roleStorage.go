@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"gocker-api/models"
+
+	"gorm.io/gorm"
+)
+
+type RoleStorage struct {
+	db *gorm.DB
+}
+
+func NewRoleStorage(db *gorm.DB) *RoleStorage {
+	return &RoleStorage{db: db}
+}
+
+func (roleStorage *RoleStorage) Get(id int) (*models.Role, error) {
+	var role *models.Role
+	database := roleStorage.db
+
+	if result := database.Find(&role, "id = ?", id); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return role, nil
+}
+
+// Returns all roles, the built-in ones first
+func (roleStorage *RoleStorage) GetAll() ([]*models.Role, error) {
+	var roles []*models.Role
+	database := roleStorage.db
+
+	if result := database.Order("id").Find(&roles); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return roles, nil
+}
+
+func (roleStorage *RoleStorage) GetByName(name string) (*models.Role, error) {
+	var role *models.Role
+	database := roleStorage.db
+
+	if result := database.Find(&role, "name = ?", name); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return role, nil
+}
+
+func (roleStorage *RoleStorage) Create(role *models.Role, auditLog *models.AuditLog) error {
+	database := roleStorage.db
+
+	return database.Transaction(func(tx *gorm.DB) error {
+		if createErr := tx.Create(role).Error; createErr != nil {
+			return translateError(createErr)
+		}
+
+		auditLog.TargetID = uint(role.ID)
+
+		return translateError(tx.Create(auditLog).Error)
+	})
+}
+
+func (roleStorage *RoleStorage) Update(role *models.Role, auditLog *models.AuditLog) error {
+	database := roleStorage.db
+
+	return database.Transaction(func(tx *gorm.DB) error {
+		if saveErr := tx.Save(role).Error; saveErr != nil {
+			return translateError(saveErr)
+		}
+
+		return translateError(tx.Create(auditLog).Error)
+	})
+}
+
+func (roleStorage *RoleStorage) Delete(role *models.Role, auditLog *models.AuditLog) error {
+	database := roleStorage.db
+
+	return database.Transaction(func(tx *gorm.DB) error {
+		if deleteErr := tx.Delete(role).Error; deleteErr != nil {
+			return translateError(deleteErr)
+		}
+
+		return translateError(tx.Create(auditLog).Error)
+	})
+}
+
+func (roleStorage *RoleStorage) Assign(ctx context.Context, user *models.User, auditLog *models.AuditLog) error {
+	database := roleStorage.db.WithContext(ctx)
+
+	return database.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("id = ?", user.ID).
+			Updates(map[string]interface{}{"role": user.Role, "version": gorm.Expr("version + 1")})
+
+		if result.Error != nil {
+			return translateError(result.Error)
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		if scanErr := tx.Model(&models.User{}).Where("id = ?", user.ID).Select("version").Scan(&user.Version).Error; scanErr != nil {
+			return translateError(scanErr)
+		}
+
+		return translateError(tx.Create(auditLog).Error)
+	})
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"errors"
+	"gocker-api/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const sessionTypeMismatchErr = "type must be session"
+
+type SessionStorage struct {
+	db *gorm.DB
+}
+
+func NewSessionStorage(db *gorm.DB) *SessionStorage {
+	return &SessionStorage{db: db}
+}
+
+func (sessionStorage *SessionStorage) Get(id int) (interface{}, error) {
+	var session *models.Session
+	database := sessionStorage.db
+
+	if result := database.Find(&session, "id = ?", id); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return session, nil
+}
+
+func (sessionStorage *SessionStorage) Create(item interface{}) error {
+	session, ok := item.(*models.Session)
+
+	if !ok {
+		return errors.New(sessionTypeMismatchErr)
+	}
+
+	database := sessionStorage.db
+
+	return translateError(database.Create(session).Error)
+}
+
+func (sessionStorage *SessionStorage) Update(item interface{}) error {
+	session, ok := item.(*models.Session)
+
+	if !ok {
+		return errors.New(sessionTypeMismatchErr)
+	}
+
+	database := sessionStorage.db
+
+	return translateError(database.Save(session).Error)
+}
+
+func (sessionStorage *SessionStorage) Delete(item interface{}) error {
+	session, ok := item.(*models.Session)
+
+	if !ok {
+		return errors.New(sessionTypeMismatchErr)
+	}
+
+	database := sessionStorage.db
+
+	return translateError(database.Delete(session).Error)
+}
+
+// Returns the session of the given token family
+func (sessionStorage *SessionStorage) GetByFamily(family string) (*models.Session, error) {
+	var session *models.Session
+	database := sessionStorage.db
+
+	if result := database.Find(&session, "family = ?", family); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return session, nil
+}
+
+// Returns all sessions of the given user, the most recently used first
+func (sessionStorage *SessionStorage) GetByUser(userId uint) ([]*models.Session, error) {
+	var sessions []*models.Session
+	database := sessionStorage.db
+
+	if result := database.Order("last_used_at DESC").Find(&sessions, "user_refer = ?", userId); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return sessions, nil
+}
+
+// Updates the last used time of a session, only if it's older than the given threshold,
+// so that authenticated requests don't write to the database every time.
+func (sessionStorage *SessionStorage) Touch(family string, threshold time.Duration) error {
+	now := time.Now()
+	database := sessionStorage.db
+
+	return database.Model(&models.Session{}).
+		Where("family = ? AND last_used_at < ?", family, now.Add(-threshold)).
+		Update("last_used_at", now).Error
+}
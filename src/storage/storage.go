@@ -1,8 +1,320 @@
 package storage
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gocker-api/utils"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotFound = utils.NewError(utils.KindNotFound, "not_found", "record not found")
+	ErrConflict = utils.NewError(utils.KindConflict, "conflict", "record conflicts with an existing one")
+	// The record has been changed since it was read, so it was neither updated nor deleted
+	ErrStaleVersion = utils.NewError(utils.KindPreconditionFailed, "stale_version", "record has been changed since it was read")
+)
+
+// Storage of records of type T. Every operation takes the context of the work it's done for,
+// so that the query is cancelled along with it (e.g. when the client disconnects or times out).
+type Repository[T any] interface {
+	// Returns the record with the given id, or ErrNotFound
+	Get(ctx context.Context, id uint) (*T, error)
+	// Returns the first record matching the filter, or ErrNotFound
+	FindBy(ctx context.Context, filter Filter) (*T, error)
+	List(ctx context.Context, options ListOptions) ([]*T, error)
+	// Returns how many records match the filter and every condition
+	Count(ctx context.Context, filter Filter, conditions ...Condition) (int64, error)
+	// Creates the record, or returns ErrConflict if it breaks a unique constraint
+	Create(ctx context.Context, item *T) error
+	// Saves every field of an existing record, or returns ErrNotFound
+	Update(ctx context.Context, item *T) error
+	// Deletes an existing record, or returns ErrNotFound
+	Delete(ctx context.Context, item *T) error
+}
+
+// Values the columns of the records must be equal to
+type Filter map[string]interface{}
+
+// Comparison a column of the records must satisfy, e.g. {"created_at", ">=", date}
+type Condition struct {
+	Column   string
+	Operator string
+	Value    interface{}
+}
+
+type ListOptions struct {
+	Filter     Filter
+	Conditions []Condition
+	// Order clause, e.g. "created_at DESC, id"
+	Order string
+	// Values of the Order columns of a record. If set, only the records that go after it in that order
+	// are listed, which allows paginating with a cursor instead of an offset. The Order must be unique
+	// (e.g. end with the id) for no record to be skipped.
+	After []interface{}
+	// Maximum number of records, no limit if it's 0
+	Limit  int
+	Offset int
+}
+
+// Column of an order clause
+type OrderColumn struct {
+	Column     string
+	Descending bool
+}
+
+var columnPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+var conditionOperators = map[string]bool{"=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// Function that parses an order clause like "created_at DESC, id"
+func ParseOrder(order string) ([]OrderColumn, error) {
+	columns := make([]OrderColumn, 0)
+
+	if strings.TrimSpace(order) == "" {
+		return columns, nil
+	}
+
+	for _, part := range strings.Split(order, ",") {
+		words := strings.Fields(part)
+
+		if len(words) == 0 || len(words) > 2 || !columnPattern.MatchString(words[0]) {
+			return nil, fmt.Errorf("invalid order %q", order)
+		}
+
+		column := OrderColumn{Column: words[0]}
+
+		if len(words) == 2 {
+			switch strings.ToUpper(words[1]) {
+			case "ASC":
+			case "DESC":
+				column.Descending = true
+			default:
+				return nil, fmt.Errorf("invalid order %q", order)
+			}
+		}
+
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+// Deprecated: storages that still implement it are being ported to Repository
 type Storage interface {
 	Get(int) (interface{}, error)
 	Create(interface{}) error
 	Update(interface{}) error
 	Delete(interface{}) error
 }
+
+// Repository backed by gorm. If scope is set, every query goes through it, so it only sees
+// the records the scope allows.
+type GormRepository[T any] struct {
+	db    *gorm.DB
+	scope func(db *gorm.DB) *gorm.DB
+}
+
+func NewGormRepository[T any](db *gorm.DB, scope func(db *gorm.DB) *gorm.DB) *GormRepository[T] {
+	return &GormRepository[T]{db: db, scope: scope}
+}
+
+func (repository *GormRepository[T]) Get(ctx context.Context, id uint) (*T, error) {
+	var item T
+
+	if result := repository.query(ctx).First(&item, id); result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+
+	return &item, nil
+}
+
+func (repository *GormRepository[T]) FindBy(ctx context.Context, filter Filter) (*T, error) {
+	var item T
+
+	if result := repository.query(ctx).Where(map[string]interface{}(filter)).First(&item); result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+
+	return &item, nil
+}
+
+func (repository *GormRepository[T]) List(ctx context.Context, options ListOptions) ([]*T, error) {
+	var items []*T
+	query := repository.query(ctx)
+
+	if len(options.Filter) > 0 {
+		query = query.Where(map[string]interface{}(options.Filter))
+	}
+
+	query, conditionsErr := whereConditions(query, options.Conditions)
+
+	if conditionsErr != nil {
+		return nil, conditionsErr
+	}
+
+	if options.After != nil {
+		afterQuery, afterErr := whereAfter(query, options.Order, options.After)
+
+		if afterErr != nil {
+			return nil, afterErr
+		}
+
+		query = afterQuery
+	}
+
+	if options.Order != "" {
+		query = query.Order(options.Order)
+	}
+
+	if options.Limit > 0 {
+		query = query.Limit(options.Limit)
+	}
+
+	if options.Offset > 0 {
+		query = query.Offset(options.Offset)
+	}
+
+	if result := query.Find(&items); result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+
+	return items, nil
+}
+
+func (repository *GormRepository[T]) Count(ctx context.Context, filter Filter, conditions ...Condition) (int64, error) {
+	var count int64
+	query := repository.query(ctx).Model(new(T))
+
+	if len(filter) > 0 {
+		query = query.Where(map[string]interface{}(filter))
+	}
+
+	query, conditionsErr := whereConditions(query, conditions)
+
+	if conditionsErr != nil {
+		return 0, conditionsErr
+	}
+
+	if result := query.Count(&count); result.Error != nil {
+		return 0, translateError(result.Error)
+	}
+
+	return count, nil
+}
+
+func (repository *GormRepository[T]) Create(ctx context.Context, item *T) error {
+	return translateError(repository.db.WithContext(ctx).Omit(clause.Associations).Create(item).Error)
+}
+
+func (repository *GormRepository[T]) Update(ctx context.Context, item *T) error {
+	// Unlike Save, it never inserts the record if it doesn't exist (or the scope doesn't see it)
+	result := repository.query(ctx).Model(item).Select("*").Omit(clause.Associations).Updates(item)
+
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (repository *GormRepository[T]) Delete(ctx context.Context, item *T) error {
+	result := repository.query(ctx).Delete(item)
+
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// AUX FUNCTIONS
+
+// Returns a query bound to the context and filtered by the scope of the repository, if it has one
+func (repository *GormRepository[T]) query(ctx context.Context) *gorm.DB {
+	query := repository.db.WithContext(ctx)
+
+	if repository.scope == nil {
+		return query
+	}
+
+	return repository.scope(query)
+}
+
+// Function that adds the conditions to the query. Columns and operators can't be bound as values,
+// so they're checked before being written in the SQL.
+func whereConditions(query *gorm.DB, conditions []Condition) (*gorm.DB, error) {
+	for _, condition := range conditions {
+		if !columnPattern.MatchString(condition.Column) || !conditionOperators[condition.Operator] {
+			return nil, fmt.Errorf("invalid condition %s %s", condition.Column, condition.Operator)
+		}
+
+		query = query.Where(condition.Column+" "+condition.Operator+" ?", condition.Value)
+	}
+
+	return query, nil
+}
+
+// Function that limits the query to the records after the one with the given values of the order columns,
+// e.g. for "created_at DESC, id": created_at < ? OR (created_at = ? AND id > ?)
+func whereAfter(query *gorm.DB, order string, after []interface{}) (*gorm.DB, error) {
+	columns, parseErr := ParseOrder(order)
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	if len(columns) == 0 || len(columns) != len(after) {
+		return nil, errors.New("the values to list after must match the order columns")
+	}
+
+	alternatives := make([]string, 0, len(columns))
+	values := make([]interface{}, 0)
+
+	for i, column := range columns {
+		comparisons := make([]string, 0, i+1)
+
+		for j := 0; j < i; j++ {
+			comparisons = append(comparisons, columns[j].Column+" = ?")
+			values = append(values, after[j])
+		}
+
+		operator := ">"
+
+		if column.Descending {
+			operator = "<"
+		}
+
+		comparisons = append(comparisons, column.Column+" "+operator+" ?")
+		values = append(values, after[i])
+		alternatives = append(alternatives, "("+strings.Join(comparisons, " AND ")+")")
+	}
+
+	return query.Where("("+strings.Join(alternatives, " OR ")+")", values...), nil
+}
+
+// Function that turns the errors of gorm into the ones of the package, so that callers don't depend on it
+func translateError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrConflict
+	default:
+		return err
+	}
+}
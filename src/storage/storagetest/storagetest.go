@@ -0,0 +1,43 @@
+// Package storagetest holds the storage backends tests run against. None of them needs a database server,
+// so the tests of every package that uses them run with a plain `go test ./...`.
+package storagetest
+
+import (
+	"gocker-api/database"
+	"gocker-api/storage"
+	"testing"
+)
+
+type Backend struct {
+	Name string
+	// Returns the storages of a new, empty store
+	Repositories func(t *testing.T) *storage.Repositories
+}
+
+var Backends = []Backend{
+	{"memory", func(t *testing.T) *storage.Repositories {
+		return storage.NewMemoryRepositories()
+	}},
+	{"sqlite", func(t *testing.T) *storage.Repositories {
+		db, dbErr := database.Open("sqlite", "file::memory:")
+
+		if dbErr != nil {
+			t.Fatal(dbErr)
+		}
+
+		if _, migrateErr := database.MigrateUp(db); migrateErr != nil {
+			t.Fatal(migrateErr)
+		}
+
+		return storage.NewRepositories(db)
+	}},
+}
+
+// Runs the test once for every backend, with an empty store of its own
+func ForEachBackend(t *testing.T, test func(t *testing.T, repositories *storage.Repositories)) {
+	for _, backend := range Backends {
+		t.Run(backend.Name, func(t *testing.T) {
+			test(t, backend.Repositories(t))
+		})
+	}
+}
@@ -1,58 +1,85 @@
 package storage
 
 import (
-	"errors"
-	"gocker-api/database"
+	"context"
 	"gocker-api/models"
+	"time"
+
+	"gorm.io/gorm"
 )
 
-const tokenTypeMismatchErr = "type must be token"
+type TokenStorage struct {
+	*GormRepository[models.Token]
+	db *gorm.DB
+}
 
-type TokenStorage struct{}
+var _ TokenRepository = (*TokenStorage)(nil)
 
-func (tokenStorage *TokenStorage) Get(id int) (interface{}, error) {
-	var token *models.Token
-	database := database.GetInstance().GetDB()
+func NewTokenStorage(db *gorm.DB) *TokenStorage {
+	return &TokenStorage{GormRepository: NewGormRepository[models.Token](db, nil), db: db}
+}
 
-	if result := database.Find(&token, "id = ?", id); result.RowsAffected == 0 {
-		return nil, errors.New("token not found")
-	}
+// Returns the token with the given value
+func (tokenStorage *TokenStorage) GetByValue(ctx context.Context, value string) (*models.Token, error) {
+	return tokenStorage.FindBy(ctx, Filter{"token_value": value})
+}
 
-	return token, nil
+// Returns all tokens that belong to the given family
+func (tokenStorage *TokenStorage) GetByFamily(ctx context.Context, family string) ([]*models.Token, error) {
+	return tokenStorage.List(ctx, ListOptions{Filter: Filter{"family": family}})
 }
 
-func (tokenStorage *TokenStorage) Create(item interface{}) error {
-	token, ok := item.(*models.Token)
+// Returns all tokens of the given user
+func (tokenStorage *TokenStorage) GetByUser(ctx context.Context, userId uint) ([]*models.Token, error) {
+	return tokenStorage.List(ctx, ListOptions{Filter: Filter{"user_refer": userId}})
+}
 
-	if !ok {
-		return errors.New(tokenTypeMismatchErr)
-	}
+// Deletes all tokens that belong to the given family
+func (tokenStorage *TokenStorage) DeleteByFamily(ctx context.Context, family string) error {
+	database := tokenStorage.db.WithContext(ctx)
 
-	database := database.GetInstance().GetDB()
-	database.Create(&token)
-	return nil
+	return translateError(database.Where("family = ?", family).Delete(&models.Token{}).Error)
 }
 
-func (tokenStorage *TokenStorage) Update(item interface{}) error {
-	token, ok := item.(*models.Token)
+// Marks the token as used, only if it had not been used before. Returns false if it had,
+// so that two concurrent calls can never both use the same token.
+func (tokenStorage *TokenStorage) MarkUsed(ctx context.Context, token *models.Token) (bool, error) {
+	now := time.Now()
+	database := tokenStorage.db.WithContext(ctx)
+	result := database.Model(&models.Token{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", now)
 
-	if !ok {
-		return errors.New(tokenTypeMismatchErr)
+	if result.Error != nil {
+		return false, translateError(result.Error)
 	}
 
-	database := database.GetInstance().GetDB()
-	database.Save(&token)
-	return nil
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	token.UsedAt = &now
+
+	return true, nil
 }
 
-func (tokenStorage *TokenStorage) Delete(item interface{}) error {
-	token, ok := item.(*models.Token)
+// Sets the family of a token issued before families existed, only if it still has none and hasn't been used.
+// Returns false otherwise, so that a concurrent refresh of the same token can never be overwritten.
+func (tokenStorage *TokenStorage) AdoptFamily(ctx context.Context, token *models.Token, family string) (bool, error) {
+	database := tokenStorage.db.WithContext(ctx)
+	result := database.Model(&models.Token{}).
+		Where("id = ? AND family = '' AND used_at IS NULL", token.ID).
+		Update("family", family)
 
-	if !ok {
-		return errors.New(tokenTypeMismatchErr)
+	if result.Error != nil {
+		return false, translateError(result.Error)
 	}
 
-	database := database.GetInstance().GetDB()
-	database.Delete(token)
-	return nil
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	token.Family = family
+
+	return true, nil
 }
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"gocker-api/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserInvitationStorage struct {
+	db *gorm.DB
+}
+
+func NewUserInvitationStorage(db *gorm.DB) *UserInvitationStorage {
+	return &UserInvitationStorage{db: db}
+}
+
+func (invitationStorage *UserInvitationStorage) Get(id int) (*models.UserInvitation, error) {
+	var invitation *models.UserInvitation
+	database := invitationStorage.db
+
+	if result := database.Find(&invitation, "id = ?", id); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+func (invitationStorage *UserInvitationStorage) Create(invitation *models.UserInvitation) error {
+	database := invitationStorage.db
+
+	return translateError(database.Create(invitation).Error)
+}
+
+func (invitationStorage *UserInvitationStorage) Update(invitation *models.UserInvitation) error {
+	database := invitationStorage.db
+
+	return translateError(database.Save(invitation).Error)
+}
+
+func (invitationStorage *UserInvitationStorage) Delete(invitation *models.UserInvitation) error {
+	database := invitationStorage.db
+
+	return translateError(database.Delete(invitation).Error)
+}
+
+// Returns the invitations that have not been accepted yet, whether they have expired or not, the newest first
+func (invitationStorage *UserInvitationStorage) GetPending() ([]*models.UserInvitation, error) {
+	var invitations []*models.UserInvitation
+	database := invitationStorage.db
+	result := database.Order("created_at DESC").Find(&invitations, "accepted_at IS NULL")
+
+	return invitations, result.Error
+}
+
+// Returns the invitation sent to the email that has not been accepted yet, if there's one
+func (invitationStorage *UserInvitationStorage) GetPendingByEmail(email string) (*models.UserInvitation, error) {
+	var invitation *models.UserInvitation
+	database := invitationStorage.db
+
+	if result := database.Find(&invitation, "email LIKE ? AND accepted_at IS NULL", email); result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+// Returns the not accepted and not expired invitation with the given token hash
+func (invitationStorage *UserInvitationStorage) GetValid(tokenHash string) (*models.UserInvitation, error) {
+	var invitation *models.UserInvitation
+	database := invitationStorage.db
+	result := database.Find(&invitation, "token_hash = ? AND accepted_at IS NULL AND expires_at > ?", tokenHash, time.Now())
+
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return invitation, nil
+}
+
+// Marks the invitation as accepted, only if it had not been accepted before. Returns false if it had.
+func (invitationStorage *UserInvitationStorage) MarkAccepted(invitation *models.UserInvitation) (bool, error) {
+	now := time.Now()
+	database := invitationStorage.db
+	result := database.Model(&models.UserInvitation{}).
+		Where("id = ? AND accepted_at IS NULL", invitation.ID).
+		Update("accepted_at", now)
+
+	if result.Error != nil {
+		return false, translateError(result.Error)
+	}
+
+	if result.RowsAffected == 1 {
+		invitation.AcceptedAt = &now
+	}
+
+	return result.RowsAffected == 1, nil
+}
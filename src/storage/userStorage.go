@@ -1,58 +1,184 @@
 package storage
 
 import (
+	"context"
 	"errors"
-	"gocker-api/database"
+	"fmt"
 	"gocker-api/models"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 )
 
-type UserStorage struct{}
+// Storage of users. The storages built by ForOrganization only see the members of its organization (OrganizationID),
+// or no user without one, so that a tenant can never read or change the users of another one.
+// The storage built by NewUserStorage sees every user, which authentication needs since users are shared by organizations.
+type UserStorage struct {
+	*GormRepository[models.User]
+	db             *gorm.DB
+	OrganizationID *uint
+}
+
+var _ UserRepository = (*UserStorage)(nil)
+
+var userSchemas sync.Map
+
+func NewUserStorage(db *gorm.DB) *UserStorage {
+	return &UserStorage{GormRepository: NewGormRepository[models.User](db, nil), db: db}
+}
+
+// Returns a storage on the same database that only sees the members of the given organization,
+// or no user if it's nil
+func (userStorage *UserStorage) ForOrganization(organizationId *uint) UserRepository {
+	if organizationId == nil {
+		return &UserStorage{GormRepository: NewGormRepository[models.User](userStorage.db, func(db *gorm.DB) *gorm.DB {
+			return db.Where("1 = 0")
+		}), db: userStorage.db}
+	}
 
-const userTypeMismatchErr = "must be type user"
+	scope := func(db *gorm.DB) *gorm.DB {
+		return db.Where("users.id IN (?)", db.Session(&gorm.Session{NewDB: true}).Model(&models.Membership{}).
+			Select("user_refer").
+			Where("organization_refer = ?", *organizationId))
+	}
 
-func (userStorage *UserStorage) Get(id int) (interface{}, error) {
-	var user *models.User
-	database := database.GetInstance().GetDB()
-	if result := database.First(&user, "id = ?", id); result.RowsAffected == 0 {
-		return nil, errors.New("user not found")
+	return &UserStorage{
+		GormRepository: NewGormRepository[models.User](userStorage.db, scope),
+		db:             userStorage.db,
+		OrganizationID: organizationId,
 	}
-	return user, nil
 }
 
-func (userStorage *UserStorage) Create(item interface{}) error {
-	user, ok := item.(*models.User)
+func (userStorage *UserStorage) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return userStorage.FindBy(ctx, Filter{"email": email})
+}
+
+// Updates every field of the user, only if it's still at the version it was read at, and moves it to the next
+// one. Returns ErrStaleVersion if it has been changed since, so that the change isn't overwritten.
+func (userStorage *UserStorage) Update(ctx context.Context, user *models.User) error {
+	updated := *user
+	updated.Version++
+
+	result := userStorage.query(ctx).Model(&updated).Where("version = ?", user.Version).
+		Select("*").Omit(clause.Associations).Updates(&updated)
 
-	if !ok {
-		return errors.New(userTypeMismatchErr)
+	if result.Error != nil {
+		return translateError(result.Error)
 	}
 
-	database := database.GetInstance().GetDB()
-	database.Create(user)
+	if result.RowsAffected == 0 {
+		return userStorage.missingOrStale(ctx, user)
+	}
+
+	user.Version = updated.Version
 
 	return nil
 }
 
-func (userStorage *UserStorage) Update(item interface{}) error {
-	user, ok := item.(*models.User)
+// Deletes the user, only if it's still at the version it was read at. Returns ErrStaleVersion if it has been changed since.
+func (userStorage *UserStorage) Delete(ctx context.Context, user *models.User) error {
+	result := userStorage.query(ctx).Where("version = ?", user.Version).Delete(user)
+
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
 
-	if !ok {
-		return errors.New(userTypeMismatchErr)
+	if result.RowsAffected == 0 {
+		return userStorage.missingOrStale(ctx, user)
 	}
 
-	database := database.GetInstance().GetDB()
-	database.Save(user)
 	return nil
 }
 
-func (userStorage *UserStorage) Delete(item interface{}) error {
-	user, ok := item.(*models.User)
+// Saves only the given columns of the user, without checking its version, and moves it to the next one,
+// which is set on the user. Returns ErrNotFound if it's not stored (or the scope doesn't see it).
+func (userStorage *UserStorage) UpdateColumns(ctx context.Context, user *models.User, columns ...string) error {
+	values, valuesErr := userStorage.columnValues(user, columns)
 
-	if !ok {
-		return errors.New(userTypeMismatchErr)
+	if valuesErr != nil {
+		return valuesErr
 	}
 
-	database := database.GetInstance().GetDB()
-	database.Delete(user)
+	values["version"] = gorm.Expr("version + 1")
 
-	return nil
+	return userStorage.query(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(values)
+
+		if result.Error != nil {
+			return translateError(result.Error)
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return translateError(tx.Model(&models.User{}).Where("id = ?", user.ID).Select("version").Scan(&user.Version).Error)
+	})
+}
+
+// Saves the counter of the last TOTP code used by the user, only if it's greater than the
+// previous one. Returns false if it's not, which means the code has already been used.
+func (userStorage *UserStorage) UpdateTOTPCounter(ctx context.Context, user *models.User, counter int64) (bool, error) {
+	result := userStorage.query(ctx).Model(&models.User{}).
+		Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+		Update("totp_last_counter", counter)
+
+	if result.Error != nil {
+		return false, translateError(result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	user.TOTPLastCounter = counter
+
+	return true, nil
+}
+
+// Returns how many users have the given role
+func (userStorage *UserStorage) CountByRole(ctx context.Context, role models.UserRole) (int64, error) {
+	return userStorage.Count(ctx, Filter{"role": role})
+}
+
+// AUX FUNCTIONS
+
+// Returns the values the user has in the given columns
+func (userStorage *UserStorage) columnValues(user *models.User, columns []string) (map[string]interface{}, error) {
+	userSchema, parseErr := schema.Parse(user, &userSchemas, userStorage.db.NamingStrategy)
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	values := map[string]interface{}{}
+
+	for _, column := range columns {
+		field := userSchema.LookUpField(column)
+
+		if field == nil {
+			return nil, fmt.Errorf("unknown column %s", column)
+		}
+
+		values[field.DBName], _ = field.ValueOf(context.Background(), reflect.ValueOf(user).Elem())
+	}
+
+	return values, nil
+}
+
+// Function that tells why a user wasn't updated or deleted: either it's not there (or the scope doesn't see it)
+// or it's at another version
+func (userStorage *UserStorage) missingOrStale(ctx context.Context, user *models.User) error {
+	if _, getErr := userStorage.Get(ctx, user.ID); getErr != nil {
+		if errors.Is(getErr, ErrNotFound) {
+			return ErrNotFound
+		}
+
+		return getErr
+	}
+
+	return ErrStaleVersion
 }
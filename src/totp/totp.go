@@ -0,0 +1,101 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters used by every authenticator app: SHA1, 6 digits and 30 seconds periods
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// Number of periods accepted before and after the current one, to allow for clock drift
+	Skew = 1
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returns a new random secret, base32 encoded
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+
+	if _, readErr := rand.Read(secret); readErr != nil {
+		return "", readErr
+	}
+
+	return secretEncoding.EncodeToString(secret), nil
+}
+
+// Returns the otpauth:// URI authenticator apps read (usually as a QR code) to enroll the secret
+func URI(issuer string, account string, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(Digits))
+	values.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + account)
+
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// Returns the counter of the period the given time belongs to
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Returns the code of the given counter, as described in RFC 4226
+func Code(secret string, counter int64, digits int) (string, error) {
+	key, decodeErr := secretEncoding.DecodeString(strings.ToUpper(secret))
+
+	if decodeErr != nil {
+		return "", decodeErr
+	}
+
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%modulo), nil
+}
+
+// Validates a code at the given time, returning the counter it matched so that callers can
+// reject codes whose counter is not greater than the last one used.
+func Validate(secret string, code string, t time.Time) (int64, bool) {
+	current := Counter(t)
+
+	for counter := current - Skew; counter <= current+Skew; counter++ {
+		expected, codeErr := Code(secret, counter, Digits)
+
+		if codeErr != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
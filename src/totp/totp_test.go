@@ -0,0 +1,64 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B test vectors for SHA1
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	var tests = []struct {
+		unixTime int64
+		expected string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, test := range tests {
+		code, err := Code(secret, Counter(time.Unix(test.unixTime, 0)), 8)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code != test.expected {
+			t.Errorf("wrong code at %d. expected %s and got %s", test.unixTime, test.expected, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, _ := GenerateSecret()
+	now := time.Now()
+	code, _ := Code(secret, Counter(now), Digits)
+	oldCode, _ := Code(secret, Counter(now.Add(-5*Period)), Digits)
+
+	var tests = []struct {
+		code     string
+		at       time.Time
+		expected bool
+	}{
+		// test the current code
+		{code, now, true},
+		// test a code of the previous period, within the allowed skew
+		{code, now.Add(Period), true},
+		// test a code too old
+		{oldCode, now, false},
+		// test a malformed code
+		{"abcdef", now, false},
+	}
+
+	for _, test := range tests {
+		if _, valid := Validate(secret, test.code, test.at); valid != test.expected {
+			t.Errorf("wrong validation result for code %s. expected %t and got %t", test.code, test.expected, valid)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Kind of an error, which tells how it's reported. Services return errors of a kind rather than status codes,
+// and the API responds to each kind with its own status code.
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindInvalid
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindPreconditionFailed
+	KindUnsupported
+	KindUnprocessable
+	KindPreconditionRequired
+)
+
+var kindStatuses = map[ErrorKind]int{
+	KindInternal:             500,
+	KindInvalid:              400,
+	KindUnauthorized:         401,
+	KindForbidden:            403,
+	KindNotFound:             404,
+	KindConflict:             409,
+	KindPreconditionFailed:   412,
+	KindUnsupported:          415,
+	KindUnprocessable:        422,
+	KindPreconditionRequired: 428,
+}
+
+// Codes of the errors that aren't specific to a resource
+const (
+	CodeInternal         = "internal_error"
+	CodeInvalidJSON      = "invalid_json"
+	CodeValidationFailed = "validation_failed"
+)
+
+// Error that clients can program against: its kind, a machine-readable code that never changes for the
+// same error, a message for people and, for validation errors, the error of every field
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+// Error of a field of the body: its json path, the rule it breaks, the parameter of the rule if it has one
+// (e.g. 8 for min=8) and a message, translated to the language of the request when it's written out
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+
+	validationErr validator.FieldError
+}
+
+// Problem details of an error response (RFC 7807), served as application/problem+json
+type Problem struct {
+	// URI of the documentation of the problem, or about:blank if there's none
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Machine-readable code of the error, which never changes
+	Code   string       `json:"code"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+var ErrInvalidJSON = NewError(KindInvalid, CodeInvalidJSON, "not valid json.")
+
+func NewError(kind ErrorKind, code string, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+// Errors with the same code are the same error, even if one of them is worded differently or has the
+// errors of its fields, so errors.Is matches them
+func (err *Error) Is(target error) bool {
+	targetErr, ok := target.(*Error)
+
+	return ok && targetErr.Code == err.Code
+}
+
+// Function that returns the status code the API responds to the error with
+func (err *Error) Status() int {
+	if status, ok := kindStatuses[err.Kind]; ok {
+		return status
+	}
+
+	return 500
+}
+
+// Function that writes out an error as problem details, with the status code of its kind.
+// Errors that aren't an *Error are unexpected, so they're logged and hidden from the client.
+func WriteError(res http.ResponseWriter, req *http.Request, err error) error {
+	var apiErr *Error
+
+	if !errors.As(err, &apiErr) {
+		log.Printf("Unexpected error at %s %s: %s\n", req.Method, req.URL.Path, err)
+
+		apiErr = NewError(KindInternal, CodeInternal, "an unexpected error occurred. Please, try again later")
+	}
+
+	// wrapped errors add what happened to the message of the error they wrap
+	problem := Problem{Status: apiErr.Status(), Detail: err.Error(), Code: apiErr.Code, Errors: apiErr.Fields}
+
+	if apiErr.Kind == KindInternal {
+		problem.Detail = apiErr.Message
+	}
+
+	if len(apiErr.Fields) > 0 {
+		var language string
+		problem.Errors, language = translateFields(req, apiErr.Fields)
+
+		res.Header().Set("Content-Language", language)
+	}
+
+	return WriteProblem(res, req, problem)
+}
+
+// Function that writes out problem details, filling in the type, the title and the instance when they're missing.
+// The type is the code under PROBLEM_TYPES_URL (e.g. https://example.com/problems/), or about:blank if it's not set.
+func WriteProblem(res http.ResponseWriter, req *http.Request, problem Problem) error {
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+
+		if typesURL := os.Getenv("PROBLEM_TYPES_URL"); typesURL != "" && problem.Code != "" {
+			problem.Type = typesURL + problem.Code
+		}
+	}
+
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+
+	if problem.Instance == "" && req != nil {
+		problem.Instance = req.URL.Path
+	}
+
+	res.Header().Set("Content-Type", "application/problem+json")
+	res.WriteHeader(problem.Status)
+
+	return json.NewEncoder(res).Encode(problem)
+}
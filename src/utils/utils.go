@@ -4,14 +4,8 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
-
-	"github.com/go-playground/validator/v10"
 )
 
-type ApiError struct {
-	Error string
-}
-
 type APIFunc func(res http.ResponseWriter, req *http.Request) error
 
 // Function that parses an APIFunc function to a http.HandlerFunc function
@@ -19,7 +13,7 @@ func ParseToHandlerFunc(f APIFunc) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 
 		if err := f(res, req); err != nil {
-			WriteJSON(res, 500, err.Error())
+			WriteError(res, req, err)
 		}
 
 	}
@@ -34,29 +28,17 @@ func WriteJSON(res http.ResponseWriter, status int, value any) error {
 	return json.NewEncoder(res).Encode(value)
 }
 
-// Function that reads content from the request body, validating it aswell
+// Function that reads content from the request body, validating it aswell.
+// Returns ErrInvalidJSON if it's not valid json, and the error of ValidateBody if it's not valid.
 func ReadJSON(reader io.Reader, body interface{}) error {
 
 	if deserializeErr := json.NewDecoder(reader).Decode(body); deserializeErr != nil {
-		return deserializeErr
+		return ErrInvalidJSON
 	}
 
-	if validationErr := validateBody(body); validationErr != nil {
+	if validationErr := ValidateBody(body); validationErr != nil {
 		return validationErr
 	}
 
 	return nil
 }
-
-// AUX FUNCTIONS
-
-// Function to validate a request's body.
-func validateBody(body interface{}) error {
-	newValidator := validator.New()
-
-	if err := newValidator.Struct(body); err != nil {
-		return err
-	}
-
-	return nil
-}
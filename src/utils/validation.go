@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	enTranslations "github.com/go-playground/validator/v10/translations/en"
+	esTranslations "github.com/go-playground/validator/v10/translations/es"
+)
+
+// Messages of the rules this API adds to the validator, for every language they're translated to
+var ruleMessages = map[string]map[string]string{
+	"password": {
+		"en": "{0} must contain at least one letter and one number",
+		"es": "{0} debe contener al menos una letra y un número",
+	},
+}
+
+// Validator of the request bodies, which reports fields by their json names, and the translator of its messages
+var validate, translator = newValidator()
+
+// Function to validate a request's body. Returns an *Error with the error of every field that isn't valid.
+func ValidateBody(body interface{}) error {
+	if err := validate.Struct(body); err != nil {
+		validationErrs, ok := err.(validator.ValidationErrors)
+
+		if !ok {
+			return err
+		}
+
+		return validationError(validationErrs)
+	}
+
+	return nil
+}
+
+// Function that returns the errors of the fields with their messages in the language the request accepts,
+// English if it accepts none of the ones they're translated to, along with that language
+func translateFields(req *http.Request, fields []FieldError) ([]FieldError, string) {
+	trans, _ := translator.FindTranslator(acceptedLanguages(req)...)
+	translated := make([]FieldError, len(fields))
+
+	for i, field := range fields {
+		translated[i] = field
+
+		if field.validationErr != nil {
+			translated[i].Message = field.validationErr.Translate(trans)
+		}
+	}
+
+	return translated, trans.Locale()
+}
+
+// AUX FUNCTIONS
+
+func newValidator() (*validator.Validate, *ut.UniversalTranslator) {
+	newValidate := validator.New()
+	universalTranslator := ut.New(en.New(), en.New(), es.New())
+
+	// fields are reported by the name clients send them with
+	newValidate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if name == "-" {
+			return ""
+		}
+
+		return name
+	})
+
+	newValidate.RegisterValidation("password", validatePassword)
+
+	englishTrans, _ := universalTranslator.GetTranslator("en")
+	spanishTrans, _ := universalTranslator.GetTranslator("es")
+
+	enTranslations.RegisterDefaultTranslations(newValidate, englishTrans)
+	esTranslations.RegisterDefaultTranslations(newValidate, spanishTrans)
+
+	for rule, messages := range ruleMessages {
+		for _, trans := range []ut.Translator{englishTrans, spanishTrans} {
+			registerRuleMessage(newValidate, trans, rule, messages[trans.Locale()])
+		}
+	}
+
+	return newValidate, universalTranslator
+}
+
+func registerRuleMessage(validate *validator.Validate, trans ut.Translator, rule string, message string) {
+	validate.RegisterTranslation(rule, trans, func(trans ut.Translator) error {
+		return trans.Add(rule, message, true)
+	}, func(trans ut.Translator, fieldErr validator.FieldError) string {
+		translated, _ := trans.T(rule, fieldErr.Field())
+
+		return translated
+	})
+}
+
+// Rule of the passwords users choose, on top of their length: they must have both letters and numbers
+func validatePassword(field validator.FieldLevel) bool {
+	hasLetter, hasNumber := false, false
+
+	for _, char := range field.Field().String() {
+		hasLetter = hasLetter || unicode.IsLetter(char)
+		hasNumber = hasNumber || unicode.IsDigit(char)
+	}
+
+	return hasLetter && hasNumber
+}
+
+func validationError(validationErrs validator.ValidationErrors) *Error {
+	validationErr := NewError(KindInvalid, CodeValidationFailed, "the body has fields that aren't valid")
+	englishTrans, _ := translator.GetTranslator("en")
+
+	for _, fieldErr := range validationErrs {
+		validationErr.Fields = append(validationErr.Fields, FieldError{
+			Field:         fieldPath(fieldErr),
+			Rule:          fieldErr.Tag(),
+			Param:         fieldErr.Param(),
+			Message:       fieldErr.Translate(englishTrans),
+			validationErr: fieldErr,
+		})
+	}
+
+	return validationErr
+}
+
+// Function that returns the path of a field from the body, e.g. redirect_uris[0], without the name of its struct
+func fieldPath(fieldErr validator.FieldError) string {
+	_, path, found := strings.Cut(fieldErr.Namespace(), ".")
+
+	if !found {
+		return fieldErr.Field()
+	}
+
+	return path
+}
+
+// Function that returns the languages of the Accept-Language header, from the most preferred to the least.
+// Regional ones (e.g. es-ES) are followed by their base language, so they're translated to it if that's all there is.
+func acceptedLanguages(req *http.Request) []string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+
+	var languages []language
+
+	if req == nil {
+		return nil
+	}
+
+	for _, part := range strings.Split(req.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, parseErr := strconv.ParseFloat(value, 64); parseErr == nil {
+				quality = parsed
+			}
+		}
+
+		if tag != "" && tag != "*" && quality > 0 {
+			languages = append(languages, language{strings.ReplaceAll(tag, "-", "_"), quality})
+		}
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+
+	var tags []string
+
+	for _, language := range languages {
+		tags = append(tags, language.tag)
+
+		if base, _, regional := strings.Cut(language.tag, "_"); regional {
+			tags = append(tags, base)
+		}
+	}
+
+	return tags
+}